@@ -11,15 +11,27 @@ interface FileInfo {
 
 interface Icon {
   src: string;
-  sizes: string;
-  type: string;
+  sizes?: string;
+  type?: string;
+  purpose?: string;
 }
 
+// Sent as the bundle's manifest.json reads; the upload is rejected if the two
+// differ
 interface Manifest {
+  id?: string;
   name: string;
   short_name: string;
+  description?: string;
   start_url: string;
+  scope?: string;
   display: string;
+  orientation?: string;
+  theme_color?: string;
+  background_color?: string;
+  lang?: string;
+  dir?: string;
+  categories?: string[];
   icons: Icon[];
 }
 
@@ -29,11 +41,38 @@ interface PublishRequest {
   entrypoint: string;
   version_notes: string;
   publisher_id: string;
+  bundle: Bundle;
+  listing?: StoreListing;
+}
+
+// The store page. Screenshots are PNG, JPEG or WebP files of the bundle
+interface StoreListing {
+  description?: string;
+  privacy_policy?: string;
+  input_types?: ('camera' | 'file' | 'microphone' | 'text')[];
+  model_card?: {
+    intended_use?: string;
+    training_data?: string;
+    limitations?: string;
+    license?: string;
+  };
+  screenshots?: {
+    path: string;
+    label?: string;
+    form_factor?: 'narrow' | 'wide';
+  }[];
+}
+
+// The upload URL only accepts a zip of this size and SHA-256
+interface Bundle {
+  size: number;
+  sha256: string;
 }
 
 interface PublishResponse {
   message: string;
   presigned_url: string;
+  headers: Record<string, string>;
 }
 
 const PublisherComponent = (): React.JSX.Element => {
@@ -41,6 +80,8 @@ const PublisherComponent = (): React.JSX.Element => {
   const [error, setError] = useState<string>('');
   const [message, setMessage] = useState<string>('');
   const [presignedUrl, setPresignedUrl] = useState<string>('');
+  const [uploadHeaders, setUploadHeaders] = useState<Record<string, string>>({});
+  const [zipBlob, setZipBlob] = useState<Blob | null>(null);
   
   // Form data
   const [appSlug, setAppSlug] = useState<string>('');
@@ -138,18 +179,18 @@ const PublisherComponent = (): React.JSX.Element => {
       return 'No files uploaded';
     }
     
-    // Check for required files
-    const hasManifest = parsedFiles.some(f => f.filename === 'manifest.json');
-    const hasModelOnnx = parsedFiles.some(f => f.filename === 'model.onnx');
-    const hasJsOrWasm = parsedFiles.some(f => 
-      f.type === 'application/javascript' || f.type === 'text/javascript' || f.type === 'application/wasm'
-    );
-    const hasHtml = parsedFiles.some(f => f.type === 'text/html');
-    
-    if (!hasManifest) return 'manifest.json is required';
-    if (!hasModelOnnx) return 'model.onnx is required';
-    if (!hasJsOrWasm) return 'At least one .js or .wasm file is required';
-    if (!hasHtml) return 'At least one HTML file is required';
+    // The PWA shell fetches these from the root of the bundle
+    const shellFiles: Record<string, string> = {
+      'manifest.json': 'the web app manifest',
+      'index.html': 'the page the shell mounts',
+      'app.js': 'the script the shell runs',
+      'sw.js': 'the service worker the shell registers',
+      'model.onnx': 'the model'
+    };
+    const missing = Object.keys(shellFiles).filter(name => !parsedFiles.some(f => f.filename === name));
+    if (missing.length) {
+      return `Missing at the root of the bundle: ${missing.map(name => `${name} (${shellFiles[name]})`).join(', ')}`;
+    }
     
     // Check file sizes
     const modelOnnxFile = parsedFiles.find(f => f.filename === 'model.onnx');
@@ -189,12 +230,15 @@ const PublisherComponent = (): React.JSX.Element => {
   }
 
   const getPresignedUrl = async (): Promise<void> => {
+    // The zip is built before asking for the URL, which is bound to its checksum
+    const zip = await createZipFromFiles(uploadedFiles);
     const requestBody: PublishRequest = {
       manifest: manifestData!,
       files: parsedFiles,
       entrypoint,
       version_notes: versionNotes,
-      publisher_id: publisherId
+      publisher_id: publisherId,
+      bundle: { size: zip.size, sha256: await sha256Hex(zip) }
     };
     
     const apiDomain = import.meta.env.VITE_API_GATEWAY_HTTPS_URL;
@@ -218,6 +262,8 @@ const PublisherComponent = (): React.JSX.Element => {
     } 
     const responseData: PublishResponse = await response.json();
     setPresignedUrl(responseData.presigned_url);
+    setUploadHeaders(responseData.headers);
+    setZipBlob(zip);
     setMessage('Files validated successfully! Use the presigned URL to upload your files.');
   }
 
@@ -247,13 +293,16 @@ const PublisherComponent = (): React.JSX.Element => {
     return await zip.generateAsync({ type: 'blob' });
   };
 
-  const uploadBlobToS3 = async (url: string, blob: Blob): Promise<void> => {
+  const sha256Hex = async (blob: Blob): Promise<string> => {
+    const digest = await crypto.subtle.digest('SHA-256', await blob.arrayBuffer());
+    return Array.from(new Uint8Array(digest), b => b.toString(16).padStart(2, '0')).join('');
+  };
+
+  const uploadBlobToS3 = async (url: string, blob: Blob, headers: Record<string, string>): Promise<void> => {
     const uploadResponse = await fetch(url, {
       method: 'PUT',
       body: blob,
-      headers: {
-        'Content-Type': 'application/zip'
-      }
+      headers
     });
     if (!uploadResponse.ok) {
       throw new Error(`Upload failed: ${uploadResponse.status} ${uploadResponse.statusText}`);
@@ -261,21 +310,19 @@ const PublisherComponent = (): React.JSX.Element => {
   };
 
   const handleUploadToS3 = async (): Promise<void> => {
-    if (!presignedUrl || uploadedFiles.length === 0) {
+    if (!presignedUrl || !zipBlob) {
       setError('No presigned URL or files available');
       return;
     }
     setIsLoading(true);
     setError('');
     try {
-      setMessage('Creating ZIP file from uploaded files...');
-      const zipBlob = await createZipFromFiles(uploadedFiles);
-  
       setMessage('Uploading files to S3...');
-      await uploadBlobToS3(presignedUrl, zipBlob);
+      await uploadBlobToS3(presignedUrl, zipBlob, uploadHeaders);
 
       setMessage('Files uploaded successfully to S3!');
       setPresignedUrl('');
+      setZipBlob(null);
       
     } catch (err) {
       const errorMessage = err instanceof Error ? err.message : 'Upload failed';
@@ -64,6 +64,53 @@ resource "aws_s3_bucket_cors_configuration" "apps" {
   }
 }
 
+# Uploads move to archive/ once unzip has queued their metadata. What is left
+# in uploads/ failed every retry and is kept long enough to investigate.
+resource "aws_s3_bucket_lifecycle_configuration" "apps" {
+  bucket = aws_s3_bucket.apps.id
+
+  rule {
+    id     = "expire-archived-uploads"
+    status = "Enabled"
+
+    filter {
+      prefix = "archive/"
+    }
+
+    expiration {
+      days = 30
+    }
+  }
+
+  rule {
+    id     = "expire-failed-uploads"
+    status = "Enabled"
+
+    filter {
+      prefix = "uploads/"
+    }
+
+    expiration {
+      days = 14
+    }
+  }
+
+  # Multipart uploads a client abandoned. Their parts are stored, and billed,
+  # until the upload is completed or aborted; two days leave time to resume.
+  rule {
+    id     = "abort-abandoned-multipart-uploads"
+    status = "Enabled"
+
+    filter {
+      prefix = "uploads/"
+    }
+
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 2
+    }
+  }
+}
+
 resource "aws_s3_bucket_policy" "apps" {
   bucket = aws_s3_bucket.apps.id
   policy = jsonencode({
@@ -227,8 +274,7 @@ resource "aws_lambda_function" "user" {
 
   environment {
     variables = {
-      SUBSCRIBER_FUNCTION_NAME = aws_lambda_function.subscriber.function_name
-      PUBLISHER_FUNCTION_NAME = aws_lambda_function.publisher.function_name
+      LOG_LEVEL = var.log_level
     }
   }
 
@@ -261,25 +307,6 @@ resource "aws_iam_role_policy" "user_cognito" {
   })
 }
 
-resource "aws_iam_role_policy" "user_lambda_invoke" {
-  name = "${var.project_name}-${var.environment}-user-lambda-invoke-policy"
-  role = aws_iam_role.user_exec.id
-
-  policy = jsonencode({
-    Version = "2012-10-17",
-    Statement = [
-      {
-        Effect = "Allow",
-        Action = "lambda:InvokeFunction",
-        Resource = [
-          aws_lambda_function.publisher.arn,
-          aws_lambda_function.subscriber.arn
-        ]
-      }
-    ]
-  })
-}
-
 resource "aws_lambda_permission" "user_cognito_trigger" {
   statement_id  = "AllowCognitoPostConfirmation"
   action        = "lambda:InvokeFunction"
@@ -309,33 +336,6 @@ resource "aws_apigatewayv2_route" "put_user_role" {
   authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
 }
 
-resource "aws_apigatewayv2_route" "user_publish" {
-  api_id    = aws_apigatewayv2_api.main.id
-  route_key = "POST /publish/{app-slug}/version/{version-id}"
-  target    = "integrations/${aws_apigatewayv2_integration.user.id}"
-
-  authorization_type = "JWT"
-  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
-}
-
-resource "aws_apigatewayv2_route" "user_get_all_apps" {
-  api_id    = aws_apigatewayv2_api.main.id
-  route_key = "GET /apps"
-  target    = "integrations/${aws_apigatewayv2_integration.user.id}"
-
-  authorization_type = "JWT"
-  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
-}
-
-resource "aws_apigatewayv2_route" "user_subscribe" {
-  api_id    = aws_apigatewayv2_api.main.id
-  route_key = "POST /subscribe"
-  target    = "integrations/${aws_apigatewayv2_integration.user.id}"
-
-  authorization_type = "JWT"
-  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
-}
-
 resource "aws_lambda_permission" "user_apigw" {
   statement_id  = "AllowAPIGatewayInvoke"
   action        = "lambda:InvokeFunction"
@@ -369,13 +369,16 @@ resource "aws_lambda_function" "publisher" {
   handler         = "publisher"
   runtime         = "provided.al2"
   architectures   = ["x86_64"]
-  depends_on = [aws_dynamodb_table.app_table]
+  depends_on = [aws_dynamodb_table.app_table, aws_dynamodb_table.publisher_table]
 
   environment {
     variables = {
-      apps_bucket        = aws_s3_bucket.apps.bucket
-      app_metadata_queue = aws_sqs_queue.app_metadata_queue.name
-      app_table_name     = aws_dynamodb_table.app_table.name
+      apps_bucket          = aws_s3_bucket.apps.bucket
+      app_metadata_queue   = aws_sqs_queue.app_metadata_queue.name
+      app_table_name       = aws_dynamodb_table.app_table.name
+      publisher_table_name = aws_dynamodb_table.publisher_table.name
+      assets_base_url      = "https://assets.${var.root_domain}"
+      LOG_LEVEL            = var.log_level
     }
   }
 
@@ -401,6 +404,52 @@ resource "aws_iam_role_policy" "publisher_s3_policy" {
         ],
         Resource = "${aws_s3_bucket.apps.arn}/*"
       },
+      {
+        # Ingest reads and flips app/{slug}/current.json and deletes expired releases
+        Effect = "Allow",
+        Action = [
+          "s3:GetObject",
+          "s3:DeleteObject"
+        ],
+        Resource = "${aws_s3_bucket.apps.arn}/app/*"
+      },
+      {
+        # Completing a delta upload reads its declaration and lists the uploaded
+        # files; resuming and completing a multipart upload lists its parts
+        Effect = "Allow",
+        Action = [
+          "s3:GetObject",
+          "s3:ListMultipartUploadParts"
+        ],
+        Resource = "${aws_s3_bucket.apps.arn}/uploads/*"
+      },
+      {
+        # Release cleanup deletes blob references and the blobs left unreferenced
+        Effect = "Allow",
+        Action = ["s3:DeleteObject"],
+        Resource = [
+          "${aws_s3_bucket.apps.arn}/blobs/*",
+          "${aws_s3_bucket.apps.arn}/blobrefs/*"
+        ]
+      },
+      {
+        # Completing an avatar upload deletes the avatar it replaces
+        Effect   = "Allow",
+        Action   = ["s3:DeleteObject"],
+        Resource = "${aws_s3_bucket.apps.arn}/publishers/*"
+      },
+      {
+        # Completing an avatar upload lists publishers/{id}/ to check it landed.
+        # The devserver enforces these prefixes, see publisherPolicy there
+        Effect   = "Allow",
+        Action   = ["s3:ListBucket"],
+        Resource = aws_s3_bucket.apps.arn,
+        Condition = {
+          StringLike = {
+            "s3:prefix" = ["app/*", "blobs/*", "blobrefs/*", "publishers/*", "uploads/*"]
+          }
+        }
+      },
     ]
   })
 }
@@ -434,25 +483,121 @@ resource "aws_iam_role_policy" "publisher_dynamodb_policy" {
     Statement = [
       {
         Effect = "Allow",
+        # Ingest queries a publisher's versions of an app to point the older
+        # ones at the live release
         Action = [
           "dynamodb:PutItem",
           "dynamodb:UpdateItem",
+          "dynamodb:Query",
         ],
         Resource = [
           aws_dynamodb_table.app_table.arn,
           "${aws_dynamodb_table.app_table.arn}/index/*"
         ]
       },
+      {
+        Effect = "Allow",
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:UpdateItem",
+        ],
+        Resource = aws_dynamodb_table.publisher_table.arn
+      },
     ]
   })
 }
 
+# Publisher API Gateway integration
+resource "aws_apigatewayv2_integration" "publisher" {
+  api_id           = aws_apigatewayv2_api.main.id
+  integration_type = "AWS_PROXY"
+  
+  integration_method = "POST"
+  integration_uri    = aws_lambda_function.publisher.invoke_arn
+  payload_format_version = "2.0"
+}
+
+resource "aws_apigatewayv2_route" "publisher_publish" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "POST /publish/{app-slug}/version/{version-id}"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_get_upload" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "GET /publish/{app-slug}/version/{version-id}/uploads/{upload-id}"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_complete_upload" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "POST /publish/{app-slug}/version/{version-id}/uploads/{upload-id}/complete"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_get_profile" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "GET /publishers/me"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_put_profile" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "PUT /publishers/me"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_post_avatar" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "POST /publishers/me/avatar"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "publisher_put_avatar" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "PUT /publishers/me/avatar"
+  target    = "integrations/${aws_apigatewayv2_integration.publisher.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_lambda_permission" "publisher_apigw" {
+  statement_id  = "AllowAPIGatewayInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.publisher.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "${aws_apigatewayv2_api.main.execution_arn}/*/*"
+}
+
 # SQS Event Source Mapping for Publisher Lambda
 resource "aws_lambda_event_source_mapping" "publisher_sqs_trigger" {
   event_source_arn = aws_sqs_queue.app_metadata_queue.arn
   function_name    = aws_lambda_function.publisher.arn
   batch_size       = 10
   enabled          = true
+
+  # The publisher returns the ids of the messages it failed to ingest, so only
+  # those are redelivered instead of the whole batch
+  function_response_types = ["ReportBatchItemFailures"]
 }
 
 # ---------------------------------------------
@@ -480,12 +625,15 @@ resource "aws_lambda_function" "subscriber" {
   handler         = "subscriber"
   runtime         = "provided.al2"
   architectures   = ["x86_64"]
-  depends_on = [aws_dynamodb_table.app_table, aws_dynamodb_table.subscription_table]
+  depends_on = [aws_dynamodb_table.app_table, aws_dynamodb_table.subscription_table, aws_dynamodb_table.publisher_table]
 
   environment {
     variables = {
       app_table_name          = aws_dynamodb_table.app_table.name
       subscription_table_name = aws_dynamodb_table.subscription_table.name
+      publisher_table_name    = aws_dynamodb_table.publisher_table.name
+      assets_base_url         = "https://assets.${var.root_domain}"
+      LOG_LEVEL               = var.log_level
     }
   }
 
@@ -530,11 +678,73 @@ resource "aws_iam_role_policy" "subscriber_dynamodb_policy" {
           aws_dynamodb_table.subscription_table.arn,
           "${aws_dynamodb_table.subscription_table.arn}/index/*"
         ]
+      },
+      {
+        Effect = "Allow",
+        Action = [
+          "dynamodb:GetItem",
+          "dynamodb:BatchGetItem"
+        ],
+        Resource = aws_dynamodb_table.publisher_table.arn
       }
     ]
   })
 }
 
+# Subscriber API Gateway integration
+resource "aws_apigatewayv2_integration" "subscriber" {
+  api_id           = aws_apigatewayv2_api.main.id
+  integration_type = "AWS_PROXY"
+  
+  integration_method = "POST"
+  integration_uri    = aws_lambda_function.subscriber.invoke_arn
+  payload_format_version = "2.0"
+}
+
+resource "aws_apigatewayv2_route" "subscriber_get_all_apps" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "GET /apps"
+  target    = "integrations/${aws_apigatewayv2_integration.subscriber.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "subscriber_subscribe" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "POST /subscribe"
+  target    = "integrations/${aws_apigatewayv2_integration.subscriber.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+resource "aws_apigatewayv2_route" "subscriber_get_app" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "GET /apps/{app-id}"
+  target    = "integrations/${aws_apigatewayv2_integration.subscriber.id}"
+
+  authorization_type = "JWT"
+  authorizer_id     = aws_apigatewayv2_authorizer.cognito.id
+}
+
+# Publisher pages are public
+resource "aws_apigatewayv2_route" "subscriber_get_publisher" {
+  api_id    = aws_apigatewayv2_api.main.id
+  route_key = "GET /publishers/{publisher-id}"
+  target    = "integrations/${aws_apigatewayv2_integration.subscriber.id}"
+
+  authorization_type = "NONE"
+}
+
+resource "aws_lambda_permission" "subscriber_apigw" {
+  statement_id  = "AllowAPIGatewayInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.subscriber.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "${aws_apigatewayv2_api.main.execution_arn}/*/*"
+}
+
 # ---------------------------------------------
 # App MetaData SQS Queue
 # ---------------------------------------------
@@ -547,6 +757,47 @@ resource "aws_sqs_queue" "app_metadata_queue" {
   receive_wait_time_seconds = 0
   visibility_timeout_seconds = 60
 
+  # Messages that fail ingest this many times move to the dead-letter queue,
+  # where they can be inspected and replayed with server/cmd/dlq
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.app_metadata_dlq.arn
+    maxReceiveCount     = 5
+  })
+
+  tags = local.tags
+}
+
+resource "aws_sqs_queue" "app_metadata_dlq" {
+  name                      = "${var.project_name}-${var.environment}-app-metadata-dlq"
+  message_retention_seconds = 1209600
+
+  tags = local.tags
+}
+
+# Only the app metadata queue may dead-letter into the DLQ, which also makes
+# it the destination of redrives started from the DLQ
+resource "aws_sqs_queue_redrive_allow_policy" "app_metadata_dlq" {
+  queue_url = aws_sqs_queue.app_metadata_dlq.id
+
+  redrive_allow_policy = jsonencode({
+    redrivePermission = "byQueue"
+    sourceQueueArns   = [aws_sqs_queue.app_metadata_queue.arn]
+  })
+}
+
+resource "aws_cloudwatch_metric_alarm" "app_metadata_dlq_not_empty" {
+  alarm_name          = "${var.project_name}-${var.environment}-app-metadata-dlq-not-empty"
+  alarm_description   = "App metadata messages failed ingest and are waiting in the dead-letter queue"
+  namespace           = "AWS/SQS"
+  metric_name         = "ApproximateNumberOfMessagesVisible"
+  dimensions          = { QueueName = aws_sqs_queue.app_metadata_dlq.name }
+  statistic           = "Maximum"
+  period              = 300
+  evaluation_periods  = 1
+  threshold           = 0
+  comparison_operator = "GreaterThanThreshold"
+  treat_missing_data  = "notBreaching"
+
   tags = local.tags
 }
 
@@ -614,6 +865,23 @@ resource "aws_dynamodb_table" "subscription_table" {
   tags = local.tags
 }
 
+# ---------------------------------------------
+# Publisher Table
+# ---------------------------------------------
+
+resource "aws_dynamodb_table" "publisher_table" {
+  name           = "${var.project_name}-${var.environment}-publisher-table"
+  billing_mode   = "PAY_PER_REQUEST"
+  hash_key       = "publisherId"
+
+  attribute {
+    name = "publisherId"
+    type = "S"
+  }
+
+  tags = local.tags
+}
+
 # ---------------------------------------------
 # PWA Shell App & Modern Access Control
 # ---------------------------------------------
@@ -701,6 +969,32 @@ resource "aws_cloudfront_cache_policy" "short_cache" {
   }
 }
 
+# Release files and blobs never change under their key: every release has its
+# own prefix and every blob is named by its SHA-256. This policy lets the
+# Cache-Control unzip stores them with (max-age=31536000, immutable for hashed
+# files, blobs and models) decide how long the edge keeps them, and honors
+# no-cache on the entry points.
+resource "aws_cloudfront_cache_policy" "immutable_cache" {
+  name        = "ImmutableCache"
+  comment     = "Honors the origin Cache-Control of release files and blobs"
+  default_ttl = 86400    # 1 day, for objects stored without Cache-Control
+  max_ttl     = 31536000 # 1 year
+  min_ttl     = 0
+
+  parameters_in_cache_key_and_forwarded_to_origin {
+    enable_accept_encoding_gzip = true
+    headers_config {
+      header_behavior = "none"
+    }
+    query_strings_config {
+      query_string_behavior = "none"
+    }
+    cookies_config {
+      cookie_behavior = "none"
+    }
+  }
+}
+
 resource "aws_cloudfront_response_headers_policy" "service_worker_allowed" {
   name = "ServiceWorkerAllowedHeader"
 
@@ -780,6 +1074,39 @@ resource "aws_cloudfront_distribution" "main_distribution" {
     cache_policy_id        = aws_cloudfront_cache_policy.short_cache.id
     response_headers_policy_id = aws_cloudfront_response_headers_policy.service_worker_allowed.id
   }
+  # A release's manifest is revalidated like the service worker, since the
+  # browser reads it to install and update the app
+  ordered_cache_behavior {
+    path_pattern     = "/app/*/manifest.json"
+    target_origin_id = aws_s3_bucket.apps.id
+    allowed_methods  = ["GET", "HEAD", "OPTIONS"]
+    cached_methods   = ["GET", "HEAD"]
+    viewer_protocol_policy = "redirect-to-https"
+    compress               = true
+    cache_policy_id        = aws_cloudfront_cache_policy.short_cache.id
+  }
+  # Every file of a release (wasm, fonts, media, source maps, ...) comes from
+  # the 'apps' bucket with the content type and Cache-Control unzip stored it
+  # with
+  ordered_cache_behavior {
+    path_pattern     = "/app/*/releases/*"
+    target_origin_id = aws_s3_bucket.apps.id
+    allowed_methods  = ["GET", "HEAD", "OPTIONS"]
+    cached_methods   = ["GET", "HEAD"]
+    viewer_protocol_policy = "redirect-to-https"
+    compress               = true
+    cache_policy_id        = aws_cloudfront_cache_policy.immutable_cache.id
+  }
+  # Models and large binaries shared by releases, stored once by SHA-256
+  ordered_cache_behavior {
+    path_pattern     = "/blobs/*"
+    target_origin_id = aws_s3_bucket.apps.id
+    allowed_methods  = ["GET", "HEAD", "OPTIONS"]
+    cached_methods   = ["GET", "HEAD"]
+    viewer_protocol_policy = "redirect-to-https"
+    compress               = true
+    cache_policy_id        = aws_cloudfront_cache_policy.immutable_cache.id
+  }
   # Path-Based Behaviors: Serve mini-app assets (with file extensions) from the 'apps' bucket
   ordered_cache_behavior {
     path_pattern     = "/app/*.js"
@@ -826,6 +1153,15 @@ resource "aws_cloudfront_distribution" "main_distribution" {
     compress               = true
     cache_policy_id        = aws_cloudfront_cache_policy.short_cache.id
   }
+  ordered_cache_behavior {
+    path_pattern     = "/publishers/*"
+    target_origin_id = aws_s3_bucket.apps.id
+    allowed_methods  = ["GET", "HEAD", "OPTIONS"]
+    cached_methods   = ["GET", "HEAD"]
+    viewer_protocol_policy = "redirect-to-https"
+    compress               = true
+    cache_policy_id        = aws_cloudfront_cache_policy.short_cache.id
+  }
   ordered_cache_behavior {
     path_pattern     = "/app/*.html"
     target_origin_id = aws_s3_bucket.apps.id
@@ -912,9 +1248,25 @@ resource "aws_s3_bucket_notification" "apps_upload_notification" {
     filter_suffix       = ".zip"
   }
 
+  # A delta upload is extracted once the publisher marks it complete
+  lambda_function {
+    lambda_function_arn = aws_lambda_function.unzip.arn
+    events              = ["s3:ObjectCreated:*"]
+    filter_prefix       = "uploads/"
+    filter_suffix       = ".complete"
+  }
+
   depends_on = [aws_lambda_permission.s3_invoke_unzip]
 }
 
+# S3 invokes unzip asynchronously. A failed invocation, for example when the
+# metadata could not be queued, is retried with the upload still in place.
+resource "aws_lambda_function_event_invoke_config" "unzip" {
+  function_name                = aws_lambda_function.unzip.function_name
+  maximum_retry_attempts       = 2
+  maximum_event_age_in_seconds = 3600
+}
+
 resource "aws_lambda_permission" "s3_invoke_unzip" {
   statement_id  = "AllowExecutionFromS3Bucket"
   action        = "lambda:InvokeFunction"
@@ -940,6 +1292,7 @@ resource "aws_lambda_function" "unzip" {
     variables = {
       apps_bucket        = aws_s3_bucket.apps.bucket
       app_metadata_queue = aws_sqs_queue.app_metadata_queue.name
+      LOG_LEVEL          = var.log_level
     }
   }
 
@@ -981,6 +1334,14 @@ resource "aws_iam_role_policy" "unzip_s3_policy" {
         ],
         Resource = "${aws_s3_bucket.apps.arn}/*"
       },
+      {
+        # Without it, looking up a blob that is not stored yet is denied
+        # instead of reporting it missing. HeadObject sends no prefix, so
+        # the permission cannot be limited to blobs/
+        Effect   = "Allow",
+        Action   = ["s3:ListBucket"],
+        Resource = aws_s3_bucket.apps.arn
+      },
     ]
   })
 }
@@ -42,3 +42,13 @@ output "cognito_endpoint" {
   description = "Endpoint of the Cognito User Pool"
   value = aws_cognito_user_pool.main.endpoint
 }
+
+output "app_metadata_queue_name" {
+  description = "Name of the SQS queue feeding app metadata to the publisher"
+  value       = aws_sqs_queue.app_metadata_queue.name
+}
+
+output "app_metadata_dlq_name" {
+  description = "Name of the dead-letter queue for app metadata that failed ingest"
+  value       = aws_sqs_queue.app_metadata_dlq.name
+}
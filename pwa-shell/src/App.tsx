@@ -5,12 +5,44 @@ interface AppContent {
   js: string;
   serviceWorker: string;
   slug: string;
+  // URL path of the live release, ending in a slash
+  basePath: string;
+  // URLs of the models and shared blobs app.js loads, by bundle path
+  resourceUrls: Record<string, string>;
 }
 
 interface Manifest {
   [key: string]: any;
 }
 
+// One entry of the content manifest in current.json
+interface ContentFile {
+  path: string;
+  size: number;
+  sha256: string;
+  contentType: string;
+  cacheControl: string;
+  // Set for models and large binaries served from a shared blob
+  url?: string;
+}
+
+// What ingest found in one of the release's declared models
+interface ModelInfo {
+  name: string;
+  role: string;
+  path: string;
+  inputs: { name: string; type: string; shape?: string[] }[];
+  outputs: { name: string; type: string; shape?: string[] }[];
+  opsetVersion: number;
+  unsupportedOperators?: string[];
+}
+
+interface Release {
+  basePath: string;
+  files: ContentFile[];
+  models: ModelInfo[];
+}
+
 const App: React.FC = () => {
   const [slug, setSlugState] = useState<string | null>(null);
   const [manifest, setManifest] = useState<Manifest | null>(null);
@@ -28,9 +60,9 @@ const App: React.FC = () => {
         const currentSlug = getSlugFromSubdomain();
         setSlugState(currentSlug);
         if (currentSlug) {
-          const { html, js, serviceWorker } = await loadAppResources(currentSlug);
+          const content = await loadAppResources(currentSlug);
           setDebugMsg('All mini program resources loaded. Setting mini program content...');
-          setAppContent({ html, js, serviceWorker, slug: currentSlug });
+          setAppContent(content);
         } else {
           setError("Invalid mini program URL: missing slug.");
           setDebugMsg('No slug found in subdomain.');
@@ -110,29 +142,90 @@ const App: React.FC = () => {
     return response;
   }
 
+  // current.json names the live release and lists the SHA-256 of each of its
+  // files. Apps published before releases existed have no pointer and are
+  // served straight from /app/{slug}/ without a content manifest.
+  const getRelease = async (slug: string): Promise<Release> => {
+    setDebugMsg('Looking up the current mini program release...');
+    const response = await fetch(`/app/${slug}/current.json`, { cache: 'no-store' });
+    if (response.status === 404 || response.status === 403) {
+      return { basePath: `/app/${slug}/`, files: [], models: [] };
+    }
+    if (!response.ok) {
+      throw new Error(`Failed to load mini program release: ${response.statusText}`);
+    }
+    const pointer = await response.json();
+    const models: ModelInfo[] = pointer.models ?? [];
+    models.forEach(model => {
+      if (model.unsupportedOperators?.length) {
+        console.warn(`Mini program model ${model.name} needs operators onnxruntime-web does not support: ${model.unsupportedOperators.join(', ')}`);
+      }
+    });
+    return { basePath: pointer.path, files: pointer.files ?? [], models };
+  };
+
+  // verifyResource checks a downloaded file against the content manifest, so
+  // a stale or corrupted copy from a cache is never run.
+  const verifyResource = async (release: Release, resourceName: string, body: ArrayBuffer): Promise<void> => {
+    const expected = release.files.find(file => file.path === resourceName);
+    if (!expected || !window.crypto?.subtle) {
+      return;
+    }
+    const digest = await window.crypto.subtle.digest('SHA-256', body);
+    const actual = Array.from(new Uint8Array(digest))
+      .map(byte => byte.toString(16).padStart(2, '0'))
+      .join('');
+    if (actual !== expected.sha256) {
+      throw new Error(`Mini program resource ${resourceName} does not match its release`);
+    }
+  };
+
+  const loadVerifiedText = async (release: Release, resourceName: string): Promise<string> => {
+    const response = await loadResource(resourceName, `${release.basePath}${resourceName}`);
+    const body = await response.arrayBuffer();
+    await verifyResource(release, resourceName, body);
+    return new TextDecoder().decode(body);
+  };
+
+  // Models are relative to the bundle, and shared blobs are outside the
+  // release altogether, so app.js gets their URLs rewritten
+  const getResourceUrls = (release: Release): Record<string, string> => {
+    const modelPaths = release.models.length ? release.models.map(model => model.path) : ['model.onnx'];
+    const resourceUrls: Record<string, string> = {};
+    modelPaths.forEach(modelPath => {
+      resourceUrls[modelPath] = `${release.basePath}${modelPath}`;
+    });
+    release.files.forEach(file => {
+      if (file.url) {
+        resourceUrls[file.path] = file.url;
+      }
+    });
+    return resourceUrls;
+  };
+
   const loadAppResources = async (slug: string): Promise<AppContent> => {
-      const manifestResponse = await loadResource('manifest.json', `/app/${slug}/manifest.json`);
-      const manifest = await manifestResponse.json();
+      const release = await getRelease(slug);
+      const basePath = release.basePath;
+      const manifest = JSON.parse(await loadVerifiedText(release, 'manifest.json'));
       setDebugMsg('Mini app manifest loaded. Fetching index.html...');
       setManifest(manifest);
 
-      const htmlResponse = await loadResource('index.html', `/app/${slug}/index.html`);
-      const htmlContent = await htmlResponse.text();
+      const htmlContent = await loadVerifiedText(release, 'index.html');
       setDebugMsg('Mini app index.html loaded. Fetching app.js...');
 
-      const jsResponse = await loadResource('app.js', `/app/${slug}/app.js`);
-      const jsContent = await jsResponse.text();
+      const jsContent = await loadVerifiedText(release, 'app.js');
       setDebugMsg('Mini app app.js loaded. Fetching sw.js...');
 
-      const serviceWorker = await loadResource('sw.js', `/app/${slug}/sw.js`);
-      const swContent = await serviceWorker.text();
-      setDebugMsg('Mini app sw.js (service worker) loaded. Pre-fetching model.onnx...');
+      const swContent = await loadVerifiedText(release, 'sw.js');
+      setDebugMsg('Mini app sw.js (service worker) loaded. Pre-fetching models...');
 
       return {
         html: htmlContent,
         js: jsContent,
         serviceWorker: swContent,
-        slug: slug
+        slug: slug,
+        basePath: basePath,
+        resourceUrls: getResourceUrls(release)
       } as AppContent;
   };
 
@@ -154,7 +247,7 @@ const App: React.FC = () => {
     setDebugMsg('Registering mini program service worker...');
     if ('serviceWorker' in navigator && content.serviceWorker) {
       // Register the service worker with the correct Shell URL scope
-      const miniAppServiceWorkerUrl = `/app/${content.slug}/sw.js`;
+      const miniAppServiceWorkerUrl = `${content.basePath}sw.js`;
       const shellDomainScope = '/';
       await navigator.serviceWorker.register(
         miniAppServiceWorkerUrl, {
@@ -166,9 +259,9 @@ const App: React.FC = () => {
   const executeMiniAppJs = async (content: AppContent) => {
     setDebugMsg('Executing mini program JS...');
     const appScript = document.createElement('script');
-    const updatedJsContent = content.js.replace(
-      /'model\.onnx'/g, 
-      `'/app/${content.slug}/model.onnx'`
+    const updatedJsContent = Object.entries(content.resourceUrls).reduce(
+      (js, [resourcePath, url]) => js.split(`'${resourcePath}'`).join(`'${url}'`),
+      content.js
     );
     appScript.textContent = updatedJsContent;
     document.body.appendChild(appScript);
@@ -33,7 +33,9 @@ for func_dir in "$LAMBDA_SRC_DIR"/*; do
     fi
 
     # Build the Lambda function for Linux AMD64 architecture
-    GOOS=linux GOARCH=amd64 go build -o bootstrap -tags lambda.norpc .
+    # The entry point lives in cmd/lambda so the handlers stay importable by the
+    # local development server
+    GOOS=linux GOARCH=amd64 go build -o bootstrap -tags lambda.norpc ./cmd/lambda
 
     # Create the zip archive, overwriting if it exists
     zip -j "${func_name}.zip" bootstrap
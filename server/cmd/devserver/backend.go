@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/google/uuid"
+
+	publisher "miniapps-lambda-publisher"
+	subscriber "miniapps-lambda-subscriber"
+	unzip "miniapps-lambda-unzip"
+	user "miniapps-lambda-user"
+)
+
+/*****************************************************/
+// Backend wiring
+/*****************************************************/
+// backend wires the lambda handlers to in-memory stores and the local bucket
+// the way Terraform wires the functions to DynamoDB, S3, SQS and Cognito.
+type backend struct {
+	cfg        config
+	bucket     *bucket
+	publisher  *publisher.Handler
+	subscriber *subscriber.Handler
+	unzip      *unzip.Handler
+	user       *user.Handler
+}
+
+func newBackend(cfg config, bucketDir string) *backend {
+	b := &backend{cfg: cfg}
+	b.bucket = newBucket(cfg, bucketDir, b.notifyUnzip)
+
+	// The publisher writes the app and publisher tables the subscriber reads
+	catalogApps := subscriber.NewMemoryAppStore()
+	catalogPublishers := subscriber.NewMemoryPublisherStore()
+	b.subscriber = subscriber.NewHandler(subscriber.Services{
+		Apps:          catalogApps,
+		Subscriptions: subscriber.NewMemorySubscriptionStore(),
+		Publishers:    catalogPublishers,
+	})
+	b.publisher = publisher.NewHandler(publisher.Services{
+		Apps:       &sharedAppStore{AppStore: publisher.NewMemoryAppStore(), catalog: catalogApps},
+		Publishers: &sharedPublisherStore{PublisherStore: publisher.NewMemoryPublisherStore(), catalog: catalogPublishers},
+		Blobs:      publisherBlobs{b.bucket},
+	})
+	b.unzip = unzip.NewHandler(unzip.Services{
+		Blobs:      b.bucket,
+		Metadata:   &metadataQueue{backend: b},
+		AppsBucket: cfg.bucket,
+	})
+	b.user = user.NewHandler(user.NewMemoryServices())
+	return b
+}
+
+func (b *backend) lambdaContext(ctx context.Context, function string) context.Context {
+	return lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+		AwsRequestID:       uuid.NewString(),
+		InvokedFunctionArn: "arn:aws:lambda:" + b.cfg.region + ":000000000000:function:" + function,
+	})
+}
+
+// notifyUnzip delivers an ObjectCreated notification for key to the unzip
+// handler, as the apps bucket notifications do for uploads/*.zip and
+// uploads/*.complete.
+func (b *backend) notifyUnzip(ctx context.Context, key string, size int64) error {
+	record := events.S3EventRecord{
+		EventVersion: "2.1",
+		EventSource:  "aws:s3",
+		AWSRegion:    b.cfg.region,
+		EventTime:    time.Now().UTC(),
+		EventName:    "ObjectCreated:Put",
+	}
+	record.S3.Bucket.Name = b.cfg.bucket
+	record.S3.Bucket.Arn = "arn:aws:s3:::" + b.cfg.bucket
+	// Notification keys are form encoded
+	record.S3.Object.Key = urlEncodeKey(key)
+	record.S3.Object.Size = size
+
+	return b.unzip.HandleRequest(b.lambdaContext(ctx, "unzip"), events.S3Event{Records: []events.S3EventRecord{record}})
+}
+
+// deliverMetadata invokes the publisher with a one-message SQS event, the
+// way the event source mapping on the app metadata queue does.
+func (b *backend) deliverMetadata(ctx context.Context, body string) error {
+	digest := md5.Sum([]byte(body))
+	message := events.SQSMessage{
+		MessageId:      uuid.NewString(),
+		ReceiptHandle:  uuid.NewString(),
+		Body:           body,
+		Md5OfBody:      hex.EncodeToString(digest[:]),
+		EventSource:    "aws:sqs",
+		EventSourceARN: "arn:aws:sqs:" + b.cfg.region + ":000000000000:" + b.cfg.queueName,
+		AWSRegion:      b.cfg.region,
+		Attributes: map[string]string{
+			"ApproximateReceiveCount": "1",
+			"SentTimestamp":           strconv.FormatInt(time.Now().UnixMilli(), 10),
+		},
+	}
+	event, err := json.Marshal(events.SQSEvent{Records: []events.SQSMessage{message}})
+	if err != nil {
+		return fmt.Errorf("failed to encode SQS event: %w", err)
+	}
+	result, err := b.publisher.HandleRequest(b.lambdaContext(ctx, "publisher"), event)
+	if err != nil {
+		return err
+	}
+	// Locally there is no redelivery or dead-letter queue, so a message the
+	// publisher reports as failed is only logged
+	if response, ok := result.(events.SQSEventResponse); ok && len(response.BatchItemFailures) > 0 {
+		return fmt.Errorf("publisher reported message %s as failed", message.MessageId)
+	}
+	return nil
+}
+
+/*****************************************************/
+// Local queue
+/*****************************************************/
+// metadataQueue delivers each message as soon as it is sent. Like SQS it
+// accepts the message even when the consumer then fails to process it.
+type metadataQueue struct {
+	backend *backend
+}
+
+func (q *metadataQueue) SendAppMetadata(ctx context.Context, metadata unzip.AppMetadataMessage) error {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := q.backend.deliverMetadata(context.WithoutCancel(ctx), string(body)); err != nil {
+		slog.ErrorContext(ctx, "Publisher failed to ingest app metadata", "error", err)
+	}
+	return nil
+}
+
+/*****************************************************/
+// Shared tables
+/*****************************************************/
+// sharedAppStore copies every app the publisher saves into the catalog the
+// subscriber reads, converting it the way a DynamoDB round trip would.
+type sharedAppStore struct {
+	publisher.AppStore
+	catalog *subscriber.MemoryAppStore
+}
+
+func (s *sharedAppStore) SaveApp(ctx context.Context, record publisher.AppRecord) error {
+	if err := s.AppStore.SaveApp(ctx, record); err != nil {
+		return err
+	}
+	var app subscriber.AppDetail
+	if err := convertItem(record, &app); err != nil {
+		return err
+	}
+	s.catalog.PutApp(app)
+	return nil
+}
+
+// sharedPublisherStore copies profile changes into the subscriber's view.
+type sharedPublisherStore struct {
+	publisher.PublisherStore
+	catalog *subscriber.MemoryPublisherStore
+}
+
+func (s *sharedPublisherStore) sync(ctx context.Context, publisherId string) error {
+	profile, err := s.PublisherStore.GetPublisher(ctx, publisherId)
+	if err != nil || profile == nil {
+		return err
+	}
+	var listing subscriber.PublisherProfile
+	if err := convertItem(profile, &listing); err != nil {
+		return err
+	}
+	s.catalog.PutPublisher(listing)
+	return nil
+}
+
+func (s *sharedPublisherStore) UpdatePublisher(ctx context.Context, publisherId string, request publisher.UpdateProfileRequest) (*publisher.PublisherProfile, error) {
+	profile, err := s.PublisherStore.UpdatePublisher(ctx, publisherId, request)
+	if err != nil {
+		return nil, err
+	}
+	return profile, s.sync(ctx, publisherId)
+}
+
+func (s *sharedPublisherStore) SetPublisherAvatar(ctx context.Context, publisherId, avatarKey string) error {
+	if err := s.PublisherStore.SetPublisherAvatar(ctx, publisherId, avatarKey); err != nil {
+		return err
+	}
+	return s.sync(ctx, publisherId)
+}
+
+// convertItem copies a record written by one lambda into the type another
+// lambda reads it as, through the DynamoDB attribute value encoding.
+func convertItem(in, out interface{}) error {
+	item, err := attributevalue.MarshalMap(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item: %w", err)
+	}
+	if err := attributevalue.UnmarshalMap(item, out); err != nil {
+		return fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+	return nil
+}
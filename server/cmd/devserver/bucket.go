@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	publisher "miniapps-lambda-publisher"
+	unzip "miniapps-lambda-unzip"
+)
+
+/*****************************************************/
+// Local S3 bucket
+/*****************************************************/
+// bucket serves path-style S3 object requests (GET, HEAD, PUT, DELETE) and
+// part uploads from a directory. It receives presigned uploads from the
+// browser and serves the extracted apps, ignoring request signatures.
+type bucket struct {
+	cfg    config
+	dir    string
+	notify func(ctx context.Context, key string, size int64) error
+
+	// Attributes of objects written since the server started
+	mu         sync.Mutex
+	attributes map[string]unzip.ObjectAttributes
+	// Serializes conditional writes, which check an object and then replace it
+	conditionalMu sync.Mutex
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	Key     string   `xml:"Key,omitempty"`
+}
+
+// Extensions mime.TypeByExtension does not know about on every platform
+var localContentTypes = map[string]string{
+	".onnx":        "application/octet-stream",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+}
+
+// newBucket serves the bucket from dir. notify is called for every zip, and
+// every delta upload marker, that lands under uploads/.
+func newBucket(cfg config, dir string, notify func(ctx context.Context, key string, size int64) error) *bucket {
+	return &bucket{cfg: cfg, dir: dir, notify: notify, attributes: make(map[string]unzip.ObjectAttributes)}
+}
+
+func writeS3Error(w http.ResponseWriter, statusCode int, code, message, key string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message, Key: key})
+}
+
+// objectPath maps a key to a file inside the bucket directory, rejecting keys
+// that would escape it.
+func (b *bucket) objectPath(key string) (string, bool) {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", false
+	}
+	cleaned := path.Clean("/" + key)
+	if cleaned != "/"+key {
+		return "", false
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(cleaned)), true
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/"+b.cfg.bucket+"/")
+	filePath, ok := b.objectPath(key)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key", key)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		b.getObject(w, r, key, filePath)
+	case http.MethodPut:
+		if r.URL.Query().Has("uploadId") {
+			b.uploadPart(w, r, key)
+			return
+		}
+		b.putObject(w, r, key, filePath)
+	case http.MethodDelete:
+		if err := b.removeObject(key, filePath); err != nil {
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not supported locally", key)
+	}
+}
+
+func (b *bucket) getObject(w http.ResponseWriter, r *http.Request, key, filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", key)
+			return
+		}
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", key)
+		return
+	}
+
+	// S3 ETags of single-part uploads are the MD5 of the body
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash.Sum(nil))+`"`)
+
+	attributes := b.objectAttributes(key)
+	w.Header().Set("Content-Type", attributes.ContentType)
+	if attributes.CacheControl != "" {
+		w.Header().Set("Cache-Control", attributes.CacheControl)
+	}
+	http.ServeContent(w, r, filepath.Base(filePath), info.ModTime(), file)
+}
+
+func (b *bucket) putObject(w http.ResponseWriter, r *http.Request, key, filePath string) {
+	body := r.Body
+	if checksum := r.Header.Get("x-amz-checksum-sha256"); checksum != "" {
+		// Like S3, reject a body that does not match the checksum it was sent with
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+			return
+		}
+		if digest := sha256.Sum256(data); base64.StdEncoding.EncodeToString(digest[:]) != checksum {
+			writeS3Error(w, http.StatusBadRequest, "BadDigest", "The SHA256 you specified did not match the calculated checksum.", key)
+			return
+		}
+		body = io.NopCloser(bytes.NewReader(data))
+	}
+	size, etag, err := b.writeObject(filePath, body)
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+		return
+	}
+	b.setAttributes(key, unzip.ObjectAttributes{
+		ContentType:  r.Header.Get("Content-Type"),
+		CacheControl: r.Header.Get("Cache-Control"),
+	})
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+	slog.Debug("Stored object", "bucket", b.cfg.bucket, "key", key, "size", size)
+
+	b.objectCreated(key, size)
+}
+
+// objectCreated notifies the unzip function of uploads/*.zip and of the
+// markers that complete delta uploads, as the apps bucket notifications do.
+// Like S3 it does so after the write has been acknowledged.
+func (b *bucket) objectCreated(key string, size int64) {
+	if !strings.HasPrefix(key, "uploads/") || !(strings.HasSuffix(key, ".zip") || strings.HasSuffix(key, ".complete")) {
+		return
+	}
+	go func() {
+		if err := b.notify(context.Background(), key, size); err != nil {
+			slog.Error("Unzip handler failed", "key", key, "error", err)
+		}
+	}()
+}
+
+// writeObject stores body at filePath through a temporary file so readers
+// never see a partial object, and returns its size and MD5 ETag.
+func (b *bucket) writeObject(filePath string, body io.Reader) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (b *bucket) setAttributes(key string, attributes unzip.ObjectAttributes) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if attributes == (unzip.ObjectAttributes{}) {
+		delete(b.attributes, key)
+	} else {
+		b.attributes[key] = attributes
+	}
+}
+
+// objectAttributes returns the attributes the object was stored with. The
+// content type falls back to the extension for objects written before the
+// server started or uploaded without one.
+func (b *bucket) objectAttributes(key string) unzip.ObjectAttributes {
+	b.mu.Lock()
+	attributes := b.attributes[key]
+	b.mu.Unlock()
+	if attributes.ContentType != "" {
+		return attributes
+	}
+	ext := strings.ToLower(path.Ext(key))
+	attributes.ContentType = localContentTypes[ext]
+	if attributes.ContentType == "" {
+		attributes.ContentType = mime.TypeByExtension(ext)
+	}
+	if attributes.ContentType == "" {
+		attributes.ContentType = "application/octet-stream"
+	}
+	return attributes
+}
+
+// urlEncodeKey form encodes each segment of a key the way S3 event
+// notifications do.
+func urlEncodeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.QueryEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+/*****************************************************/
+// Handler blob stores
+/*****************************************************/
+// The bucket also serves as the unzip function's blob store, and through
+// publisherBlobs as the publisher's, so the handlers read and write the same
+// files the browser does.
+
+func (b *bucket) checkBucket(name string) error {
+	if name != b.cfg.bucket {
+		return fmt.Errorf("NoSuchBucket: bucket %s does not exist locally", name)
+	}
+	return nil
+}
+
+// PresignPut returns a plain object URL. The local bucket ignores signatures,
+// so only the checksum header a client sends is checked.
+func (b *bucket) PresignPut(ctx context.Context, key, contentType string, size int64, sha256Hex string) (string, error) {
+	return b.cfg.publicUrl + "/" + b.cfg.bucket + "/" + key, nil
+}
+
+func (b *bucket) GetObject(ctx context.Context, bucketName, key string) ([]byte, error) {
+	if err := b.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	filePath, ok := b.objectPath(key)
+	if !ok {
+		return nil, fmt.Errorf("invalid object key %q", key)
+	}
+	body, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = unzip.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucketName, err)
+	}
+	return body, nil
+}
+
+func (b *bucket) ObjectExists(ctx context.Context, bucketName, key string) (bool, error) {
+	if err := b.checkBucket(bucketName); err != nil {
+		return false, err
+	}
+	filePath, ok := b.objectPath(key)
+	if !ok {
+		return false, fmt.Errorf("invalid object key %q", key)
+	}
+	_, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *bucket) PutObject(ctx context.Context, bucketName, key string, body []byte, attributes unzip.ObjectAttributes) error {
+	if err := b.checkBucket(bucketName); err != nil {
+		return err
+	}
+	filePath, ok := b.objectPath(key)
+	if !ok {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	if attributes.SHA256 != "" {
+		// S3 rejects a body that does not match its checksum
+		if digest := sha256.Sum256(body); hex.EncodeToString(digest[:]) != attributes.SHA256 {
+			return fmt.Errorf("BadDigest: SHA-256 of %s does not match", key)
+		}
+	}
+	if _, _, err := b.writeObject(filePath, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	b.setAttributes(key, attributes)
+	return nil
+}
+
+func (b *bucket) DeleteObject(ctx context.Context, bucketName, key string) error {
+	if err := b.checkBucket(bucketName); err != nil {
+		return err
+	}
+	filePath, ok := b.objectPath(key)
+	if !ok {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return b.removeObject(key, filePath)
+}
+
+// removeObject deletes an object. Like S3, deleting a missing key succeeds.
+func (b *bucket) removeObject(key, filePath string) error {
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	b.setAttributes(key, unzip.ObjectAttributes{})
+	return nil
+}
+
+// publisherBlobs adapts the bucket to the publisher's blob store, whose calls
+// name no bucket because the publisher only uses the apps bucket. Listing and
+// deleting are held to publisherPolicy.
+type publisherBlobs struct {
+	*bucket
+}
+
+// s3Policy lists the key prefixes a role may list and delete under.
+type s3Policy struct {
+	listPrefixes   []string
+	deletePrefixes []string
+}
+
+// publisherPolicy mirrors the prefixes publisher_s3_policy in main.tf grants,
+// so a call the publisher role would be denied in AWS is denied locally too.
+// TestPublisherPolicyMatchesTerraform keeps the two in step.
+var publisherPolicy = s3Policy{
+	listPrefixes:   []string{"app/", "blobs/", "blobrefs/", "publishers/", "uploads/"},
+	deletePrefixes: []string{"app/", "blobs/", "blobrefs/", "publishers/"},
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p publisherBlobs) GetObject(ctx context.Context, key string) ([]byte, error) {
+	body, err := p.bucket.GetObject(ctx, p.cfg.bucket, key)
+	if errors.Is(err, unzip.ErrObjectNotFound) {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, publisher.ErrObjectNotFound)
+	}
+	return body, err
+}
+
+func (p publisherBlobs) GetObjectWithETag(ctx context.Context, key string) ([]byte, string, error) {
+	body, err := p.GetObject(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, fmt.Sprintf(`"%x"`, md5.Sum(body)), nil
+}
+
+// PutObjectIfMatch checks the object's MD5 ETag like S3 checks If-Match, and
+// that it does not exist like If-None-Match: *.
+func (p publisherBlobs) PutObjectIfMatch(ctx context.Context, key string, body []byte, contentType, cacheControl, etag string) error {
+	p.conditionalMu.Lock()
+	defer p.conditionalMu.Unlock()
+	_, current, err := p.GetObjectWithETag(ctx, key)
+	if err != nil && !errors.Is(err, publisher.ErrObjectNotFound) {
+		return err
+	}
+	if current != etag {
+		return fmt.Errorf("PreconditionFailed: %s changed: %w", key, publisher.ErrPreconditionFailed)
+	}
+	return p.PutObject(ctx, key, body, contentType, cacheControl)
+}
+
+func (p publisherBlobs) PutObject(ctx context.Context, key string, body []byte, contentType, cacheControl string) error {
+	if err := p.bucket.PutObject(ctx, p.cfg.bucket, key, body, unzip.ObjectAttributes{ContentType: contentType, CacheControl: cacheControl}); err != nil {
+		return err
+	}
+	p.objectCreated(key, int64(len(body)))
+	return nil
+}
+
+func (p publisherBlobs) ListObjects(ctx context.Context, prefix string) ([]publisher.ObjectInfo, error) {
+	if !hasAnyPrefix(prefix, publisherPolicy.listPrefixes) {
+		return nil, fmt.Errorf("AccessDenied: the publisher may not list objects under %q", prefix)
+	}
+	var objects []publisher.ObjectInfo
+	err := filepath.WalkDir(p.dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == multipartDir {
+			return fs.SkipDir
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, publisher.ObjectInfo{Key: key, LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+func (p publisherBlobs) DeleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if !hasAnyPrefix(key, publisherPolicy.deletePrefixes) {
+			return fmt.Errorf("AccessDenied: the publisher may not delete %s", key)
+		}
+	}
+	for _, key := range keys {
+		if err := p.DeleteObject(ctx, p.cfg.bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*****************************************************/
+// Multipart uploads
+/*****************************************************/
+// Parts of multipart uploads are kept under .multipart/{uploadId}/ in the
+// bucket directory, next to a file naming the key they are uploaded to, until
+// the upload is completed. Uploads that are never completed stay there.
+
+const multipartDir = ".multipart"
+
+func (b *bucket) multipartPath(uploadId string, elem ...string) (string, bool) {
+	if uploadId == "" || strings.ContainsAny(uploadId, `/\.`) {
+		return "", false
+	}
+	return filepath.Join(append([]string{b.dir, multipartDir, uploadId}, elem...)...), true
+}
+
+// multipartKey returns the key a multipart upload is for, or an error
+// wrapping publisher.ErrUploadNotFound.
+func (b *bucket) multipartKey(uploadId string) (string, error) {
+	keyPath, ok := b.multipartPath(uploadId, "key")
+	if !ok {
+		return "", fmt.Errorf("invalid upload id %q: %w", uploadId, publisher.ErrUploadNotFound)
+	}
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("upload %s: %w", uploadId, publisher.ErrUploadNotFound)
+	}
+	return string(key), err
+}
+
+func (b *bucket) uploadPart(w http.ResponseWriter, r *http.Request, key string) {
+	uploadId := r.URL.Query().Get("uploadId")
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000", key)
+		return
+	}
+	if uploadKey, err := b.multipartKey(uploadId); err != nil || uploadKey != key {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.", key)
+		return
+	}
+	partPath, _ := b.multipartPath(uploadId, strconv.Itoa(partNumber))
+	size, etag, err := b.writeObject(partPath, r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), key)
+		return
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+	slog.Debug("Stored part", "bucket", b.cfg.bucket, "key", key, "part", partNumber, "size", size)
+}
+
+func (p publisherBlobs) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	if _, ok := p.objectPath(key); !ok {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadId := hex.EncodeToString(id)
+	keyPath, _ := p.multipartPath(uploadId, "key")
+	if _, _, err := p.writeObject(keyPath, strings.NewReader(key)); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload of %s: %w", key, err)
+	}
+	return uploadId, nil
+}
+
+// PresignUploadPart returns a plain part URL. The local bucket ignores
+// signatures.
+func (p publisherBlobs) PresignUploadPart(ctx context.Context, key, uploadId string, partNumber int, size int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadId}}
+	return p.cfg.publicUrl + "/" + p.cfg.bucket + "/" + key + "?" + query.Encode(), nil
+}
+
+func (p publisherBlobs) ListParts(ctx context.Context, key, uploadId string) ([]publisher.UploadedPart, error) {
+	if uploadKey, err := p.multipartKey(uploadId); err != nil || uploadKey != key {
+		return nil, fmt.Errorf("failed to list parts of %s: %w", key, publisher.ErrUploadNotFound)
+	}
+	dir, _ := p.multipartPath(uploadId)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts of %s: %w", key, err)
+	}
+	var parts []publisher.UploadedPart
+	for _, entry := range entries {
+		partNumber, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part %d of %s: %w", partNumber, key, err)
+		}
+		parts = append(parts, publisher.UploadedPart{
+			PartNumber: partNumber,
+			ETag:       fmt.Sprintf(`"%x"`, md5.Sum(body)),
+			Size:       int64(len(body)),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload joins the listed parts into the object and notifies
+// unzip like a PUT does. Like S3 it rejects parts whose ETag differs.
+func (p publisherBlobs) CompleteMultipartUpload(ctx context.Context, key, uploadId string, parts []publisher.UploadedPart) error {
+	stored, err := p.ListParts(ctx, key, uploadId)
+	if err != nil {
+		return err
+	}
+	etags := make(map[int]string, len(stored))
+	for _, part := range stored {
+		etags[part.PartNumber] = part.ETag
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		if etags[part.PartNumber] != part.ETag {
+			return fmt.Errorf("InvalidPart: part %d of %s was not uploaded", part.PartNumber, key)
+		}
+		partPath, _ := p.multipartPath(uploadId, strconv.Itoa(part.PartNumber))
+		file, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	filePath, _ := p.objectPath(key)
+	size, _, err := p.writeObject(filePath, io.MultiReader(readers...))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+	p.setAttributes(key, unzip.ObjectAttributes{})
+	dir, _ := p.multipartPath(uploadId)
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Warn("Failed to remove parts of completed upload", "key", key, "error", err)
+	}
+	p.objectCreated(key, size)
+	return nil
+}
@@ -0,0 +1,1027 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	publisher "miniapps-lambda-publisher"
+	subscriber "miniapps-lambda-subscriber"
+	unzip "miniapps-lambda-unzip"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+const (
+	exampleAppDir = "../../../example-mini-app-1"
+	// The example ships a 192px icon; unzip generates the 512px and the
+	// maskable one
+	exampleGeneratedIcons = 2
+	testRequestId         = "req-e2e"
+	testPublisherId       = "publisher-1"
+	testSubscriberId      = "subscriber-1"
+)
+
+var (
+	publisherClaims = map[string]string{
+		"sub":            testPublisherId,
+		"username":       testPublisherId,
+		"email":          "publisher@example.com",
+		"cognito:groups": "[Publisher Subscriber]",
+		"iss":            "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_test",
+	}
+	subscriberClaims = map[string]string{
+		"sub":            testSubscriberId,
+		"username":       testSubscriberId,
+		"email":          "subscriber@example.com",
+		"cognito:groups": "[Subscriber]",
+		"iss":            "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_test",
+	}
+)
+
+/*****************************************************/
+// Harness
+/*****************************************************/
+// testHarness wires the handlers the way the devserver does, except that the
+// metadata the unzip handler sends is captured so each step of the pipeline
+// can be driven and inspected on its own.
+type testHarness struct {
+	t        *testing.T
+	cfg      config
+	backend  *backend
+	handlers map[string]apiHandler
+	queue    *unzip.MemoryMetadataQueue
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+	cfg := config{
+		publicUrl: "http://devserver.test",
+		stage:     "default",
+		bucket:    "miniapps-test-apps",
+		queueName: "miniapps-test-app-metadata",
+		region:    "us-east-1",
+	}
+	t.Setenv("apps_bucket", cfg.bucket)
+	t.Setenv("assets_base_url", cfg.publicUrl+"/"+cfg.bucket)
+
+	b := newBackend(cfg, t.TempDir())
+	queue := unzip.NewMemoryMetadataQueue()
+	b.unzip = unzip.NewHandler(unzip.Services{Blobs: b.bucket, Metadata: queue, AppsBucket: cfg.bucket})
+	return &testHarness{t: t, cfg: cfg, backend: b, handlers: functionHandlers(b), queue: queue}
+}
+
+func newTestRequest(method, path string, claims map[string]string, body string) events.APIGatewayV2HTTPRequest {
+	rawPath, rawQuery, _ := strings.Cut(path, "?")
+	query := make(map[string]string)
+	values, _ := url.ParseQuery(rawQuery)
+	for name := range values {
+		query[name] = values.Get(name)
+	}
+	var request events.APIGatewayV2HTTPRequest
+	request.Version = "2.0"
+	request.RawPath = "/default" + rawPath
+	request.RawQueryString = rawQuery
+	request.QueryStringParameters = query
+	request.Body = body
+	request.Headers = map[string]string{"content-type": "application/json"}
+	request.RequestContext.Stage = "default"
+	request.RequestContext.RequestID = testRequestId
+	request.RequestContext.HTTP.Method = method
+	request.RequestContext.HTTP.Path = request.RawPath
+	request.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: claims},
+	}
+	return request
+}
+
+// call sends a request to the function API Gateway routes it to.
+func (h *testHarness) call(function string, request events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	h.t.Helper()
+	ctx := h.backend.lambdaContext(context.Background(), function)
+	response, err := h.handlers[function](ctx, request)
+	if err != nil {
+		h.t.Fatalf("%s %s returned an error: %v", request.RequestContext.HTTP.Method, request.RawPath, err)
+	}
+	return response
+}
+
+func (h *testHarness) callOK(function string, request events.APIGatewayV2HTTPRequest, out interface{}) {
+	h.t.Helper()
+	response := h.call(function, request)
+	if response.StatusCode != 200 {
+		h.t.Fatalf("%s %s: expected 200, got %d: %s", request.RequestContext.HTTP.Method, request.RawPath, response.StatusCode, response.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal([]byte(response.Body), out); err != nil {
+			h.t.Fatalf("%s %s: invalid response body: %v: %s", request.RequestContext.HTTP.Method, request.RawPath, err, response.Body)
+		}
+	}
+}
+
+// buildExampleApp zips example-mini-app-1. The example ships the script that
+// generates its model rather than the model, so a placeholder is added.
+func buildExampleApp(t *testing.T) ([]byte, []publisher.File) {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	var files []publisher.File
+	add := func(name string, body []byte) {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, publisher.File{Filename: name, Size: len(body), Type: testContentType(name)})
+	}
+
+	err := filepath.WalkDir(exampleAppDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(exampleAppDir, path)
+		if err != nil {
+			return err
+		}
+		add(filepath.ToSlash(name), body)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read example app: %v", err)
+	}
+	add("model.onnx", exampleModel())
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), files
+}
+
+// exampleModel builds an ONNX model with the interface of the example app's
+// shape classifier: a 1x1x56x56 image in, scores for 6 shapes out. It has no
+// weights; only unzip's inspection reads it.
+func exampleModel() []byte {
+	message := func(b []byte, num protowire.Number, fields ...[]byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, bytes.Join(fields, nil))
+	}
+	text := func(num protowire.Number, value string) []byte {
+		b := protowire.AppendTag(nil, num, protowire.BytesType)
+		return protowire.AppendString(b, value)
+	}
+	number := func(num protowire.Number, value int) []byte {
+		b := protowire.AppendTag(nil, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(value))
+	}
+	// ValueInfoProto of a float tensor
+	tensor := func(num protowire.Number, name string, dims ...int) []byte {
+		var shape []byte
+		for _, dim := range dims {
+			shape = message(shape, 1, number(1, dim))
+		}
+		tensorType := append(number(1, 1), message(nil, 2, shape)...)
+		return message(nil, num, text(1, name), message(nil, 2, message(nil, 1, tensorType)))
+	}
+
+	var graph []byte
+	for _, op := range []string{"Conv", "Relu", "MaxPool", "Flatten", "Gemm"} {
+		graph = message(graph, 1, text(4, op))
+	}
+	graph = append(graph, tensor(11, "input", 1, 1, 56, 56)...)
+	graph = append(graph, tensor(12, "output", 1, 6)...)
+	return bytes.Join([][]byte{
+		number(1, 8),                   // ir_version
+		message(nil, 7, graph),         // graph
+		message(nil, 8, number(2, 17)), // opset_import
+	}, nil)
+}
+
+// testContentType mirrors the types the client declares in publish requests.
+func testContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".html":
+		return "text/html"
+	case ".js":
+		return "application/javascript"
+	case ".json":
+		return "application/json"
+	case ".png":
+		return "image/png"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func readExampleManifest(t *testing.T) publisher.Manifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(exampleAppDir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest publisher.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("example manifest is invalid: %v", err)
+	}
+	return manifest
+}
+
+func encodeJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+/*****************************************************/
+// Publish -> unzip -> ingest -> catalog
+/*****************************************************/
+func TestPublishUnzipIngestCatalog(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.callOK("publisher", newTestRequest("PUT", "/publishers/me", publisherClaims,
+		`{"display_name":"Example Publisher","website":"https://example.com"}`), nil)
+
+	// 1. Publish returns a presigned URL for the bundle
+	bundle, files := buildExampleApp(t)
+	publishReq := publisher.PublishRequest{
+		Manifest:     readExampleManifest(t),
+		Files:        files,
+		Entrypoint:   "index.html",
+		VersionNotes: "First release",
+		Bundle:       bundleOf(bundle),
+		Listing: &publisher.StoreListing{
+			Description: "Draw a shape and the model **names it**.",
+			InputTypes:  []string{"camera", "file"},
+			ModelCard:   &publisher.ModelCard{IntendedUse: "Recognizing simple drawn shapes", License: "MIT"},
+		},
+	}
+	var published struct {
+		PresignedUrl string            `json:"presigned_url"`
+		Headers      map[string]string `json:"headers"`
+	}
+	h.callOK("publisher", newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, encodeJSON(t, publishReq)), &published)
+	if published.Headers["Content-Type"] != "application/zip" || published.Headers["x-amz-checksum-sha256"] == "" {
+		t.Errorf("the upload must be sent as a zip with its checksum, got headers %v", published.Headers)
+	}
+
+	prefix := h.cfg.publicUrl + "/" + h.cfg.bucket + "/"
+	if !strings.HasPrefix(published.PresignedUrl, prefix) {
+		t.Fatalf("presigned URL %q is not in the apps bucket", published.PresignedUrl)
+	}
+	uploadKey := strings.TrimPrefix(published.PresignedUrl, prefix)
+	if !strings.HasPrefix(uploadKey, "uploads/shape/1.0.0/"+testPublisherId+"/") || !strings.HasSuffix(uploadKey, ".zip") {
+		t.Fatalf("unexpected upload key %q", uploadKey)
+	}
+
+	// 2. The client uploads the zip to that URL
+	ctx := context.Background()
+	if err := h.backend.bucket.PutObject(ctx, h.cfg.bucket, uploadKey, bundle, unzip.ObjectAttributes{ContentType: "application/zip"}); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	// 3. S3 notifies the unzip function
+	var record events.S3EventRecord
+	record.EventSource = "aws:s3"
+	record.EventName = "ObjectCreated:Put"
+	record.EventTime = time.Now().UTC()
+	record.S3.Bucket.Name = h.cfg.bucket
+	record.S3.Object.Key = urlEncodeKey(uploadKey)
+	record.S3.Object.Size = int64(len(bundle))
+	if err := h.backend.unzip.HandleRequest(h.backend.lambdaContext(ctx, "unzip"), events.S3Event{Records: []events.S3EventRecord{record}}); err != nil {
+		t.Fatalf("unzip failed: %v", err)
+	}
+
+	// Each upload is extracted into its own release prefix, apart from the
+	// model, which is stored once by content under blobs/
+	releaseId := strings.TrimSuffix(path.Base(uploadKey), ".zip")
+	releasePath := "app/shape/releases/" + releaseId + "/"
+	for _, file := range files {
+		if file.Filename == "model.onnx" {
+			continue
+		}
+		if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, releasePath+file.Filename); err != nil {
+			t.Errorf("extracted file %s missing: %v", file.Filename, err)
+		}
+	}
+	if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, uploadKey); !errors.Is(err, unzip.ErrObjectNotFound) {
+		t.Errorf("uploaded zip %s was not moved out of uploads/: %v", uploadKey, err)
+	}
+	archiveKey := "archive/" + strings.TrimPrefix(uploadKey, "uploads/")
+	if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, archiveKey); err != nil {
+		t.Errorf("uploaded zip was not archived at %s: %v", archiveKey, err)
+	}
+
+	messages := h.queue.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 metadata message, got %d", len(messages))
+	}
+	metadata := messages[0]
+	if metadata.AppSlug != "shape" || metadata.VersionId != "1.0.0" || metadata.PublisherId != testPublisherId || metadata.ReleaseId != releaseId {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if metadata.Entrypoint != "index.html" || metadata.ServiceWorker != "sw.js" {
+		t.Errorf("expected the shell's entrypoint and service worker, got %q and %q", metadata.Entrypoint, metadata.ServiceWorker)
+	}
+	if len(metadata.Models) != 1 || metadata.Models[0].Name != "model" || metadata.Models[0].Inputs[0].Name != "input" || len(metadata.Models[0].UnsupportedOperators) != 0 {
+		t.Errorf("unexpected model inspection: %+v", metadata.Models)
+	}
+	if !metadata.ManifestFound || len(metadata.ProcessedFiles) != len(files)+exampleGeneratedIcons {
+		t.Errorf("expected manifest and %d files, got manifest=%v files=%v", len(files)+exampleGeneratedIcons, metadata.ManifestFound, metadata.ProcessedFiles)
+	}
+	var modelBlob string
+	for _, entry := range metadata.Files {
+		if entry.Path == "model.onnx" {
+			modelBlob = entry.Blob
+		}
+	}
+	if !strings.HasPrefix(modelBlob, "blobs/sha256/") {
+		t.Errorf("model was not stored as a shared blob: %q", modelBlob)
+	} else if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, modelBlob); err != nil {
+		t.Errorf("model blob missing: %v", err)
+	}
+
+	// Nothing is live until ingest has recorded the app
+	if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, "app/shape/current.json"); !errors.Is(err, unzip.ErrObjectNotFound) {
+		t.Errorf("release went live before ingest: %v", err)
+	}
+
+	// 4. SQS delivers the metadata to the publisher's ingest, which points
+	// the app at the new release
+	body := encodeJSON(t, metadata)
+	if err := h.backend.deliverMetadata(ctx, body); err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	pointerBody, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, "app/shape/current.json")
+	if err != nil {
+		t.Fatalf("release pointer missing: %v", err)
+	}
+	var pointer publisher.ReleasePointer
+	if err := json.Unmarshal(pointerBody, &pointer); err != nil {
+		t.Fatal(err)
+	}
+	if pointer.ReleaseId != releaseId || pointer.Path != "/"+releasePath {
+		t.Errorf("unexpected release pointer: %+v", pointer)
+	}
+	if len(pointer.Files) != len(files)+exampleGeneratedIcons {
+		t.Errorf("content manifest lists %d files, want %d", len(pointer.Files), len(files)+exampleGeneratedIcons)
+	}
+
+	// The browser gets each file, at the URL the manifest gives, with its
+	// cache policy and an ETag
+	urls := make(map[string]string, len(pointer.Files))
+	for _, file := range pointer.Files {
+		urls[file.Path] = pointer.Path + file.Path
+		if file.Url != "" {
+			urls[file.Path] = file.Url
+		}
+	}
+	for _, test := range []struct{ file, cacheControl string }{
+		{"sw.js", "no-cache"},
+		{"index.html", "no-cache"},
+		{"model.onnx", "public, max-age=31536000, immutable"},
+	} {
+		recorder := httptest.NewRecorder()
+		h.backend.bucket.ServeHTTP(recorder, httptest.NewRequest("GET", "/"+h.cfg.bucket+urls[test.file], nil))
+		if got := recorder.Header().Get("Cache-Control"); recorder.Code != 200 || got != test.cacheControl {
+			t.Errorf("GET %s: status %d, Cache-Control %q, want %q", test.file, recorder.Code, got, test.cacheControl)
+		}
+		if recorder.Header().Get("ETag") == "" {
+			t.Errorf("GET %s: no ETag", test.file)
+		}
+	}
+
+	// 5. The app is in the catalog with its publisher's name
+	var catalog struct {
+		Apps []struct {
+			AppId         string `json:"appId"`
+			AppSlug       string `json:"appSlug"`
+			AppName       string `json:"appName"`
+			PublisherId   string `json:"publisherId"`
+			PublisherName string `json:"publisherName"`
+			Models        []struct {
+				Inputs []struct {
+					Shape []string `json:"shape"`
+				} `json:"inputs"`
+			} `json:"models"`
+			Thumbnails []struct {
+				Url   string `json:"url"`
+				Width int    `json:"width"`
+			} `json:"thumbnails"`
+		} `json:"apps"`
+		Count int `json:"count"`
+	}
+	h.callOK("subscriber", newTestRequest("GET", "/apps", subscriberClaims, ""), &catalog)
+	if catalog.Count != 1 || len(catalog.Apps) != 1 {
+		t.Fatalf("expected 1 app in the catalog, got %d", catalog.Count)
+	}
+	app := catalog.Apps[0]
+	if app.AppSlug != "shape" || app.AppName != publishReq.Manifest.Name || app.PublisherId != testPublisherId {
+		t.Errorf("unexpected listing: %+v", app)
+	}
+	if len(app.Models) != 1 || len(app.Models[0].Inputs) != 1 || strings.Join(app.Models[0].Inputs[0].Shape, "x") != "1x1x56x56" {
+		t.Errorf("expected the model's input shape in the listing, got %+v", app.Models)
+	}
+	if len(app.Thumbnails) != 1+exampleGeneratedIcons || app.Thumbnails[0].Url != "/"+releasePath+"icon.png" || app.Thumbnails[1].Width != 512 {
+		t.Errorf("expected the icon and the generated 512px icons as thumbnails, got %+v", app.Thumbnails)
+	}
+	if app.PublisherName != "Example Publisher" {
+		t.Errorf("expected publisher name to be attached, got %q", app.PublisherName)
+	}
+
+	// The publisher's page is public, so it needs no subscriber group
+	var page struct {
+		Publisher struct {
+			DisplayName string `json:"displayName"`
+		} `json:"publisher"`
+		Count int `json:"count"`
+	}
+	anonymous := newTestRequest("GET", "/publishers/"+testPublisherId, nil, "")
+	anonymous.RequestContext.Authorizer = nil
+	h.callOK("subscriber", anonymous, &page)
+	if page.Publisher.DisplayName != "Example Publisher" || page.Count != 1 {
+		t.Errorf("unexpected publisher page: %+v", page)
+	}
+
+	// Its store page has the listing of the publish request and the changelog
+	var detail struct {
+		App struct {
+			AppId           string   `json:"appId"`
+			PublisherName   string   `json:"publisherName"`
+			LongDescription string   `json:"longDescription"`
+			InputTypes      []string `json:"inputTypes"`
+			ModelCard       struct {
+				IntendedUse string `json:"intendedUse"`
+				License     string `json:"license"`
+			} `json:"modelCard"`
+			Changelog []struct {
+				VersionId    string `json:"versionId"`
+				VersionNotes string `json:"versionNotes"`
+			} `json:"changelog"`
+		} `json:"app"`
+	}
+	h.callOK("subscriber", newTestRequest("GET", "/apps/"+app.AppId, subscriberClaims, ""), &detail)
+	if detail.App.AppId != app.AppId || detail.App.PublisherName != "Example Publisher" ||
+		detail.App.LongDescription != publishReq.Listing.Description || strings.Join(detail.App.InputTypes, ",") != "camera,file" {
+		t.Errorf("unexpected app detail: %+v", detail.App)
+	}
+	if detail.App.ModelCard.IntendedUse != "Recognizing simple drawn shapes" || detail.App.ModelCard.License != "MIT" {
+		t.Errorf("expected the model card, got %+v", detail.App.ModelCard)
+	}
+	if len(detail.App.Changelog) != 1 || detail.App.Changelog[0].VersionId != "1.0.0" || detail.App.Changelog[0].VersionNotes != "First release" {
+		t.Errorf("expected the version notes as the changelog, got %+v", detail.App.Changelog)
+	}
+
+	// 6. A subscriber subscribes, and the app shows up in their subscriptions
+	var subscribed map[string]string
+	h.callOK("subscriber", newTestRequest("POST", "/subscribe?appID="+app.AppId, subscriberClaims, ""), &subscribed)
+	if subscribed["message"] != "Successfully subscribed to app" {
+		t.Errorf("unexpected subscribe response: %v", subscribed)
+	}
+	if response := h.call("subscriber", newTestRequest("POST", "/subscribe?appID="+app.AppId, subscriberClaims, "")); response.StatusCode != 409 {
+		t.Errorf("expected 409 for a repeated subscription, got %d", response.StatusCode)
+	}
+
+	catalog.Apps = nil
+	h.callOK("subscriber", newTestRequest("GET", "/apps?getSubscribed=true", subscriberClaims, ""), &catalog)
+	if catalog.Count != 1 || catalog.Apps[0].AppId != app.AppId {
+		t.Errorf("expected the subscribed app, got %+v", catalog.Apps)
+	}
+	catalog.Apps = nil
+	h.callOK("subscriber", newTestRequest("GET", "/apps?getSubscribed=true", publisherClaims, ""), &catalog)
+	if catalog.Count != 0 {
+		t.Errorf("expected no subscriptions for another user, got %+v", catalog.Apps)
+	}
+}
+
+// TestDeltaRepublishUploadsOnlyChangedFiles republishes the example app with
+// a changed app.js as a delta upload: only app.js is uploaded, and the new
+// release serves it next to the files reused from the first one.
+func TestDeltaRepublishUploadsOnlyChangedFiles(t *testing.T) {
+	h := newTestHarness(t)
+	ctx := context.Background()
+	notified := make(chan string, 1)
+	h.backend.bucket.notify = func(ctx context.Context, key string, size int64) error {
+		notified <- key
+		return nil
+	}
+	publish := func(version, requestId string, files []publisher.File, bundle *publisher.Bundle, out interface{}) {
+		t.Helper()
+		request := newTestRequest("POST", "/publish/shape/version/"+version, publisherClaims, encodeJSON(t, publisher.PublishRequest{
+			Manifest:     readExampleManifest(t),
+			Files:        files,
+			Entrypoint:   "index.html",
+			VersionNotes: "Release " + version,
+			Bundle:       bundle,
+		}))
+		request.RequestContext.RequestID = requestId
+		h.callOK("publisher", request, out)
+	}
+	extractAndIngest := func(key string) {
+		t.Helper()
+		if err := h.backend.notifyUnzip(ctx, key, 0); err != nil {
+			t.Fatalf("unzip failed: %v", err)
+		}
+		messages := h.queue.Messages()
+		if err := h.backend.deliverMetadata(ctx, encodeJSON(t, messages[len(messages)-1])); err != nil {
+			t.Fatalf("ingest failed: %v", err)
+		}
+	}
+
+	// Version 1.0.0 is published as a whole zip
+	bundle, files := buildExampleApp(t)
+	var published struct {
+		PresignedUrl string `json:"presigned_url"`
+	}
+	publish("1.0.0", "req-v1", files, bundleOf(bundle), &published)
+	uploadKey := strings.TrimPrefix(published.PresignedUrl, h.cfg.publicUrl+"/"+h.cfg.bucket+"/")
+	if err := h.backend.bucket.PutObject(ctx, h.cfg.bucket, uploadKey, bundle, unzip.ObjectAttributes{ContentType: "application/zip"}); err != nil {
+		t.Fatal(err)
+	}
+	extractAndIngest(uploadKey)
+
+	// Version 1.0.1 only changes app.js
+	zipReader, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[file.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	bodies["app.js"] = append(bodies["app.js"], []byte("\nconsole.log('1.0.1');\n")...)
+	for i := range files {
+		digest := sha256.Sum256(bodies[files[i].Filename])
+		files[i].Size = len(bodies[files[i].Filename])
+		files[i].SHA256 = hex.EncodeToString(digest[:])
+	}
+
+	var delta struct {
+		UploadId string                 `json:"upload_id"`
+		Uploads  []publisher.FileUpload `json:"uploads"`
+		Reused   int                    `json:"reused_files"`
+	}
+	publish("1.0.1", "req-v2", files, nil, &delta)
+	if len(delta.Uploads) != 1 || delta.Uploads[0].Filename != "app.js" || delta.Reused != len(files)-1 {
+		t.Fatalf("expected to upload only app.js, got %+v", delta)
+	}
+
+	complete := newTestRequest("POST", "/publish/shape/version/1.0.1/uploads/"+delta.UploadId+"/complete", publisherClaims, "")
+	if response := h.call("publisher", complete); response.StatusCode != 400 || !strings.Contains(response.Body, "app.js") {
+		t.Errorf("completing before the upload: got %d %s, want 400 naming app.js", response.StatusCode, response.Body)
+	}
+
+	// The client uploads app.js to its presigned URL, which only accepts the
+	// declared content
+	uploadPath := strings.TrimPrefix(delta.Uploads[0].PresignedUrl, h.cfg.publicUrl)
+	upload := func(body []byte) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("PUT", uploadPath, bytes.NewReader(body))
+		for name, value := range delta.Uploads[0].Headers {
+			request.Header.Set(name, value)
+		}
+		h.backend.bucket.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := upload([]byte("console.log('tampered')")); code != 400 {
+		t.Errorf("upload of other content: got %d, want 400", code)
+	}
+	if code := upload(bodies["app.js"]); code != 200 {
+		t.Fatalf("upload of app.js failed: %d", code)
+	}
+
+	if response := h.call("publisher", complete); response.StatusCode != 202 {
+		t.Fatalf("complete: got %d %s, want 202", response.StatusCode, response.Body)
+	}
+	select {
+	case key := <-notified:
+		extractAndIngest(key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("completing the upload did not notify unzip")
+	}
+
+	pointerBody, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, "app/shape/current.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pointer publisher.ReleasePointer
+	if err := json.Unmarshal(pointerBody, &pointer); err != nil {
+		t.Fatal(err)
+	}
+	if pointer.ReleaseId != "req-v2" || len(pointer.Files) != len(files)+exampleGeneratedIcons {
+		t.Fatalf("unexpected release pointer: %+v", pointer)
+	}
+	for _, name := range []string{"app.js", "index.html"} {
+		body, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, strings.TrimPrefix(pointer.Path, "/")+name)
+		if err != nil || !bytes.Equal(body, bodies[name]) {
+			t.Errorf("release serves the wrong %s: %v", name, err)
+		}
+	}
+
+	// Both versions stay in the catalog, and their icons are the live release's
+	var catalog subscriber.AppListResponse
+	h.callOK("subscriber", newTestRequest("GET", "/apps", subscriberClaims, ""), &catalog)
+	if catalog.Count != 2 {
+		t.Fatalf("expected both versions in the catalog, got %+v", catalog.Apps)
+	}
+	superseded := 0
+	for _, app := range catalog.Apps {
+		if app.SupersededBy != "" {
+			superseded++
+		}
+		if len(app.Thumbnails) == 0 {
+			t.Errorf("app %s has no thumbnails", app.AppId)
+		}
+		for _, thumbnail := range app.Thumbnails {
+			if !strings.HasPrefix(thumbnail.Url, pointer.Path) {
+				t.Errorf("app %s links %s outside the live release", app.AppId, thumbnail.Url)
+			}
+		}
+	}
+	if superseded != 1 {
+		t.Errorf("expected version 1.0.0 to be superseded, got %+v", catalog.Apps)
+	}
+}
+
+// TestMultipartPublishIsExtractedOnceCompleted uploads the example app in
+// parts, resumes after the first one and completes the upload, which starts
+// unzip on the assembled zip.
+func TestMultipartPublishIsExtractedOnceCompleted(t *testing.T) {
+	h := newTestHarness(t)
+	ctx := context.Background()
+	notified := make(chan string, 1)
+	h.backend.bucket.notify = func(ctx context.Context, key string, size int64) error {
+		notified <- key
+		return nil
+	}
+
+	bundle, files := buildExampleApp(t)
+	bundle, files = addFiller(t, bundle, files, 8*1024*1024)
+
+	type part struct {
+		PartNumber   int    `json:"part_number"`
+		Size         int64  `json:"size"`
+		ETag         string `json:"etag"`
+		PresignedUrl string `json:"presigned_url"`
+	}
+	var published struct {
+		UploadId string `json:"upload_id"`
+		Parts    []part `json:"parts"`
+	}
+	h.callOK("publisher", newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, encodeJSON(t, publisher.PublishRequest{
+		Manifest:     readExampleManifest(t),
+		Files:        files,
+		Entrypoint:   "index.html",
+		VersionNotes: "Multipart release",
+		Multipart:    &publisher.MultipartRequest{Size: int64(len(bundle)), SHA256: bundleOf(bundle).SHA256},
+	})), &published)
+	if len(published.Parts) != 2 {
+		t.Fatalf("expected two parts, got %+v", published.Parts)
+	}
+
+	offset := int64(0)
+	putPart := func(p part) string {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		body := bundle[offset : offset+p.Size]
+		h.backend.bucket.ServeHTTP(recorder, httptest.NewRequest("PUT", strings.TrimPrefix(p.PresignedUrl, h.cfg.publicUrl), bytes.NewReader(body)))
+		if recorder.Code != 200 {
+			t.Fatalf("upload of part %d failed: %d", p.PartNumber, recorder.Code)
+		}
+		offset += p.Size
+		return recorder.Header().Get("ETag")
+	}
+	first := putPart(published.Parts[0])
+
+	// The client resumes and only gets a URL for the second part
+	uploadPath := "/publish/shape/version/1.0.0/uploads/" + published.UploadId
+	var resumed struct {
+		Parts []part `json:"parts"`
+	}
+	h.callOK("publisher", newTestRequest("GET", uploadPath, publisherClaims, ""), &resumed)
+	if resumed.Parts[0].ETag != first || resumed.Parts[0].PresignedUrl != "" || resumed.Parts[1].PresignedUrl == "" {
+		t.Fatalf("unexpected resumed parts: %+v", resumed.Parts)
+	}
+	second := putPart(resumed.Parts[1])
+
+	complete := encodeJSON(t, publisher.CompleteUploadRequest{Parts: []publisher.CompletedPart{
+		{PartNumber: 1, ETag: first},
+		{PartNumber: 2, ETag: second},
+	}})
+	if response := h.call("publisher", newTestRequest("POST", uploadPath+"/complete", publisherClaims, complete)); response.StatusCode != 202 {
+		t.Fatalf("complete: got %d %s, want 202", response.StatusCode, response.Body)
+	}
+	select {
+	case key := <-notified:
+		if !strings.HasSuffix(key, published.UploadId+".zip") {
+			t.Fatalf("unzip notified of %s", key)
+		}
+		if err := h.backend.notifyUnzip(ctx, key, int64(len(bundle))); err != nil {
+			t.Fatalf("unzip failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("completing the upload did not notify unzip")
+	}
+	if messages := h.queue.Messages(); len(messages) != 1 || messages[0].VersionId != "1.0.0" {
+		t.Fatalf("expected one metadata message for 1.0.0, got %+v", messages)
+	}
+}
+
+// Completing an avatar lists and deletes under publishers/, which the
+// publisher role must be granted; the bucket holds the handler to the same
+// prefixes as main.tf.
+func TestAvatarReplacesThePreviousOne(t *testing.T) {
+	h := newTestHarness(t)
+	h.callOK("publisher", newTestRequest("PUT", "/publishers/me", publisherClaims, `{"display_name":"Example Publisher"}`), nil)
+
+	uploadAvatar := func() string {
+		t.Helper()
+		var issued struct {
+			PresignedUrl string `json:"presigned_url"`
+			AvatarKey    string `json:"avatar_key"`
+		}
+		h.callOK("publisher", newTestRequest("POST", "/publishers/me/avatar", publisherClaims, `{"content_type":"image/png","size":4}`), &issued)
+		recorder := httptest.NewRecorder()
+		h.backend.bucket.ServeHTTP(recorder, httptest.NewRequest("PUT", strings.TrimPrefix(issued.PresignedUrl, h.cfg.publicUrl), strings.NewReader("\x89PNG")))
+		if recorder.Code != 200 {
+			t.Fatalf("avatar upload failed: %d", recorder.Code)
+		}
+		h.callOK("publisher", newTestRequest("PUT", "/publishers/me/avatar", publisherClaims, encodeJSON(t, publisher.AvatarCompleteRequest{AvatarKey: issued.AvatarKey})), nil)
+		return issued.AvatarKey
+	}
+	first := uploadAvatar()
+	second := uploadAvatar()
+
+	ctx := context.Background()
+	if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, first); !errors.Is(err, unzip.ErrObjectNotFound) {
+		t.Errorf("the replaced avatar %s was not deleted: %v", first, err)
+	}
+	if _, err := h.backend.bucket.GetObject(ctx, h.cfg.bucket, second); err != nil {
+		t.Errorf("the current avatar %s is missing: %v", second, err)
+	}
+}
+
+// bundleOf declares a zip's size and SHA-256.
+func bundleOf(bundle []byte) *publisher.Bundle {
+	digest := sha256.Sum256(bundle)
+	return &publisher.Bundle{Size: int64(len(bundle)), SHA256: hex.EncodeToString(digest[:])}
+}
+
+// addFiller adds an uncompressed file of size bytes the app never reads, to
+// make the bundle take several parts.
+func addFiller(t *testing.T, bundle []byte, files []publisher.File, size int) ([]byte, []publisher.File) {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range reader.File {
+		if err := archive.Copy(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: "filler.bin", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, publisher.File{Filename: "filler.bin", Size: size, Type: "application/octet-stream"})
+	return buf.Bytes(), files
+}
+
+/*****************************************************/
+// Golden error responses
+/*****************************************************/
+type goldenResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       json.RawMessage   `json:"body"`
+}
+
+func checkGolden(t *testing.T, name string, response events.APIGatewayV2HTTPResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	if err := json.Indent(&body, []byte(response.Body), "  ", "  "); err != nil {
+		t.Fatalf("response body is not JSON: %v: %s", err, response.Body)
+	}
+	got, err := json.MarshalIndent(goldenResponse{
+		StatusCode: response.StatusCode,
+		Headers:    response.Headers,
+		Body:       body.Bytes(),
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file (run go test -update): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestErrorResponsesMatchGolden(t *testing.T) {
+	validPublish := func(t *testing.T) publisher.PublishRequest {
+		bundle, files := buildExampleApp(t)
+		return publisher.PublishRequest{
+			Manifest:     readExampleManifest(t),
+			Files:        files,
+			Entrypoint:   "index.html",
+			VersionNotes: "First release",
+			Bundle:       bundleOf(bundle),
+		}
+	}
+	noGroups := map[string]string{"sub": "user-1"}
+
+	tests := []struct {
+		name     string
+		function string
+		request  func(t *testing.T) events.APIGatewayV2HTTPRequest
+		// setup requests are sent first and must succeed
+		setup []events.APIGatewayV2HTTPRequest
+	}{
+		{
+			name:     "publish_invalid_body",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, "{")
+			},
+		},
+		{
+			name:     "publish_requires_publisher_role",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("POST", "/publish/shape/version/1.0.0", subscriberClaims, encodeJSON(t, validPublish(t)))
+			},
+		},
+		{
+			name:     "publish_missing_version_notes",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				publishReq := validPublish(t)
+				publishReq.VersionNotes = ""
+				return newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, encodeJSON(t, publishReq))
+			},
+		},
+		{
+			name:     "publish_missing_service_worker",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				publishReq := validPublish(t)
+				var files []publisher.File
+				for _, file := range publishReq.Files {
+					if file.Filename != "sw.js" {
+						files = append(files, file)
+					}
+				}
+				publishReq.Files = files
+				return newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, encodeJSON(t, publishReq))
+			},
+		},
+		{
+			name:     "publish_missing_model",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				publishReq := validPublish(t)
+				var files []publisher.File
+				for _, file := range publishReq.Files {
+					if file.Filename != "model.onnx" {
+						files = append(files, file)
+					}
+				}
+				publishReq.Files = files
+				return newTestRequest("POST", "/publish/shape/version/1.0.0", publisherClaims, encodeJSON(t, publishReq))
+			},
+		},
+		{
+			name:     "publisher_route_not_found",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/publish", publisherClaims, "")
+			},
+		},
+		{
+			name:     "publisher_profile_method_not_allowed",
+			function: "publisher",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("DELETE", "/publishers/me", publisherClaims, "")
+			},
+		},
+		{
+			name:     "apps_requires_groups",
+			function: "subscriber",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/apps", noGroups, "")
+			},
+		},
+		{
+			name:     "apps_invalid_limit",
+			function: "subscriber",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/apps?limit=500", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "subscribe_missing_app_id",
+			function: "subscriber",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("POST", "/subscribe", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "subscribe_already_subscribed",
+			function: "subscriber",
+			setup:    []events.APIGatewayV2HTTPRequest{newTestRequest("POST", "/subscribe?appID=app-1", subscriberClaims, "")},
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("POST", "/subscribe?appID=app-1", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "publisher_not_found",
+			function: "subscriber",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/publishers/unknown-publisher", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "app_not_found",
+			function: "subscriber",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/apps/unknown-app", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "user_route_not_found",
+			function: "user",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("GET", "/user-roles", subscriberClaims, "")
+			},
+		},
+		{
+			name:     "user_role_invalid_body",
+			function: "user",
+			request: func(t *testing.T) events.APIGatewayV2HTTPRequest {
+				return newTestRequest("PUT", "/user-role", subscriberClaims, "not json")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			for _, request := range tt.setup {
+				h.callOK(tt.function, request, nil)
+			}
+			checkGolden(t, tt.name, h.call(tt.function, tt.request(t)))
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/google/uuid"
+)
+
+/*****************************************************/
+// API Gateway routes
+/*****************************************************/
+type apiHandler func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error)
+
+type gatewayRoute struct {
+	method   string
+	pattern  string
+	function string
+}
+
+// gatewayRoutes mirrors the aws_apigatewayv2_route resources in main.tf.
+// Routes with literal segments come before parameterised ones so the most
+// specific route wins, as it does in API Gateway.
+var gatewayRoutes = []gatewayRoute{
+	{"POST", "/publish/{app-slug}/version/{version-id}", "publisher"},
+	{"GET", "/publish/{app-slug}/version/{version-id}/uploads/{upload-id}", "publisher"},
+	{"POST", "/publish/{app-slug}/version/{version-id}/uploads/{upload-id}/complete", "publisher"},
+	{"GET", "/publishers/me", "publisher"},
+	{"PUT", "/publishers/me", "publisher"},
+	{"POST", "/publishers/me/avatar", "publisher"},
+	{"PUT", "/publishers/me/avatar", "publisher"},
+	{"GET", "/apps", "subscriber"},
+	{"GET", "/apps/{app-id}", "subscriber"},
+	{"POST", "/subscribe", "subscriber"},
+	{"GET", "/publishers/{publisher-id}", "subscriber"},
+	{"PUT", "/user-role", "user"},
+}
+
+// functionHandlers maps each function name used in gatewayRoutes to the
+// handler the backend runs for it.
+func functionHandlers(backend *backend) map[string]apiHandler {
+	return map[string]apiHandler{
+		"publisher":  invokeRawHandler(backend.publisher.HandleRequest),
+		"subscriber": backend.subscriber.HandleRequest,
+		"user":       invokeRawHandler(backend.user.HandleRequest),
+	}
+}
+
+// invokeRawHandler adapts a handler taking raw events, passing the request
+// and response through JSON like the Lambda runtime does.
+func invokeRawHandler(handler func(context.Context, json.RawMessage) (interface{}, error)) apiHandler {
+	return func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		var response events.APIGatewayV2HTTPResponse
+		event, err := json.Marshal(request)
+		if err != nil {
+			return response, err
+		}
+		result, err := handler(ctx, event)
+		if err != nil {
+			return response, err
+		}
+		body, err := json.Marshal(result)
+		if err != nil {
+			return response, err
+		}
+		err = json.Unmarshal(body, &response)
+		return response, err
+	}
+}
+
+func matchRoute(method, path string) (gatewayRoute, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range gatewayRoutes {
+		if route.method != method {
+			continue
+		}
+		patternSegments := strings.Split(strings.Trim(route.pattern, "/"), "/")
+		if len(patternSegments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, segment := range patternSegments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				params[strings.Trim(segment, "{}")] = segments[i]
+			} else if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route, params, true
+		}
+	}
+	return gatewayRoute{}, nil, false
+}
+
+/*****************************************************/
+// JWT claims
+/*****************************************************/
+// loadDefaultClaims reads the claims used for requests without a bearer token.
+func loadDefaultClaims(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("claims file must be a JSON object: %w", err)
+	}
+	return flattenClaims(raw), nil
+}
+
+// claimsFromToken decodes the payload of a bearer token without verifying its
+// signature. Any token works locally, including ones issued by Cognito.
+func claimsFromToken(authorization string) (map[string]string, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse token payload: %w", err)
+	}
+	return flattenClaims(raw), nil
+}
+
+// flattenClaims renders claim values the way the API Gateway JWT authorizer
+// does: arrays become "[a b]" and everything else its JSON text.
+func flattenClaims(raw map[string]interface{}) map[string]string {
+	claims := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			claims[key] = v
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			claims[key] = "[" + strings.Join(items, " ") + "]"
+		default:
+			encoded, _ := json.Marshal(v)
+			claims[key] = string(encoded)
+		}
+	}
+	return claims
+}
+
+/*****************************************************/
+// Gateway handler
+/*****************************************************/
+type gateway struct {
+	cfg           config
+	defaultClaims map[string]string
+	handlers      map[string]apiHandler
+}
+
+func newGateway(cfg config, defaultClaims map[string]string, backend *backend) *gateway {
+	return &gateway{cfg: cfg, defaultClaims: defaultClaims, handlers: functionHandlers(backend)}
+}
+
+func writeGatewayMessage(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stage := "$default"
+	path := r.URL.Path
+	if prefix := "/" + g.cfg.stage; strings.HasPrefix(path, prefix+"/") {
+		stage = g.cfg.stage
+		path = strings.TrimPrefix(path, prefix)
+	}
+
+	route, params, ok := matchRoute(r.Method, path)
+	if !ok {
+		writeGatewayMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	claims := g.defaultClaims
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		tokenClaims, err := claimsFromToken(authorization)
+		if err != nil {
+			slog.Warn("Rejecting request with unreadable token", "error", err)
+			writeGatewayMessage(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		claims = tokenClaims
+	}
+	// Every route in main.tf uses the Cognito JWT authorizer
+	if claims == nil {
+		writeGatewayMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeGatewayMessage(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	request := newGatewayRequest(r, route, stage, params, claims, body)
+	ctx := lambdacontext.NewContext(r.Context(), &lambdacontext.LambdaContext{
+		AwsRequestID:       uuid.NewString(),
+		InvokedFunctionArn: "arn:aws:lambda:" + g.cfg.region + ":000000000000:function:" + route.function,
+	})
+
+	start := time.Now()
+	response, err := g.handlers[route.function](ctx, request)
+	if err != nil {
+		// API Gateway hides Lambda errors behind a generic 500
+		slog.Error("Handler returned an error", "route", route.method+" "+route.pattern, "error", err)
+		writeGatewayMessage(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	slog.Info("Handled request", "function", route.function, "route", route.method+" "+route.pattern,
+		"status", response.StatusCode, "duration", time.Since(start))
+	writeGatewayResponse(w, response)
+}
+
+func newGatewayRequest(r *http.Request, route gatewayRoute, stage string, params, claims map[string]string, body []byte) events.APIGatewayV2HTTPRequest {
+	now := time.Now()
+	headers := make(map[string]string)
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	query := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		query[name] = strings.Join(values, ",")
+	}
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		cookies = append(cookies, cookie.String())
+	}
+	sourceIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	request := events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              route.method + " " + route.pattern,
+		RawPath:               r.URL.EscapedPath(),
+		RawQueryString:        r.URL.RawQuery,
+		Cookies:               cookies,
+		Headers:               headers,
+		QueryStringParameters: query,
+		PathParameters:        params,
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			RouteKey:   route.method + " " + route.pattern,
+			AccountID:  "000000000000",
+			Stage:      stage,
+			RequestID:  uuid.NewString(),
+			APIID:      "devserver",
+			DomainName: r.Host,
+			Time:       now.Format("02/Jan/2006:15:04:05 -0700"),
+			TimeEpoch:  now.UnixMilli(),
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  r.Proto,
+				SourceIP:  sourceIP,
+				UserAgent: r.UserAgent(),
+			},
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: claims,
+				},
+			},
+		},
+	}
+	if utf8.Valid(body) {
+		request.Body = string(body)
+	} else {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+	return request
+}
+
+func writeGatewayResponse(w http.ResponseWriter, response events.APIGatewayV2HTTPResponse) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	for _, cookie := range response.Cookies {
+		w.Header().Add("Set-Cookie", cookie)
+	}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			writeGatewayMessage(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		body = decoded
+	}
+	if w.Header().Get("Content-Type") == "" && len(body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
@@ -0,0 +1,142 @@
+// Command devserver runs the MiniApps backend on one local HTTP endpoint.
+//
+// API requests are turned into API Gateway events and handled in-process by
+// the same handlers the Lambda functions run, wired to in-memory stores in
+// place of DynamoDB and Cognito. A local directory stands in for the apps
+// bucket: uploads landing in it trigger the unzip handler, and the app
+// metadata it queues is fed straight to the publisher's SQS ingest.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	publisher "miniapps-lambda-publisher"
+)
+
+/*****************************************************/
+// Configuration
+/*****************************************************/
+type config struct {
+	addr       string
+	publicUrl  string
+	stage      string
+	dataDir    string
+	bucket     string
+	queueName  string
+	claimsFile string
+	region     string
+}
+
+func parseFlags() config {
+	var cfg config
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:8080", "address to listen on")
+	flag.StringVar(&cfg.publicUrl, "public-url", "", "base URL clients use to reach the server (default http://<addr>)")
+	flag.StringVar(&cfg.stage, "stage", "default", "API Gateway stage name used as the path prefix")
+	flag.StringVar(&cfg.dataDir, "data-dir", ".devserver", "directory standing in for S3 buckets")
+	flag.StringVar(&cfg.bucket, "bucket", "miniapps-local-apps", "name of the local apps bucket")
+	flag.StringVar(&cfg.queueName, "queue", "miniapps-local-app-metadata", "name of the local app metadata queue")
+	flag.StringVar(&cfg.claimsFile, "claims", "", "JSON file with JWT claims used when a request has no bearer token")
+	flag.StringVar(&cfg.region, "region", "us-east-1", "AWS region reported to the handlers")
+	flag.Parse()
+
+	if cfg.publicUrl == "" {
+		cfg.publicUrl = "http://" + cfg.addr
+	}
+	cfg.publicUrl = strings.TrimSuffix(cfg.publicUrl, "/")
+	return cfg
+}
+
+// configureEnvironment sets the variables Terraform gives the Lambda functions
+// that the handlers still read directly.
+func configureEnvironment(cfg config) error {
+	env := map[string]string{
+		"apps_bucket":     cfg.bucket,
+		"assets_base_url": cfg.publicUrl + "/" + cfg.bucket,
+		"AWS_REGION":      cfg.region,
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	// Every lambda logs through the same handler, so one setup serves them all
+	publisher.SetupLogging()
+	cfg := parseFlags()
+	if err := configureEnvironment(cfg); err != nil {
+		slog.Error("Failed to configure environment", "error", err)
+		os.Exit(1)
+	}
+
+	claims, err := loadDefaultClaims(cfg.claimsFile)
+	if err != nil {
+		slog.Error("Failed to load claims", "file", cfg.claimsFile, "error", err)
+		os.Exit(1)
+	}
+
+	bucketDir := filepath.Join(cfg.dataDir, cfg.bucket)
+	if err := os.MkdirAll(bucketDir, 0o755); err != nil {
+		slog.Error("Failed to create bucket directory", "dir", bucketDir, "error", err)
+		os.Exit(1)
+	}
+
+	backend := newBackend(cfg, bucketDir)
+	mux := http.NewServeMux()
+	mux.Handle("/"+cfg.bucket+"/", backend.bucket)
+	mux.Handle("/", newGateway(cfg, claims, backend))
+
+	listener, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		slog.Error("Failed to listen", "addr", cfg.addr, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Development server listening",
+		"api", cfg.publicUrl+"/"+cfg.stage,
+		"bucket", cfg.publicUrl+"/"+cfg.bucket,
+		"data_dir", cfg.dataDir,
+	)
+
+	server := &http.Server{
+		Handler:           withCors(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.Serve(listener); err != nil {
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+/*****************************************************/
+// CORS
+/*****************************************************/
+// withCors answers preflight requests the way the API Gateway and apps bucket
+// CORS rules do, but for any local origin.
+func withCors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Correlation-Id")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "*")
+			w.Header().Set("Access-Control-Max-Age", "300")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
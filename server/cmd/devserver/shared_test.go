@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sharedFiles are copied into every lambda that lists them, because each
+// lambda builds as a module of its own. The copies may differ only in their
+// package clause.
+var sharedFiles = map[string][]string{
+	"logging.go": {"publisher", "subscriber", "unzip", "user"},
+	"router.go":  {"publisher", "subscriber", "user"},
+}
+
+func TestSharedFilesMatch(t *testing.T) {
+	for name, lambdas := range sharedFiles {
+		var reference, referenceLambda string
+		for _, lambda := range lambdas {
+			source, err := os.ReadFile(filepath.Join("..", "..", "lambda", lambda, name))
+			if err != nil {
+				t.Fatalf("read %s of %s: %v", name, lambda, err)
+			}
+			_, body, ok := strings.Cut(string(source), "\n")
+			if !ok || !strings.HasPrefix(string(source), "package ") {
+				t.Fatalf("%s of %s does not start with a package clause", name, lambda)
+			}
+			if referenceLambda == "" {
+				reference, referenceLambda = body, lambda
+				continue
+			}
+			if body != reference {
+				t.Errorf("%s of %s differs from the copy in %s", name, lambda, referenceLambda)
+			}
+		}
+	}
+}
+
+var (
+	policyPrefixList = regexp.MustCompile(`"s3:prefix"\s*=\s*\[([^\]]*)\]`)
+	policyQuoted     = regexp.MustCompile(`"([^"]*)\*"`)
+	policyResource   = regexp.MustCompile(`"\$\{aws_s3_bucket\.apps\.arn\}/([^"]*)\*"`)
+)
+
+// TestPublisherPolicyMatchesTerraform checks that the prefixes the devserver
+// lets the publisher list and delete under are the ones main.tf grants it.
+func TestPublisherPolicyMatchesTerraform(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("..", "..", "..", "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, block, ok := strings.Cut(string(source), `resource "aws_iam_role_policy" "publisher_s3_policy"`)
+	if !ok {
+		t.Fatal("main.tf has no publisher_s3_policy")
+	}
+	block, _, _ = strings.Cut(block, "\nresource ")
+
+	var listPrefixes, deletePrefixes []string
+	if match := policyPrefixList.FindStringSubmatch(block); match != nil {
+		for _, prefix := range policyQuoted.FindAllStringSubmatch(match[1], -1) {
+			listPrefixes = append(listPrefixes, prefix[1])
+		}
+	}
+	for _, statement := range strings.Split(block, "\n      {") {
+		if !strings.Contains(statement, `"s3:DeleteObject"`) {
+			continue
+		}
+		for _, resource := range policyResource.FindAllStringSubmatch(statement, -1) {
+			deletePrefixes = append(deletePrefixes, resource[1])
+		}
+	}
+
+	sorted := func(prefixes []string) string {
+		prefixes = append([]string{}, prefixes...)
+		sort.Strings(prefixes)
+		return strings.Join(prefixes, ",")
+	}
+	if got, want := sorted(publisherPolicy.listPrefixes), sorted(listPrefixes); got != want {
+		t.Errorf("devserver lets the publisher list under %s, main.tf grants %s", got, want)
+	}
+	if got, want := sorted(publisherPolicy.deletePrefixes), sorted(deletePrefixes); got != want {
+		t.Errorf("devserver lets the publisher delete under %s, main.tf grants %s", got, want)
+	}
+}
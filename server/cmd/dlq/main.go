@@ -0,0 +1,267 @@
+// Command dlq inspects and replays app metadata messages that failed ingest.
+//
+// The publisher reports every message it cannot ingest as a batch item
+// failure. After five failed deliveries SQS moves the message to the
+// app-metadata dead-letter queue, unchanged. Once the cause is fixed, replay
+// starts an SQS redrive that moves the messages back to the app metadata
+// queue, where the publisher ingests them again. Ingest is keyed on the
+// upload, so replaying a message that was already ingested is harmless.
+//
+// Usage:
+//
+//	dlq -dlq NAME list [-max 50] [-bodies]
+//	dlq -dlq NAME replay [-queue NAME] [-rate 10]
+//	dlq -dlq NAME status
+//
+// The queue names are the app_metadata_dlq_name and app_metadata_queue_name
+// Terraform outputs. AWS credentials and region come from the environment.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	publisher "miniapps-lambda-publisher"
+)
+
+const (
+	// peekVisibility hides listed messages only briefly, so a list never
+	// delays a replay by much
+	peekVisibility = 30
+	callTimeout    = 30 * time.Second
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  dlq -dlq NAME list [-max 50] [-bodies]        show the messages waiting in the DLQ
+  dlq -dlq NAME replay [-queue NAME] [-rate N]  move them back for ingest
+  dlq -dlq NAME status                          show recent replays
+
+Flags:`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	dlqName := flag.String("dlq", os.Getenv("APP_METADATA_DLQ"), "name of the app metadata dead-letter queue (default $APP_METADATA_DLQ)")
+	flag.Usage = usage
+	flag.Parse()
+	if *dlqName == "" || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load AWS configuration: %v\n", err)
+		os.Exit(1)
+	}
+	client := sqs.NewFromConfig(cfg)
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "list":
+		err = runList(ctx, client, *dlqName, args, os.Stdout)
+	case "replay":
+		err = runReplay(ctx, client, *dlqName, args, os.Stdout)
+	case "status":
+		err = runStatus(ctx, client, *dlqName, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dlq:", err)
+		os.Exit(1)
+	}
+}
+
+/*****************************************************/
+// Queue lookup
+/*****************************************************/
+type queue struct {
+	url string
+	arn string
+}
+
+func lookupQueue(ctx context.Context, client *sqs.Client, name string) (queue, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	urlResp, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		return queue{}, fmt.Errorf("failed to find queue %s: %w", name, err)
+	}
+	attributes, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       urlResp.QueueUrl,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return queue{}, fmt.Errorf("failed to read attributes of queue %s: %w", name, err)
+	}
+	return queue{
+		url: aws.ToString(urlResp.QueueUrl),
+		arn: attributes.Attributes[string(types.QueueAttributeNameQueueArn)],
+	}, nil
+}
+
+/*****************************************************/
+// list
+/*****************************************************/
+func runList(ctx context.Context, client *sqs.Client, dlqName string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	max := flags.Int("max", 50, "maximum number of messages to show")
+	bodies := flags.Bool("bodies", false, "print each message body")
+	flags.Parse(args)
+
+	dlq, err := lookupQueue(ctx, client, dlqName)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var messages []types.Message
+	for len(messages) < *max {
+		batch := min(*max-len(messages), 10)
+		callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+		resp, err := client.ReceiveMessage(callCtx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(dlq.url),
+			MaxNumberOfMessages:         int32(batch),
+			VisibilityTimeout:           peekVisibility,
+			WaitTimeSeconds:             1,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to receive messages: %w", err)
+		}
+		added := 0
+		for _, message := range resp.Messages {
+			id := aws.ToString(message.MessageId)
+			if !seen[id] {
+				seen[id] = true
+				messages = append(messages, message)
+				added++
+			}
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	if len(messages) == 0 {
+		fmt.Fprintln(out, "The dead-letter queue is empty.")
+		return nil
+	}
+	writeMessages(out, messages, *bodies)
+	fmt.Fprintf(out, "\n%d message(s). Listed messages stay hidden for %ds.\n", len(messages), peekVisibility)
+	return nil
+}
+
+func writeMessages(out io.Writer, messages []types.Message, bodies bool) {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "MESSAGE ID\tRECEIVES\tFIRST SENT\tAPP\tVERSION\tPUBLISHER\tUPLOAD")
+	for _, message := range messages {
+		sent := "-"
+		if millis, err := strconv.ParseInt(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+			sent = time.UnixMilli(millis).UTC().Format(time.RFC3339)
+		}
+		receives := message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]
+
+		var metadata publisher.AppMetadataMessage
+		if err := json.Unmarshal([]byte(aws.ToString(message.Body)), &metadata); err != nil {
+			fmt.Fprintf(table, "%s\t%s\t%s\t(unparseable body)\t\t\t\n", aws.ToString(message.MessageId), receives, sent)
+		} else {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", aws.ToString(message.MessageId), receives, sent,
+				metadata.AppSlug, metadata.VersionId, metadata.PublisherId, metadata.RequestId)
+		}
+	}
+	table.Flush()
+
+	if bodies {
+		for _, message := range messages {
+			fmt.Fprintf(out, "\n--- %s\n%s\n", aws.ToString(message.MessageId), aws.ToString(message.Body))
+		}
+	}
+}
+
+/*****************************************************/
+// replay
+/*****************************************************/
+func runReplay(ctx context.Context, client *sqs.Client, dlqName string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	queueName := flags.String("queue", "", "queue to move the messages to (default: the queue each message came from)")
+	rate := flags.Int("rate", 10, "messages moved per second")
+	flags.Parse(args)
+
+	dlq, err := lookupQueue(ctx, client, dlqName)
+	if err != nil {
+		return err
+	}
+	input := &sqs.StartMessageMoveTaskInput{
+		SourceArn:                    aws.String(dlq.arn),
+		MaxNumberOfMessagesPerSecond: aws.Int32(int32(*rate)),
+	}
+	if *queueName != "" {
+		destination, err := lookupQueue(ctx, client, *queueName)
+		if err != nil {
+			return err
+		}
+		input.DestinationArn = aws.String(destination.arn)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	resp, err := client.StartMessageMoveTask(callCtx, input)
+	if err != nil {
+		return fmt.Errorf("failed to start replay: %w", err)
+	}
+	fmt.Fprintf(out, "Replay started (task %s). Run \"dlq -dlq %s status\" to follow it.\n", aws.ToString(resp.TaskHandle), dlqName)
+	return nil
+}
+
+/*****************************************************/
+// status
+/*****************************************************/
+func runStatus(ctx context.Context, client *sqs.Client, dlqName string, out io.Writer) error {
+	dlq, err := lookupQueue(ctx, client, dlqName)
+	if err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	resp, err := client.ListMessageMoveTasks(callCtx, &sqs.ListMessageMoveTasksInput{
+		SourceArn:  aws.String(dlq.arn),
+		MaxResults: aws.Int32(10),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list replays: %w", err)
+	}
+	if len(resp.Results) == 0 {
+		fmt.Fprintln(out, "No replays have been started from this queue.")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "STARTED\tSTATUS\tMOVED\tTO MOVE\tFAILURE")
+	for _, task := range resp.Results {
+		toMove := "-"
+		if task.ApproximateNumberOfMessagesToMove != nil {
+			toMove = strconv.FormatInt(*task.ApproximateNumberOfMessagesToMove, 10)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n",
+			time.UnixMilli(task.StartedTimestamp).UTC().Format(time.RFC3339),
+			aws.ToString(task.Status), task.ApproximateNumberOfMessagesMoved, toMove, aws.ToString(task.FailureReason))
+	}
+	return table.Flush()
+}
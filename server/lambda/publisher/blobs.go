@@ -0,0 +1,75 @@
+package publisher
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sort"
+)
+
+/*****************************************************/
+// Shared blobs
+/*****************************************************/
+// Unzip stores models and other large binaries once by content under
+// blobs/sha256/{hex}, shared by every release and app that ships the same
+// bytes. A release that uses a blob has a reference at
+// blobrefs/{appSlug}/{releaseId}/{hex}, which release cleanup deletes along
+// with the release's files.
+
+const (
+	blobsPrefix    = "blobs/sha256/"
+	blobRefsPrefix = "blobrefs/"
+)
+
+// blobExists reports whether a shared blob is stored. Listing the key as a
+// prefix avoids reading a body that can be 25MB.
+func (h *Handler) blobExists(ctx context.Context, key string) (bool, error) {
+	objects, err := h.services.Blobs.ListObjects(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	for _, object := range objects {
+		if object.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collectBlobs deletes the blobs among candidates that no release of any app
+// references any more. Only releases still kept have references, a few per
+// release, so listing them all stays cheap. A release extracted while this
+// runs writes its reference before it looks the blob up; if the blob is
+// deleted in between, verifyRelease fails its message instead of promoting a
+// release with a missing file. Failures are only logged: a blob left behind
+// costs storage and is reused if the same bytes are published again.
+func (h *Handler) collectBlobs(ctx context.Context, candidates []string) {
+	refs, err := h.services.Blobs.ListObjects(ctx, blobRefsPrefix)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping blob collection", "error", err)
+		return
+	}
+	referenced := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		referenced[path.Base(ref.Key)] = true
+	}
+
+	var keys []string
+	for _, sha256Hex := range candidates {
+		if referenced[sha256Hex] {
+			continue
+		}
+		// Several removed releases can reference the same blob
+		referenced[sha256Hex] = true
+		keys = append(keys, blobsPrefix+sha256Hex)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	if err := h.services.Blobs.DeleteObjects(ctx, keys); err != nil {
+		slog.WarnContext(ctx, "Failed to delete unreferenced blobs", "blobs", keys, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Deleted unreferenced blobs", "blobs", keys)
+}
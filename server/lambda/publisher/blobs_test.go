@@ -0,0 +1,73 @@
+package publisher
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withBlob adds a file stored as the shared blob of sha256Hex to a release
+// message, with the blob and the release's reference to it.
+func withBlob(blobs *MemoryBlobStore, metadata AppMetadataMessage, name, sha256Hex string) AppMetadataMessage {
+	key := blobsPrefix + sha256Hex
+	blobs.PutObjectAt(key, []byte(name), metadata.UploadTimestamp)
+	blobs.PutObjectAt(blobRefsPrefix+metadata.AppSlug+"/"+metadata.ReleaseId+"/"+sha256Hex, nil, metadata.UploadTimestamp)
+	metadata.ProcessedFiles = append(metadata.ProcessedFiles, key)
+	metadata.Files = append(metadata.Files, FileEntry{Path: name, SHA256: sha256Hex, Blob: key})
+	return metadata
+}
+
+func TestCleanupDeletesBlobsNoReleaseReferences(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	// Another app ships the same bytes as b
+	blobs.PutObjectAt(blobRefsPrefix+"other/o1/b", nil, base)
+
+	v1 := newReleaseMessage(blobs, "r1", base, "index.html")
+	v1 = withBlob(blobs, v1, "model.onnx", "a")
+	v1 = withBlob(blobs, v1, "encoder.onnx", "b")
+	v1 = withBlob(blobs, v1, "decoder.onnx", "c")
+	if err := handler.saveAppMetadata(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	v2 := withBlob(blobs, newReleaseMessage(blobs, "r2", base.Add(time.Minute), "index.html"), "model.onnx", "a")
+	if err := handler.saveAppMetadata(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+	// r1 is retired once r3 replaces r2, which stays as the previous release
+	v3 := withBlob(blobs, newReleaseMessage(blobs, "r3", base.Add(2*time.Minute), "index.html"), "model.onnx", "a")
+	if err := handler.saveAppMetadata(ctx, v3); err != nil {
+		t.Fatal(err)
+	}
+
+	pointer := readPointer(t, blobs)
+	if model := pointer.Files[1]; model.Path != "model.onnx" || model.Url != "/blobs/sha256/a" {
+		t.Errorf("pointer lists the model as %+v, want it served from its blob", model)
+	}
+	if keys := listKeys(t, blobs, blobsPrefix); strings.Join(keys, ",") != "blobs/sha256/a,blobs/sha256/b" {
+		t.Errorf("blobs = %v, want the ones still referenced", keys)
+	}
+	if keys := listKeys(t, blobs, blobRefsPrefix); strings.Join(keys, ",") != "blobrefs/other/o1/b,blobrefs/shape/r2/a,blobrefs/shape/r3/a" {
+		t.Errorf("references = %v, want those of the live and previous releases", keys)
+	}
+}
+
+func TestReleaseWithMissingBlobIsNotPromoted(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+
+	metadata := withBlob(blobs, newReleaseMessage(blobs, "r1", time.Now(), "index.html"), "model.onnx", "a")
+	blobs.DeleteObjects(ctx, []string{blobsPrefix + "a"})
+	if err := handler.saveAppMetadata(ctx, metadata); err == nil {
+		t.Fatal("expected a release with a missing blob to fail ingest")
+	}
+}
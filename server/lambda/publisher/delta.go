@@ -0,0 +1,242 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Delta uploads
+/*****************************************************/
+// A publish request whose files all carry their SHA-256 is a delta upload.
+// Instead of one URL for the whole zip, the client gets a presigned PUT for
+// each file the apps bucket does not hold yet; files the live release already
+// serves and shared blobs are reused. The declaration stored next to the
+// upload lists every file with the key unzip reads it from. Once the client
+// has uploaded its files it calls the complete route, which writes
+// uploads/.../{uploadId}.complete and so starts unzip.
+
+const deltaCompleteSuffix = ".complete"
+
+// Smaller files are never stored as shared blobs, unless they are models,
+// so only larger ones are looked up under blobs/
+const blobMinSize = 1 << 20
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DeltaFile is one file of a delta upload. Source is the key its content is
+// read from: the client's upload, the live release or a shared blob.
+type DeltaFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Source string `json:"source"`
+}
+
+// FileUpload is a file the client has to upload for a delta upload. Files
+// with the same content are uploaded once. The URL only accepts a body of
+// the file's size and SHA-256, sent with Headers.
+type FileUpload struct {
+	Filename     string            `json:"filename"`
+	SHA256       string            `json:"sha256"`
+	PresignedUrl string            `json:"presigned_url"`
+	Headers      map[string]string `json:"headers"`
+}
+
+func isDeltaRequest(request PublishRequest) bool {
+	for _, file := range request.Files {
+		if file.SHA256 != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func validateDeltaFiles(files []File) (events.APIGatewayV2HTTPResponse, error) {
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if !sha256Pattern.MatchString(file.SHA256) {
+			return createErrorResponse(400, "Every file of a delta upload needs its SHA-256 as 64 lowercase hex digits")
+		}
+		if seen[file.Filename] {
+			return createErrorResponse(400, fmt.Sprintf("File %s is listed twice", file.Filename))
+		}
+		seen[file.Filename] = true
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// reusableFiles returns the key of every file of the request the apps bucket
+// already holds, by SHA-256. A file reused from the live release is read by
+// unzip after the client has uploaded the rest, so if another version goes
+// live in between and its cleanup removes the file, the upload fails and the
+// client publishes again.
+func (h *Handler) reusableFiles(ctx context.Context, appSlug string, request PublishRequest) (map[string]string, error) {
+	sources := make(map[string]string)
+	current, err := h.currentRelease(ctx, appSlug)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		for _, file := range current.Files {
+			if file.Url != "" {
+				sources[file.SHA256] = strings.TrimPrefix(file.Url, "/")
+			} else {
+				sources[file.SHA256] = strings.TrimPrefix(current.Path, "/") + file.Path
+			}
+		}
+	}
+
+	models := make(map[string]bool)
+	for _, model := range publishModels(request) {
+		models[model.Path] = true
+	}
+	for _, file := range request.Files {
+		if sources[file.SHA256] != "" || (file.Size < blobMinSize && !models[file.Filename]) {
+			continue
+		}
+		exists, err := h.blobExists(ctx, blobsPrefix+file.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			sources[file.SHA256] = blobsPrefix + file.SHA256
+		}
+	}
+	return sources, nil
+}
+
+// createDeltaUpload stores the declaration of a delta upload and issues an
+// upload URL for each file content the apps bucket is missing.
+func (h *Handler) createDeltaUpload(ctx context.Context, appSlug, versionId, publisherId string, request PublishRequest, declaration PublishDeclaration) (string, []FileUpload, error) {
+	sources, err := h.reusableFiles(ctx, appSlug, request)
+	if err != nil {
+		return "", nil, err
+	}
+	uploadId := newUploadId(ctx)
+	upload := uploadKeyBase(appSlug, versionId, publisherId, uploadId)
+
+	uploads := []FileUpload{}
+	for _, file := range request.Files {
+		source, ok := sources[file.SHA256]
+		if !ok {
+			source = upload + "/" + file.SHA256
+			url, err := h.services.Blobs.PresignPut(ctx, source, "", int64(file.Size), file.SHA256)
+			if err != nil {
+				return "", nil, err
+			}
+			uploads = append(uploads, FileUpload{
+				Filename:     file.Filename,
+				SHA256:       file.SHA256,
+				PresignedUrl: url,
+				Headers:      uploadHeaders("", file.SHA256),
+			})
+			sources[file.SHA256] = source
+		}
+		declaration.Files = append(declaration.Files, DeltaFile{
+			Path:   file.Filename,
+			SHA256: file.SHA256,
+			Size:   int64(file.Size),
+			Source: source,
+		})
+	}
+	if err := h.storeDeclaration(ctx, upload, declaration); err != nil {
+		return "", nil, err
+	}
+	return uploadId, uploads, nil
+}
+
+func (h *Handler) handleDeltaPublish(ctx context.Context, appSlug, versionId, publisherId string, request PublishRequest, declaration PublishDeclaration) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validateDeltaFiles(request.Files); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	uploadId, uploads, err := h.createDeltaUpload(ctx, appSlug, versionId, publisherId, request, declaration)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating delta upload", "error", err)
+		return createErrorResponse(500, "Failed to generate presigned URLs")
+	}
+
+	declaredBytes, uploadBytes := 0, 0
+	sizes := make(map[string]int, len(request.Files))
+	for _, file := range request.Files {
+		declaredBytes += file.Size
+		sizes[file.SHA256] = file.Size
+	}
+	for _, upload := range uploads {
+		uploadBytes += sizes[upload.SHA256]
+	}
+	emitMetrics("POST /publish/{app-slug}/version/{version-id}", outcomeSuccess,
+		countMetric("PublishHandshakes", 1),
+		countMetric("PublishDeclaredFiles", len(request.Files)),
+		bytesMetric("PublishDeclaredBytes", int64(declaredBytes)),
+		countMetric("PublishUploadFiles", len(uploads)),
+		bytesMetric("PublishUploadBytes", int64(uploadBytes)),
+	)
+
+	return createSuccessResponse(200, map[string]interface{}{
+		"message":      "Presigned URLs generated successfully",
+		"upload_id":    uploadId,
+		"uploads":      uploads,
+		"reused_files": len(request.Files) - len(uploads),
+	}), nil
+}
+
+// handleCompleteUpload starts the extraction of a delta upload once every
+// file the client had to upload is there, and completes multipart uploads.
+func (h *Handler) handleCompleteUpload(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+	upload, declaration, err := h.loadUpload(ctx, publisherId, request)
+	if errors.Is(err, ErrObjectNotFound) {
+		return createErrorResponse(404, "Upload not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading publish declaration", "error", err)
+		return createErrorResponse(500, "Failed to complete upload")
+	}
+	if declaration.Multipart != nil {
+		return h.completeMultipartUpload(ctx, request, upload, *declaration.Multipart)
+	}
+	if len(declaration.Files) == 0 {
+		return createErrorResponse(400, "Only delta and multipart uploads are completed through this route")
+	}
+
+	objects, err := h.services.Blobs.ListObjects(ctx, upload+"/")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing uploaded files", "error", err)
+		return createErrorResponse(500, "Failed to complete upload")
+	}
+	uploaded := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		uploaded[object.Key] = true
+	}
+	var missing []string
+	for _, file := range declaration.Files {
+		if strings.HasPrefix(file.Source, upload+"/") && !uploaded[file.Source] {
+			missing = append(missing, file.Path)
+		}
+	}
+	if len(missing) > 0 {
+		return createErrorResponse(400, fmt.Sprintf("Files not uploaded yet: %s", strings.Join(missing, ", ")))
+	}
+
+	if err := h.services.Blobs.PutObject(ctx, upload+deltaCompleteSuffix, nil, "", ""); err != nil {
+		slog.ErrorContext(ctx, "Error marking upload complete", "error", err)
+		return createErrorResponse(500, "Failed to complete upload")
+	}
+	return createSuccessResponse(202, map[string]interface{}{
+		"message":   "Upload complete, processing started",
+		"upload_id": request.PathParameters["upload-id"],
+	}), nil
+}
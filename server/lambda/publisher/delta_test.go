@@ -0,0 +1,89 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeltaUploadOnlyRequestsMissingFiles(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+
+	live := withBlob(blobs, newReleaseMessage(blobs, "r1", time.Now(), "index.html"), "model.onnx", strings.Repeat("b", 64))
+	if err := handler.saveAppMetadata(ctx, live); err != nil {
+		t.Fatal(err)
+	}
+	// The same model as another app, stored as a shared blob
+	blobs.PutObjectAt(blobsPrefix+strings.Repeat("c", 64), nil, time.Now())
+
+	request := PublishRequest{Files: []File{
+		{Filename: "index.html", Size: 10, SHA256: live.Files[0].SHA256},
+		{Filename: "app.js", Size: 20, SHA256: strings.Repeat("a", 64)},
+		{Filename: "copy.js", Size: 20, SHA256: strings.Repeat("a", 64)},
+		{Filename: "model.onnx", Size: 5 << 20, SHA256: strings.Repeat("b", 64)},
+		{Filename: "encoder.onnx", Size: 5 << 20, SHA256: strings.Repeat("c", 64)},
+	}}
+	uploadId, uploads, err := handler.createDeltaUpload(ctx, "shape", "1.0.1", "publisher-1", request, PublishDeclaration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || uploads[0].Filename != "app.js" {
+		t.Fatalf("uploads = %+v, want only app.js", uploads)
+	}
+	upload := uploadKeyBase("shape", "1.0.1", "publisher-1", uploadId)
+	if keys := blobs.PresignedKeys(); len(keys) != 1 || keys[0] != upload+"/"+strings.Repeat("a", 64) {
+		t.Errorf("presigned keys = %v", keys)
+	}
+
+	body, err := blobs.GetObject(ctx, upload+".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var declaration PublishDeclaration
+	if err := json.Unmarshal(body, &declaration); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"app/shape/releases/r1/index.html",
+		upload + "/" + strings.Repeat("a", 64),
+		upload + "/" + strings.Repeat("a", 64),
+		blobsPrefix + strings.Repeat("b", 64),
+		blobsPrefix + strings.Repeat("c", 64),
+	}
+	for i, file := range declaration.Files {
+		if file.Path != request.Files[i].Filename || file.Source != want[i] {
+			t.Errorf("file %d = %+v, want %s from %s", i, file, request.Files[i].Filename, want[i])
+		}
+	}
+}
+
+func TestValidateDeltaFiles(t *testing.T) {
+	sha := strings.Repeat("a", 64)
+	tests := map[string]struct {
+		files []File
+		want  string
+	}{
+		"valid":          {files: []File{{Filename: "index.html", SHA256: sha}}},
+		"missing hash":   {files: []File{{Filename: "index.html", SHA256: sha}, {Filename: "app.js"}}, want: "needs its SHA-256"},
+		"uppercase hash": {files: []File{{Filename: "index.html", SHA256: strings.ToUpper(sha)}}, want: "needs its SHA-256"},
+		"repeated file":  {files: []File{{Filename: "app.js", SHA256: sha}, {Filename: "app.js", SHA256: sha}}, want: "File app.js is listed twice"},
+	}
+	for name, tt := range tests {
+		resp, _ := validateDeltaFiles(tt.files)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
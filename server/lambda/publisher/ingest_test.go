@@ -0,0 +1,109 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// failingAppStore fails to save the apps whose slug is listed in failSlugs.
+type failingAppStore struct {
+	*MemoryAppStore
+	failSlugs map[string]bool
+}
+
+func (s *failingAppStore) SaveApp(ctx context.Context, record AppRecord) error {
+	if s.failSlugs[record.AppSlug] {
+		return errors.New("dynamodb unavailable")
+	}
+	return s.MemoryAppStore.SaveApp(ctx, record)
+}
+
+func newMetadataMessage(t *testing.T, messageId, appSlug string) events.SQSMessage {
+	t.Helper()
+	body, err := json.Marshal(AppMetadataMessage{
+		AppSlug:         appSlug,
+		VersionId:       "1.0.0",
+		PublisherId:     "publisher-1",
+		RequestId:       "upload-" + appSlug,
+		S3FilePath:      "app/" + appSlug + "/",
+		UploadTimestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ProcessedFiles:  []string{"app/" + appSlug + "/index.html"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.SQSMessage{MessageId: messageId, Body: string(body)}
+}
+
+func TestHandleSQSEventReportsOnlyFailedMessages(t *testing.T) {
+	captureMetrics(t)
+	apps := &failingAppStore{MemoryAppStore: NewMemoryAppStore(), failSlugs: map[string]bool{"broken": true}}
+	services := NewMemoryServices("http://localhost")
+	services.Apps = apps
+	handler := NewHandler(services)
+
+	response := handler.handleSQSEvent(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{
+			newMetadataMessage(t, "m-1", "first"),
+			{MessageId: "m-2", Body: "not json"},
+			newMetadataMessage(t, "m-3", "broken"),
+			newMetadataMessage(t, "m-4", "second"),
+		},
+	})
+
+	var failed []string
+	for _, failure := range response.BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+	if len(failed) != 2 || failed[0] != "m-2" || failed[1] != "m-3" {
+		t.Errorf("expected m-2 and m-3 to be reported, got %v", failed)
+	}
+	if saved := apps.Apps(); len(saved) != 2 {
+		t.Errorf("expected the two good messages to be saved, got %d apps", len(saved))
+	}
+}
+
+func TestRedeliveredMessageOverwritesItsApp(t *testing.T) {
+	captureMetrics(t)
+	services := NewMemoryServices("http://localhost")
+	handler := NewHandler(services)
+	message := newMetadataMessage(t, "m-1", "first")
+
+	for i := 0; i < 2; i++ {
+		response := handler.handleSQSEvent(context.Background(), events.SQSEvent{Records: []events.SQSMessage{message}})
+		if len(response.BatchItemFailures) != 0 {
+			t.Fatalf("delivery %d failed: %+v", i+1, response.BatchItemFailures)
+		}
+	}
+	if saved := services.Apps.(*MemoryAppStore).Apps(); len(saved) != 1 {
+		t.Errorf("expected a replayed message to keep one app, got %d", len(saved))
+	}
+}
+
+func TestAppRecordListsIconsAsThumbnails(t *testing.T) {
+	record := newAppRecord(context.Background(), AppMetadataMessage{
+		AppSlug:    "shape",
+		ReleaseId:  "r1",
+		S3FilePath: "app/shape/releases/r1/",
+		Manifest:   &Manifest{Name: "Shape", Description: "Draws shapes"},
+		Icons: []IconInfo{
+			{Path: "icon.png", Width: 192, Height: 192, Type: "image/png", Purpose: "any"},
+			{Path: "_generated/icon-512x512.png", Width: 512, Height: 512, Type: "image/png", Purpose: "any", Generated: true},
+		},
+	})
+	if record.AppName != "Shape" || record.AppDescription != "Draws shapes" {
+		t.Errorf("record named %q, %q, want the manifest's name and description", record.AppName, record.AppDescription)
+	}
+	want := []Thumbnail{
+		{Url: "/app/shape/releases/r1/icon.png", Width: 192, Height: 192, Type: "image/png", Purpose: "any"},
+		{Url: "/app/shape/releases/r1/_generated/icon-512x512.png", Width: 512, Height: 512, Type: "image/png", Purpose: "any"},
+	}
+	if len(record.Thumbnails) != len(want) || record.Thumbnails[0] != want[0] || record.Thumbnails[1] != want[1] {
+		t.Errorf("thumbnails = %+v, want %+v", record.Thumbnails, want)
+	}
+}
@@ -0,0 +1,166 @@
+package publisher
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Store listing
+/*****************************************************/
+// A publish request can describe the app's store page beyond the manifest's
+// name and description. Screenshots are images in the bundle, which unzip
+// decodes and measures; the text travels with the publish declaration and
+// the metadata message into the app record, where the subscriber's app
+// detail route reads it. The version notes of each version form the
+// changelog.
+
+const (
+	maxListingTextChars   = 10000
+	maxModelCardChars     = 2000
+	maxScreenshots        = 8
+	maxScreenshotLabelLen = 100
+)
+
+var (
+	listingInputTypes     = map[string]bool{"camera": true, "file": true, "microphone": true, "text": true}
+	screenshotFormFactors = map[string]bool{"narrow": true, "wide": true}
+	screenshotExtensions  = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".webp": true}
+)
+
+// StoreListing is the app's store page. Description is the long description
+// and PrivacyPolicy the privacy statement, both Markdown, which clients
+// render without raw HTML.
+type StoreListing struct {
+	Description   string       `json:"description,omitempty"`
+	PrivacyPolicy string       `json:"privacy_policy,omitempty"`
+	InputTypes    []string     `json:"input_types,omitempty"`
+	ModelCard     *ModelCard   `json:"model_card,omitempty"`
+	Screenshots   []Screenshot `json:"screenshots,omitempty"`
+}
+
+// ModelCard tells users what the app's models are for and where they fall
+// short.
+type ModelCard struct {
+	IntendedUse  string `json:"intended_use,omitempty" dynamodbav:"intendedUse,omitempty"`
+	TrainingData string `json:"training_data,omitempty" dynamodbav:"trainingData,omitempty"`
+	Limitations  string `json:"limitations,omitempty" dynamodbav:"limitations,omitempty"`
+	License      string `json:"license,omitempty" dynamodbav:"license,omitempty"`
+}
+
+// Screenshot is an image of the bundle shown on the store page. Width,
+// Height and Type are set by unzip from the image itself.
+type Screenshot struct {
+	Path       string `json:"path"`
+	Label      string `json:"label,omitempty"`
+	FormFactor string `json:"form_factor,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Type       string `json:"type,omitempty"`
+}
+
+// StoreScreenshot is a screenshot as the app record keeps it, with the path
+// the release serves it at.
+type StoreScreenshot struct {
+	Url        string `dynamodbav:"url"`
+	Label      string `dynamodbav:"label,omitempty"`
+	FormFactor string `dynamodbav:"formFactor,omitempty"`
+	Width      int    `dynamodbav:"width"`
+	Height     int    `dynamodbav:"height"`
+	Type       string `dynamodbav:"type"`
+}
+
+func validateListingText(field, text string, limit int) (events.APIGatewayV2HTTPResponse, error) {
+	if utf8.RuneCountInString(text) > limit {
+		return createErrorResponse(400, fmt.Sprintf("Listing %s must be at most %d characters", field, limit))
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// validateListing checks the store listing of a publish request. Screenshots
+// must be PNG, JPEG or WebP files of the bundle; unzip checks their images.
+func validateListing(listing *StoreListing, files []File) (events.APIGatewayV2HTTPResponse, error) {
+	if listing == nil {
+		return events.APIGatewayV2HTTPResponse{}, nil
+	}
+	if errorResp, _ := validateListingText("description", listing.Description, maxListingTextChars); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	if errorResp, _ := validateListingText("privacy policy", listing.PrivacyPolicy, maxListingTextChars); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	seenInputs := make(map[string]bool, len(listing.InputTypes))
+	for _, input := range listing.InputTypes {
+		if !listingInputTypes[input] {
+			return createErrorResponse(400, fmt.Sprintf("Listing input type %q must be camera, file, microphone or text", input))
+		}
+		if seenInputs[input] {
+			return createErrorResponse(400, fmt.Sprintf("Listing input type %s is listed twice", input))
+		}
+		seenInputs[input] = true
+	}
+	if card := listing.ModelCard; card != nil {
+		for _, field := range [][2]string{
+			{"model card intended use", card.IntendedUse},
+			{"model card training data", card.TrainingData},
+			{"model card limitations", card.Limitations},
+			{"model card license", card.License},
+		} {
+			if errorResp, _ := validateListingText(field[0], field[1], maxModelCardChars); errorResp.StatusCode != 0 {
+				return errorResp, nil
+			}
+		}
+	}
+
+	if len(listing.Screenshots) > maxScreenshots {
+		return createErrorResponse(400, fmt.Sprintf("A listing has at most %d screenshots", maxScreenshots))
+	}
+	bundled := make(map[string]bool, len(files))
+	for _, file := range files {
+		bundled[file.Filename] = true
+	}
+	seenScreenshots := make(map[string]bool, len(listing.Screenshots))
+	for _, screenshot := range listing.Screenshots {
+		if !bundled[screenshot.Path] {
+			return createErrorResponse(400, fmt.Sprintf("Screenshot %s is not a file of the bundle", screenshot.Path))
+		}
+		if seenScreenshots[screenshot.Path] {
+			return createErrorResponse(400, fmt.Sprintf("Screenshot %s is listed twice", screenshot.Path))
+		}
+		seenScreenshots[screenshot.Path] = true
+		if !screenshotExtensions[strings.ToLower(path.Ext(screenshot.Path))] {
+			return createErrorResponse(400, fmt.Sprintf("Screenshot %s must be a PNG, JPEG or WebP image", screenshot.Path))
+		}
+		if utf8.RuneCountInString(screenshot.Label) > maxScreenshotLabelLen {
+			return createErrorResponse(400, fmt.Sprintf("Screenshot labels are at most %d characters", maxScreenshotLabelLen))
+		}
+		if screenshot.FormFactor != "" && !screenshotFormFactors[screenshot.FormFactor] {
+			return createErrorResponse(400, "Screenshot form factor must be narrow or wide")
+		}
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// storeScreenshots lists the screenshots of the release with the URLs it
+// serves them at.
+func storeScreenshots(metadata AppMetadataMessage) []StoreScreenshot {
+	if metadata.Listing == nil {
+		return nil
+	}
+	var screenshots []StoreScreenshot
+	for _, screenshot := range metadata.Listing.Screenshots {
+		screenshots = append(screenshots, StoreScreenshot{
+			Url:        "/" + metadata.S3FilePath + screenshot.Path,
+			Label:      screenshot.Label,
+			FormFactor: screenshot.FormFactor,
+			Width:      screenshot.Width,
+			Height:     screenshot.Height,
+			Type:       screenshot.Type,
+		})
+	}
+	return screenshots
+}
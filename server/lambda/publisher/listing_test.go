@@ -0,0 +1,77 @@
+package publisher
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateListing(t *testing.T) {
+	files := []File{{Filename: "index.html"}, {Filename: "screens/home.png"}, {Filename: "screens/result.webp"}, {Filename: "notes.txt"}}
+	tests := map[string]struct {
+		listing *StoreListing
+		want    string
+	}{
+		"none": {},
+		"valid": {listing: &StoreListing{
+			Description:   "# Shape\n\nDraw a shape and the model names it.",
+			PrivacyPolicy: "Drawings never leave the device.",
+			InputTypes:    []string{"camera", "file"},
+			ModelCard:     &ModelCard{IntendedUse: "Recognizing hand-drawn shapes", License: "MIT"},
+			Screenshots: []Screenshot{
+				{Path: "screens/home.png", Label: "Home", FormFactor: "narrow"},
+				{Path: "screens/result.webp", FormFactor: "wide"},
+			},
+		}},
+		"long description":   {listing: &StoreListing{Description: strings.Repeat("a", maxListingTextChars+1)}, want: "Listing description must be at most 10000 characters"},
+		"multibyte text":     {listing: &StoreListing{Description: strings.Repeat("é", maxListingTextChars)}},
+		"long privacy":       {listing: &StoreListing{PrivacyPolicy: strings.Repeat("a", maxListingTextChars+1)}, want: "privacy policy must be at most"},
+		"unknown input":      {listing: &StoreListing{InputTypes: []string{"gps"}}, want: "must be camera, file, microphone or text"},
+		"repeated input":     {listing: &StoreListing{InputTypes: []string{"camera", "camera"}}, want: "listed twice"},
+		"long model card":    {listing: &StoreListing{ModelCard: &ModelCard{Limitations: strings.Repeat("a", maxModelCardChars+1)}}, want: "model card limitations must be at most 2000"},
+		"screenshot missing": {listing: &StoreListing{Screenshots: []Screenshot{{Path: "screens/missing.png"}}}, want: "not a file of the bundle"},
+		"screenshot twice":   {listing: &StoreListing{Screenshots: []Screenshot{{Path: "screens/home.png"}, {Path: "screens/home.png"}}}, want: "listed twice"},
+		"not an image":       {listing: &StoreListing{Screenshots: []Screenshot{{Path: "notes.txt"}}}, want: "PNG, JPEG or WebP"},
+		"long label":         {listing: &StoreListing{Screenshots: []Screenshot{{Path: "screens/home.png", Label: strings.Repeat("a", 101)}}}, want: "at most 100 characters"},
+		"form factor":        {listing: &StoreListing{Screenshots: []Screenshot{{Path: "screens/home.png", FormFactor: "tablet"}}}, want: "narrow or wide"},
+		"too many screenshots": {
+			listing: &StoreListing{Screenshots: make([]Screenshot, maxScreenshots+1)},
+			want:    "at most 8 screenshots",
+		},
+	}
+	for name, tt := range tests {
+		resp, _ := validateListing(tt.listing, files)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestAppRecordKeepsTheStoreListing(t *testing.T) {
+	record := newAppRecord(context.Background(), AppMetadataMessage{
+		AppSlug:      "shape",
+		VersionId:    "1.1.0",
+		S3FilePath:   "app/shape/releases/r2/",
+		VersionNotes: "Recognizes triangles",
+		Listing: &StoreListing{
+			Description: "Draw a shape",
+			InputTypes:  []string{"camera"},
+			ModelCard:   &ModelCard{License: "MIT"},
+			Screenshots: []Screenshot{{Path: "screens/home.png", Label: "Home", FormFactor: "narrow", Width: 540, Height: 1080, Type: "image/png"}},
+		},
+	})
+	if record.VersionId != "1.1.0" || record.VersionNotes != "Recognizes triangles" || record.LongDescription != "Draw a shape" ||
+		len(record.InputTypes) != 1 || record.ModelCard == nil || record.ModelCard.License != "MIT" {
+		t.Errorf("record does not carry the listing: %+v", record)
+	}
+	want := StoreScreenshot{Url: "/app/shape/releases/r2/screens/home.png", Label: "Home", FormFactor: "narrow", Width: 540, Height: 1080, Type: "image/png"}
+	if len(record.Screenshots) != 1 || record.Screenshots[0] != want {
+		t.Errorf("screenshots = %+v, want %+v", record.Screenshots, want)
+	}
+}
@@ -0,0 +1,310 @@
+package publisher
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Web app manifest
+/*****************************************************/
+// The publish request declares the app's manifest. It is normalized, so
+// that spelling differences such as "./index.html" or "Standalone" do not
+// count, validated against the W3C Web App Manifest and stored in the publish
+// declaration. Unzip normalizes the bundle's manifest.json the same way and
+// rejects the upload unless the two match, and the app record keeps the
+// normalized copy.
+//
+// Each release serves the manifest at
+// /app/{slug}/releases/{releaseId}/manifest.json, and the release id is only
+// known once the upload is extracted. Icons therefore name bundle files by
+// relative URLs, which resolve against whichever release serves them; a
+// root-absolute /app/{slug}/icon.png points outside every release.
+
+var (
+	manifestDisplayModes = map[string]bool{"fullscreen": true, "standalone": true, "minimal-ui": true, "browser": true}
+	manifestOrientations = map[string]bool{
+		"any": true, "natural": true, "landscape": true, "landscape-primary": true, "landscape-secondary": true,
+		"portrait": true, "portrait-primary": true, "portrait-secondary": true,
+	}
+	manifestDirs         = map[string]bool{"ltr": true, "rtl": true, "auto": true}
+	manifestIconPurposes = map[string]bool{"any": true, "maskable": true, "monochrome": true}
+
+	langPattern      = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+	iconSizePattern  = regexp.MustCompile(`^([1-9][0-9]*)x([1-9][0-9]*)$`)
+	hexColorPattern  = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{4}|[0-9a-f]{6}|[0-9a-f]{8})$`)
+	funcColorPattern = regexp.MustCompile(`^(rgb|rgba|hsl|hsla|hwb|lab|lch|oklab|oklch|color)\([^()]+\)$`)
+)
+
+// Image types by extension, for icons that do not declare their type
+var iconTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".ico":  "image/x-icon",
+}
+
+// normalizeManifest trims every member, lowercases the keywords, colors and
+// categories, cleans relative URLs and fills in the defaults the W3C
+// processing rules give scope, id and icon purpose.
+func normalizeManifest(manifest Manifest) Manifest {
+	normalized := Manifest{
+		Name:            strings.TrimSpace(manifest.Name),
+		ShortName:       strings.TrimSpace(manifest.ShortName),
+		Description:     strings.TrimSpace(manifest.Description),
+		StartUrl:        normalizeManifestUrl(manifest.StartUrl),
+		Scope:           normalizeManifestUrl(manifest.Scope),
+		Id:              normalizeManifestUrl(manifest.Id),
+		Display:         strings.ToLower(strings.TrimSpace(manifest.Display)),
+		Orientation:     strings.ToLower(strings.TrimSpace(manifest.Orientation)),
+		ThemeColor:      strings.ToLower(strings.TrimSpace(manifest.ThemeColor)),
+		BackgroundColor: strings.ToLower(strings.TrimSpace(manifest.BackgroundColor)),
+		Lang:            strings.TrimSpace(manifest.Lang),
+		Dir:             strings.ToLower(strings.TrimSpace(manifest.Dir)),
+	}
+	if normalized.Scope == "" {
+		normalized.Scope = defaultScope(normalized.StartUrl)
+	}
+	if normalized.Id == "" {
+		normalized.Id = normalized.StartUrl
+	}
+	for _, category := range manifest.Categories {
+		if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+			normalized.Categories = append(normalized.Categories, category)
+		}
+	}
+	if manifest.Icons != nil {
+		normalized.Icons = make([]Icon, 0, len(manifest.Icons))
+	}
+	for _, icon := range manifest.Icons {
+		icon = Icon{
+			Src:     normalizeManifestUrl(icon.Src),
+			Sizes:   strings.Join(strings.Fields(strings.ToLower(icon.Sizes)), " "),
+			Type:    strings.ToLower(strings.TrimSpace(icon.Type)),
+			Purpose: strings.Join(strings.Fields(strings.ToLower(icon.Purpose)), " "),
+		}
+		if icon.Type == "" {
+			icon.Type = iconTypes[strings.ToLower(path.Ext(icon.Src))]
+		}
+		if icon.Purpose == "" {
+			icon.Purpose = "any"
+		}
+		normalized.Icons = append(normalized.Icons, icon)
+	}
+	return normalized
+}
+
+// normalizeManifestUrl cleans the path of a relative URL, keeping a trailing
+// slash, and leaves absolute URLs and paths as they are.
+func normalizeManifestUrl(raw string) string {
+	raw = strings.TrimSpace(raw)
+	ref, err := url.Parse(raw)
+	if raw == "" || err != nil || ref.IsAbs() || ref.Host != "" || ref.Path == "" || strings.HasPrefix(ref.Path, "/") {
+		return raw
+	}
+	cleaned := path.Clean(ref.Path)
+	if cleaned == "." {
+		cleaned = "./"
+	} else if strings.HasSuffix(ref.Path, "/") {
+		cleaned += "/"
+	}
+	ref.Path = cleaned
+	return ref.String()
+}
+
+// defaultScope is the directory of the start URL, as the W3C processing
+// rules define it for a manifest without a scope.
+func defaultScope(startUrl string) string {
+	ref, err := url.Parse(startUrl)
+	if startUrl == "" || err != nil || ref.IsAbs() || ref.Host != "" {
+		return ""
+	}
+	dir := path.Dir(ref.Path)
+	if strings.HasSuffix(ref.Path, "/") {
+		dir = ref.Path
+	}
+	if dir == "." {
+		return "./"
+	}
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+// manifestBase stands in for the URL a release of the app serves its manifest
+// at, to check that start_url, scope and id stay on the app's origin.
+func manifestBase(appSlug string) *url.URL {
+	return &url.URL{Scheme: "https", Host: "apps.invalid", Path: "/app/" + appSlug + "/manifest.json"}
+}
+
+// resolveManifestUrl resolves a manifest URL and reports whether it stays on
+// the app's origin.
+func resolveManifestUrl(appSlug, raw string) (*url.URL, bool) {
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return nil, false
+	}
+	base := manifestBase(appSlug)
+	resolved := base.ResolveReference(ref)
+	return resolved, resolved.Scheme == base.Scheme && resolved.Host == base.Host
+}
+
+// manifestFilePath returns the bundle file a relative manifest URL points at.
+// Absolute and root-absolute URLs, and relative ones that climb out of the
+// release, name no file of the bundle.
+func manifestFilePath(raw string) (string, bool) {
+	ref, err := url.Parse(raw)
+	if err != nil || ref.Scheme != "" || ref.Host != "" || ref.RawQuery != "" || strings.HasPrefix(ref.Path, "/") {
+		return "", false
+	}
+	name := path.Clean(ref.Path)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// isCSSColor accepts hex colors, color functions and named colors. The
+// functions' arguments are left to the browser.
+func isCSSColor(color string) bool {
+	return hexColorPattern.MatchString(color) || funcColorPattern.MatchString(color) || cssNamedColors[color]
+}
+
+func validIconSizes(sizes string) bool {
+	for _, size := range strings.Fields(sizes) {
+		if size == "any" {
+			continue
+		}
+		match := iconSizePattern.FindStringSubmatch(size)
+		if match == nil {
+			return false
+		}
+		for _, dimension := range match[1:] {
+			if _, err := strconv.Atoi(dimension); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateManifest checks a normalized manifest. Its icons must be files of
+// the bundle, since the catalog and the PWA shell serve them from the
+// release.
+func validateManifest(appSlug string, manifest Manifest, files []File) (events.APIGatewayV2HTTPResponse, error) {
+	if manifest.Name == "" {
+		return createErrorResponse(400, "Manifest name is required")
+	}
+	if manifest.ShortName == "" {
+		return createErrorResponse(400, "Manifest short name is required")
+	}
+	if manifest.StartUrl == "" {
+		return createErrorResponse(400, "Manifest start url is required")
+	}
+	if manifest.Display == "" {
+		return createErrorResponse(400, "Manifest display is required")
+	}
+	if len(manifest.Icons) == 0 {
+		return createErrorResponse(400, "Manifest icons are required")
+	}
+	if !manifestDisplayModes[manifest.Display] {
+		return createErrorResponse(400, "Manifest display must be fullscreen, standalone, minimal-ui or browser")
+	}
+	if manifest.Orientation != "" && !manifestOrientations[manifest.Orientation] {
+		return createErrorResponse(400, fmt.Sprintf("Manifest orientation %q is not a valid orientation", manifest.Orientation))
+	}
+	if manifest.Dir != "" && !manifestDirs[manifest.Dir] {
+		return createErrorResponse(400, "Manifest dir must be ltr, rtl or auto")
+	}
+	if manifest.Lang != "" && !langPattern.MatchString(manifest.Lang) {
+		return createErrorResponse(400, fmt.Sprintf("Manifest lang %q is not a language tag", manifest.Lang))
+	}
+	for member, color := range map[string]string{"theme_color": manifest.ThemeColor, "background_color": manifest.BackgroundColor} {
+		if color != "" && !isCSSColor(color) {
+			return createErrorResponse(400, fmt.Sprintf("Manifest %s %q is not a CSS color", member, color))
+		}
+	}
+
+	startUrl, sameOrigin := resolveManifestUrl(appSlug, manifest.StartUrl)
+	if !sameOrigin {
+		return createErrorResponse(400, "Manifest start url must be on the app's origin")
+	}
+	scope, sameOrigin := resolveManifestUrl(appSlug, manifest.Scope)
+	if !sameOrigin {
+		return createErrorResponse(400, "Manifest scope must be on the app's origin")
+	}
+	if !strings.HasPrefix(startUrl.Path, scope.Path) {
+		return createErrorResponse(400, fmt.Sprintf("Manifest start url %s is not within scope %s", manifest.StartUrl, manifest.Scope))
+	}
+	if _, sameOrigin := resolveManifestUrl(appSlug, manifest.Id); !sameOrigin {
+		return createErrorResponse(400, "Manifest id must be on the app's origin")
+	}
+
+	bundled := make(map[string]bool, len(files))
+	for _, file := range files {
+		bundled[file.Filename] = true
+	}
+	for _, icon := range manifest.Icons {
+		if icon.Src == "" {
+			return createErrorResponse(400, "Every manifest icon needs a src")
+		}
+		if name, ok := manifestFilePath(icon.Src); !ok || !bundled[name] {
+			return createErrorResponse(400, fmt.Sprintf("Manifest icon %s is not a file of the bundle", icon.Src))
+		}
+		if !validIconSizes(icon.Sizes) {
+			return createErrorResponse(400, fmt.Sprintf("Manifest icon %s has invalid sizes %q", icon.Src, icon.Sizes))
+		}
+		if icon.Type != "" && !strings.HasPrefix(icon.Type, "image/") {
+			return createErrorResponse(400, fmt.Sprintf("Manifest icon %s has type %s, not an image type", icon.Src, icon.Type))
+		}
+		for _, purpose := range strings.Fields(icon.Purpose) {
+			if !manifestIconPurposes[purpose] {
+				return createErrorResponse(400, fmt.Sprintf("Manifest icon %s has unknown purpose %q", icon.Src, purpose))
+			}
+		}
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// CSS named colors, with transparent. currentcolor means nothing in a
+// manifest.
+var cssNamedColors = map[string]bool{
+	"transparent": true, "aliceblue": true, "antiquewhite": true, "aqua": true, "aquamarine": true,
+	"azure": true, "beige": true, "bisque": true, "black": true, "blanchedalmond": true, "blue": true,
+	"blueviolet": true, "brown": true, "burlywood": true, "cadetblue": true, "chartreuse": true,
+	"chocolate": true, "coral": true, "cornflowerblue": true, "cornsilk": true, "crimson": true,
+	"cyan": true, "darkblue": true, "darkcyan": true, "darkgoldenrod": true, "darkgray": true,
+	"darkgreen": true, "darkgrey": true, "darkkhaki": true, "darkmagenta": true, "darkolivegreen": true,
+	"darkorange": true, "darkorchid": true, "darkred": true, "darksalmon": true, "darkseagreen": true,
+	"darkslateblue": true, "darkslategray": true, "darkslategrey": true, "darkturquoise": true,
+	"darkviolet": true, "deeppink": true, "deepskyblue": true, "dimgray": true, "dimgrey": true,
+	"dodgerblue": true, "firebrick": true, "floralwhite": true, "forestgreen": true, "fuchsia": true,
+	"gainsboro": true, "ghostwhite": true, "gold": true, "goldenrod": true, "gray": true, "green": true,
+	"greenyellow": true, "grey": true, "honeydew": true, "hotpink": true, "indianred": true,
+	"indigo": true, "ivory": true, "khaki": true, "lavender": true, "lavenderblush": true,
+	"lawngreen": true, "lemonchiffon": true, "lightblue": true, "lightcoral": true, "lightcyan": true,
+	"lightgoldenrodyellow": true, "lightgray": true, "lightgreen": true, "lightgrey": true,
+	"lightpink": true, "lightsalmon": true, "lightseagreen": true, "lightskyblue": true,
+	"lightslategray": true, "lightslategrey": true, "lightsteelblue": true, "lightyellow": true,
+	"lime": true, "limegreen": true, "linen": true, "magenta": true, "maroon": true,
+	"mediumaquamarine": true, "mediumblue": true, "mediumorchid": true, "mediumpurple": true,
+	"mediumseagreen": true, "mediumslateblue": true, "mediumspringgreen": true,
+	"mediumturquoise": true, "mediumvioletred": true, "midnightblue": true, "mintcream": true,
+	"mistyrose": true, "moccasin": true, "navajowhite": true, "navy": true, "oldlace": true,
+	"olive": true, "olivedrab": true, "orange": true, "orangered": true, "orchid": true,
+	"palegoldenrod": true, "palegreen": true, "paleturquoise": true, "palevioletred": true,
+	"papayawhip": true, "peachpuff": true, "peru": true, "pink": true, "plum": true,
+	"powderblue": true, "purple": true, "rebeccapurple": true, "red": true, "rosybrown": true,
+	"royalblue": true, "saddlebrown": true, "salmon": true, "sandybrown": true, "seagreen": true,
+	"seashell": true, "sienna": true, "silver": true, "skyblue": true, "slateblue": true,
+	"slategray": true, "slategrey": true, "snow": true, "springgreen": true, "steelblue": true,
+	"tan": true, "teal": true, "thistle": true, "tomato": true, "turquoise": true, "violet": true,
+	"wheat": true, "white": true, "whitesmoke": true, "yellow": true, "yellowgreen": true,
+}
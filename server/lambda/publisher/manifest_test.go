@@ -0,0 +1,107 @@
+package publisher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func validManifest() Manifest {
+	return Manifest{
+		Name:      "Shape",
+		ShortName: "Shape",
+		StartUrl:  "index.html",
+		Display:   "standalone",
+		Icons:     []Icon{{Src: "icons/icon-192.png", Sizes: "192x192", Type: "image/png"}},
+	}
+}
+
+func TestNormalizeManifest(t *testing.T) {
+	got := normalizeManifest(Manifest{
+		Name:            " Shape ",
+		ShortName:       "Shape",
+		StartUrl:        "./app/../index.html?source=pwa",
+		Display:         "Standalone",
+		ThemeColor:      "#FFAA00",
+		BackgroundColor: " White",
+		Categories:      []string{"Education", " ", "games "},
+		Icons: []Icon{
+			{Src: "./icons/icon.png", Sizes: "192X192  512x512"},
+			{Src: "/app/shape/mask.webp", Type: "IMAGE/WEBP", Purpose: "Maskable"},
+		},
+	})
+	want := Manifest{
+		Id:              "index.html?source=pwa",
+		Name:            "Shape",
+		ShortName:       "Shape",
+		StartUrl:        "index.html?source=pwa",
+		Scope:           "./",
+		Display:         "standalone",
+		ThemeColor:      "#ffaa00",
+		BackgroundColor: "white",
+		Categories:      []string{"education", "games"},
+		Icons: []Icon{
+			{Src: "icons/icon.png", Sizes: "192x192 512x512", Type: "image/png", Purpose: "any"},
+			{Src: "/app/shape/mask.webp", Type: "image/webp", Purpose: "maskable"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeManifest =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if scope := normalizeManifest(Manifest{StartUrl: "games/play.html"}).Scope; scope != "games/" {
+		t.Errorf("default scope = %q, want the start url's directory", scope)
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	files := []File{{Filename: "index.html"}, {Filename: "icons/icon-192.png"}}
+	tests := map[string]struct {
+		edit func(*Manifest)
+		want string
+	}{
+		"valid":                 {edit: func(m *Manifest) {}},
+		"missing name":          {edit: func(m *Manifest) { m.Name = " " }, want: "Manifest name is required"},
+		"missing short name":    {edit: func(m *Manifest) { m.ShortName = "" }, want: "Manifest short name is required"},
+		"missing start url":     {edit: func(m *Manifest) { m.StartUrl = "" }, want: "Manifest start url is required"},
+		"missing display":       {edit: func(m *Manifest) { m.Display = "" }, want: "Manifest display is required"},
+		"no icons":              {edit: func(m *Manifest) { m.Icons = []Icon{} }, want: "Manifest icons are required"},
+		"unknown display":       {edit: func(m *Manifest) { m.Display = "window" }, want: "display must be"},
+		"unknown orientation":   {edit: func(m *Manifest) { m.Orientation = "sideways" }, want: "not a valid orientation"},
+		"unknown dir":           {edit: func(m *Manifest) { m.Dir = "up" }, want: "dir must be"},
+		"bad lang":              {edit: func(m *Manifest) { m.Lang = "english!" }, want: "not a language tag"},
+		"lang":                  {edit: func(m *Manifest) { m.Lang = "pt-BR" }},
+		"named color":           {edit: func(m *Manifest) { m.ThemeColor = "RebeccaPurple" }},
+		"functional color":      {edit: func(m *Manifest) { m.BackgroundColor = "rgb(255 0 0 / 50%)" }},
+		"bad color":             {edit: func(m *Manifest) { m.ThemeColor = "#ff00zz" }, want: "not a CSS color"},
+		"cross-origin start":    {edit: func(m *Manifest) { m.StartUrl = "https://example.com/" }, want: "start url must be on the app's origin"},
+		"cross-origin scope":    {edit: func(m *Manifest) { m.Scope = "//example.com/" }, want: "scope must be on the app's origin"},
+		"start outside scope":   {edit: func(m *Manifest) { m.Scope = "games/" }, want: "is not within scope"},
+		"origin-wide scope":     {edit: func(m *Manifest) { m.Scope = "/" }},
+		"cross-origin id":       {edit: func(m *Manifest) { m.Id = "https://example.com/shape" }, want: "id must be on the app's origin"},
+		"icon not in bundle":    {edit: func(m *Manifest) { m.Icons[0].Src = "icon.png" }, want: "not a file of the bundle"},
+		"icon of another app":   {edit: func(m *Manifest) { m.Icons[0].Src = "/app/other/icons/icon-192.png" }, want: "not a file of the bundle"},
+		"root-absolute icon":    {edit: func(m *Manifest) { m.Icons[0].Src = "/app/shape/icons/icon-192.png" }, want: "not a file of the bundle"},
+		"icon above the bundle": {edit: func(m *Manifest) { m.Icons[0].Src = "../icons/icon-192.png" }, want: "not a file of the bundle"},
+		"icon in a subfolder":   {edit: func(m *Manifest) { m.Icons[0].Src = "./icons/../icons/icon-192.png" }},
+		"bad icon sizes":        {edit: func(m *Manifest) { m.Icons[0].Sizes = "192" }, want: "invalid sizes"},
+		"any icon size":         {edit: func(m *Manifest) { m.Icons[0].Sizes = "any" }},
+		"icon not an image":     {edit: func(m *Manifest) { m.Icons[0].Type = "text/html" }, want: "not an image type"},
+		"unknown icon purpose":  {edit: func(m *Manifest) { m.Icons[0].Purpose = "any badge" }, want: "unknown purpose"},
+		"maskable and any icon": {edit: func(m *Manifest) { m.Icons[0].Purpose = "maskable any" }},
+	}
+	for name, tt := range tests {
+		manifest := validManifest()
+		tt.edit(&manifest)
+		resp, _ := validateManifest("shape", normalizeManifest(manifest), files)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package publisher
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*****************************************************/
+// In-memory services
+/*****************************************************/
+// The in-memory services stand in for DynamoDB and S3 in tests and in the
+// local development server. They keep the semantics the handlers rely on
+// but none of the AWS limits.
+
+// NewMemoryServices returns services backed by fresh in-memory stores.
+// Presigned URLs point below baseUrl.
+func NewMemoryServices(baseUrl string) Services {
+	return Services{
+		Apps:       NewMemoryAppStore(),
+		Publishers: NewMemoryPublisherStore(),
+		Blobs:      NewMemoryBlobStore(baseUrl),
+	}
+}
+
+// MemoryAppStore keeps app records keyed by app id.
+type MemoryAppStore struct {
+	mu   sync.Mutex
+	apps map[string]AppRecord
+}
+
+func NewMemoryAppStore() *MemoryAppStore {
+	return &MemoryAppStore{apps: make(map[string]AppRecord)}
+}
+
+func (s *MemoryAppStore) SaveApp(ctx context.Context, record AppRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[record.AppId] = record
+	return nil
+}
+
+func (s *MemoryAppStore) ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]AppRecord, error) {
+	var records []AppRecord
+	for _, record := range s.Apps() {
+		if record.PublisherId == publisherId && record.AppSlug == appSlug {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Apps returns the stored records ordered by upload time.
+func (s *MemoryAppStore) Apps() []AppRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := make([]AppRecord, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].UploadTimestamp != apps[j].UploadTimestamp {
+			return apps[i].UploadTimestamp < apps[j].UploadTimestamp
+		}
+		return apps[i].AppId < apps[j].AppId
+	})
+	return apps
+}
+
+// MemoryPublisherStore keeps publisher profiles keyed by publisher id.
+type MemoryPublisherStore struct {
+	mu         sync.Mutex
+	publishers map[string]PublisherProfile
+}
+
+func NewMemoryPublisherStore() *MemoryPublisherStore {
+	return &MemoryPublisherStore{publishers: make(map[string]PublisherProfile)}
+}
+
+func (s *MemoryPublisherStore) GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.publishers[publisherId]
+	if !ok {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+func (s *MemoryPublisherStore) UpdatePublisher(ctx context.Context, publisherId string, request UpdateProfileRequest) (*PublisherProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339)
+	profile, ok := s.publishers[publisherId]
+	if !ok {
+		profile = PublisherProfile{PublisherId: publisherId, CreatedAt: now}
+	}
+	profile.DisplayName = strings.TrimSpace(request.DisplayName)
+	profile.Website = request.Website
+	profile.UpdatedAt = now
+	s.publishers[publisherId] = profile
+	return &profile, nil
+}
+
+func (s *MemoryPublisherStore) SetPublisherAvatar(ctx context.Context, publisherId, avatarKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.publishers[publisherId]
+	if !ok {
+		return errPublisherNotFound
+	}
+	profile.AvatarKey = avatarKey
+	profile.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	s.publishers[publisherId] = profile
+	return nil
+}
+
+// MemoryBlobStore hands out unsigned URLs, remembers the keys it issued and
+// keeps the objects written through it in memory.
+type MemoryBlobStore struct {
+	mu      sync.Mutex
+	baseUrl string
+	keys    []string
+	puts    map[string]PresignedPut
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+// memoryUpload is a multipart upload in progress.
+type memoryUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+type memoryObject struct {
+	body         []byte
+	contentType  string
+	cacheControl string
+	lastModified time.Time
+}
+
+func NewMemoryBlobStore(baseUrl string) *MemoryBlobStore {
+	return &MemoryBlobStore{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		puts:    make(map[string]PresignedPut),
+		objects: make(map[string]memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (s *MemoryBlobStore) PresignPut(ctx context.Context, key, contentType string, size int64, sha256Hex string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	s.puts[key] = PresignedPut{ContentType: contentType, Size: size, SHA256: sha256Hex}
+	return s.baseUrl + "/" + key, nil
+}
+
+// PresignedKeys returns the keys of every URL handed out so far.
+func (s *MemoryBlobStore) PresignedKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+// PresignedPut is what an upload URL issued by PresignPut is bound to.
+type PresignedPut struct {
+	ContentType string
+	Size        int64
+	SHA256      string
+}
+
+// PresignedPut returns what the last upload URL issued for key accepts.
+func (s *MemoryBlobStore) PresignedPut(key string) (PresignedPut, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	put, ok := s.puts[key]
+	return put, ok
+}
+
+func (s *MemoryBlobStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := s.GetObjectWithETag(ctx, key)
+	return body, err
+}
+
+func (s *MemoryBlobStore) GetObjectWithETag(ctx context.Context, key string) ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, "", fmt.Errorf("failed to get object %s: %w", key, ErrObjectNotFound)
+	}
+	return append([]byte(nil), object.body...), memoryETag(object.body), nil
+}
+
+func (s *MemoryBlobStore) PutObject(ctx context.Context, key string, body []byte, contentType, cacheControl string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putObject(key, body, contentType, cacheControl)
+	return nil
+}
+
+// PutObjectIfMatch checks the ETag of the object the way S3 checks If-Match
+// and If-None-Match: * on a conditional write.
+func (s *MemoryBlobStore) PutObjectIfMatch(ctx context.Context, key string, body []byte, contentType, cacheControl, etag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, exists := s.objects[key]
+	if (etag == "" && exists) || (etag != "" && (!exists || memoryETag(object.body) != etag)) {
+		return fmt.Errorf("failed to put object %s: %w", key, ErrPreconditionFailed)
+	}
+	s.putObject(key, body, contentType, cacheControl)
+	return nil
+}
+
+func (s *MemoryBlobStore) putObject(key string, body []byte, contentType, cacheControl string) {
+	s.objects[key] = memoryObject{
+		body:         append([]byte(nil), body...),
+		contentType:  contentType,
+		cacheControl: cacheControl,
+		lastModified: time.Now(),
+	}
+}
+
+// PutObjectAt writes an object with the given modification time, so tests can
+// create objects that look old.
+func (s *MemoryBlobStore) PutObjectAt(key string, body []byte, lastModified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{body: append([]byte(nil), body...), lastModified: lastModified}
+}
+
+func (s *MemoryBlobStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []ObjectInfo
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, LastModified: object.lastModified})
+		}
+	}
+	// S3 lists keys in lexicographic order
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *MemoryBlobStore) DeleteObjects(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	return nil
+}
+
+func (s *MemoryBlobStore) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadId := fmt.Sprintf("multipart-%d", len(s.uploads)+1)
+	s.uploads[uploadId] = &memoryUpload{key: key, parts: make(map[int][]byte)}
+	return uploadId, nil
+}
+
+func (s *MemoryBlobStore) PresignUploadPart(ctx context.Context, key, uploadId string, partNumber int, size int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return fmt.Sprintf("%s/%s?partNumber=%d&uploadId=%s", s.baseUrl, key, partNumber, uploadId), nil
+}
+
+// UploadPart stores a part the way a client's PUT to its presigned URL does
+// and returns its ETag.
+func (s *MemoryBlobStore) UploadPart(uploadId string, partNumber int, body []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok {
+		return "", ErrUploadNotFound
+	}
+	upload.parts[partNumber] = append([]byte(nil), body...)
+	return memoryETag(body), nil
+}
+
+func memoryETag(body []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(body))
+}
+
+func (s *MemoryBlobStore) ListParts(ctx context.Context, key, uploadId string) ([]UploadedPart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.key != key {
+		return nil, fmt.Errorf("failed to list parts of %s: %w", key, ErrUploadNotFound)
+	}
+	parts := make([]UploadedPart, 0, len(upload.parts))
+	for partNumber, body := range upload.parts {
+		parts = append(parts, UploadedPart{PartNumber: partNumber, ETag: memoryETag(body), Size: int64(len(body))})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload joins the listed parts into the object. Like S3 it
+// rejects parts that were not uploaded or whose ETag differs.
+func (s *MemoryBlobStore) CompleteMultipartUpload(ctx context.Context, key, uploadId string, parts []UploadedPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.key != key {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, ErrUploadNotFound)
+	}
+	var body []byte
+	for _, part := range parts {
+		stored, ok := upload.parts[part.PartNumber]
+		if !ok || memoryETag(stored) != part.ETag {
+			return fmt.Errorf("InvalidPart: part %d of %s was not uploaded", part.PartNumber, key)
+		}
+		body = append(body, stored...)
+	}
+	delete(s.uploads, uploadId)
+	s.objects[key] = memoryObject{body: body, lastModified: time.Now()}
+	return nil
+}
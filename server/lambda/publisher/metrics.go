@@ -0,0 +1,123 @@
+package publisher
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*****************************************************/
+// CloudWatch Embedded Metric Format
+/*****************************************************/
+// Lines written to stdout in EMF are turned into CloudWatch metrics by the
+// Lambda log agent, so no PutMetricData calls or extra permissions are needed.
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+const (
+	metricsService          = "publisher"
+	defaultMetricsNamespace = "MiniApps"
+)
+
+type metricUnit string
+
+const (
+	unitCount        metricUnit = "Count"
+	unitMilliseconds metricUnit = "Milliseconds"
+	unitBytes        metricUnit = "Bytes"
+)
+
+const (
+	outcomeSuccess     = "success"
+	outcomeClientError = "client_error"
+	outcomeServerError = "server_error"
+)
+
+type metric struct {
+	name  string
+	unit  metricUnit
+	value float64
+}
+
+type emfMetricDefinition struct {
+	Name string     `json:"Name"`
+	Unit metricUnit `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string                `json:"Namespace"`
+	Dimensions [][]string            `json:"Dimensions"`
+	Metrics    []emfMetricDefinition `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+var (
+	metricsOutput io.Writer = os.Stdout
+	metricsMutex  sync.Mutex
+)
+
+func countMetric(name string, value int) metric {
+	return metric{name: name, unit: unitCount, value: float64(value)}
+}
+
+func bytesMetric(name string, value int64) metric {
+	return metric{name: name, unit: unitBytes, value: float64(value)}
+}
+
+func durationMetric(name string, duration time.Duration) metric {
+	return metric{name: name, unit: unitMilliseconds, value: float64(duration.Microseconds()) / 1000}
+}
+
+// outcomeForStatus groups HTTP status codes so the Outcome dimension stays small.
+func outcomeForStatus(statusCode int) string {
+	switch {
+	case statusCode >= 500:
+		return outcomeServerError
+	case statusCode >= 400:
+		return outcomeClientError
+	default:
+		return outcomeSuccess
+	}
+}
+
+// emitMetrics writes one EMF line with the Service, Route and Outcome dimensions.
+func emitMetrics(route, outcome string, metrics ...metric) {
+	if len(metrics) == 0 {
+		return
+	}
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultMetricsNamespace
+	}
+
+	line := map[string]interface{}{
+		"Service": metricsService,
+		"Route":   route,
+		"Outcome": outcome,
+	}
+	definitions := make([]emfMetricDefinition, 0, len(metrics))
+	for _, m := range metrics {
+		definitions = append(definitions, emfMetricDefinition{Name: m.name, Unit: m.unit})
+		line[m.name] = m.value
+	}
+	line["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixMilli(),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  namespace,
+			Dimensions: [][]string{{"Service", "Route", "Outcome"}},
+			Metrics:    definitions,
+		}},
+	}
+
+	body, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	metricsOutput.Write(append(body, '\n'))
+}
@@ -0,0 +1,195 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := metricsOutput
+	metricsOutput = &buf
+	t.Cleanup(func() { metricsOutput = previous })
+	return &buf
+}
+
+func decodeMetricLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("metric line is not JSON: %v: %s", err, line)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func newAPIRequest(method, path string) events.APIGatewayV2HTTPRequest {
+	var request events.APIGatewayV2HTTPRequest
+	request.RawPath = path
+	request.RequestContext.RequestID = "req-123"
+	request.RequestContext.HTTP.Method = method
+	return request
+}
+
+func TestEmitMetricsWritesEMFLine(t *testing.T) {
+	buf := captureMetrics(t)
+
+	emitMetrics("POST /publish/{app-slug}/version/{version-id}", outcomeSuccess,
+		countMetric("PublishHandshakes", 1),
+		bytesMetric("PublishDeclaredBytes", 2048),
+		durationMetric("Latency", 1500*time.Microsecond),
+	)
+
+	lines := decodeMetricLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 metric line, got %d", len(lines))
+	}
+	line := lines[0]
+	if line["Service"] != "publisher" || line["Route"] != "POST /publish/{app-slug}/version/{version-id}" || line["Outcome"] != "success" {
+		t.Errorf("unexpected dimensions: %v", line)
+	}
+	if line["PublishHandshakes"] != 1.0 || line["PublishDeclaredBytes"] != 2048.0 || line["Latency"] != 1.5 {
+		t.Errorf("unexpected metric values: %v", line)
+	}
+
+	awsMetadata := line["_aws"].(map[string]interface{})
+	if _, ok := awsMetadata["Timestamp"].(float64); !ok {
+		t.Errorf("missing Timestamp: %v", awsMetadata)
+	}
+	directive := awsMetadata["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	if directive["Namespace"] != defaultMetricsNamespace {
+		t.Errorf("unexpected namespace: %v", directive["Namespace"])
+	}
+	dimensions, _ := json.Marshal(directive["Dimensions"])
+	if string(dimensions) != `[["Service","Route","Outcome"]]` {
+		t.Errorf("unexpected dimensions: %s", dimensions)
+	}
+	definitions, _ := json.Marshal(directive["Metrics"])
+	expected := `[{"Name":"PublishHandshakes","Unit":"Count"},{"Name":"PublishDeclaredBytes","Unit":"Bytes"},{"Name":"Latency","Unit":"Milliseconds"}]`
+	if string(definitions) != expected {
+		t.Errorf("unexpected metric definitions: %s", definitions)
+	}
+}
+
+func TestEmitMetricsUsesConfiguredNamespace(t *testing.T) {
+	buf := captureMetrics(t)
+	t.Setenv("METRICS_NAMESPACE", "MiniApps/Test")
+
+	emitMetrics("unzip", outcomeSuccess, countMetric("Extractions", 1))
+
+	line := decodeMetricLines(t, buf)[0]
+	directive := line["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	if directive["Namespace"] != "MiniApps/Test" {
+		t.Errorf("unexpected namespace: %v", directive["Namespace"])
+	}
+}
+
+func TestRouterEmitsRequestMetricsPerRouteAndOutcome(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     routeHandler
+		method      string
+		path        string
+		wantRoute   string
+		wantOutcome string
+	}{
+		{
+			name: "success",
+			handler: func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+				return createSuccessResponse(200, "ok"), nil
+			},
+			method:      "POST",
+			path:        "/default/publish/shape/version/v1",
+			wantRoute:   "POST /publish/{app-slug}/version/{version-id}",
+			wantOutcome: outcomeSuccess,
+		},
+		{
+			name: "client error",
+			handler: func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+				return createErrorResponse(400, "Invalid request body")
+			},
+			method:      "POST",
+			path:        "/publish/shape/version/v1",
+			wantRoute:   "POST /publish/{app-slug}/version/{version-id}",
+			wantOutcome: outcomeClientError,
+		},
+		{
+			name: "handler error",
+			handler: func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+				return events.APIGatewayV2HTTPResponse{}, errors.New("boom")
+			},
+			method:      "POST",
+			path:        "/publish/shape/version/v1",
+			wantRoute:   "POST /publish/{app-slug}/version/{version-id}",
+			wantOutcome: outcomeServerError,
+		},
+		{
+			name:        "unmatched",
+			method:      "GET",
+			path:        "/unknown",
+			wantRoute:   "unmatched",
+			wantOutcome: outcomeClientError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureMetrics(t)
+			r := newRouter()
+			if tt.handler != nil {
+				r.handle("POST", "/publish/{app-slug}/version/{version-id}", tt.handler)
+			}
+			request := newAPIRequest(tt.method, tt.path)
+			request.RequestContext.Stage = "default"
+
+			if _, err := r.dispatch(context.Background(), request); err != nil {
+				t.Fatalf("dispatch returned error: %v", err)
+			}
+
+			lines := decodeMetricLines(t, buf)
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 metric line, got %d: %s", len(lines), buf.String())
+			}
+			if lines[0]["Route"] != tt.wantRoute || lines[0]["Outcome"] != tt.wantOutcome {
+				t.Errorf("got route %v outcome %v, want %s %s", lines[0]["Route"], lines[0]["Outcome"], tt.wantRoute, tt.wantOutcome)
+			}
+			if lines[0]["Requests"] != 1.0 {
+				t.Errorf("expected Requests=1, got %v", lines[0]["Requests"])
+			}
+		})
+	}
+}
+
+func TestHandleSQSEventCountsUnparseableMessages(t *testing.T) {
+	buf := captureMetrics(t)
+	handler := NewHandler(NewMemoryServices("http://localhost"))
+
+	response := handler.handleSQSEvent(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{{MessageId: "m-1", Body: "not json"}},
+	})
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "m-1" {
+		t.Fatalf("expected m-1 to be reported as failed, got %+v", response.BatchItemFailures)
+	}
+
+	lines := decodeMetricLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 metric line, got %d", len(lines))
+	}
+	if lines[0]["Route"] != ingestMetricsRoute || lines[0]["Outcome"] != outcomeClientError || lines[0]["MetadataMessages"] != 1.0 {
+		t.Errorf("unexpected ingest metric line: %v", lines[0])
+	}
+}
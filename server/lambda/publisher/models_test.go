@@ -0,0 +1,50 @@
+package publisher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateModels(t *testing.T) {
+	files := []File{
+		{Filename: "encoder.onnx", Size: 20 * 1024 * 1024},
+		{Filename: "models/decoder.onnx", Size: 20 * 1024 * 1024},
+		{Filename: "models/large.onnx", Size: 40 * 1024 * 1024},
+		{Filename: "weights.bin", Size: 1024},
+		{Filename: "model.onnx", Size: 1024},
+	}
+	encoder := ModelFile{Name: "encoder", Path: "encoder.onnx", Role: "encoder"}
+	decoder := ModelFile{Name: "decoder", Path: "models/decoder.onnx", Role: "decoder"}
+	tests := []struct {
+		name   string
+		models []ModelFile
+		files  []File
+		error  string
+	}{
+		{"default model", nil, files, ""},
+		{"default model missing", nil, files[:4], "The model.onnx file is required"},
+		{"encoder and decoder", []ModelFile{encoder, decoder}, files, ""},
+		{"duplicate name", []ModelFile{encoder, {Name: "encoder", Path: "model.onnx", Role: "full"}}, files, "Model encoder is listed twice"},
+		{"duplicate path", []ModelFile{encoder, {Name: "full", Path: "encoder.onnx", Role: "full"}}, files, "Model file encoder.onnx is listed twice"},
+		{"not in bundle", []ModelFile{{Name: "x", Path: "missing.onnx", Role: "x"}}, files, "Model x must be an .onnx file in the bundle"},
+		{"not onnx", []ModelFile{{Name: "x", Path: "weights.bin", Role: "x"}}, files, "Model x must be an .onnx file in the bundle"},
+		{"bad role", []ModelFile{{Name: "x", Path: "model.onnx", Role: "Main Model"}}, files, "Model names and roles must be 1-32 lowercase letters, digits, '-' or '_'"},
+		{"model too large", []ModelFile{{Name: "large", Path: "models/large.onnx", Role: "full"}}, files, "Model large exceeds 25MB"},
+		{"total too large", []ModelFile{encoder, decoder, {Name: "extra", Path: "models/extra.onnx", Role: "extra"},
+			{Name: "more", Path: "models/more.onnx", Role: "extra"}},
+			append(files, File{Filename: "models/extra.onnx", Size: 20 * 1024 * 1024}, File{Filename: "models/more.onnx", Size: 20 * 1024 * 1024}),
+			"The models together exceed 75MB"},
+	}
+	for _, test := range tests {
+		response, _ := validateModels(PublishRequest{Models: test.models, Files: test.files})
+		if test.error == "" {
+			if response.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", test.name, response.Body)
+			}
+			continue
+		}
+		if response.StatusCode != 400 || !strings.Contains(response.Body, test.error) {
+			t.Errorf("%s: got %d %s, want 400 %q", test.name, response.StatusCode, response.Body, test.error)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Multipart uploads
+/*****************************************************/
+// A publish request with multipart set uploads the bundle in parts instead of
+// one PUT, which a slow connection may not finish before its URL expires. The
+// publisher starts an S3 multipart upload of the usual uploads/.../{id}.zip
+// key and hands out a URL per part. The client can ask for the parts again to
+// resume with fresh URLs for those still missing, and calls the complete
+// route with the ETag of each part. S3 notifies unzip once the upload is
+// completed, as for a single PUT. Uploads that are never completed are
+// aborted by a lifecycle rule of the apps bucket.
+
+const (
+	multipartPartSize = 8 * 1024 * 1024
+	// The files' own limit plus room for the zip's headers
+	maxBundleBytes = 128 * 1024 * 1024
+)
+
+// MultipartRequest asks for a multipart upload of a bundle of Size bytes.
+// Parts are only bound to their size, so unzip checks the whole zip against
+// SHA256 before extracting it.
+type MultipartRequest struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// MultipartUpload is the S3 multipart upload behind an upload. It is stored in
+// the publish declaration, where unzip ignores it.
+type MultipartUpload struct {
+	UploadId string `json:"upload_id"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+}
+
+func (m MultipartUpload) partCount() int {
+	return int((m.Size + m.PartSize - 1) / m.PartSize)
+}
+
+// partSize is the size of a part; only the last one is smaller.
+func (m MultipartUpload) partSize(partNumber int) int64 {
+	return min(m.PartSize, m.Size-int64(partNumber-1)*m.PartSize)
+}
+
+// UploadPart is a part of a multipart upload as the client sees it: its ETag
+// once it is uploaded, an upload URL until then.
+type UploadPart struct {
+	PartNumber   int    `json:"part_number"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	PresignedUrl string `json:"presigned_url,omitempty"`
+}
+
+// CompleteUploadRequest lists the ETag S3 returned for each uploaded part.
+type CompleteUploadRequest struct {
+	Parts []CompletedPart `json:"parts"`
+}
+
+type CompletedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+func validateMultipart(request PublishRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if request.Multipart == nil {
+		return events.APIGatewayV2HTTPResponse{}, nil
+	}
+	if isDeltaRequest(request) {
+		return createErrorResponse(400, "A delta upload cannot also be a multipart upload")
+	}
+	if request.Multipart.Size <= 0 || request.Multipart.Size > maxBundleBytes {
+		return createErrorResponse(400, fmt.Sprintf("Multipart uploads need the bundle size, at most %dMB", maxBundleBytes/(1024*1024)))
+	}
+	if !sha256Pattern.MatchString(request.Multipart.SHA256) {
+		return createErrorResponse(400, "Multipart uploads need the bundle's SHA-256 as 64 lowercase hex digits")
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// createMultipartUpload starts the multipart upload of the bundle and stores
+// it with the declaration. An upload whose declaration could not be stored is
+// never completed and is aborted by the lifecycle rule.
+func (h *Handler) createMultipartUpload(ctx context.Context, appSlug, versionId, publisherId string, request MultipartRequest, declaration PublishDeclaration) (string, []UploadPart, error) {
+	uploadId := newUploadId(ctx)
+	upload := uploadKeyBase(appSlug, versionId, publisherId, uploadId)
+	multipartId, err := h.services.Blobs.CreateMultipartUpload(ctx, upload+".zip")
+	if err != nil {
+		return "", nil, err
+	}
+	multipart := MultipartUpload{UploadId: multipartId, Size: request.Size, PartSize: multipartPartSize}
+	declaration.Multipart = &multipart
+	declaration.Bundle = &Bundle{Size: request.Size, SHA256: request.SHA256}
+	if err := h.storeDeclaration(ctx, upload, declaration); err != nil {
+		return "", nil, err
+	}
+	parts, err := h.uploadParts(ctx, upload+".zip", multipart, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return uploadId, parts, nil
+}
+
+// uploadParts lists every part of a multipart upload, with the ETag of those
+// already uploaded in full and a fresh URL for the others.
+func (h *Handler) uploadParts(ctx context.Context, key string, multipart MultipartUpload, uploaded []UploadedPart) ([]UploadPart, error) {
+	stored := make(map[int]UploadedPart, len(uploaded))
+	for _, part := range uploaded {
+		stored[part.PartNumber] = part
+	}
+	parts := make([]UploadPart, 0, multipart.partCount())
+	for partNumber := 1; partNumber <= multipart.partCount(); partNumber++ {
+		part := UploadPart{PartNumber: partNumber, Size: multipart.partSize(partNumber)}
+		if existing, ok := stored[partNumber]; ok && existing.Size == part.Size {
+			part.ETag = existing.ETag
+		} else {
+			url, err := h.services.Blobs.PresignUploadPart(ctx, key, multipart.UploadId, partNumber, part.Size)
+			if err != nil {
+				return nil, err
+			}
+			part.PresignedUrl = url
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func (h *Handler) handleMultipartPublish(ctx context.Context, appSlug, versionId, publisherId string, request PublishRequest, declaration PublishDeclaration) (events.APIGatewayV2HTTPResponse, error) {
+	uploadId, parts, err := h.createMultipartUpload(ctx, appSlug, versionId, publisherId, *request.Multipart, declaration)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating multipart upload", "error", err)
+		return createErrorResponse(500, "Failed to generate presigned URLs")
+	}
+
+	declaredBytes := 0
+	for _, file := range request.Files {
+		declaredBytes += file.Size
+	}
+	emitMetrics("POST /publish/{app-slug}/version/{version-id}", outcomeSuccess,
+		countMetric("PublishHandshakes", 1),
+		countMetric("PublishDeclaredFiles", len(request.Files)),
+		bytesMetric("PublishDeclaredBytes", int64(declaredBytes)),
+		countMetric("PublishUploadParts", len(parts)),
+	)
+
+	return createSuccessResponse(200, map[string]interface{}{
+		"message":   "Presigned URLs generated successfully",
+		"upload_id": uploadId,
+		"part_size": multipartPartSize,
+		"parts":     parts,
+	}), nil
+}
+
+// loadUpload reads the declaration of one of the caller's uploads. The
+// publisher id is part of the key, so a publisher can only reach their own
+// uploads. A missing declaration is reported with ErrObjectNotFound.
+func (h *Handler) loadUpload(ctx context.Context, publisherId string, request events.APIGatewayV2HTTPRequest) (string, PublishDeclaration, error) {
+	var declaration PublishDeclaration
+	upload := uploadKeyBase(request.PathParameters["app-slug"], request.PathParameters["version-id"], publisherId, request.PathParameters["upload-id"])
+	body, err := h.services.Blobs.GetObject(ctx, upload+".json")
+	if err != nil {
+		return "", declaration, err
+	}
+	if err := json.Unmarshal(body, &declaration); err != nil {
+		return "", declaration, fmt.Errorf("failed to parse publish declaration of %s: %w", upload, err)
+	}
+	return upload, declaration, nil
+}
+
+// UploadStatus is what unzip records about an upload it rejected, at
+// uploads/.../{uploadId}.status.json.
+type UploadStatus struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	RejectedAt time.Time `json:"rejected_at"`
+}
+
+// uploadStatus reads the status unzip recorded for an upload, or nil when it
+// recorded none.
+func (h *Handler) uploadStatus(ctx context.Context, upload string) (*UploadStatus, error) {
+	body, err := h.services.Blobs.GetObject(ctx, upload+".status.json")
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status UploadStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status of %s: %w", upload, err)
+	}
+	return &status, nil
+}
+
+// handleGetUpload reports why unzip rejected an upload, and lets a client
+// resume a multipart upload: it lists the parts S3 holds and issues new URLs
+// for the rest.
+func (h *Handler) handleGetUpload(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+	upload, declaration, err := h.loadUpload(ctx, publisherId, request)
+	if errors.Is(err, ErrObjectNotFound) {
+		return createErrorResponse(404, "Upload not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading publish declaration", "error", err)
+		return createErrorResponse(500, "Failed to read upload")
+	}
+	status, err := h.uploadStatus(ctx, upload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading upload status", "error", err)
+		return createErrorResponse(500, "Failed to read upload")
+	}
+	if status != nil {
+		return createSuccessResponse(200, map[string]interface{}{
+			"upload_id":   request.PathParameters["upload-id"],
+			"status":      status.Status,
+			"error":       status.Error,
+			"rejected_at": status.RejectedAt,
+		}), nil
+	}
+	if declaration.Multipart == nil {
+		return createErrorResponse(400, "Only multipart uploads can be resumed")
+	}
+
+	uploaded, err := h.services.Blobs.ListParts(ctx, upload+".zip", declaration.Multipart.UploadId)
+	if errors.Is(err, ErrUploadNotFound) {
+		return createErrorResponse(404, "Upload is no longer in progress")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing uploaded parts", "error", err)
+		return createErrorResponse(500, "Failed to read upload")
+	}
+	parts, err := h.uploadParts(ctx, upload+".zip", *declaration.Multipart, uploaded)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating presigned URLs", "error", err)
+		return createErrorResponse(500, "Failed to generate presigned URLs")
+	}
+	return createSuccessResponse(200, map[string]interface{}{
+		"upload_id": request.PathParameters["upload-id"],
+		"part_size": declaration.Multipart.PartSize,
+		"parts":     parts,
+	}), nil
+}
+
+// completeMultipartUpload checks the client's parts against those S3 holds
+// before completing the upload, so a bundle is never assembled from a missing,
+// truncated or replaced part.
+func (h *Handler) completeMultipartUpload(ctx context.Context, request events.APIGatewayV2HTTPRequest, upload string, multipart MultipartUpload) (events.APIGatewayV2HTTPResponse, error) {
+	var completeReq CompleteUploadRequest
+	if err := json.Unmarshal([]byte(request.Body), &completeReq); err != nil {
+		return createErrorResponse(400, "Invalid request body")
+	}
+	if len(completeReq.Parts) != multipart.partCount() {
+		return createErrorResponse(400, fmt.Sprintf("The upload has %d parts", multipart.partCount()))
+	}
+
+	uploaded, err := h.services.Blobs.ListParts(ctx, upload+".zip", multipart.UploadId)
+	if errors.Is(err, ErrUploadNotFound) {
+		return createErrorResponse(404, "Upload is no longer in progress")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing uploaded parts", "error", err)
+		return createErrorResponse(500, "Failed to complete upload")
+	}
+	stored := make(map[int]UploadedPart, len(uploaded))
+	for _, part := range uploaded {
+		stored[part.PartNumber] = part
+	}
+
+	var missing []string
+	parts := make([]UploadedPart, 0, len(completeReq.Parts))
+	for i, part := range completeReq.Parts {
+		partNumber := i + 1
+		if part.PartNumber != partNumber {
+			return createErrorResponse(400, "Parts must be listed in order, starting with 1")
+		}
+		existing, ok := stored[partNumber]
+		if !ok || existing.Size != multipart.partSize(partNumber) {
+			missing = append(missing, strconv.Itoa(partNumber))
+			continue
+		}
+		if strings.Trim(part.ETag, `"`) != strings.Trim(existing.ETag, `"`) {
+			return createErrorResponse(400, fmt.Sprintf("Part %d does not match the uploaded part", partNumber))
+		}
+		parts = append(parts, existing)
+	}
+	if len(missing) > 0 {
+		return createErrorResponse(400, fmt.Sprintf("Parts not uploaded yet: %s", strings.Join(missing, ", ")))
+	}
+
+	if err := h.services.Blobs.CompleteMultipartUpload(ctx, upload+".zip", multipart.UploadId, parts); err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			return createErrorResponse(404, "Upload is no longer in progress")
+		}
+		slog.ErrorContext(ctx, "Error completing multipart upload", "error", err)
+		return createErrorResponse(500, "Failed to complete upload")
+	}
+	return createSuccessResponse(202, map[string]interface{}{
+		"message":   "Upload complete, processing started",
+		"upload_id": request.PathParameters["upload-id"],
+	}), nil
+}
@@ -0,0 +1,149 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMultipartUploadIsResumedAndCompletedAgainstItsParts(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+
+	bundle := bytes.Repeat([]byte("z"), 2*multipartPartSize+100)
+	uploadId, parts, err := handler.createMultipartUpload(ctx, "shape", "1.0.0", "publisher-1", MultipartRequest{Size: int64(len(bundle)), SHA256: strings.Repeat("a", 64)}, PublishDeclaration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 || parts[2].Size != 100 || parts[0].PresignedUrl == "" {
+		t.Fatalf("parts = %+v, want two full parts and one of 100 bytes", parts)
+	}
+	upload := uploadKeyBase("shape", "1.0.0", "publisher-1", uploadId)
+	body, err := blobs.GetObject(ctx, upload+".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var declaration PublishDeclaration
+	if err := json.Unmarshal(body, &declaration); err != nil || declaration.Multipart == nil {
+		t.Fatalf("declaration %s does not record the multipart upload: %v", body, err)
+	}
+	multipart := *declaration.Multipart
+
+	// The connection drops after the first part
+	etags := make([]string, len(parts))
+	etags[0], err = blobs.UploadPart(multipart.UploadId, 1, bundle[:multipartPartSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := blobs.ListParts(ctx, upload+".zip", multipart.UploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := handler.uploadParts(ctx, upload+".zip", multipart, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed[0].ETag != etags[0] || resumed[0].PresignedUrl != "" || resumed[1].PresignedUrl == "" || resumed[2].PresignedUrl == "" {
+		t.Fatalf("resumed parts = %+v, want URLs only for parts 2 and 3", resumed)
+	}
+
+	complete := func(etags []string) events.APIGatewayV2HTTPResponse {
+		t.Helper()
+		var request CompleteUploadRequest
+		for i, etag := range etags {
+			request.Parts = append(request.Parts, CompletedPart{PartNumber: i + 1, ETag: etag})
+		}
+		body, _ := json.Marshal(request)
+		response, err := handler.completeMultipartUpload(ctx, events.APIGatewayV2HTTPRequest{Body: string(body)}, upload, multipart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if response := complete(etags); response.StatusCode != 400 || !strings.Contains(response.Body, "Parts not uploaded yet: 2, 3") {
+		t.Errorf("completing early: got %d %s", response.StatusCode, response.Body)
+	}
+
+	etags[1], _ = blobs.UploadPart(multipart.UploadId, 2, bundle[multipartPartSize:2*multipartPartSize])
+	etags[2], _ = blobs.UploadPart(multipart.UploadId, 3, bundle[2*multipartPartSize:])
+	if response := complete([]string{etags[0], etags[2], etags[1]}); response.StatusCode != 400 || !strings.Contains(response.Body, "Part 2 does not match") {
+		t.Errorf("completing with a wrong ETag: got %d %s", response.StatusCode, response.Body)
+	}
+	if response := complete(etags[:2]); response.StatusCode != 400 || !strings.Contains(response.Body, "has 3 parts") {
+		t.Errorf("completing without the last part: got %d %s", response.StatusCode, response.Body)
+	}
+	if response := complete(etags); response.StatusCode != 202 {
+		t.Fatalf("complete: got %d %s, want 202", response.StatusCode, response.Body)
+	}
+	zip, err := blobs.GetObject(ctx, upload+".zip")
+	if err != nil || !bytes.Equal(zip, bundle) {
+		t.Fatalf("the completed upload is not the bundle: %v", err)
+	}
+	if response := complete(etags); response.StatusCode != 404 {
+		t.Errorf("completing twice: got %d %s, want 404", response.StatusCode, response.Body)
+	}
+}
+
+func TestValidateMultipart(t *testing.T) {
+	sha := strings.Repeat("a", 64)
+	tests := map[string]struct {
+		request PublishRequest
+		want    string
+	}{
+		"single upload": {request: PublishRequest{}},
+		"valid":         {request: PublishRequest{Multipart: &MultipartRequest{Size: 50 << 20, SHA256: sha}}},
+		"no size":       {request: PublishRequest{Multipart: &MultipartRequest{SHA256: sha}}, want: "need the bundle size"},
+		"too large":     {request: PublishRequest{Multipart: &MultipartRequest{Size: maxBundleBytes + 1, SHA256: sha}}, want: "at most 128MB"},
+		"no checksum":   {request: PublishRequest{Multipart: &MultipartRequest{Size: 1}}, want: "SHA-256 as 64 lowercase hex digits"},
+		"bad checksum":  {request: PublishRequest{Multipart: &MultipartRequest{Size: 1, SHA256: "abc"}}, want: "SHA-256 as 64 lowercase hex digits"},
+		"delta": {
+			request: PublishRequest{Multipart: &MultipartRequest{Size: 1, SHA256: sha}, Files: []File{{Filename: "app.js", SHA256: sha}}},
+			want:    "cannot also be a multipart upload",
+		},
+	}
+	for name, tt := range tests {
+		resp, _ := validateMultipart(tt.request)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestRejectedUploadReportsWhy(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+
+	upload := uploadKeyBase("shape", "1.0.0", "publisher-1", "req-1")
+	blobs.PutObject(ctx, upload+".json", []byte(`{"models":[]}`), jsonContentType, "")
+	status, _ := json.Marshal(UploadStatus{Status: "rejected", Error: "sw.js is not at the root of the bundle"})
+	blobs.PutObject(ctx, upload+".status.json", status, jsonContentType, "")
+
+	response, err := handler.router.dispatch(ctx, publisherRequest("GET", "/publish/shape/version/1.0.0/uploads/req-1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || body["status"] != "rejected" || body["error"] != "sw.js is not at the root of the bundle" {
+		t.Errorf("got %d %s, want the rejection", response.StatusCode, response.Body)
+	}
+}
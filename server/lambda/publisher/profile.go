@@ -0,0 +1,279 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Publisher Profile types
+/*****************************************************/
+type PublisherProfile struct {
+	PublisherId string `dynamodbav:"publisherId" json:"publisherId"`
+	DisplayName string `dynamodbav:"displayName" json:"displayName"`
+	Website     string `dynamodbav:"website,omitempty" json:"website,omitempty"`
+	AvatarKey   string `dynamodbav:"avatarKey,omitempty" json:"-"`
+	AvatarUrl   string `dynamodbav:"-" json:"avatarUrl,omitempty"`
+	Verified    bool   `dynamodbav:"verified" json:"verified"`
+	CreatedAt   string `dynamodbav:"createdAt" json:"createdAt"`
+	UpdatedAt   string `dynamodbav:"updatedAt" json:"updatedAt"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName string `json:"display_name"`
+	Website     string `json:"website"`
+}
+
+type AvatarUploadRequest struct {
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// AvatarCompleteRequest names the avatar a publisher uploaded to the URL
+// handleAvatarUpload issued.
+type AvatarCompleteRequest struct {
+	AvatarKey string `json:"avatar_key"`
+}
+
+const (
+	maxDisplayNameLength = 50
+	maxWebsiteLength     = 200
+	maxAvatarSize        = 1 * 1024 * 1024 // 1 MB
+)
+
+var avatarExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+}
+
+/*****************************************************/
+// Publisher Profile helper functions
+/*****************************************************/
+func getPublisherIdFromJWT(request events.APIGatewayV2HTTPRequest) (string, error) {
+	claims := request.RequestContext.Authorizer.JWT.Claims
+	if sub, ok := claims["sub"]; ok && sub != "" {
+		return sub, nil
+	}
+	return "", errors.New("no sub claim found in JWT")
+}
+
+// avatarPrefix is where a publisher's avatars are uploaded.
+func avatarPrefix(publisherId string) string {
+	return "publishers/" + publisherId + "/"
+}
+
+func getAvatarUrl(avatarKey string) string {
+	if avatarKey == "" {
+		return ""
+	}
+	return strings.TrimSuffix(os.Getenv("assets_base_url"), "/") + "/" + avatarKey
+}
+
+func validateUpdateProfileRequest(request UpdateProfileRequest) (events.APIGatewayV2HTTPResponse, error) {
+	displayName := strings.TrimSpace(request.DisplayName)
+	if displayName == "" {
+		return createErrorResponse(400, "Display name is required")
+	}
+	if len(displayName) > maxDisplayNameLength {
+		return createErrorResponse(400, fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength))
+	}
+	if request.Website != "" {
+		if len(request.Website) > maxWebsiteLength {
+			return createErrorResponse(400, fmt.Sprintf("Website must be at most %d characters", maxWebsiteLength))
+		}
+		websiteUrl, err := url.Parse(request.Website)
+		if err != nil || (websiteUrl.Scheme != "http" && websiteUrl.Scheme != "https") || websiteUrl.Host == "" {
+			return createErrorResponse(400, "Website must be a valid http or https URL")
+		}
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+func validateAvatarUploadRequest(request AvatarUploadRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if _, ok := avatarExtensions[request.ContentType]; !ok {
+		return createErrorResponse(400, "Avatar must be a png, jpeg or webp image")
+	}
+	if request.Size <= 0 {
+		return createErrorResponse(400, "Avatar size is required")
+	}
+	if request.Size > maxAvatarSize {
+		return createErrorResponse(400, "Avatar size exceeds 1MB")
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+/*****************************************************/
+// Publisher Profile handler functions
+/*****************************************************/
+func (h *Handler) handleGetPublisherProfile(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+
+	profile, err := h.services.Publishers.GetPublisher(ctx, publisherId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting publisher profile", "error", err)
+		return createErrorResponse(500, "Error retrieving publisher profile")
+	}
+	if profile == nil {
+		return createErrorResponse(404, "Publisher profile not found")
+	}
+	profile.AvatarUrl = getAvatarUrl(profile.AvatarKey)
+	return createSuccessResponse(200, profile), nil
+}
+
+func (h *Handler) handleUpdatePublisherProfile(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+
+	var updateReq UpdateProfileRequest
+	if err := json.Unmarshal([]byte(request.Body), &updateReq); err != nil {
+		return createErrorResponse(400, "Invalid request body")
+	}
+	if errorResp, _ := validateUpdateProfileRequest(updateReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+
+	profile, err := h.services.Publishers.UpdatePublisher(ctx, publisherId, updateReq)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error updating publisher profile", "error", err)
+		return createErrorResponse(500, "Error updating publisher profile")
+	}
+	profile.AvatarUrl = getAvatarUrl(profile.AvatarKey)
+	return createSuccessResponse(200, profile), nil
+}
+
+func (h *Handler) handleAvatarUpload(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+
+	var avatarReq AvatarUploadRequest
+	if err := json.Unmarshal([]byte(request.Body), &avatarReq); err != nil {
+		return createErrorResponse(400, "Invalid request body")
+	}
+	if errorResp, _ := validateAvatarUploadRequest(avatarReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+
+	profile, err := h.services.Publishers.GetPublisher(ctx, publisherId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting publisher profile", "error", err)
+		return createErrorResponse(500, "Error retrieving publisher profile")
+	}
+	if profile == nil {
+		return createErrorResponse(404, "Create a publisher profile before uploading an avatar")
+	}
+
+	// A new key per upload lets CloudFront cache avatars without invalidation.
+	// The profile only points at it once the upload is completed.
+	avatarKey := fmt.Sprintf("%savatar-%d.%s", avatarPrefix(publisherId), time.Now().UnixNano(), avatarExtensions[avatarReq.ContentType])
+	presignedURL, err := h.services.Blobs.PresignPut(ctx, avatarKey, avatarReq.ContentType, int64(avatarReq.Size), "")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating avatar presigned URL", "error", err)
+		return createErrorResponse(500, "Failed to generate presigned URL")
+	}
+
+	return createSuccessResponse(200, map[string]interface{}{
+		"message":       "Presigned URL generated successfully",
+		"presigned_url": presignedURL,
+		"avatar_key":    avatarKey,
+	}), nil
+}
+
+// handleAvatarComplete points the profile at an uploaded avatar and deletes
+// the one it replaces.
+func (h *Handler) handleAvatarComplete(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
+
+	var completeReq AvatarCompleteRequest
+	if err := json.Unmarshal([]byte(request.Body), &completeReq); err != nil {
+		return createErrorResponse(400, "Invalid request body")
+	}
+	if !strings.HasPrefix(completeReq.AvatarKey, avatarPrefix(publisherId)+"avatar-") || strings.Contains(completeReq.AvatarKey, "..") {
+		return createErrorResponse(400, "avatar_key must be a key issued by POST /publishers/me/avatar")
+	}
+	objects, err := h.services.Blobs.ListObjects(ctx, completeReq.AvatarKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking uploaded avatar", "error", err)
+		return createErrorResponse(500, "Error checking the uploaded avatar")
+	}
+	uploaded := false
+	for _, object := range objects {
+		uploaded = uploaded || object.Key == completeReq.AvatarKey
+	}
+	if !uploaded {
+		return createErrorResponse(409, "The avatar has not been uploaded yet")
+	}
+
+	profile, err := h.services.Publishers.GetPublisher(ctx, publisherId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting publisher profile", "error", err)
+		return createErrorResponse(500, "Error retrieving publisher profile")
+	}
+	if profile == nil {
+		return createErrorResponse(404, "Create a publisher profile before uploading an avatar")
+	}
+	previousKey := profile.AvatarKey
+	if err := h.services.Publishers.SetPublisherAvatar(ctx, publisherId, completeReq.AvatarKey); err != nil {
+		if errors.Is(err, errPublisherNotFound) {
+			return createErrorResponse(404, "Create a publisher profile before uploading an avatar")
+		}
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error setting publisher avatar", "error", err)
+		return createErrorResponse(500, "Error updating publisher profile")
+	}
+	if previousKey != "" && previousKey != completeReq.AvatarKey {
+		// The profile no longer points at it, so a failure only leaves garbage
+		if err := h.services.Blobs.DeleteObjects(ctx, []string{previousKey}); err != nil {
+			slog.WarnContext(ctx, "Failed to delete the previous avatar", "key", previousKey, "error", err)
+		}
+	}
+
+	profile.AvatarKey = completeReq.AvatarKey
+	profile.AvatarUrl = getAvatarUrl(profile.AvatarKey)
+	return createSuccessResponse(200, profile), nil
+}
@@ -0,0 +1,112 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func publisherRequest(method, path, body string) events.APIGatewayV2HTTPRequest {
+	var request events.APIGatewayV2HTTPRequest
+	request.RequestContext.HTTP.Method = method
+	request.RawPath = path
+	request.Body = body
+	request.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"sub": "publisher-1", "cognito:groups": "[Publisher]"},
+		},
+	}
+	return request
+}
+
+func TestAvatarIsSetOnceUploadedAndReplacesThePreviousOne(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+	if _, err := services.Publishers.UpdatePublisher(ctx, "publisher-1", UpdateProfileRequest{DisplayName: "Example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func() string {
+		t.Helper()
+		response, err := handler.router.dispatch(ctx, publisherRequest("POST", "/publishers/me/avatar", `{"content_type":"image/png","size":100}`))
+		if err != nil || response.StatusCode != 200 {
+			t.Fatalf("avatar upload: %d %s %v", response.StatusCode, response.Body, err)
+		}
+		var issued struct {
+			AvatarKey string `json:"avatar_key"`
+		}
+		json.Unmarshal([]byte(response.Body), &issued)
+		return issued.AvatarKey
+	}
+	complete := func(key string) events.APIGatewayV2HTTPResponse {
+		t.Helper()
+		response, err := handler.router.dispatch(ctx, publisherRequest("PUT", "/publishers/me/avatar", `{"avatar_key":"`+key+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	first := upload()
+	if profile, _ := services.Publishers.GetPublisher(ctx, "publisher-1"); profile.AvatarKey != "" {
+		t.Errorf("avatar %q was set before it was uploaded", profile.AvatarKey)
+	}
+	if response := complete(first); response.StatusCode != 409 {
+		t.Errorf("completing a missing upload: got %d %s, want 409", response.StatusCode, response.Body)
+	}
+	blobs.PutObject(ctx, first, []byte("png"), "image/png", "")
+	if response := complete(first); response.StatusCode != 200 {
+		t.Fatalf("completing the upload: %d %s", response.StatusCode, response.Body)
+	}
+
+	second := upload()
+	blobs.PutObject(ctx, second, []byte("png"), "image/png", "")
+	if response := complete(second); response.StatusCode != 200 {
+		t.Fatalf("completing the second upload: %d %s", response.StatusCode, response.Body)
+	}
+	if profile, _ := services.Publishers.GetPublisher(ctx, "publisher-1"); profile.AvatarKey != second {
+		t.Errorf("avatar = %q, want %q", profile.AvatarKey, second)
+	}
+	if _, err := blobs.GetObject(ctx, first); err == nil {
+		t.Errorf("the replaced avatar %s was not deleted", first)
+	}
+
+	for _, key := range []string{"publishers/publisher-2/avatar-1.png", "publishers/publisher-1/../publisher-2/avatar-1.png", "app/shape/current.json"} {
+		if response := complete(key); response.StatusCode != 400 || !strings.Contains(response.Body, "avatar_key") {
+			t.Errorf("completing %s: got %d %s, want 400", key, response.StatusCode, response.Body)
+		}
+	}
+}
+
+// throttledPublishers fails every profile write the way DynamoDB does when
+// the table is throttled.
+type throttledPublishers struct {
+	PublisherStore
+}
+
+func (throttledPublishers) UpdatePublisher(ctx context.Context, publisherId string, request UpdateProfileRequest) (*PublisherProfile, error) {
+	return nil, apiError("ProvisionedThroughputExceededException")
+}
+
+func TestProfileUpdateHandsThrottlingToTheRouter(t *testing.T) {
+	captureMetrics(t)
+	services := NewMemoryServices("http://localhost")
+	services.Publishers = throttledPublishers{services.Publishers}
+	handler := NewHandler(services)
+
+	request := publisherRequest("PUT", "/publishers/me", `{"display_name":"Example"}`)
+	request.RequestContext.RequestID = "req-123"
+	response, err := handler.router.dispatch(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 503 || response.Headers["Retry-After"] == "" || response.Headers[correlationIdHeader] != "req-123" {
+		t.Errorf("got %d %v %s, want a 503 to retry with the correlation id", response.StatusCode, response.Headers, response.Body)
+	}
+}
@@ -0,0 +1,107 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestZipUploadUrlIsBoundToTheDeclaredBundle(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+
+	bundle := Bundle{Size: 4096, SHA256: strings.Repeat("ab", 32)}
+	if _, _, err := handler.createPresignedUrl(ctx, "shape", "1.0.0", "publisher-1", bundle, PublishDeclaration{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := blobs.PresignedKeys()
+	if len(keys) != 1 {
+		t.Fatalf("presigned keys = %v", keys)
+	}
+	put, _ := blobs.PresignedPut(keys[0])
+	if put != (PresignedPut{ContentType: "application/zip", Size: 4096, SHA256: bundle.SHA256}) {
+		t.Errorf("upload URL accepts %+v, want the declared zip only", put)
+	}
+
+	body, err := blobs.GetObject(ctx, strings.TrimSuffix(keys[0], ".zip")+".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var declaration PublishDeclaration
+	if err := json.Unmarshal(body, &declaration); err != nil || declaration.Bundle == nil || *declaration.Bundle != bundle {
+		t.Errorf("declaration %s does not record the bundle for unzip: %v", body, err)
+	}
+
+	headers := uploadHeaders(zipContentType, bundle.SHA256)
+	if headers["Content-Type"] != "application/zip" || headers["x-amz-checksum-sha256"] != "q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s=" {
+		t.Errorf("upload headers = %v", headers)
+	}
+}
+
+func TestValidateBundle(t *testing.T) {
+	sha := strings.Repeat("a", 64)
+	tests := map[string]struct {
+		request PublishRequest
+		want    string
+	}{
+		"valid":        {request: PublishRequest{Bundle: &Bundle{Size: 1024, SHA256: sha}}},
+		"missing":      {request: PublishRequest{}, want: "size and SHA-256"},
+		"no size":      {request: PublishRequest{Bundle: &Bundle{SHA256: sha}}, want: "size and SHA-256"},
+		"no checksum":  {request: PublishRequest{Bundle: &Bundle{Size: 1024}}, want: "size and SHA-256"},
+		"too large":    {request: PublishRequest{Bundle: &Bundle{Size: maxBundleBytes + 1, SHA256: sha}}, want: "exceeds 128MB"},
+		"delta upload": {request: PublishRequest{Files: []File{{Filename: "app.js", SHA256: sha}}}},
+		"multipart":    {request: PublishRequest{Multipart: &MultipartRequest{Size: 1024}}},
+	}
+	for name, tt := range tests {
+		resp, _ := validateBundle(tt.request)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestValidateBundleLimits(t *testing.T) {
+	files := func(count int) []File {
+		files := make([]File, count)
+		for i := range files {
+			files[i] = File{Filename: fmt.Sprintf("assets/%d.png", i), Size: 1024}
+		}
+		return files
+	}
+	tests := map[string]struct {
+		request PublishRequest
+		want    string
+	}{
+		"at the limits": {request: PublishRequest{
+			Files:        append(files(maxBundleFiles-1), File{Filename: "manifest.json", Size: maxManifestBytes}),
+			VersionNotes: strings.Repeat("é", maxVersionNotesChars),
+		}},
+		"too many files":     {request: PublishRequest{Files: files(maxBundleFiles + 1)}, want: "at most 250 files"},
+		"long file name":     {request: PublishRequest{Files: []File{{Filename: strings.Repeat("a", maxFilePathLength+1)}}}, want: "at most 128 bytes"},
+		"large manifest":     {request: PublishRequest{Files: []File{{Filename: "manifest.json", Size: maxManifestBytes + 1}}}, want: "manifest.json exceeds 16KB"},
+		"long version notes": {request: PublishRequest{VersionNotes: strings.Repeat("a", maxVersionNotesChars+1)}, want: "at most 2000 characters"},
+	}
+	for name, tt := range tests {
+		resp, _ := validateBundleLimits(tt.request)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
@@ -1,46 +1,60 @@
-package main
+package publisher
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/google/uuid"
 )
 
 /*****************************************************/
 // Publish Request types
 /*****************************************************/
+// Manifest holds the members of a W3C web app manifest the catalog and the
+// PWA shell use. Members it does not list are served with manifest.json but
+// neither validated nor compared.
 type Manifest struct {
-	Name        string `json:"name"`
-	ShortName   string `json:"short_name"`
-	StartUrl    string `json:"start_url"`
-	Display     string `json:"display"`
-	Description string `json:"description"`
-	Icons       []Icon `json:"icons"`
+	Id              string   `json:"id,omitempty" dynamodbav:"id,omitempty"`
+	Name            string   `json:"name" dynamodbav:"name"`
+	ShortName       string   `json:"short_name" dynamodbav:"shortName"`
+	Description     string   `json:"description,omitempty" dynamodbav:"description,omitempty"`
+	StartUrl        string   `json:"start_url" dynamodbav:"startUrl"`
+	Scope           string   `json:"scope,omitempty" dynamodbav:"scope,omitempty"`
+	Display         string   `json:"display" dynamodbav:"display"`
+	Orientation     string   `json:"orientation,omitempty" dynamodbav:"orientation,omitempty"`
+	ThemeColor      string   `json:"theme_color,omitempty" dynamodbav:"themeColor,omitempty"`
+	BackgroundColor string   `json:"background_color,omitempty" dynamodbav:"backgroundColor,omitempty"`
+	Lang            string   `json:"lang,omitempty" dynamodbav:"lang,omitempty"`
+	Dir             string   `json:"dir,omitempty" dynamodbav:"dir,omitempty"`
+	Categories      []string `json:"categories,omitempty" dynamodbav:"categories,omitempty"`
+	Icons           []Icon   `json:"icons" dynamodbav:"icons"`
 }
 
 type Icon struct {
-	Src   string `json:"src"`
-	Sizes string `json:"sizes"`
-	Type  string `json:"type"`
+	Src     string `json:"src" dynamodbav:"src"`
+	Sizes   string `json:"sizes,omitempty" dynamodbav:"sizes,omitempty"`
+	Type    string `json:"type,omitempty" dynamodbav:"type,omitempty"`
+	Purpose string `json:"purpose,omitempty" dynamodbav:"purpose,omitempty"`
 }
 
 type File struct {
 	Filename string `json:"filename"`
 	Size     int    `json:"size"`
 	Type     string `json:"type"`
+	// SHA256 is set by clients publishing a delta upload
+	SHA256 string `json:"sha256,omitempty"`
 }
 
 type PublishRequest struct {
@@ -48,36 +62,182 @@ type PublishRequest struct {
 	Files        []File   `json:"files"`
 	Entrypoint   string   `json:"entrypoint"`
 	VersionNotes string   `json:"version_notes"`
-	PublisherId  string   `json:"publisher_id"`
+	// Models lists the app's model files. Without it the app has one model,
+	// model.onnx at the bundle root.
+	Models []ModelFile `json:"models,omitempty"`
+	// Multipart asks for the bundle to be uploaded in parts
+	Multipart *MultipartRequest `json:"multipart,omitempty"`
+	// Bundle is the zip the client uploads in one PUT
+	Bundle *Bundle `json:"bundle,omitempty"`
+	// Listing is the app's store page
+	Listing *StoreListing `json:"listing,omitempty"`
 }
 
+// Bundle is the size and SHA-256 of the zip a client uploads. The upload URL
+// only accepts that body, and unzip checks it again before extracting.
+type Bundle struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ModelFile names one model in the bundle and says what it is for, such as
+// "encoder" and "decoder", or "quantized" and "full-precision" variants.
+type ModelFile struct {
+	Name string `json:"name" dynamodbav:"name"`
+	Path string `json:"path" dynamodbav:"path"`
+	Role string `json:"role" dynamodbav:"role"`
+}
+
+// PublishDeclaration is what a publish request declares beyond the bundle
+// itself. It is stored next to the upload, at the upload key with a .json
+// extension, for unzip to read. Files is only set for delta uploads,
+// Multipart only for multipart uploads and Bundle for both kinds of zip
+// uploads. Manifest is the normalized manifest the bundle's manifest.json must
+// match, and Entrypoint and ServiceWorker are files the bundle must have.
+// Unzip passes them, the version notes and the store listing on to ingest.
+type PublishDeclaration struct {
+	Models        []ModelFile      `json:"models"`
+	Files         []DeltaFile      `json:"files,omitempty"`
+	Multipart     *MultipartUpload `json:"multipart,omitempty"`
+	Bundle        *Bundle          `json:"bundle,omitempty"`
+	Manifest      *Manifest        `json:"manifest,omitempty"`
+	Entrypoint    string           `json:"entrypoint,omitempty"`
+	ServiceWorker string           `json:"service_worker,omitempty"`
+	VersionNotes  string           `json:"version_notes,omitempty"`
+	Listing       *StoreListing    `json:"listing,omitempty"`
+}
+
+// defaultModel is the model of apps that do not list their models.
+var defaultModel = ModelFile{Name: "model", Path: "model.onnx", Role: "default"}
+
 /*****************************************************/
 // App Metadata types for SQS processing
 /*****************************************************/
 type AppMetadataMessage struct {
 	AppSlug         string    `json:"app_slug"`
 	VersionId       string    `json:"version_id"`
+	PublisherId     string    `json:"publisher_id"`
+	RequestId       string    `json:"request_id,omitempty"`
+	ReleaseId       string    `json:"release_id"`
 	S3FilePath      string    `json:"s3_file_path"`
 	UploadTimestamp time.Time `json:"upload_timestamp"`
 	ProcessedFiles  []string  `json:"processed_files"`
-	ManifestFound   bool      `json:"manifest_found"`
-	ManifestContent string    `json:"manifest_content,omitempty"`
+	// Files is the content manifest of the release, in ProcessedFiles order
+	Files []FileEntry `json:"files"`
+	// Models describes each declared model as unzip parsed it
+	Models          []ModelInfo `json:"models,omitempty"`
+	ManifestFound   bool        `json:"manifest_found"`
+	ManifestContent string      `json:"manifest_content,omitempty"`
+	// Manifest is the normalized manifest.json, checked against the declared one
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// Icons are the manifest's raster icons and those unzip generated for it
+	Icons []IconInfo `json:"icons,omitempty"`
+	// VersionNotes and Listing are passed on from the publish request, with
+	// the screenshots measured by unzip
+	VersionNotes string        `json:"version_notes,omitempty"`
+	Listing      *StoreListing `json:"listing,omitempty"`
+	// Entrypoint and ServiceWorker are the release's files the shell loads
+	// and registers, checked by unzip
+	Entrypoint    string `json:"entrypoint,omitempty"`
+	ServiceWorker string `json:"service_worker,omitempty"`
+}
+
+// FileEntry describes one extracted file. Path is relative to the release
+// prefix; SHA256 is the hex digest of its content. Blob is the key of the
+// shared blob a model or large binary is stored as instead of in the release.
+type FileEntry struct {
+	Path         string `json:"path" dynamodbav:"path"`
+	Size         int64  `json:"size" dynamodbav:"size"`
+	SHA256       string `json:"sha256" dynamodbav:"sha256"`
+	ContentType  string `json:"content_type" dynamodbav:"contentType"`
+	CacheControl string `json:"cache_control" dynamodbav:"cacheControl"`
+	Blob         string `json:"blob,omitempty" dynamodbav:"blob,omitempty"`
+}
+
+// ModelInfo describes what an ONNX model expects and what it needs from the
+// runtime. UnsupportedOperators lists what onnxruntime-web cannot run.
+type ModelInfo struct {
+	Name                 string        `json:"name" dynamodbav:"name"`
+	Role                 string        `json:"role" dynamodbav:"role"`
+	Path                 string        `json:"path" dynamodbav:"path"`
+	IrVersion            int64         `json:"ir_version" dynamodbav:"irVersion"`
+	OpsetVersion         int64         `json:"opset_version" dynamodbav:"opsetVersion"`
+	ProducerName         string        `json:"producer_name,omitempty" dynamodbav:"producerName,omitempty"`
+	Opsets               []OperatorSet `json:"opsets" dynamodbav:"opsets"`
+	Inputs               []TensorInfo  `json:"inputs" dynamodbav:"inputs"`
+	Outputs              []TensorInfo  `json:"outputs" dynamodbav:"outputs"`
+	Operators            []string      `json:"operators" dynamodbav:"operators"`
+	UnsupportedOperators []string      `json:"unsupported_operators,omitempty" dynamodbav:"unsupportedOperators,omitempty"`
+}
+
+type OperatorSet struct {
+	Domain  string `json:"domain" dynamodbav:"domain"`
+	Version int64  `json:"version" dynamodbav:"version"`
+}
+
+// IconInfo describes a raster icon of the release, with the dimensions unzip
+// decoded. Path is relative to the release prefix; Generated icons were made
+// by unzip for a standard size the manifest lacks.
+type IconInfo struct {
+	Path      string `json:"path"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Type      string `json:"type"`
+	Purpose   string `json:"purpose"`
+	Generated bool   `json:"generated,omitempty"`
+}
+
+// TensorInfo describes a model input or output. Dynamic dimensions are named
+// ("batch") or "?".
+type TensorInfo struct {
+	Name  string   `json:"name" dynamodbav:"name"`
+	Type  string   `json:"type" dynamodbav:"type"`
+	Shape []string `json:"shape,omitempty" dynamodbav:"shape,omitempty"`
 }
 
 /*****************************************************/
 // App Record types for DynamoDB
 /*****************************************************/
 type AppRecord struct {
-	AppId           string   `dynamodbav:"appId"`
-	AppSlug         string   `dynamodbav:"appSlug"`
-	PublisherId     string   `dynamodbav:"publisherId"`
-	UploadTimestamp string   `dynamodbav:"uploadTimestamp"`
-	VersionNumber   int      `dynamodbav:"versionNumber"`
-	S3FilePath      string   `dynamodbav:"s3FilePath"`
-	AppDescription  string   `dynamodbav:"appDescription"`
-	AppName         string   `dynamodbav:"appName"`
-	ManifestContent string   `dynamodbav:"manifestContent,omitempty"`
-	ProcessedFiles  []string `dynamodbav:"processedFiles"`
+	AppId           string      `dynamodbav:"appId"`
+	AppSlug         string      `dynamodbav:"appSlug"`
+	PublisherId     string      `dynamodbav:"publisherId"`
+	UploadTimestamp string      `dynamodbav:"uploadTimestamp"`
+	VersionNumber   int         `dynamodbav:"versionNumber"`
+	S3FilePath      string      `dynamodbav:"s3FilePath"`
+	ReleaseId       string      `dynamodbav:"releaseId,omitempty"`
+	Entrypoint      string      `dynamodbav:"entrypoint,omitempty"`
+	ServiceWorker   string      `dynamodbav:"serviceWorker,omitempty"`
+	AppDescription  string      `dynamodbav:"appDescription"`
+	AppName         string      `dynamodbav:"appName"`
+	VersionId       string      `dynamodbav:"versionId,omitempty"`
+	VersionNotes    string      `dynamodbav:"versionNotes,omitempty"`
+	ManifestContent string      `dynamodbav:"manifestContent,omitempty"`
+	Manifest        *Manifest   `dynamodbav:"manifest,omitempty"`
+	Thumbnails      []Thumbnail `dynamodbav:"thumbnails,omitempty"`
+	ProcessedFiles  []string    `dynamodbav:"processedFiles"`
+	Files           []FileEntry `dynamodbav:"files,omitempty"`
+	Models          []ModelInfo `dynamodbav:"models,omitempty"`
+	// The store page
+	LongDescription string            `dynamodbav:"longDescription,omitempty"`
+	PrivacyPolicy   string            `dynamodbav:"privacyPolicy,omitempty"`
+	InputTypes      []string          `dynamodbav:"inputTypes,omitempty"`
+	ModelCard       *ModelCard        `dynamodbav:"modelCard,omitempty"`
+	Screenshots     []StoreScreenshot `dynamodbav:"screenshots,omitempty"`
+	// SupersededBy is the app id of the live upload once a newer one of the
+	// app went live. The files of this upload are deleted then, so the
+	// record's file and asset fields describe the live release instead.
+	SupersededBy string `dynamodbav:"supersededBy,omitempty"`
+}
+
+// Thumbnail is an icon of the app as the catalog shows it. Url is the path
+// the release serves it at.
+type Thumbnail struct {
+	Url     string `dynamodbav:"url"`
+	Width   int    `dynamodbav:"width"`
+	Height  int    `dynamodbav:"height"`
+	Type    string `dynamodbav:"type"`
+	Purpose string `dynamodbav:"purpose"`
 }
 
 /*****************************************************/
@@ -96,10 +256,31 @@ type Response events.APIGatewayV2HTTPResponse
 const (
 	contentTypeHeader = "Content-Type"
 	jsonContentType   = "application/json"
+	zipContentType    = "application/zip"
 )
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error         string `json:"error"`
+	CorrelationId string `json:"correlationId,omitempty"`
+}
+
+// Handler serves the publish and publisher profile routes and ingests app
+// metadata from the queue.
+type Handler struct {
+	services Services
+	router   *router
+}
+
+func NewHandler(services Services) *Handler {
+	h := &Handler{services: services, router: newRouter()}
+	h.router.handle("POST", "/publish/{app-slug}/version/{version-id}", h.handlePostRequest)
+	h.router.handle("GET", "/publish/{app-slug}/version/{version-id}/uploads/{upload-id}", h.handleGetUpload)
+	h.router.handle("POST", "/publish/{app-slug}/version/{version-id}/uploads/{upload-id}/complete", h.handleCompleteUpload)
+	h.router.handle("GET", "/publishers/me", h.handleGetPublisherProfile)
+	h.router.handle("PUT", "/publishers/me", h.handleUpdatePublisherProfile)
+	h.router.handle("POST", "/publishers/me/avatar", h.handleAvatarUpload)
+	h.router.handle("PUT", "/publishers/me/avatar", h.handleAvatarComplete)
+	return h
 }
 
 /*****************************************************/
@@ -124,15 +305,6 @@ func createSuccessResponse(statusCode int, data interface{}) events.APIGatewayV2
 	}
 }
 
-func validateAppEntrypoint(entrypoint string, files []File) (events.APIGatewayV2HTTPResponse, error) {
-	for _, file := range files {
-		if file.Filename == entrypoint {
-			return events.APIGatewayV2HTTPResponse{}, nil
-		}
-	}
-	return createErrorResponse(400, "The entrypoint is not a valid file")
-}
-
 /*****************************************************/
 // Validation functions
 /*****************************************************/
@@ -144,13 +316,18 @@ func validatePublisher(request events.APIGatewayV2HTTPRequest) (events.APIGatewa
 	}
 
 	if !strings.HasPrefix(groupsClaim, "[") || !strings.HasSuffix(groupsClaim, "]") {
-		log.Println("Group claim is not in the expected format '[...]'")
+		slog.Warn("Group claim is not in the expected format '[...]'")
 		return createErrorResponse(403, "Invalid group format in token.")
 	}
 	trimmedGroups := groupsClaim[1 : len(groupsClaim)-1]
 	var groupsList []string
 	if trimmedGroups != "" {
-		groupsList = strings.Split(trimmedGroups, ", ")
+		// Handle both comma-separated and space-separated formats
+		if strings.Contains(trimmedGroups, ", ") {
+			groupsList = strings.Split(trimmedGroups, ", ")
+		} else {
+			groupsList = strings.Fields(trimmedGroups)
+		}
 	} else {
 		groupsList = []string{}
 	}
@@ -169,21 +346,6 @@ func validatePublisher(request events.APIGatewayV2HTTPRequest) (events.APIGatewa
 }
 
 func validatePublishRequest(request PublishRequest) (events.APIGatewayV2HTTPResponse, error) {
-	if request.Manifest.Name == "" {
-		return createErrorResponse(400, "Manifest name is required")
-	}
-	if request.Manifest.ShortName == "" {
-		return createErrorResponse(400, "Manifest short name is required")
-	}
-	if request.Manifest.StartUrl == "" {
-		return createErrorResponse(400, "Manifest start url is required")
-	}
-	if request.Manifest.Display == "" {
-		return createErrorResponse(400, "Manifest display is required")
-	}
-	if request.Manifest.Icons == nil {
-		return createErrorResponse(400, "Manifest icons are required")
-	}
 	if request.Files == nil {
 		return createErrorResponse(400, "Files are required")
 	}
@@ -193,8 +355,65 @@ func validatePublishRequest(request PublishRequest) (events.APIGatewayV2HTTPResp
 	if request.VersionNotes == "" {
 		return createErrorResponse(400, "Version notes are required")
 	}
-	if request.PublisherId == "" {
-		return createErrorResponse(400, "Publisher id is required")
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+const (
+	maxModels          = 8
+	maxModelBytes      = 25 * 1024 * 1024
+	maxTotalModelBytes = 75 * 1024 * 1024
+)
+
+var modelNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// publishModels returns the models a request declares, or the default
+// model.onnx when it declares none.
+func publishModels(request PublishRequest) []ModelFile {
+	if len(request.Models) == 0 {
+		return []ModelFile{defaultModel}
+	}
+	return request.Models
+}
+
+func validateModels(request PublishRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if len(request.Models) == 0 {
+		return validateModelOnnxFile(request.Files)
+	}
+	if len(request.Models) > maxModels {
+		return createErrorResponse(400, fmt.Sprintf("An app can have at most %d models", maxModels))
+	}
+
+	sizes := make(map[string]int, len(request.Files))
+	for _, file := range request.Files {
+		sizes[file.Filename] = file.Size
+	}
+	names := make(map[string]bool, len(request.Models))
+	paths := make(map[string]bool, len(request.Models))
+	totalSize := 0
+	for _, model := range request.Models {
+		if !modelNamePattern.MatchString(model.Name) || !modelNamePattern.MatchString(model.Role) {
+			return createErrorResponse(400, "Model names and roles must be 1-32 lowercase letters, digits, '-' or '_'")
+		}
+		if names[model.Name] {
+			return createErrorResponse(400, fmt.Sprintf("Model %s is listed twice", model.Name))
+		}
+		if paths[model.Path] {
+			return createErrorResponse(400, fmt.Sprintf("Model file %s is listed twice", model.Path))
+		}
+		names[model.Name] = true
+		paths[model.Path] = true
+
+		size, ok := sizes[model.Path]
+		if !ok || !strings.HasSuffix(model.Path, ".onnx") {
+			return createErrorResponse(400, fmt.Sprintf("Model %s must be an .onnx file in the bundle", model.Name))
+		}
+		if size > maxModelBytes {
+			return createErrorResponse(400, fmt.Sprintf("Model %s exceeds 25MB", model.Name))
+		}
+		totalSize += size
+	}
+	if totalSize > maxTotalModelBytes {
+		return createErrorResponse(400, "The models together exceed 75MB")
 	}
 	return events.APIGatewayV2HTTPResponse{}, nil
 }
@@ -210,7 +429,7 @@ func validateModelOnnxFile(files []File) (events.APIGatewayV2HTTPResponse, error
 		return createErrorResponse(400, "The model.onnx file is required")
 	}
 
-	if modelOnnxFile.Size > 25*1024*1024 {
+	if modelOnnxFile.Size > maxModelBytes {
 		return createErrorResponse(400, "The model.onnx file size exceeds 25MB")
 	}
 	return events.APIGatewayV2HTTPResponse{}, nil
@@ -229,76 +448,215 @@ func validateFileSize(files []File) (events.APIGatewayV2HTTPResponse, error) {
 	return events.APIGatewayV2HTTPResponse{}, nil
 }
 
-func validateAppFiles(files []File) (events.APIGatewayV2HTTPResponse, error) {
-	jsFiles := 0
-	wasmFiles := 0
-	htmlFiles := 0
-	for _, file := range files {
-		if file.Type == "application/javascript" {
-			jsFiles++
-		}
-		if file.Type == "application/wasm" {
-			wasmFiles++
+// Every file of a bundle has an entry in the metadata message and the app
+// record, which the manifest, listing and version notes travel with too. These
+// limits keep the largest bundle well within SQS's 256 KB per message and
+// DynamoDB's 400 KB per item; unzip checks the message it sends again.
+const (
+	maxBundleFiles       = 250
+	maxFilePathLength    = 128
+	maxManifestBytes     = 16 * 1024
+	maxVersionNotesChars = 2000
+)
+
+func validateBundleLimits(request PublishRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if len(request.Files) > maxBundleFiles {
+		return createErrorResponse(400, fmt.Sprintf("A bundle has at most %d files", maxBundleFiles))
+	}
+	for _, file := range request.Files {
+		if len(file.Filename) > maxFilePathLength {
+			return createErrorResponse(400, fmt.Sprintf("File names are at most %d bytes", maxFilePathLength))
 		}
-		if file.Type == "text/html" {
-			htmlFiles++
+		if file.Filename == "manifest.json" && file.Size > maxManifestBytes {
+			return createErrorResponse(400, fmt.Sprintf("manifest.json exceeds %dKB", maxManifestBytes/1024))
 		}
 	}
-	if jsFiles == 0 && wasmFiles == 0 {
-		return createErrorResponse(400, "There must be at least one .js file or .wasm file")
+	if utf8.RuneCountInString(request.VersionNotes) > maxVersionNotesChars {
+		return createErrorResponse(400, fmt.Sprintf("Version notes must be at most %d characters", maxVersionNotesChars))
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
+
+// validateBundle requires the size and SHA-256 of a zip uploaded in one PUT.
+// Delta uploads declare each file instead, and multipart uploads declare the
+// zip's size and SHA-256 with the multipart request.
+func validateBundle(request PublishRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if isDeltaRequest(request) || request.Multipart != nil {
+		return events.APIGatewayV2HTTPResponse{}, nil
+	}
+	bundle := request.Bundle
+	if bundle == nil || bundle.Size <= 0 || !sha256Pattern.MatchString(bundle.SHA256) {
+		return createErrorResponse(400, "The bundle's size and SHA-256 as 64 lowercase hex digits are required")
 	}
-	if htmlFiles == 0 {
-		return createErrorResponse(400, "There must be at least one html file")
+	if bundle.Size > maxBundleBytes {
+		return createErrorResponse(400, fmt.Sprintf("The zip exceeds %dMB", maxBundleBytes/(1024*1024)))
 	}
 	return events.APIGatewayV2HTTPResponse{}, nil
 }
 
 /*****************************************************/
-// DynamoDB functions
+// App record functions
 /*****************************************************/
-func saveAppMetadata(ctx context.Context, dynamoClient *dynamodb.Client, tableName string, metadata AppMetadataMessage) error {
-	var manifest Manifest
+// uploadNamespace scopes the app ids derived from upload ids.
+var uploadNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("miniapps:upload"))
+
+// appIdForUpload derives the app id from the upload the message describes, so
+// a redelivered or replayed message overwrites the record it created the first
+// time instead of adding a duplicate app.
+func appIdForUpload(metadata AppMetadataMessage) string {
+	if metadata.RequestId == "" {
+		return uuid.New().String()
+	}
+	name := strings.Join([]string{metadata.PublisherId, metadata.AppSlug, metadata.VersionId, metadata.RequestId}, "/")
+	return uuid.NewSHA1(uploadNamespace, []byte(name)).String()
+}
+
+func newAppRecord(ctx context.Context, metadata AppMetadataMessage) AppRecord {
+	manifest := metadata.Manifest
+	if manifest == nil && metadata.ManifestFound && metadata.ManifestContent != "" {
+		// Sent by an unzip that did not normalize the manifest yet
+		var parsed Manifest
+		if err := json.Unmarshal([]byte(metadata.ManifestContent), &parsed); err != nil {
+			slog.WarnContext(ctx, "Ignoring unparsable manifest", "app_slug", metadata.AppSlug, "error", err)
+		} else {
+			parsed = normalizeManifest(parsed)
+			manifest = &parsed
+		}
+	}
+
 	appName := metadata.AppSlug // Default to app slug
 	appDescription := ""
-
-	if metadata.ManifestFound && metadata.ManifestContent != "" {
-		if err := json.Unmarshal([]byte(metadata.ManifestContent), &manifest); err == nil {
-			if manifest.Name != "" {
-				appName = manifest.Name
-			}
-			if manifest.Description != "" {
-				appDescription = manifest.Description
-			}
+	if manifest != nil {
+		if manifest.Name != "" {
+			appName = manifest.Name
 		}
+		appDescription = manifest.Description
+	}
+	var listing StoreListing
+	if metadata.Listing != nil {
+		listing = *metadata.Listing
 	}
-	appId := uuid.New().String()
 
-	appRecord := AppRecord{
-		AppId:           appId,
+	return AppRecord{
+		AppId:           appIdForUpload(metadata),
 		AppSlug:         metadata.AppSlug,
-		PublisherId:     metadata.VersionId,
+		PublisherId:     metadata.PublisherId,
 		UploadTimestamp: metadata.UploadTimestamp.Format(time.RFC3339),
 		VersionNumber:   1,
 		S3FilePath:      metadata.S3FilePath,
+		ReleaseId:       metadata.ReleaseId,
+		Entrypoint:      metadata.Entrypoint,
+		ServiceWorker:   metadata.ServiceWorker,
 		AppDescription:  appDescription,
 		AppName:         appName,
+		VersionId:       metadata.VersionId,
+		VersionNotes:    metadata.VersionNotes,
 		ManifestContent: metadata.ManifestContent,
+		Manifest:        manifest,
+		Thumbnails:      thumbnails(metadata),
+		LongDescription: listing.Description,
+		PrivacyPolicy:   listing.PrivacyPolicy,
+		InputTypes:      listing.InputTypes,
+		ModelCard:       listing.ModelCard,
+		Screenshots:     storeScreenshots(metadata),
 		ProcessedFiles:  metadata.ProcessedFiles,
+		Files:           metadata.Files,
+		Models:          metadata.Models,
 	}
-	item, err := attributevalue.MarshalMap(appRecord)
+}
+
+// thumbnails lists the icons of the release with the URLs it serves them at.
+func thumbnails(metadata AppMetadataMessage) []Thumbnail {
+	var thumbnails []Thumbnail
+	for _, icon := range metadata.Icons {
+		thumbnails = append(thumbnails, Thumbnail{
+			Url:     "/" + metadata.S3FilePath + icon.Path,
+			Width:   icon.Width,
+			Height:  icon.Height,
+			Type:    icon.Type,
+			Purpose: icon.Purpose,
+		})
+	}
+	return thumbnails
+}
+
+// saveAppMetadata checks the release holds exactly the listed files, makes it
+// live and only then records the app and points its older records at it, so
+// the catalog never lists a version or links a file that is not served. A
+// release older than the live one is not recorded at all, since cleanup
+// deletes its files. A failure at any step fails the message, and every step
+// is safe to repeat.
+func (h *Handler) saveAppMetadata(ctx context.Context, metadata AppMetadataMessage) error {
+	if metadata.ReleaseId == "" {
+		// Extracted straight into app/{slug}/ before releases existed
+		_, err := h.saveAppRecord(ctx, metadata)
+		return err
+	}
+	if err := h.verifyRelease(ctx, metadata); err != nil {
+		return err
+	}
+	promoted, retired, err := h.promoteRelease(ctx, metadata)
 	if err != nil {
-		return fmt.Errorf("failed to marshal app record: %w", err)
+		return err
 	}
-
-	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      item,
-	})
+	if !promoted {
+		slog.WarnContext(ctx, "Not recording a release that never went live",
+			"app_slug", metadata.AppSlug, "release_id", metadata.ReleaseId)
+		return nil
+	}
+	appRecord, err := h.saveAppRecord(ctx, metadata)
 	if err != nil {
-		return fmt.Errorf("failed to put item in DynamoDB: %w", err)
+		return err
+	}
+	if err := h.supersedeAppRecords(ctx, appRecord); err != nil {
+		return err
+	}
+	h.cleanupReleases(ctx, metadata.AppSlug, retired, time.Now())
+	return nil
+}
+
+func (h *Handler) saveAppRecord(ctx context.Context, metadata AppMetadataMessage) (AppRecord, error) {
+	appRecord := newAppRecord(ctx, metadata)
+	if err := h.services.Apps.SaveApp(ctx, appRecord); err != nil {
+		return AppRecord{}, err
 	}
+	slog.InfoContext(ctx, "Saved app metadata", "app_slug", metadata.AppSlug, "app_id", appRecord.AppId)
+	return appRecord, nil
+}
 
-	log.Printf("Successfully saved app metadata for %s (ID: %s)", metadata.AppSlug, appId)
+// supersedeAppRecords points the publisher's older records of the app at the
+// live release before cleanup deletes the files they were recorded with, so
+// the catalog never links to a file that is gone. Their version, notes and
+// listing stay as they were, which keeps the changelog intact.
+func (h *Handler) supersedeAppRecords(ctx context.Context, live AppRecord) error {
+	records, err := h.services.Apps.ListAppVersions(ctx, live.PublisherId, live.AppSlug)
+	if err != nil {
+		return fmt.Errorf("failed to list the versions of %s: %w", live.AppSlug, err)
+	}
+	superseded := 0
+	for _, record := range records {
+		if record.AppId == live.AppId || (record.SupersededBy == live.AppId && record.ReleaseId == live.ReleaseId) {
+			continue
+		}
+		record.SupersededBy = live.AppId
+		record.S3FilePath = live.S3FilePath
+		record.ReleaseId = live.ReleaseId
+		record.Entrypoint = live.Entrypoint
+		record.ServiceWorker = live.ServiceWorker
+		record.Thumbnails = live.Thumbnails
+		record.Screenshots = live.Screenshots
+		record.ProcessedFiles = live.ProcessedFiles
+		record.Files = live.Files
+		record.Models = live.Models
+		if err := h.services.Apps.SaveApp(ctx, record); err != nil {
+			return fmt.Errorf("failed to supersede app %s: %w", record.AppId, err)
+		}
+		superseded++
+	}
+	if superseded > 0 {
+		slog.InfoContext(ctx, "Pointed older versions at the live release",
+			"app_slug", live.AppSlug, "app_id", live.AppId, "records", superseded)
+	}
 	return nil
 }
 
@@ -306,33 +664,74 @@ func saveAppMetadata(ctx context.Context, dynamoClient *dynamodb.Client, tableNa
 // Handler functions
 /*****************************************************/
 
-func createPresignedUrl(ctx context.Context, appSlug string, versionId string) (string, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// createPresignedUrl stores the publish declaration and issues an upload URL
+// for the app bundle, which only accepts a zip of the declared size and
+// SHA-256. The publisher id is part of the key so the unzip lambda can
+// attribute the version to its owner, and the object is named after the
+// publish request id so the upload can be traced and its status read.
+func (h *Handler) createPresignedUrl(ctx context.Context, appSlug string, versionId string, publisherId string, bundle Bundle, declaration PublishDeclaration) (string, string, error) {
+	uploadId := newUploadId(ctx)
+	upload := uploadKeyBase(appSlug, versionId, publisherId, uploadId)
+	declaration.Bundle = &bundle
+	if err := h.storeDeclaration(ctx, upload, declaration); err != nil {
+		return "", "", err
+	}
+	presignedURL, err := h.services.Blobs.PresignPut(ctx, upload+".zip", zipContentType, bundle.Size, bundle.SHA256)
 	if err != nil {
-		return "", fmt.Errorf("error loading AWS configuration: %w", err)
+		return "", "", err
 	}
-	s3Client := s3.NewFromConfig(cfg)
-	presigner := s3.NewPresignClient(s3Client)
-	appsBucket := os.Getenv("apps_bucket")
-	uploadKey := fmt.Sprintf("uploads/%s/%s/%d.zip", appSlug, versionId, time.Now().UnixNano())
+	return uploadId, presignedURL, nil
+}
 
-	req, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(appsBucket),
-		Key:    aws.String(uploadKey),
-	}, func(opts *s3.PresignOptions) {
-		opts.Expires = time.Duration(15 * time.Minute)
-	})
+// uploadHeaders are the headers a client sends with a PUT to a URL presigned
+// for contentType and sha256Hex. S3 takes the checksum in base64.
+func uploadHeaders(contentType, sha256Hex string) map[string]string {
+	headers := make(map[string]string)
+	if contentType != "" {
+		headers[contentTypeHeader] = contentType
+	}
+	if checksum, err := checksumSHA256(sha256Hex); err == nil {
+		headers["x-amz-checksum-sha256"] = checksum
+	}
+	return headers
+}
 
-	if err != nil {
-		return "", fmt.Errorf("error creating presigned URL: %w", err)
+func checksumSHA256(sha256Hex string) (string, error) {
+	digest, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(digest) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 %q", sha256Hex)
 	}
+	return base64.StdEncoding.EncodeToString(digest), nil
+}
 
-	return req.URL, nil
+// newUploadId names an upload after the publish request that started it.
+func newUploadId(ctx context.Context) string {
+	if uploadId := requestIdFromContext(ctx); uploadId != "" {
+		return uploadId
+	}
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
 }
 
-func handlePostRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	if _, err := validatePublisher(request); err != nil {
-		return createErrorResponse(403, "Access denied. Publisher role required.")
+// uploadKeyBase is the key of an upload without its extension:
+// uploads/{appSlug}/{versionId}/{publisherId}/{uploadId}
+func uploadKeyBase(appSlug, versionId, publisherId, uploadId string) string {
+	return fmt.Sprintf("uploads/%s/%s/%s/%s", appSlug, versionId, publisherId, uploadId)
+}
+
+func (h *Handler) storeDeclaration(ctx context.Context, upload string, declaration PublishDeclaration) error {
+	body, err := json.Marshal(declaration)
+	if err != nil {
+		return fmt.Errorf("failed to marshal publish declaration: %w", err)
+	}
+	if err := h.services.Blobs.PutObject(ctx, upload+".json", body, jsonContentType, ""); err != nil {
+		return fmt.Errorf("failed to store publish declaration: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) handlePostRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if errorResp, _ := validatePublisher(request); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
 
 	var publishReq PublishRequest
@@ -342,82 +741,158 @@ func handlePostRequest(ctx context.Context, request events.APIGatewayV2HTTPReque
 
 	appSlug := request.PathParameters["app-slug"]
 	versionId := request.PathParameters["version-id"]
+	publisherId, err := getPublisherIdFromJWT(request)
+	if err != nil {
+		return createErrorResponse(401, "Unable to determine publisher")
+	}
 
 	if appSlug == "" || versionId == "" {
-		log.Printf("Could not find app-slug or version-id in path parameters: %+v", request.PathParameters)
+		slog.WarnContext(ctx, "Could not find app-slug or version-id in path parameters")
 		return createErrorResponse(400, "app-slug and version-id are required in the URL path")
 	}
 
 	// Run all validations
-	if _, err := validatePublishRequest(publishReq); err != nil {
-		return createErrorResponse(400, err.Error())
+	if errorResp, _ := validatePublishRequest(publishReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	manifest := normalizeManifest(publishReq.Manifest)
+	if errorResp, _ := validateManifest(appSlug, manifest, publishReq.Files); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	if errorResp, _ := validateListing(publishReq.Listing, publishReq.Files); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
-	if _, err := validateModelOnnxFile(publishReq.Files); err != nil {
-		return createErrorResponse(400, err.Error())
+	if errorResp, _ := validateModels(publishReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
-	if _, err := validateFileSize(publishReq.Files); err != nil {
-		return createErrorResponse(400, err.Error())
+	if errorResp, _ := validateFileSize(publishReq.Files); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
-	if _, err := validateAppFiles(publishReq.Files); err != nil {
-		return createErrorResponse(400, err.Error())
+	if errorResp, _ := validateBundleLimits(publishReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
-	if _, err := validateAppEntrypoint(publishReq.Entrypoint, publishReq.Files); err != nil {
-		return createErrorResponse(400, err.Error())
+	if errorResp, _ := validateShellContract(publishReq.Entrypoint, publishReq.Files); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	if errorResp, _ := validateMultipart(publishReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
+	}
+	if errorResp, _ := validateBundle(publishReq); errorResp.StatusCode != 0 {
+		return errorResp, nil
 	}
 
-	presignedURL, err := createPresignedUrl(ctx, appSlug, versionId)
+	declaration := PublishDeclaration{
+		Models:        publishModels(publishReq),
+		Manifest:      &manifest,
+		Entrypoint:    shellEntrypoint,
+		ServiceWorker: shellServiceWorker,
+		VersionNotes:  publishReq.VersionNotes,
+		Listing:       publishReq.Listing,
+	}
+	if isDeltaRequest(publishReq) {
+		return h.handleDeltaPublish(ctx, appSlug, versionId, publisherId, publishReq, declaration)
+	}
+	if publishReq.Multipart != nil {
+		return h.handleMultipartPublish(ctx, appSlug, versionId, publisherId, publishReq, declaration)
+	}
+	uploadId, presignedURL, err := h.createPresignedUrl(ctx, appSlug, versionId, publisherId, *publishReq.Bundle, declaration)
 	if err != nil {
-		log.Printf("Error creating presigned URL: %v", err)
+		slog.ErrorContext(ctx, "Error creating presigned URL", "error", err)
 		return createErrorResponse(500, "Failed to generate presigned URL")
 	}
 
+	declaredBytes := 0
+	for _, file := range publishReq.Files {
+		declaredBytes += file.Size
+	}
+	emitMetrics("POST /publish/{app-slug}/version/{version-id}", outcomeSuccess,
+		countMetric("PublishHandshakes", 1),
+		countMetric("PublishDeclaredFiles", len(publishReq.Files)),
+		bytesMetric("PublishDeclaredBytes", int64(declaredBytes)),
+	)
+
 	return createSuccessResponse(200, map[string]interface{}{
 		"message":       "Presigned URL generated successfully",
+		"upload_id":     uploadId,
 		"presigned_url": presignedURL,
+		"headers":       uploadHeaders(zipContentType, publishReq.Bundle.SHA256),
 	}), nil
 }
 
-func handleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent) error {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to load AWS configuration: %w", err)
-	}
-
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	tableName := os.Getenv("app_table_name")
+const ingestMetricsRoute = "sqs:app-metadata"
 
+// handleSQSEvent ingests every message on its own and reports the ones that
+// failed, so SQS redelivers only those instead of the whole batch. A message
+// that keeps failing, including one that can never be parsed, is moved to the
+// dead-letter queue by the redrive policy once it reaches maxReceiveCount.
+func (h *Handler) handleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent) events.SQSEventResponse {
+	response := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
 	for _, record := range sqsEvent.Records {
-		log.Printf("Processing SQS message: %s", record.MessageId)
-
-		var metadata AppMetadataMessage
-		if err := json.Unmarshal([]byte(record.Body), &metadata); err != nil {
-			log.Printf("Failed to unmarshal SQS message: %v", err)
-			continue // Skip this message but continue processing others
-		}
-
-		if err := saveAppMetadata(ctx, dynamoClient, tableName, metadata); err != nil {
-			log.Printf("Failed to save app metadata: %v", err)
-			return err // Return error to trigger message retry
+		if err := h.ingestMessage(ctx, record); err != nil {
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
 		}
 	}
+	if len(response.BatchItemFailures) > 0 {
+		slog.WarnContext(ctx, "Reporting failed SQS messages for redelivery",
+			"failed", len(response.BatchItemFailures), "batch_size", len(sqsEvent.Records))
+	}
+	return response
+}
 
+func (h *Handler) ingestMessage(ctx context.Context, record events.SQSMessage) error {
+	slog.InfoContext(ctx, "Processing SQS message", "message_id", record.MessageId,
+		"receive_count", record.Attributes["ApproximateReceiveCount"])
+
+	var metadata AppMetadataMessage
+	if err := json.Unmarshal([]byte(record.Body), &metadata); err != nil {
+		slog.ErrorContext(ctx, "Failed to unmarshal SQS message", "message_id", record.MessageId, "error", err)
+		emitMetrics(ingestMetricsRoute, outcomeClientError, countMetric("MetadataMessages", 1))
+		return err
+	}
+
+	// Log under the id of the publish request that produced this message
+	if metadata.RequestId != "" {
+		ctx = withRequestId(ctx, metadata.RequestId)
+	}
+	start := time.Now()
+	if err := h.saveAppMetadata(ctx, metadata); err != nil {
+		slog.ErrorContext(ctx, "Failed to save app metadata", "message_id", record.MessageId, "error", err)
+		emitMetrics(ingestMetricsRoute, outcomeServerError,
+			countMetric("MetadataMessages", 1),
+			durationMetric("IngestDuration", time.Since(start)),
+		)
+		return err
+	}
+	emitMetrics(ingestMetricsRoute, outcomeSuccess,
+		countMetric("MetadataMessages", 1),
+		durationMetric("IngestDuration", time.Since(start)),
+		countMetric("IngestedFiles", len(metadata.ProcessedFiles)),
+	)
 	return nil
 }
 
-func handleRequest(ctx context.Context, event json.RawMessage) (interface{}, error) {
+// HandleRequest is the Lambda entry point. The function is invoked by API
+// Gateway for publish routes and by SQS for app metadata messages.
+func (h *Handler) HandleRequest(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = withRequestId(ctx, lambdaCtx.AwsRequestID)
+	}
+
 	var eventMap map[string]interface{}
 	if err := json.Unmarshal(event, &eventMap); err != nil {
 		return nil, fmt.Errorf("failed to parse event: %w", err)
 	}
 
-	// Handle API Gateway event (relayed from user lambda)
+	// Handle API Gateway event
 	if requestContext, ok := eventMap["requestContext"].(map[string]interface{}); ok {
 		if _, hasHTTP := requestContext["http"]; hasHTTP {
 			var apiEvent events.APIGatewayV2HTTPRequest
 			if err := json.Unmarshal(event, &apiEvent); err != nil {
 				return nil, fmt.Errorf("failed to parse API Gateway event: %w", err)
 			}
-			return handlePostRequest(ctx, apiEvent)
+			return h.router.dispatch(ctx, apiEvent)
 		}
 	}
 
@@ -429,19 +904,13 @@ func handleRequest(ctx context.Context, event json.RawMessage) (interface{}, err
 				if err := json.Unmarshal(event, &sqsEvent); err != nil {
 					return nil, fmt.Errorf("failed to parse SQS event: %w", err)
 				}
-				err := handleSQSEvent(ctx, sqsEvent)
-				if err != nil {
-					return nil, err
-				}
-				return "Successfully processed SQS messages", nil
+				// The event source mapping reports batch item failures, so the
+				// response lists the messages SQS should deliver again
+				return h.handleSQSEvent(ctx, sqsEvent), nil
 			}
 		}
 	}
 
-	log.Printf("Unsupported event structure: %+v", eventMap)
+	slog.ErrorContext(ctx, "Unsupported event structure")
 	return nil, fmt.Errorf("unsupported event type")
 }
-
-func main() {
-	lambda.Start(handleRequest)
-}
@@ -0,0 +1,355 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*****************************************************/
+// Releases
+/*****************************************************/
+// Unzip extracts every upload into its own app/{slug}/releases/{releaseId}/
+// prefix. Nothing there is served until ingest points app/{slug}/current.json
+// at the release. That single object write is what makes a version live, so a
+// partial extraction is never served, and the app record is saved after it.
+
+const (
+	releasePointerName         = "current.json"
+	releasePointerCacheControl = "no-cache"
+	// Releases that are not live are kept this long. A metadata message can
+	// point at a release for up to 14 days, the SQS retention of the queue and
+	// its dead-letter queue, so anything older is abandoned or superseded.
+	releaseRetention = 15 * 24 * time.Hour
+	// Ingests of the same app race to rewrite its pointer. Each write is
+	// conditional on the pointer it read, and the loser reads it again.
+	maxPromoteAttempts = 5
+)
+
+// ReleasePointer is the content of app/{slug}/current.json, which the PWA
+// shell reads to find the files of the live release. Files is the content
+// manifest clients check what they download against.
+type ReleasePointer struct {
+	ReleaseId string `json:"releaseId"`
+	// Path is the URL path of the release prefix, e.g. /app/shape/releases/r1/
+	Path        string    `json:"path"`
+	VersionId   string    `json:"versionId"`
+	PublishedAt time.Time `json:"publishedAt"`
+	// PreviousReleaseId is the release this one replaced, kept until the next
+	// promotion so clients still loading it can finish
+	PreviousReleaseId string         `json:"previousReleaseId,omitempty"`
+	Files             []ContentFile  `json:"files,omitempty"`
+	Models            []ReleaseModel `json:"models,omitempty"`
+}
+
+// ReleaseModel is ModelInfo as the shell reads it, so it can find each model
+// and check its inputs and outputs before creating an inference session.
+type ReleaseModel struct {
+	Name                 string        `json:"name"`
+	Role                 string        `json:"role"`
+	Path                 string        `json:"path"`
+	IrVersion            int64         `json:"irVersion"`
+	OpsetVersion         int64         `json:"opsetVersion"`
+	ProducerName         string        `json:"producerName,omitempty"`
+	Opsets               []OperatorSet `json:"opsets"`
+	Inputs               []TensorInfo  `json:"inputs"`
+	Outputs              []TensorInfo  `json:"outputs"`
+	Operators            []string      `json:"operators"`
+	UnsupportedOperators []string      `json:"unsupportedOperators,omitempty"`
+}
+
+// ContentFile is one entry of the content manifest. Path is relative to the
+// release path; Url is set for files served from a shared blob instead.
+type ContentFile struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	ContentType  string `json:"contentType"`
+	CacheControl string `json:"cacheControl"`
+	Url          string `json:"url,omitempty"`
+}
+
+func releaseModels(models []ModelInfo) []ReleaseModel {
+	release := make([]ReleaseModel, 0, len(models))
+	for _, model := range models {
+		release = append(release, ReleaseModel(model))
+	}
+	return release
+}
+
+func contentManifest(files []FileEntry) []ContentFile {
+	manifest := make([]ContentFile, 0, len(files))
+	for _, file := range files {
+		entry := ContentFile{
+			Path:         file.Path,
+			Size:         file.Size,
+			SHA256:       file.SHA256,
+			ContentType:  file.ContentType,
+			CacheControl: file.CacheControl,
+		}
+		if file.Blob != "" {
+			entry.Url = "/" + file.Blob
+		}
+		manifest = append(manifest, entry)
+	}
+	return manifest
+}
+
+func releasePointerKey(appSlug string) string {
+	return fmt.Sprintf("app/%s/%s", appSlug, releasePointerName)
+}
+
+func releasesPrefix(appSlug string) string {
+	return fmt.Sprintf("app/%s/releases/", appSlug)
+}
+
+// currentRelease returns the live release of an app, or nil if it has none.
+func (h *Handler) currentRelease(ctx context.Context, appSlug string) (*ReleasePointer, error) {
+	pointer, _, err := h.readReleasePointer(ctx, appSlug)
+	return pointer, err
+}
+
+// readReleasePointer is currentRelease that also returns the ETag of the
+// pointer, or "" if the app has none, to make the next write conditional on.
+func (h *Handler) readReleasePointer(ctx context.Context, appSlug string) (*ReleasePointer, string, error) {
+	body, etag, err := h.services.Blobs.GetObjectWithETag(ctx, releasePointerKey(appSlug))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var pointer ReleasePointer
+	if err := json.Unmarshal(body, &pointer); err != nil {
+		return nil, "", fmt.Errorf("invalid release pointer for %s: %w", appSlug, err)
+	}
+	return &pointer, etag, nil
+}
+
+// verifyRelease checks that the release prefix holds exactly the files the
+// message lists and that its shared blobs exist, so what the pointer makes
+// live is what was recorded. Missing files fail the message; files the upload
+// did not list are removed.
+func (h *Handler) verifyRelease(ctx context.Context, metadata AppMetadataMessage) error {
+	prefix := releasesPrefix(metadata.AppSlug) + metadata.ReleaseId + "/"
+	objects, err := h.services.Blobs.ListObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Key] = true
+	}
+
+	var missing []string
+	for _, key := range metadata.ProcessedFiles {
+		if strings.HasPrefix(key, blobsPrefix) {
+			exists, err := h.blobExists(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		if !stored[key] {
+			missing = append(missing, key)
+		}
+		delete(stored, key)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("release %s is missing %d files, first %s", metadata.ReleaseId, len(missing), missing[0])
+	}
+	if len(stored) == 0 {
+		return nil
+	}
+
+	unlisted := make([]string, 0, len(stored))
+	for key := range stored {
+		unlisted = append(unlisted, key)
+	}
+	sort.Strings(unlisted)
+	if err := h.services.Blobs.DeleteObjects(ctx, unlisted); err != nil {
+		return fmt.Errorf("failed to remove unlisted files from release %s: %w", metadata.ReleaseId, err)
+	}
+	slog.WarnContext(ctx, "Removed files the upload did not list",
+		"app_slug", metadata.AppSlug, "release_id", metadata.ReleaseId, "files", unlisted)
+	return nil
+}
+
+// promoteRelease points the app at the release the message describes. The
+// release it replaces becomes the previous one, and the id of the release that
+// was previous until now is returned so it can be deleted. An upload older
+// than the live release never replaces it, so a redelivered or replayed
+// message cannot roll an app back; promoted is false then. The pointer is
+// only written if nobody rewrote it since it was read, so a concurrent
+// promotion is never lost.
+func (h *Handler) promoteRelease(ctx context.Context, metadata AppMetadataMessage) (promoted bool, retired string, err error) {
+	for attempt := 1; ; attempt++ {
+		promoted, retired, err = h.tryPromoteRelease(ctx, metadata)
+		if !errors.Is(err, ErrPreconditionFailed) || attempt == maxPromoteAttempts {
+			return promoted, retired, err
+		}
+		slog.InfoContext(ctx, "Release pointer changed while promoting, reading it again",
+			"app_slug", metadata.AppSlug, "release_id", metadata.ReleaseId, "attempt", attempt)
+	}
+}
+
+func (h *Handler) tryPromoteRelease(ctx context.Context, metadata AppMetadataMessage) (promoted bool, retired string, err error) {
+	current, etag, err := h.readReleasePointer(ctx, metadata.AppSlug)
+	if err != nil {
+		return false, "", err
+	}
+	if current != nil && current.ReleaseId != metadata.ReleaseId && current.PublishedAt.After(metadata.UploadTimestamp) {
+		slog.InfoContext(ctx, "Newer release is already live, not promoting",
+			"app_slug", metadata.AppSlug, "release_id", metadata.ReleaseId, "live_release_id", current.ReleaseId)
+		return false, "", nil
+	}
+
+	var previous string
+	if current != nil {
+		previous, retired = current.ReleaseId, current.PreviousReleaseId
+		if current.ReleaseId == metadata.ReleaseId {
+			// Redelivered, the previous release stays where it is
+			previous, retired = current.PreviousReleaseId, ""
+		}
+	}
+
+	pointer, err := json.Marshal(ReleasePointer{
+		ReleaseId:         metadata.ReleaseId,
+		Path:              "/" + releasesPrefix(metadata.AppSlug) + metadata.ReleaseId + "/",
+		VersionId:         metadata.VersionId,
+		PublishedAt:       metadata.UploadTimestamp.UTC(),
+		PreviousReleaseId: previous,
+		Files:             contentManifest(metadata.Files),
+		Models:            releaseModels(metadata.Models),
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to marshal release pointer: %w", err)
+	}
+	if err := h.services.Blobs.PutObjectIfMatch(ctx, releasePointerKey(metadata.AppSlug), pointer, jsonContentType, releasePointerCacheControl, etag); err != nil {
+		return false, "", fmt.Errorf("failed to promote release: %w", err)
+	}
+	slog.InfoContext(ctx, "Promoted release", "app_slug", metadata.AppSlug, "release_id", metadata.ReleaseId,
+		"previous_release_id", previous)
+	return true, retired, nil
+}
+
+// cleanupReleases deletes the files of an app that are no longer served:
+//   - the retired release, the one that was previous until the latest
+//     promotion, which can never go live again because ingest does not
+//     promote an older upload over a newer one
+//   - files written directly under app/{slug}/ before releases existed
+//   - releases that are neither live nor previous and were last written more
+//     than releaseRetention ago, which covers extractions whose upload never
+//     made it through ingest
+//
+// The previous release is kept, so clients that read the pointer just before
+// a promotion can still load its files. The blob references of deleted
+// releases go with them, and then the blobs nothing references any more. The
+// live release is read again so a concurrent promotion is never undone.
+// Failures are only logged because the next publish tries again.
+func (h *Handler) cleanupReleases(ctx context.Context, appSlug, retired string, now time.Time) {
+	current, err := h.currentRelease(ctx, appSlug)
+	if err != nil || current == nil {
+		slog.WarnContext(ctx, "Skipping release cleanup", "app_slug", appSlug, "error", err)
+		return
+	}
+	appPrefix := fmt.Sprintf("app/%s/", appSlug)
+	objects, err := h.services.Blobs.ListObjects(ctx, appPrefix)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping release cleanup", "app_slug", appSlug, "error", err)
+		return
+	}
+
+	refs, err := h.services.Blobs.ListObjects(ctx, blobRefsPrefix+appSlug+"/")
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping release cleanup", "app_slug", appSlug, "error", err)
+		return
+	}
+
+	// File names relative to their release, and blobs referenced, per release
+	filesByRelease := make(map[string][]string)
+	blobsByRelease := make(map[string][]string)
+	lastWritten := make(map[string]time.Time)
+	for _, ref := range refs {
+		releaseId, sha256Hex, ok := strings.Cut(strings.TrimPrefix(ref.Key, blobRefsPrefix+appSlug+"/"), "/")
+		if !ok {
+			continue
+		}
+		blobsByRelease[releaseId] = append(blobsByRelease[releaseId], sha256Hex)
+		if ref.LastModified.After(lastWritten[releaseId]) {
+			lastWritten[releaseId] = ref.LastModified
+		}
+	}
+	var legacy []string
+	for _, object := range objects {
+		if object.Key == releasePointerKey(appSlug) {
+			continue
+		}
+		releaseId, name, ok := strings.Cut(strings.TrimPrefix(object.Key, releasesPrefix(appSlug)), "/")
+		if !strings.HasPrefix(object.Key, releasesPrefix(appSlug)) || !ok {
+			legacy = append(legacy, object.Key)
+			continue
+		}
+		filesByRelease[releaseId] = append(filesByRelease[releaseId], name)
+		if object.LastModified.After(lastWritten[releaseId]) {
+			lastWritten[releaseId] = object.LastModified
+		}
+	}
+
+	var removed, unreferenced []string
+	keys := legacy
+	for releaseId := range lastWritten {
+		if releaseId == current.ReleaseId || releaseId == current.PreviousReleaseId {
+			continue
+		}
+		if releaseId != retired && now.Sub(lastWritten[releaseId]) <= releaseRetention {
+			continue
+		}
+		removed = append(removed, releaseId)
+		for _, name := range filesByRelease[releaseId] {
+			keys = append(keys, releasesPrefix(appSlug)+releaseId+"/"+name)
+		}
+		for _, sha256Hex := range blobsByRelease[releaseId] {
+			keys = append(keys, blobRefsPrefix+appSlug+"/"+releaseId+"/"+sha256Hex)
+			unreferenced = append(unreferenced, sha256Hex)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(removed)
+	if err := h.services.Blobs.DeleteObjects(ctx, keys); err != nil {
+		slog.WarnContext(ctx, "Failed to delete stale files", "app_slug", appSlug, "releases", removed, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Deleted stale files", "app_slug", appSlug, "releases", removed,
+		"legacy_files", len(legacy), "files", len(keys), "dropped_files", droppedFiles(filesByRelease[current.PreviousReleaseId], filesByRelease[current.ReleaseId]))
+	if len(unreferenced) > 0 {
+		h.collectBlobs(ctx, unreferenced)
+	}
+}
+
+// droppedFiles returns the names in the previous release that the live one
+// no longer has, the files a publisher removed between versions.
+func droppedFiles(previous, live []string) []string {
+	kept := make(map[string]bool, len(live))
+	for _, name := range live {
+		kept[name] = true
+	}
+	dropped := []string{}
+	for _, name := range previous {
+		if !kept[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
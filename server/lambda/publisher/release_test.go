@@ -0,0 +1,290 @@
+package publisher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newReleaseMessage describes a release of shape whose files are already in
+// blobs, the way unzip leaves them before it queues the message.
+func newReleaseMessage(blobs *MemoryBlobStore, releaseId string, uploaded time.Time, files ...string) AppMetadataMessage {
+	var processedFiles []string
+	var entries []FileEntry
+	for _, file := range files {
+		key := "app/shape/releases/" + releaseId + "/" + file
+		blobs.PutObjectAt(key, []byte(file), uploaded)
+		processedFiles = append(processedFiles, key)
+		digest := sha256.Sum256([]byte(file))
+		entries = append(entries, FileEntry{Path: file, Size: int64(len(file)), SHA256: hex.EncodeToString(digest[:])})
+	}
+	return AppMetadataMessage{
+		AppSlug:         "shape",
+		VersionId:       "1.0.0",
+		PublisherId:     "publisher-1",
+		RequestId:       releaseId,
+		ReleaseId:       releaseId,
+		S3FilePath:      "app/shape/releases/" + releaseId + "/",
+		UploadTimestamp: uploaded,
+		ProcessedFiles:  processedFiles,
+		Files:           entries,
+	}
+}
+
+func readPointer(t *testing.T, blobs *MemoryBlobStore) ReleasePointer {
+	t.Helper()
+	body, err := blobs.GetObject(context.Background(), "app/shape/current.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pointer ReleasePointer
+	if err := json.Unmarshal(body, &pointer); err != nil {
+		t.Fatal(err)
+	}
+	return pointer
+}
+
+func TestSaveAppMetadataPromotesOnlyNewerReleases(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := handler.saveAppMetadata(ctx, newReleaseMessage(blobs, "r2", base.Add(time.Hour), "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	pointer := readPointer(t, blobs)
+	if pointer.ReleaseId != "r2" || pointer.Path != "/app/shape/releases/r2/" {
+		t.Fatalf("pointer = %+v, want release r2", pointer)
+	}
+	if len(pointer.Files) != 1 || pointer.Files[0].Path != "index.html" || pointer.Files[0].SHA256 == "" {
+		t.Errorf("pointer content manifest = %+v, want index.html with its hash", pointer.Files)
+	}
+
+	// A late or replayed older upload neither goes live nor is recorded
+	if err := handler.saveAppMetadata(ctx, newReleaseMessage(blobs, "r1", base, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if pointer := readPointer(t, blobs); pointer.ReleaseId != "r2" {
+		t.Fatalf("older release went live: %+v", pointer)
+	}
+	if apps := services.Apps.(*MemoryAppStore).Apps(); len(apps) != 1 || apps[0].ReleaseId != "r2" {
+		t.Fatalf("catalog = %+v, want only the live release r2", apps)
+	}
+
+	// Redelivering the live release keeps it live
+	if err := handler.saveAppMetadata(ctx, newReleaseMessage(blobs, "r2", base.Add(time.Hour), "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if pointer := readPointer(t, blobs); pointer.ReleaseId != "r2" {
+		t.Fatalf("pointer = %+v after redelivery", pointer)
+	}
+}
+
+// racingBlobStore runs race once, right after the release pointer is read,
+// as if another ingest of the app ran between that read and the write.
+type racingBlobStore struct {
+	*MemoryBlobStore
+	race func()
+}
+
+func (s *racingBlobStore) GetObjectWithETag(ctx context.Context, key string) ([]byte, string, error) {
+	body, etag, err := s.MemoryBlobStore.GetObjectWithETag(ctx, key)
+	if race := s.race; race != nil && key == "app/shape/current.json" {
+		s.race = nil
+		race()
+	}
+	return body, etag, err
+}
+
+func TestConcurrentPromotionIsNotLost(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	other := NewHandler(services)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	if err := other.saveAppMetadata(ctx, newReleaseMessage(blobs, "r1", base, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	r2 := newReleaseMessage(blobs, "r2", base.Add(time.Minute), "index.html")
+	r3 := newReleaseMessage(blobs, "r3", base.Add(2*time.Minute), "index.html")
+
+	racing := &racingBlobStore{MemoryBlobStore: blobs, race: func() {
+		if err := other.saveAppMetadata(ctx, r3); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	services.Blobs = racing
+	if err := NewHandler(services).saveAppMetadata(ctx, r2); err != nil {
+		t.Fatal(err)
+	}
+	if pointer := readPointer(t, blobs); pointer.ReleaseId != "r3" || pointer.PreviousReleaseId != "r1" {
+		t.Fatalf("pointer = %s previous %s, want r3 previous r1", pointer.ReleaseId, pointer.PreviousReleaseId)
+	}
+}
+
+func listKeys(t *testing.T, blobs *MemoryBlobStore, prefix string) []string {
+	t.Helper()
+	objects, err := blobs.ListObjects(context.Background(), prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+func TestNewVersionKeepsOnlyThePreviousRelease(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	// Served before releases existed
+	blobs.PutObjectAt("app/shape/index.html", nil, base.Add(-time.Hour))
+	blobs.PutObjectAt("app/shape/old.png", nil, base.Add(-time.Hour))
+
+	v1 := newReleaseMessage(blobs, "r1", base, "index.html", "old.png")
+	if err := handler.saveAppMetadata(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	v2 := newReleaseMessage(blobs, "r2", base.Add(time.Minute), "index.html", "new.png")
+	if err := handler.saveAppMetadata(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+
+	// r1 stays until the next promotion for clients still loading it
+	want := append(append([]string{}, v1.ProcessedFiles...), v2.ProcessedFiles...)
+	if served := listKeys(t, blobs, "app/shape/releases/"); strings.Join(served, ",") != strings.Join(want, ",") {
+		t.Errorf("served files = %v, want exactly %v", served, want)
+	}
+	if keys := listKeys(t, blobs, "app/shape/"); len(keys) != len(want)+1 {
+		t.Errorf("stale files left under app/shape/: %v", keys)
+	}
+	if pointer := readPointer(t, blobs); pointer.PreviousReleaseId != "r1" {
+		t.Errorf("previous release = %q, want r1", pointer.PreviousReleaseId)
+	}
+
+	// Redelivering the live release keeps the previous one
+	if err := handler.saveAppMetadata(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+	if pointer := readPointer(t, blobs); pointer.PreviousReleaseId != "r1" {
+		t.Errorf("previous release = %q after redelivery, want r1", pointer.PreviousReleaseId)
+	}
+
+	v3 := newReleaseMessage(blobs, "r3", base.Add(2*time.Minute), "index.html")
+	if err := handler.saveAppMetadata(ctx, v3); err != nil {
+		t.Fatal(err)
+	}
+	want = append(append([]string{}, v2.ProcessedFiles...), v3.ProcessedFiles...)
+	if served := listKeys(t, blobs, "app/shape/releases/"); strings.Join(served, ",") != strings.Join(want, ",") {
+		t.Errorf("served files = %v, want exactly %v", served, want)
+	}
+}
+
+func TestOlderRecordsFollowTheLiveRelease(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	v1 := newReleaseMessage(blobs, "r1", base, "index.html", "icon.png")
+	v1.Icons = []IconInfo{{Path: "icon.png", Width: 192, Height: 192, Type: "image/png"}}
+	if err := handler.saveAppMetadata(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	v2 := newReleaseMessage(blobs, "r2", base.Add(time.Minute), "index.html", "logo.png")
+	v2.VersionId = "2.0.0"
+	v2.Icons = []IconInfo{{Path: "logo.png", Width: 192, Height: 192, Type: "image/png"}}
+	if err := handler.saveAppMetadata(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+	// A redelivery finds nothing left to supersede
+	if err := handler.saveAppMetadata(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := services.Apps.(*MemoryAppStore).Apps()
+	if len(apps) != 2 {
+		t.Fatalf("expected a record per version, got %+v", apps)
+	}
+	older, live := apps[0], apps[1]
+	if older.VersionId != "1.0.0" || older.SupersededBy != live.AppId {
+		t.Errorf("version 1.0.0 = %+v, want it superseded by %s", older, live.AppId)
+	}
+	if older.S3FilePath != live.S3FilePath || older.ReleaseId != "r2" ||
+		strings.Join(older.ProcessedFiles, ",") != strings.Join(v2.ProcessedFiles, ",") ||
+		len(older.Thumbnails) != 1 || older.Thumbnails[0].Url != "/app/shape/releases/r2/logo.png" {
+		t.Errorf("version 1.0.0 still points at its own files: %+v", older)
+	}
+	if live.SupersededBy != "" {
+		t.Errorf("the live version is marked superseded by %s", live.SupersededBy)
+	}
+}
+
+func TestReleaseWithMissingFilesIsNotPromoted(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+
+	metadata := newReleaseMessage(blobs, "r1", time.Now(), "index.html")
+	metadata.ProcessedFiles = append(metadata.ProcessedFiles, "app/shape/releases/r1/app.js")
+	if err := handler.saveAppMetadata(context.Background(), metadata); err == nil {
+		t.Fatal("expected an incomplete release to fail ingest")
+	}
+	if _, err := blobs.GetObject(context.Background(), "app/shape/current.json"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("incomplete release went live: %v", err)
+	}
+	if apps := services.Apps.(*MemoryAppStore).Apps(); len(apps) != 0 {
+		t.Errorf("incomplete release was added to the catalog: %+v", apps)
+	}
+}
+
+func TestCleanupReleasesKeepsLivePreviousAndRecentReleases(t *testing.T) {
+	blobs := NewMemoryBlobStore("http://localhost")
+	services := NewMemoryServices("http://localhost")
+	services.Blobs = blobs
+	handler := NewHandler(services)
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-releaseRetention - time.Hour)
+
+	blobs.PutObjectAt("app/shape/releases/live/index.html", nil, old)
+	blobs.PutObjectAt("app/shape/releases/previous/index.html", nil, old)
+	blobs.PutObjectAt("app/shape/releases/stale/index.html", nil, old)
+	blobs.PutObjectAt("app/shape/releases/stale/app.js", nil, old)
+	blobs.PutObjectAt("app/shape/releases/pending/index.html", nil, now.Add(-time.Hour))
+	blobs.PutObjectAt("app/other/releases/stale/index.html", nil, old)
+	pointer, _ := json.Marshal(ReleasePointer{ReleaseId: "live", PreviousReleaseId: "previous"})
+	blobs.PutObjectAt("app/shape/current.json", pointer, old)
+
+	handler.cleanupReleases(context.Background(), "shape", "", now)
+
+	keys := listKeys(t, blobs, "app/")
+	want := []string{
+		"app/other/releases/stale/index.html",
+		"app/shape/current.json",
+		"app/shape/releases/live/index.html",
+		"app/shape/releases/pending/index.html",
+		"app/shape/releases/previous/index.html",
+	}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,111 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// apiError is an AWS SDK v2 API error with the given code.
+type apiError string
+
+func (e apiError) Error() string     { return string(e) }
+func (e apiError) ErrorCode() string { return string(e) }
+
+func TestRouterMapsHandlerFailures(t *testing.T) {
+	captureMetrics(t)
+	tests := []struct {
+		name         string
+		method       string
+		err          error
+		failures     int // attempts that fail before the handler succeeds
+		panics       bool
+		wantStatus   int
+		wantAttempts int
+		wantError    string
+	}{
+		{name: "throttled GET", method: "GET", err: apiError("ThrottlingException"), failures: 5,
+			wantStatus: 503, wantAttempts: maxGetAttempts, wantError: "Service is busy, please retry"},
+		{name: "timed out GET", method: "GET", err: context.DeadlineExceeded, failures: 5,
+			wantStatus: 504, wantAttempts: maxGetAttempts, wantError: "Request timed out"},
+		{name: "GET recovers on retry", method: "GET", err: apiError("SlowDown"), failures: 1,
+			wantStatus: 200, wantAttempts: 2},
+		{name: "failed GET", method: "GET", err: errors.New("access denied"), failures: 5,
+			wantStatus: 502, wantAttempts: 1, wantError: "Upstream request failed"},
+		{name: "throttled POST", method: "POST", err: apiError("ThrottlingException"), failures: 5,
+			wantStatus: 503, wantAttempts: 1, wantError: "Service is busy, please retry"},
+		{name: "panic", method: "GET", panics: true,
+			wantStatus: 502, wantAttempts: 1, wantError: "Upstream request failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			r := newRouter()
+			handler := func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+				attempts++
+				if tt.panics {
+					panic("nil map")
+				}
+				if attempts <= tt.failures {
+					return events.APIGatewayV2HTTPResponse{}, tt.err
+				}
+				return createSuccessResponse(200, "ok"), nil
+			}
+			r.handle(tt.method, "/publishers/me", handler)
+
+			response, err := r.dispatch(context.Background(), newAPIRequest(tt.method, "/publishers/me"))
+			if err != nil {
+				t.Fatalf("dispatch returned an error: %v", err)
+			}
+			if response.StatusCode != tt.wantStatus || attempts != tt.wantAttempts {
+				t.Errorf("got %d after %d attempts, want %d after %d", response.StatusCode, attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if got := response.Headers[correlationIdHeader]; got != "req-123" {
+				t.Errorf("%s = %q, want the request id", correlationIdHeader, got)
+			}
+			if tt.wantError == "" {
+				return
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatalf("error body is not JSON: %v: %s", err, response.Body)
+			}
+			if body.Error != tt.wantError || body.CorrelationId != "req-123" {
+				t.Errorf("body = %+v, want %q with the request id", body, tt.wantError)
+			}
+			if retryAfter := response.Headers["Retry-After"]; (tt.wantStatus == 503) != (retryAfter != "") {
+				t.Errorf("Retry-After = %q for status %d", retryAfter, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRouterAnswersUnknownRoutesAndMethods(t *testing.T) {
+	captureMetrics(t)
+	r := newRouter()
+	ok := func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return createSuccessResponse(200, request.PathParameters), nil
+	}
+	r.handle("GET", "/publishers/me", ok)
+	r.handle("PUT", "/publishers/me", ok)
+	r.handle("GET", "/publish/{app-slug}", ok)
+
+	response, _ := r.dispatch(context.Background(), newAPIRequest("DELETE", "/publishers/me"))
+	if response.StatusCode != 405 || response.Headers["Allow"] != "GET, PUT" {
+		t.Errorf("DELETE: got %d with Allow %q, want 405 with GET, PUT", response.StatusCode, response.Headers["Allow"])
+	}
+	response, _ = r.dispatch(context.Background(), newAPIRequest("GET", "/publishers"))
+	if response.StatusCode != 404 || response.Body != `{"error":"Route not found"}` {
+		t.Errorf("unknown route: got %d %s", response.StatusCode, response.Body)
+	}
+
+	request := newAPIRequest("GET", "/default/publish/shape")
+	request.RequestContext.Stage = "default"
+	response, _ = r.dispatch(context.Background(), request)
+	if response.StatusCode != 200 || response.Body != `{"app-slug":"shape"}` {
+		t.Errorf("stage prefixed path: got %d %s", response.StatusCode, response.Body)
+	}
+}
@@ -0,0 +1,538 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+/*****************************************************/
+// Storage interfaces
+/*****************************************************/
+// AppStore persists the app records ingested from the metadata queue.
+type AppStore interface {
+	SaveApp(ctx context.Context, record AppRecord) error
+	// ListAppVersions returns the records of a publisher's app, one per
+	// ingested upload.
+	ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]AppRecord, error)
+}
+
+// PublisherStore persists publisher profiles. GetPublisher returns nil when
+// the publisher has no profile yet.
+type PublisherStore interface {
+	GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error)
+	UpdatePublisher(ctx context.Context, publisherId string, request UpdateProfileRequest) (*PublisherProfile, error)
+	SetPublisherAvatar(ctx context.Context, publisherId, avatarKey string) error
+}
+
+// BlobStore issues upload URLs for objects in the apps bucket and manages the
+// released app files in it.
+type BlobStore interface {
+	// PresignPut issues an upload URL. An empty content type, a zero size or
+	// an empty SHA-256 leaves that part of the upload unconstrained. The client
+	// sends the SHA-256 in the x-amz-checksum-sha256 header, and S3 rejects a
+	// body that does not match it.
+	PresignPut(ctx context.Context, key, contentType string, size int64, sha256Hex string) (string, error)
+	// GetObject returns an error wrapping ErrObjectNotFound when the key does
+	// not exist.
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	// GetObjectWithETag is GetObject that also returns the object's ETag.
+	GetObjectWithETag(ctx context.Context, key string) ([]byte, string, error)
+	PutObject(ctx context.Context, key string, body []byte, contentType, cacheControl string) error
+	// PutObjectIfMatch is PutObject that only writes over the object with the
+	// ETag etag, or only creates the object when etag is empty. Otherwise it
+	// returns an error wrapping ErrPreconditionFailed.
+	PutObjectIfMatch(ctx context.Context, key string, body []byte, contentType, cacheControl, etag string) error
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	DeleteObjects(ctx context.Context, keys []string) error
+
+	// CreateMultipartUpload starts a multipart upload of key and returns its
+	// id.
+	CreateMultipartUpload(ctx context.Context, key string) (string, error)
+	// PresignUploadPart issues an upload URL for one part of a multipart
+	// upload, constrained to size bytes.
+	PresignUploadPart(ctx context.Context, key, uploadId string, partNumber int, size int64) (string, error)
+	// ListParts returns the parts uploaded so far by part number, or an error
+	// wrapping ErrUploadNotFound once the upload is completed or aborted.
+	ListParts(ctx context.Context, key, uploadId string) ([]UploadedPart, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadId string, parts []UploadedPart) error
+}
+
+// ObjectInfo describes one object returned by ListObjects.
+type ObjectInfo struct {
+	Key          string
+	LastModified time.Time
+}
+
+// UploadedPart is one part of a multipart upload stored by S3.
+type UploadedPart struct {
+	PartNumber int
+	ETag       string
+	Size       int64
+}
+
+// ErrObjectNotFound reports a missing object. It is exported so blob stores
+// outside this package, like the local development server's, can return it.
+var ErrObjectNotFound = errors.New("the specified key does not exist")
+
+// ErrPreconditionFailed reports a conditional write that lost to another
+// write of the same object.
+var ErrPreconditionFailed = errors.New("the object changed since it was read")
+
+// ErrUploadNotFound reports a multipart upload that is no longer in progress.
+var ErrUploadNotFound = errors.New("the specified multipart upload does not exist")
+
+// errPublisherNotFound is returned when updating a profile that does not exist.
+var errPublisherNotFound = errors.New("publisher profile not found")
+
+const (
+	presignedUrlExpiry = 15 * time.Minute
+	// DeleteObjects accepts at most 1000 keys per request
+	maxDeleteKeys = 1000
+	// awsCallTimeout bounds each DynamoDB or S3 call, retries included, so one
+	// slow call fails on its own instead of using up the whole invocation.
+	// Listings and batch deletes apply it per page or batch, so their length
+	// does not count against it.
+	awsCallTimeout = 5 * time.Second
+)
+
+// Services are the clients the handlers use. They are created once per cold
+// start and shared by every invocation.
+type Services struct {
+	Apps       AppStore
+	Publishers PublisherStore
+	Blobs      BlobStore
+}
+
+// NewAWSServices creates the DynamoDB and S3 backed services from the
+// environment Terraform gives the function.
+func NewAWSServices(ctx context.Context) (Services, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return Services{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	s3Client := s3.NewFromConfig(cfg)
+	return Services{
+		Apps: &dynamoAppStore{
+			client:    dynamoClient,
+			tableName: os.Getenv("app_table_name"),
+		},
+		Publishers: &dynamoPublisherStore{
+			client:    dynamoClient,
+			tableName: os.Getenv("publisher_table_name"),
+		},
+		Blobs: &s3BlobStore{
+			client:    s3Client,
+			presigner: s3.NewPresignClient(s3Client),
+			bucket:    os.Getenv("apps_bucket"),
+		},
+	}, nil
+}
+
+/*****************************************************/
+// DynamoDB app store
+/*****************************************************/
+type dynamoAppStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func (s *dynamoAppStore) SaveApp(ctx context.Context, record AppRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app record: %w", err)
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put item in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+// publisherAppsIndexName is the index of the app table by publisher and upload
+// time, which projects every attribute.
+const publisherAppsIndexName = "publisherId-uploadTimestamp-index"
+
+func (s *dynamoAppStore) ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]AppRecord, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(publisherAppsIndexName),
+		KeyConditionExpression: aws.String("publisherId = :publisherId"),
+		FilterExpression:       aws.String("appSlug = :appSlug"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":publisherId": &types.AttributeValueMemberS{Value: publisherId},
+			":appSlug":     &types.AttributeValueMemberS{Value: appSlug},
+		},
+	})
+	var records []AppRecord
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query app versions: %w", err)
+		}
+		var pageRecords []AppRecord
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageRecords); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal app versions: %w", err)
+		}
+		records = append(records, pageRecords...)
+	}
+	return records, nil
+}
+
+/*****************************************************/
+// DynamoDB publisher store
+/*****************************************************/
+type dynamoPublisherStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func (s *dynamoPublisherStore) GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get publisher profile: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var profile PublisherProfile
+	if err := attributevalue.UnmarshalMap(result.Item, &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal publisher profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// UpdatePublisher only sets the fields a publisher may edit. The verified
+// flag is managed by platform admins and is never written from a request.
+func (s *dynamoPublisherStore) UpdatePublisher(ctx context.Context, publisherId string, request UpdateProfileRequest) (*PublisherProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		},
+		UpdateExpression: aws.String(
+			"SET displayName = :displayName, website = :website, updatedAt = :now, " +
+				"createdAt = if_not_exists(createdAt, :now), verified = if_not_exists(verified, :false)",
+		),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":displayName": &types.AttributeValueMemberS{Value: strings.TrimSpace(request.DisplayName)},
+			":website":     &types.AttributeValueMemberS{Value: request.Website},
+			":now":         &types.AttributeValueMemberS{Value: now},
+			":false":       &types.AttributeValueMemberBOOL{Value: false},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update publisher profile: %w", err)
+	}
+
+	var profile PublisherProfile
+	if err := attributevalue.UnmarshalMap(result.Attributes, &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal publisher profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// SetPublisherAvatar requires an existing profile so an avatar can never
+// create a profile without a display name.
+func (s *dynamoPublisherStore) SetPublisherAvatar(ctx context.Context, publisherId, avatarKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		},
+		UpdateExpression:    aws.String("SET avatarKey = :avatarKey, updatedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(publisherId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":avatarKey": &types.AttributeValueMemberS{Value: avatarKey},
+			":now":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return errPublisherNotFound
+		}
+		return fmt.Errorf("failed to set publisher avatar: %w", err)
+	}
+	return nil
+}
+
+/*****************************************************/
+// S3 blob store
+/*****************************************************/
+type s3BlobStore struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+}
+
+func (s *s3BlobStore) PresignPut(ctx context.Context, key, contentType string, size int64, sha256Hex string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if size > 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	if sha256Hex != "" {
+		checksum, err := checksumSHA256(sha256Hex)
+		if err != nil {
+			return "", err
+		}
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+
+	req, err := s.presigner.PresignPutObject(ctx, input, func(opts *s3.PresignOptions) {
+		opts.Expires = presignedUrlExpiry
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating presigned URL: %w", err)
+	}
+	return req.URL, nil
+}
+
+func (s *s3BlobStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := s.GetObjectWithETag(ctx, key)
+	return body, err
+}
+
+func (s *s3BlobStore) GetObjectWithETag(ctx context.Context, key string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			err = ErrObjectNotFound
+		}
+		return nil, "", fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	return body, aws.ToString(resp.ETag), nil
+}
+
+func (s *s3BlobStore) PutObject(ctx context.Context, key string, body []byte, contentType, cacheControl string) error {
+	return s.putObject(ctx, key, body, contentType, cacheControl, nil)
+}
+
+// Conditional writes fail with 412 when the object changed, or with 409 when
+// another conditional write of it is still in flight.
+var preconditionErrorCodes = map[string]bool{
+	"PreconditionFailed":         true,
+	"ConditionalRequestConflict": true,
+}
+
+func (s *s3BlobStore) PutObjectIfMatch(ctx context.Context, key string, body []byte, contentType, cacheControl, etag string) error {
+	return s.putObject(ctx, key, body, contentType, cacheControl, func(input *s3.PutObjectInput) {
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+	})
+}
+
+func (s *s3BlobStore) putObject(ctx context.Context, key string, body []byte, contentType, cacheControl string, condition func(*s3.PutObjectInput)) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if condition != nil {
+		condition(input)
+	}
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		var apiErr interface{ ErrorCode() string }
+		if errors.As(err, &apiErr) && preconditionErrorCodes[apiErr.ErrorCode()] {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *s3BlobStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	var objects []ObjectInfo
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *s3BlobStore) DeleteObjects(ctx context.Context, keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteKeys {
+		end := min(start+maxDeleteKeys, len(keys))
+		objects := make([]s3types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, s3types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		batchCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		resp, err := s.client.DeleteObjects(batchCtx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(resp.Errors) > 0 {
+			return fmt.Errorf("failed to delete %d objects, first %s: %s",
+				len(resp.Errors), aws.ToString(resp.Errors[0].Key), aws.ToString(resp.Errors[0].Message))
+		}
+	}
+	return nil
+}
+
+func (s *s3BlobStore) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	resp, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload of %s: %w", key, err)
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+func (s *s3BlobStore) PresignUploadPart(ctx context.Context, key, uploadId string, partNumber int, size int64) (string, error) {
+	req, err := s.presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadId),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		ContentLength: aws.Int64(size),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = presignedUrlExpiry
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating presigned URL for part %d: %w", partNumber, err)
+	}
+	return req.URL, nil
+}
+
+func (s *s3BlobStore) ListParts(ctx context.Context, key, uploadId string) ([]UploadedPart, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	var parts []UploadedPart
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			var noSuchUpload *s3types.NoSuchUpload
+			if errors.As(err, &noSuchUpload) {
+				err = ErrUploadNotFound
+			}
+			return nil, fmt.Errorf("failed to list parts of %s: %w", key, err)
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, UploadedPart{
+				PartNumber: int(aws.ToInt32(part.PartNumber)),
+				ETag:       aws.ToString(part.ETag),
+				Size:       aws.ToInt64(part.Size),
+			})
+		}
+	}
+	return parts, nil
+}
+
+func (s *s3BlobStore) CompleteMultipartUpload(ctx context.Context, key, uploadId string, parts []UploadedPart) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	completed := make([]s3types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, s3types.CompletedPart{
+			PartNumber: aws.Int32(int32(part.PartNumber)),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		var noSuchUpload *s3types.NoSuchUpload
+		if errors.As(err, &noSuchUpload) {
+			err = ErrUploadNotFound
+		}
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+	return nil
+}
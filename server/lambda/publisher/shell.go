@@ -0,0 +1,73 @@
+package publisher
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Shell runtime contract
+/*****************************************************/
+// The PWA shell runs an app by fetching fixed files from the root of its
+// release: manifest.json, index.html, whose markup it mounts, app.js, which it
+// runs as a classic script, and sw.js, which it registers as the service
+// worker. An app missing one of them publishes fine and only fails once
+// installed, so the publisher rejects it up front and unzip checks the bundle
+// again. Models are checked by validateModels. The entrypoint and service
+// worker are stored in the app record and travel with the declaration.
+
+const (
+	shellEntrypoint    = "index.html"
+	shellScript        = "app.js"
+	shellServiceWorker = "sw.js"
+)
+
+var javascriptTypes = []string{"application/javascript", "text/javascript"}
+
+// shellFiles are the files the shell fetches besides the models, what it does
+// with each and the types they can be declared with.
+var shellFiles = []struct {
+	path  string
+	usage string
+	types []string
+}{
+	{path: "manifest.json", usage: "the web app manifest", types: []string{"application/json", "application/manifest+json"}},
+	{path: shellEntrypoint, usage: "the page the shell mounts", types: []string{"text/html"}},
+	{path: shellScript, usage: "the script the shell runs", types: javascriptTypes},
+	{path: shellServiceWorker, usage: "the service worker the shell registers", types: javascriptTypes},
+}
+
+// validateShellContract checks that the bundle has every file the shell
+// fetches, at the root and of the right type, and that the entrypoint is the
+// page the shell loads. Every missing file is reported at once.
+func validateShellContract(entrypoint string, files []File) (events.APIGatewayV2HTTPResponse, error) {
+	if normalized := normalizeManifestUrl(entrypoint); normalized != shellEntrypoint {
+		return createErrorResponse(400, fmt.Sprintf("Entrypoint %s is not supported: the PWA shell always loads %s from the root of the bundle, so rename the entry page to %s",
+			entrypoint, shellEntrypoint, shellEntrypoint))
+	}
+
+	types := make(map[string]string, len(files))
+	for _, file := range files {
+		types[file.Filename] = file.Type
+	}
+	var missing []string
+	for _, shellFile := range shellFiles {
+		fileType, ok := types[shellFile.path]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%s (%s)", shellFile.path, shellFile.usage))
+			continue
+		}
+		// Browsers leave the type empty for extensions they do not know
+		if fileType != "" && !slices.Contains(shellFile.types, fileType) {
+			return createErrorResponse(400, fmt.Sprintf("%s must be declared as %s, not %s",
+				shellFile.path, strings.Join(shellFile.types, " or "), fileType))
+		}
+	}
+	if len(missing) > 0 {
+		return createErrorResponse(400, fmt.Sprintf("The PWA shell needs these files at the root of the bundle: %s", strings.Join(missing, ", ")))
+	}
+	return events.APIGatewayV2HTTPResponse{}, nil
+}
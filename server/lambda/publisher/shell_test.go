@@ -0,0 +1,70 @@
+package publisher
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateShellContract(t *testing.T) {
+	shellFiles := func(drop string, retype map[string]string) []File {
+		var files []File
+		for _, file := range []File{
+			{Filename: "manifest.json", Type: "application/json"},
+			{Filename: "index.html", Type: "text/html"},
+			{Filename: "app.js", Type: "text/javascript"},
+			{Filename: "sw.js", Type: "application/javascript"},
+			{Filename: "model.onnx", Type: "application/octet-stream"},
+		} {
+			if file.Filename == drop {
+				continue
+			}
+			if fileType, ok := retype[file.Filename]; ok {
+				file.Type = fileType
+			}
+			files = append(files, file)
+		}
+		return files
+	}
+	tests := map[string]struct {
+		entrypoint string
+		files      []File
+		want       string
+	}{
+		"complete":           {entrypoint: "index.html", files: shellFiles("", nil)},
+		"relative entry":     {entrypoint: "./index.html", files: shellFiles("", nil)},
+		"untyped files":      {entrypoint: "index.html", files: shellFiles("", map[string]string{"sw.js": ""})},
+		"other entrypoint":   {entrypoint: "main.html", files: shellFiles("", nil), want: "rename the entry page to index.html"},
+		"nested entrypoint":  {entrypoint: "web/index.html", files: shellFiles("", nil), want: "Entrypoint web/index.html is not supported"},
+		"no service worker":  {entrypoint: "index.html", files: shellFiles("sw.js", nil), want: "sw.js (the service worker the shell registers)"},
+		"no script":          {entrypoint: "index.html", files: shellFiles("app.js", nil), want: "app.js (the script the shell runs)"},
+		"no page":            {entrypoint: "index.html", files: shellFiles("index.html", nil), want: "index.html (the page the shell mounts)"},
+		"wasm only":          {entrypoint: "index.html", files: shellFiles("app.js", map[string]string{"sw.js": "application/wasm"}), want: "sw.js must be declared as application/javascript or text/javascript"},
+		"page is not html":   {entrypoint: "index.html", files: shellFiles("", map[string]string{"index.html": "text/plain"}), want: "index.html must be declared as text/html, not text/plain"},
+		"nested shell files": {entrypoint: "index.html", files: []File{{Filename: "dist/app.js"}, {Filename: "dist/sw.js"}, {Filename: "index.html"}, {Filename: "manifest.json"}}, want: "app.js (the script the shell runs), sw.js"},
+	}
+	for name, tt := range tests {
+		resp, _ := validateShellContract(tt.entrypoint, tt.files)
+		if tt.want == "" {
+			if resp.StatusCode != 0 {
+				t.Errorf("%s: unexpected error %s", name, resp.Body)
+			}
+			continue
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, tt.want) {
+			t.Errorf("%s: got %d %s, want 400 with %q", name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestAppRecordKeepsTheShellFiles(t *testing.T) {
+	record := newAppRecord(context.Background(), AppMetadataMessage{
+		AppSlug:       "shape",
+		S3FilePath:    "app/shape/releases/r1/",
+		Entrypoint:    "index.html",
+		ServiceWorker: "sw.js",
+	})
+	if record.Entrypoint != "index.html" || record.ServiceWorker != "sw.js" {
+		t.Errorf("entrypoint %q and service worker %q were not kept", record.Entrypoint, record.ServiceWorker)
+	}
+}
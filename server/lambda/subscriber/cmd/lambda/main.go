@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	subscriber "miniapps-lambda-subscriber"
+)
+
+func main() {
+	subscriber.SetupLogging()
+
+	// Clients are created once per cold start and reused by every invocation
+	services, err := subscriber.NewAWSServices(context.Background())
+	if err != nil {
+		slog.Error("Failed to create services", "error", err)
+		os.Exit(1)
+	}
+	lambda.Start(subscriber.NewHandler(services).HandleRequest)
+}
@@ -0,0 +1,208 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+/*****************************************************/
+// In-memory services
+/*****************************************************/
+// The in-memory services stand in for DynamoDB in tests and in the local
+// development server. The catalog and publisher profiles are written by the
+// publisher, so they are filled through PutApp and PutPublisher.
+
+// NewMemoryServices returns services backed by fresh in-memory stores.
+func NewMemoryServices() Services {
+	return Services{
+		Apps:          NewMemoryAppStore(),
+		Subscriptions: NewMemorySubscriptionStore(),
+		Publishers:    NewMemoryPublisherStore(),
+	}
+}
+
+// MemoryAppStore keeps the catalog ordered by app id, which is also the
+// cursor order.
+type MemoryAppStore struct {
+	mu   sync.Mutex
+	apps map[string]AppDetail
+}
+
+func NewMemoryAppStore() *MemoryAppStore {
+	return &MemoryAppStore{apps: make(map[string]AppDetail)}
+}
+
+// PutApp adds or replaces an app.
+func (s *MemoryAppStore) PutApp(app AppDetail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[app.AppId] = app
+}
+
+func (s *MemoryAppStore) sortedApps() []AppListing {
+	apps := make([]AppListing, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app.AppListing)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].AppId < apps[j].AppId })
+	return apps
+}
+
+func (s *MemoryAppStore) ListApps(ctx context.Context, query AppQuery) ([]AppListing, string, error) {
+	var after string
+	if query.Cursor != "" {
+		decodedCursor, err := url.QueryUnescape(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		var cursor map[string]string
+		if err := json.Unmarshal([]byte(decodedCursor), &cursor); err != nil {
+			return nil, "", err
+		}
+		after = cursor["appId"]
+	}
+	var wanted map[string]bool
+	if query.AppIds != nil {
+		wanted = make(map[string]bool, len(query.AppIds))
+		for _, appId := range query.AppIds {
+			wanted[appId] = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := []AppListing{}
+	for _, app := range s.sortedApps() {
+		if app.AppId <= after || (wanted != nil && !wanted[app.AppId]) {
+			continue
+		}
+		apps = append(apps, app)
+		if len(apps) == query.Limit+extraToDetermineIfNextPage {
+			break
+		}
+	}
+	return getNextCursor(apps, query.Limit)
+}
+
+func (s *MemoryAppStore) ListAppsByPublisher(ctx context.Context, publisherId string) ([]AppListing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := []AppListing{}
+	for _, app := range s.sortedApps() {
+		if app.PublisherId == publisherId {
+			apps = append(apps, app)
+		}
+	}
+	// newest uploads first
+	sort.SliceStable(apps, func(i, j int) bool { return apps[i].UploadTimestamp > apps[j].UploadTimestamp })
+	return apps, nil
+}
+
+func (s *MemoryAppStore) GetApp(ctx context.Context, appId string) (*AppDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[appId]
+	if !ok {
+		return nil, nil
+	}
+	return &app, nil
+}
+
+func (s *MemoryAppStore) ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]ChangelogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changelog := []ChangelogEntry{}
+	for _, app := range s.sortedApps() {
+		if app.PublisherId == publisherId && app.AppSlug == appSlug {
+			detail := s.apps[app.AppId]
+			changelog = append(changelog, ChangelogEntry{
+				AppId:           detail.AppId,
+				VersionId:       detail.VersionId,
+				VersionNotes:    detail.VersionNotes,
+				UploadTimestamp: detail.UploadTimestamp,
+			})
+		}
+	}
+	// newest uploads first
+	sort.SliceStable(changelog, func(i, j int) bool { return changelog[i].UploadTimestamp > changelog[j].UploadTimestamp })
+	return changelog, nil
+}
+
+// MemorySubscriptionStore keeps subscriptions keyed by app and user.
+type MemorySubscriptionStore struct {
+	mu            sync.Mutex
+	subscriptions map[[2]string]SubscriptionItem
+}
+
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{subscriptions: make(map[[2]string]SubscriptionItem)}
+}
+
+func (s *MemorySubscriptionStore) ListSubscribedAppIds(ctx context.Context, userId string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var appIds []string
+	for _, subscription := range s.subscriptions {
+		if subscription.UserId == userId {
+			appIds = append(appIds, subscription.AppId)
+		}
+	}
+	sort.Strings(appIds)
+	return appIds, nil
+}
+
+func (s *MemorySubscriptionStore) SubscriptionExists(ctx context.Context, appId, userId string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subscriptions[[2]string{appId, userId}]
+	return ok, nil
+}
+
+func (s *MemorySubscriptionStore) Subscribe(ctx context.Context, appId, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[[2]string{appId, userId}] = SubscriptionItem{AppId: appId, UserId: userId}
+	return nil
+}
+
+// MemoryPublisherStore keeps publisher profiles keyed by publisher id.
+type MemoryPublisherStore struct {
+	mu         sync.Mutex
+	publishers map[string]PublisherProfile
+}
+
+func NewMemoryPublisherStore() *MemoryPublisherStore {
+	return &MemoryPublisherStore{publishers: make(map[string]PublisherProfile)}
+}
+
+// PutPublisher adds or replaces a profile.
+func (s *MemoryPublisherStore) PutPublisher(profile PublisherProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishers[profile.PublisherId] = profile
+}
+
+func (s *MemoryPublisherStore) GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.publishers[publisherId]
+	if !ok {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+func (s *MemoryPublisherStore) GetPublisherNames(ctx context.Context, publisherIds []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make(map[string]string)
+	for _, publisherId := range publisherIds {
+		if profile, ok := s.publishers[publisherId]; ok {
+			names[publisherId] = profile.DisplayName
+		}
+	}
+	return names, nil
+}
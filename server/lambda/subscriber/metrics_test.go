@@ -0,0 +1,109 @@
+package subscriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := metricsOutput
+	metricsOutput = &buf
+	t.Cleanup(func() { metricsOutput = previous })
+	return &buf
+}
+
+func decodeMetricLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("metric line is not JSON: %v: %s", err, line)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func newAPIRequest(method, path string) events.APIGatewayV2HTTPRequest {
+	var request events.APIGatewayV2HTTPRequest
+	request.RawPath = path
+	request.RequestContext.RequestID = "req-123"
+	request.RequestContext.HTTP.Method = method
+	return request
+}
+
+func TestOutcomeForStatus(t *testing.T) {
+	tests := map[int]string{
+		200: outcomeSuccess,
+		302: outcomeSuccess,
+		404: outcomeClientError,
+		409: outcomeClientError,
+		502: outcomeServerError,
+	}
+	for statusCode, want := range tests {
+		if got := outcomeForStatus(statusCode); got != want {
+			t.Errorf("outcomeForStatus(%d) = %s, want %s", statusCode, got, want)
+		}
+	}
+}
+
+func TestRouterEmitsCatalogLatencyByRouteTemplate(t *testing.T) {
+	buf := captureMetrics(t)
+	r := newRouter()
+	r.handle("GET", "/publishers/{publisher-id}", func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		time.Sleep(time.Millisecond)
+		return createSuccessResponse(200, request.PathParameters), nil
+	})
+
+	response, err := r.dispatch(context.Background(), newAPIRequest("GET", "/publishers/publisher-1"))
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("unexpected response %d: %v", response.StatusCode, err)
+	}
+
+	lines := decodeMetricLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 metric line, got %d", len(lines))
+	}
+	line := lines[0]
+	if line["Service"] != "subscriber" || line["Route"] != "GET /publishers/{publisher-id}" || line["Outcome"] != outcomeSuccess {
+		t.Errorf("unexpected dimensions: %v", line)
+	}
+	if latency, ok := line["Latency"].(float64); !ok || latency < 1 {
+		t.Errorf("expected Latency of at least 1ms, got %v", line["Latency"])
+	}
+}
+
+func TestHandleSubscribeRejectionIsCountedAsClientError(t *testing.T) {
+	buf := captureMetrics(t)
+	request := newAPIRequest("POST", "/subscribe")
+	request.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"sub": "user-1", "cognito:groups": "[Subscriber]"},
+		},
+	}
+
+	response, err := NewHandler(NewMemoryServices()).HandleRequest(context.Background(), request)
+	if err != nil || response.StatusCode != 400 {
+		t.Fatalf("expected 400 for missing appID, got %d: %v", response.StatusCode, err)
+	}
+
+	lines := decodeMetricLines(t, buf)
+	if len(lines) != 1 || lines[0]["Route"] != "POST /subscribe" || lines[0]["Outcome"] != outcomeClientError {
+		t.Errorf("unexpected metric lines: %v", lines)
+	}
+	if _, ok := lines[0]["Subscriptions"]; ok {
+		t.Errorf("rejected subscription must not be counted: %v", lines[0])
+	}
+}
@@ -0,0 +1,106 @@
+package subscriber
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*****************************************************/
+// Publisher types for response
+/*****************************************************/
+type PublisherProfile struct {
+	PublisherId string `dynamodbav:"publisherId" json:"publisherId"`
+	DisplayName string `dynamodbav:"displayName" json:"displayName"`
+	Website     string `dynamodbav:"website,omitempty" json:"website,omitempty"`
+	AvatarKey   string `dynamodbav:"avatarKey,omitempty" json:"-"`
+	AvatarUrl   string `dynamodbav:"-" json:"avatarUrl,omitempty"`
+	Verified    bool   `dynamodbav:"verified" json:"verified"`
+}
+
+type PublisherPageResponse struct {
+	Publisher PublisherProfile `json:"publisher"`
+	Apps      []AppListing     `json:"apps"`
+	Count     int              `json:"count"`
+}
+
+/*****************************************************/
+// Publisher helper functions
+/*****************************************************/
+func getAvatarUrl(avatarKey string) string {
+	if avatarKey == "" {
+		return ""
+	}
+	return strings.TrimSuffix(os.Getenv("assets_base_url"), "/") + "/" + avatarKey
+}
+
+// attachPublisherNames fills in PublisherName for each listing. A missing
+// profile is not an error: the app is still listed, just without a name.
+func (h *Handler) attachPublisherNames(ctx context.Context, apps []AppListing) error {
+	var publisherIds []string
+	seen := make(map[string]bool)
+	for _, app := range apps {
+		if app.PublisherId == "" || seen[app.PublisherId] {
+			continue
+		}
+		seen[app.PublisherId] = true
+		publisherIds = append(publisherIds, app.PublisherId)
+	}
+	if len(publisherIds) == 0 {
+		return nil
+	}
+
+	names, err := h.services.Publishers.GetPublisherNames(ctx, publisherIds)
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		apps[i].PublisherName = names[apps[i].PublisherId]
+	}
+	return nil
+}
+
+/*****************************************************/
+// Publisher handler functions
+/*****************************************************/
+func (h *Handler) handleGetPublisher(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	publisherId := request.PathParameters["publisher-id"]
+	if publisherId == "" {
+		return createErrorResponse(400, "publisher-id is required in the URL path")
+	}
+
+	profile, err := h.services.Publishers.GetPublisher(ctx, publisherId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting publisher profile", "error", err)
+		return createErrorResponse(500, "Error retrieving publisher")
+	}
+	if profile == nil {
+		return createErrorResponse(404, "Publisher not found")
+	}
+	profile.AvatarUrl = getAvatarUrl(profile.AvatarKey)
+
+	apps, err := h.services.Apps.ListAppsByPublisher(ctx, publisherId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting publisher apps", "error", err)
+		return createErrorResponse(500, "Error retrieving publisher apps")
+	}
+	for i := range apps {
+		apps[i].PublisherName = profile.DisplayName
+	}
+
+	response := PublisherPageResponse{
+		Publisher: *profile,
+		Apps:      apps,
+		Count:     len(apps),
+	}
+	return createSuccessResponse(200, response), nil
+}
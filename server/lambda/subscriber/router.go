@@ -0,0 +1,270 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Every lambda is a Go module of its own that build.sh compiles and zips on its
+// own, so this router is copied into each lambda rather than shared. The
+// copies differ only in their package clause; the devserver's
+// TestSharedFilesMatch fails as soon as one of them drifts.
+
+/*****************************************************/
+// Route table
+/*****************************************************/
+type routeHandler func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error)
+
+// route is one entry of the route table. The path template uses the same
+// syntax as API Gateway route keys, e.g. /publish/{app-slug}/version/{version-id}.
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  routeHandler
+}
+
+type router struct {
+	routes []route
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+func (r *router) handle(method, pathTemplate string, handler routeHandler) {
+	r.routes = append(r.routes, route{
+		method:   method,
+		pattern:  method + " " + pathTemplate,
+		segments: splitPath(pathTemplate),
+		handler:  handler,
+	})
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// match compares the request path with the route template and returns the
+// values captured by {param} segments.
+func (rt route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, templateSegment := range rt.segments {
+		if strings.HasPrefix(templateSegment, "{") && strings.HasSuffix(templateSegment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[templateSegment[1:len(templateSegment)-1]] = segments[i]
+			continue
+		}
+		if templateSegment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// requestPath returns the request path without the stage prefix that API
+// Gateway adds for named stages (/default/apps -> /apps).
+func requestPath(request events.APIGatewayV2HTTPRequest) string {
+	path := request.RawPath
+	if path == "" {
+		path = request.RequestContext.HTTP.Path
+	}
+	stage := request.RequestContext.Stage
+	if stage != "" && stage != "$default" {
+		path = strings.TrimPrefix(path, "/"+stage)
+	}
+	return path
+}
+
+func (r *router) dispatch(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	start := time.Now()
+	ctx = withRequestId(ctx, request.RequestContext.RequestID)
+	method := request.RequestContext.HTTP.Method
+	path := requestPath(request)
+	segments := splitPath(path)
+	slog.InfoContext(ctx, "Received request", "method", method, "path", path)
+
+	var allowedMethods []string
+	for _, rt := range r.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != method {
+			allowedMethods = append(allowedMethods, rt.method)
+			continue
+		}
+		if request.PathParameters == nil {
+			request.PathParameters = map[string]string{}
+		}
+		for name, value := range params {
+			request.PathParameters[name] = value
+		}
+		response, err := invokeWithRetry(ctx, rt.handler, request)
+		if response.Headers == nil {
+			response.Headers = map[string]string{}
+		}
+		response.Headers[correlationIdHeader] = request.RequestContext.RequestID
+		emitMetrics(rt.pattern, outcomeForStatus(response.StatusCode),
+			countMetric("Requests", 1),
+			durationMetric("Latency", time.Since(start)),
+		)
+		return response, err
+	}
+
+	emitMetrics("unmatched", outcomeClientError, countMetric("Requests", 1))
+	if len(allowedMethods) > 0 {
+		sort.Strings(allowedMethods)
+		response, err := createErrorResponse(405, "Method not allowed")
+		response.Headers["Allow"] = strings.Join(allowedMethods, ", ")
+		return response, err
+	}
+	return createErrorResponse(404, "Route not found")
+}
+
+/*****************************************************/
+// Handler error mapping
+/*****************************************************/
+type errorClass int
+
+const (
+	errorClassFailure errorClass = iota
+	errorClassThrottled
+	errorClassTimeout
+)
+
+const (
+	correlationIdHeader = "X-Correlation-Id"
+	maxGetAttempts      = 3
+	baseRetryBackoff    = 100 * time.Millisecond
+)
+
+var throttleErrorCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"RequestLimitExceeded":                   true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"SlowDown":                               true,
+}
+
+// classifyError tells throttling and timeouts apart from other failures using
+// the ErrorCode() that AWS SDK v2 API errors expose.
+func classifyError(err error) errorClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errorClassTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errorClassTimeout
+	}
+	var v2Err interface{ ErrorCode() string }
+	if errors.As(err, &v2Err) && throttleErrorCodes[v2Err.ErrorCode()] {
+		return errorClassThrottled
+	}
+	return errorClassFailure
+}
+
+// isTransientError reports whether a handler should hand the error back to the
+// router, which retries GETs and maps it to a 503 or 504, instead of answering
+// with its own error response.
+func isTransientError(err error) bool {
+	return classifyError(err) != errorClassFailure
+}
+
+// invokeHandler turns a panic into an error so that one bad request can never
+// take down the invocation without a response.
+func invokeHandler(ctx context.Context, handler routeHandler, request events.APIGatewayV2HTTPRequest) (response events.APIGatewayV2HTTPResponse, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panic: %v", recovered)
+		}
+	}()
+	return handler(ctx, request)
+}
+
+// invokeWithRetry retries throttled and timed out GET requests with jittered
+// exponential backoff. Other methods are not idempotent and run only once.
+func invokeWithRetry(ctx context.Context, handler routeHandler, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	maxAttempts := 1
+	if request.RequestContext.HTTP.Method == "GET" {
+		maxAttempts = maxGetAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var response events.APIGatewayV2HTTPResponse
+		response, err = invokeHandler(ctx, handler, request)
+		if err == nil {
+			return response, nil
+		}
+		if attempt >= maxAttempts || !isTransientError(err) {
+			break
+		}
+
+		backoff := baseRetryBackoff << (attempt - 1)
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			break
+		}
+		slog.WarnContext(ctx, "Retrying request after transient error",
+			"method", request.RequestContext.HTTP.Method, "path", request.RawPath, "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return createHandlerErrorResponse(ctx, request, ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+	return createHandlerErrorResponse(ctx, request, err)
+}
+
+// createHandlerErrorResponse answers a failed handler with a JSON error that
+// carries the API Gateway request id, so a client report can be matched to the
+// CloudWatch logs.
+func createHandlerErrorResponse(ctx context.Context, request events.APIGatewayV2HTTPRequest, err error) (events.APIGatewayV2HTTPResponse, error) {
+	correlationId := request.RequestContext.RequestID
+	slog.ErrorContext(ctx, "Handler failed",
+		"method", request.RequestContext.HTTP.Method, "path", request.RawPath, "error", err)
+
+	statusCode, message := 502, "Upstream request failed"
+	headers := map[string]string{
+		contentTypeHeader:   jsonContentType,
+		correlationIdHeader: correlationId,
+	}
+	switch classifyError(err) {
+	case errorClassThrottled:
+		statusCode, message = 503, "Service is busy, please retry"
+		headers["Retry-After"] = "1"
+	case errorClassTimeout:
+		statusCode, message = 504, "Request timed out"
+	}
+
+	body, _ := json.Marshal(ErrorResponse{Error: message, CorrelationId: correlationId})
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
@@ -0,0 +1,415 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+/*****************************************************/
+// Storage interfaces
+/*****************************************************/
+// AppQuery selects one page of the catalog. A nil AppIds lists every app.
+type AppQuery struct {
+	Limit  int
+	Cursor string
+	AppIds []string
+}
+
+// AppStore reads the app catalog written by the publisher's ingest.
+type AppStore interface {
+	// ListApps returns one page of apps and the cursor of the next page, which
+	// is empty on the last page.
+	ListApps(ctx context.Context, query AppQuery) ([]AppListing, string, error)
+	// ListAppsByPublisher returns a publisher's apps, newest first.
+	ListAppsByPublisher(ctx context.Context, publisherId string) ([]AppListing, error)
+	// GetApp returns the store page of an app, or nil if there is no such app.
+	GetApp(ctx context.Context, appId string) (*AppDetail, error)
+	// ListAppVersions returns the changelog of a publisher's app, newest first.
+	ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]ChangelogEntry, error)
+}
+
+// SubscriptionStore records which users subscribed to which apps.
+type SubscriptionStore interface {
+	ListSubscribedAppIds(ctx context.Context, userId string) ([]string, error)
+	SubscriptionExists(ctx context.Context, appId, userId string) (bool, error)
+	Subscribe(ctx context.Context, appId, userId string) error
+}
+
+// PublisherStore reads publisher profiles. GetPublisher returns nil when the
+// publisher has no profile.
+type PublisherStore interface {
+	GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error)
+	// GetPublisherNames maps publisher ids to display names. Publishers
+	// without a profile are left out.
+	GetPublisherNames(ctx context.Context, publisherIds []string) (map[string]string, error)
+}
+
+// Services are the clients the handlers use. They are created once per cold
+// start and shared by every invocation.
+type Services struct {
+	Apps          AppStore
+	Subscriptions SubscriptionStore
+	Publishers    PublisherStore
+}
+
+// awsCallTimeout bounds each DynamoDB call, retries included, so one slow call
+// fails on its own instead of using up the whole invocation.
+const awsCallTimeout = 5 * time.Second
+
+// NewAWSServices creates the DynamoDB backed services from the environment
+// Terraform gives the function.
+func NewAWSServices(ctx context.Context) (Services, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return Services{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	return Services{
+		Apps: &dynamoAppStore{
+			client:    dynamoClient,
+			tableName: os.Getenv("app_table_name"),
+		},
+		Subscriptions: &dynamoSubscriptionStore{
+			client:    dynamoClient,
+			tableName: os.Getenv("subscription_table_name"),
+		},
+		Publishers: &dynamoPublisherStore{
+			client:    dynamoClient,
+			tableName: os.Getenv("publisher_table_name"),
+		},
+	}, nil
+}
+
+/*****************************************************/
+// DynamoDB app store
+/*****************************************************/
+const (
+	publisherAppsIndexName = "publisherId-uploadTimestamp-index"
+	// BatchGetItem accepts at most 100 keys per request
+	maxBatchGetKeys = 100
+)
+
+type dynamoAppStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func getLastEvaluatedKey(cursor string) (
+	map[string]types.AttributeValue,
+	error,
+) {
+	var lastEvaluatedKey map[string]types.AttributeValue
+	if cursor != "" {
+		decodedCursor, err := url.QueryUnescape(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		var tempMap map[string]interface{}
+		if err := json.Unmarshal([]byte(decodedCursor), &tempMap); err != nil {
+			return nil, err
+		}
+
+		lastEvaluatedKey = make(map[string]types.AttributeValue)
+		for k, v := range tempMap {
+			if strVal, ok := v.(string); ok {
+				lastEvaluatedKey[k] = &types.AttributeValueMemberS{Value: strVal}
+			}
+		}
+	}
+	return lastEvaluatedKey, nil
+}
+
+func (s *dynamoAppStore) ListApps(ctx context.Context, query AppQuery) ([]AppListing, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+		// fetch one more to check if there are more pages
+		Limit: aws.Int32(int32(query.Limit + extraToDetermineIfNextPage)),
+	}
+	if query.AppIds != nil {
+		// Build FilterExpression with individual parameters for each app ID
+		var filterParts []string
+		expressionAttributeValues := make(map[string]types.AttributeValue)
+		for i, appId := range query.AppIds {
+			paramName := fmt.Sprintf(":appId%d", i)
+			filterParts = append(filterParts, fmt.Sprintf("appId = %s", paramName))
+			expressionAttributeValues[paramName] = &types.AttributeValueMemberS{Value: appId}
+		}
+		input.FilterExpression = aws.String(strings.Join(filterParts, " OR "))
+		input.ExpressionAttributeValues = expressionAttributeValues
+	}
+
+	lastEvaluatedKey, err := getLastEvaluatedKey(query.Cursor)
+	if err != nil {
+		slog.DebugContext(ctx, "Error getting last evaluated key", "error", err)
+		return nil, "", err
+	}
+	if lastEvaluatedKey != nil {
+		input.ExclusiveStartKey = lastEvaluatedKey
+	}
+
+	result, err := s.client.Scan(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "DynamoDB scan failed", "error", err)
+		return nil, "", err
+	}
+
+	var apps []AppListing
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &apps); err != nil {
+		slog.DebugContext(ctx, "Error unmarshaling apps", "error", err)
+		return nil, "", err
+	}
+
+	apps, nextCursor, err := getNextCursor(apps, query.Limit)
+	if err != nil {
+		slog.DebugContext(ctx, "Error getting next cursor", "error", err)
+		return nil, "", err
+	}
+	return apps, nextCursor, nil
+}
+
+func (s *dynamoAppStore) ListAppsByPublisher(ctx context.Context, publisherId string) ([]AppListing, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(publisherAppsIndexName),
+		KeyConditionExpression: aws.String("publisherId = :publisherId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		},
+		// newest uploads first
+		ScanIndexForward: aws.Bool(false),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query apps by publisher: %w", err)
+	}
+
+	apps := []AppListing{}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &apps); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal apps: %w", err)
+	}
+	return apps, nil
+}
+
+func (s *dynamoAppStore) GetApp(ctx context.Context, appId string) (*AppDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"appId": &types.AttributeValueMemberS{Value: appId},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get app: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var app AppDetail
+	if err := attributevalue.UnmarshalMap(result.Item, &app); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal app: %w", err)
+	}
+	return &app, nil
+}
+
+func (s *dynamoAppStore) ListAppVersions(ctx context.Context, publisherId, appSlug string) ([]ChangelogEntry, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(publisherAppsIndexName),
+		KeyConditionExpression: aws.String("publisherId = :publisherId"),
+		FilterExpression:       aws.String("appSlug = :appSlug"),
+		ProjectionExpression:   aws.String("appId, versionId, versionNotes, uploadTimestamp"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":publisherId": &types.AttributeValueMemberS{Value: publisherId},
+			":appSlug":     &types.AttributeValueMemberS{Value: appSlug},
+		},
+		// newest uploads first
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	changelog := []ChangelogEntry{}
+	paginator := dynamodb.NewQueryPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		result, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query app versions: %w", err)
+		}
+		var entries []ChangelogEntry
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &entries); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal app versions: %w", err)
+		}
+		changelog = append(changelog, entries...)
+	}
+	return changelog, nil
+}
+
+/*****************************************************/
+// DynamoDB subscription store
+/*****************************************************/
+type SubscriptionItem struct {
+	AppId            string `dynamodbav:"appId"`
+	UserId           string `dynamodbav:"userId"`
+	SubscriptionTime string `dynamodbav:"subscriptionTime"`
+}
+
+type dynamoSubscriptionStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func (s *dynamoSubscriptionStore) ListSubscribedAppIds(ctx context.Context, userId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(s.tableName),
+		FilterExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+	result, err := s.client.Scan(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "Error scanning subscription table", "error", err)
+		return nil, err
+	}
+
+	var subscriptions []SubscriptionItem
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &subscriptions)
+	if err != nil {
+		slog.DebugContext(ctx, "Error unmarshaling subscriptions", "error", err)
+		return nil, err
+	}
+
+	// Extract app IDs from the subscription items
+	var appIds []string
+	for _, subscription := range subscriptions {
+		appIds = append(appIds, subscription.AppId)
+	}
+	return appIds, nil
+}
+
+// SubscriptionExists checks if a subscription already exists for the given appId and userId
+func (s *dynamoSubscriptionStore) SubscriptionExists(ctx context.Context, appId, userId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"appId":  &types.AttributeValueMemberS{Value: appId},
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+	result, err := s.client.GetItem(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "Error checking subscription existence", "error", err)
+		return false, err
+	}
+	return result.Item != nil, nil
+}
+
+func (s *dynamoSubscriptionStore) Subscribe(ctx context.Context, appId, userId string) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	subscriptionTime := time.Now().UTC().Format(time.RFC3339)
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"appId":            &types.AttributeValueMemberS{Value: appId},
+			"userId":           &types.AttributeValueMemberS{Value: userId},
+			"subscriptionTime": &types.AttributeValueMemberS{Value: subscriptionTime},
+		},
+	}
+	_, err := s.client.PutItem(ctx, input)
+	return err
+}
+
+/*****************************************************/
+// DynamoDB publisher store
+/*****************************************************/
+type dynamoPublisherStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func (s *dynamoPublisherStore) GetPublisher(ctx context.Context, publisherId string) (*PublisherProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get publisher profile: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var profile PublisherProfile
+	if err := attributevalue.UnmarshalMap(result.Item, &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal publisher profile: %w", err)
+	}
+	return &profile, nil
+}
+
+func (s *dynamoPublisherStore) GetPublisherNames(ctx context.Context, publisherIds []string) (map[string]string, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(publisherIds))
+	for _, publisherId := range publisherIds {
+		keys = append(keys, map[string]types.AttributeValue{
+			"publisherId": &types.AttributeValueMemberS{Value: publisherId},
+		})
+	}
+
+	names := make(map[string]string)
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := min(start+maxBatchGetKeys, len(keys))
+		requestItems := map[string]types.KeysAndAttributes{
+			s.tableName: {
+				Keys:                 keys[start:end],
+				ProjectionExpression: aws.String("publisherId, displayName"),
+			},
+		}
+		for len(requestItems) > 0 {
+			batchCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+			result, err := s.client.BatchGetItem(batchCtx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch get publisher profiles: %w", err)
+			}
+
+			var profiles []PublisherProfile
+			if err := attributevalue.UnmarshalListOfMaps(result.Responses[s.tableName], &profiles); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal publisher profiles: %w", err)
+			}
+			for _, profile := range profiles {
+				names[profile.PublisherId] = profile.DisplayName
+			}
+			requestItems = result.UnprocessedKeys
+		}
+	}
+	return names, nil
+}
@@ -1,24 +1,14 @@
-package main
+package subscriber
 
 import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
-	"net/url"
-	"os"
+	"log/slog"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 /*****************************************************/
@@ -30,9 +20,56 @@ type AppListing struct {
 	AppName         string `json:"appName"`
 	AppDescription  string `json:"appDescription"`
 	PublisherId     string `json:"publisherId"`
+	PublisherName   string `json:"publisherName,omitempty"`
 	UploadTimestamp string `json:"uploadTimestamp"`
 	VersionNumber   int    `json:"versionNumber"`
 	ManifestContent string `json:"manifestContent,omitempty"`
+	// Models are what unzip found in the app's declared models
+	Models []ModelInfo `json:"models,omitempty"`
+	// Thumbnails are the app's icons, including those generated for the
+	// standard sizes
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+	// SupersededBy is the app id of the live version once a newer one went
+	// live. The files and icons listed are then the live version's, since
+	// this version's were deleted.
+	SupersededBy string `json:"supersededBy,omitempty"`
+}
+
+// Thumbnail is an icon of the app. Url is a path on the catalog's origin.
+type Thumbnail struct {
+	Url     string `json:"url"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+}
+
+// ModelInfo describes the inputs and outputs of one of an app's ONNX models
+// and the operators it needs. UnsupportedOperators lists those
+// onnxruntime-web cannot run.
+type ModelInfo struct {
+	Name                 string        `json:"name"`
+	Role                 string        `json:"role"`
+	Path                 string        `json:"path"`
+	IrVersion            int64         `json:"irVersion"`
+	OpsetVersion         int64         `json:"opsetVersion"`
+	ProducerName         string        `json:"producerName,omitempty"`
+	Opsets               []OperatorSet `json:"opsets"`
+	Inputs               []TensorInfo  `json:"inputs"`
+	Outputs              []TensorInfo  `json:"outputs"`
+	Operators            []string      `json:"operators"`
+	UnsupportedOperators []string      `json:"unsupportedOperators,omitempty"`
+}
+
+type OperatorSet struct {
+	Domain  string `json:"domain"`
+	Version int64  `json:"version"`
+}
+
+type TensorInfo struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Shape []string `json:"shape,omitempty"`
 }
 
 type AppListResponse struct {
@@ -41,14 +78,70 @@ type AppListResponse struct {
 	NextCursor string       `json:"nextCursor,omitempty"`
 }
 
+// AppDetail is the store page of an app: its listing and what the publisher
+// wrote about it. Markdown fields are rendered by clients without raw HTML.
+type AppDetail struct {
+	AppListing
+	VersionId       string       `json:"versionId,omitempty"`
+	VersionNotes    string       `json:"versionNotes,omitempty"`
+	LongDescription string       `json:"longDescription,omitempty"`
+	PrivacyPolicy   string       `json:"privacyPolicy,omitempty"`
+	InputTypes      []string     `json:"inputTypes,omitempty"`
+	ModelCard       *ModelCard   `json:"modelCard,omitempty"`
+	Screenshots     []Screenshot `json:"screenshots,omitempty"`
+	// Changelog lists the version notes of the app's uploads, newest first
+	Changelog []ChangelogEntry `json:"changelog"`
+}
+
+type ModelCard struct {
+	IntendedUse  string `json:"intendedUse,omitempty"`
+	TrainingData string `json:"trainingData,omitempty"`
+	Limitations  string `json:"limitations,omitempty"`
+	License      string `json:"license,omitempty"`
+}
+
+// Screenshot is an image of the app for its store page. Url is a path on the
+// catalog's origin.
+type Screenshot struct {
+	Url        string `json:"url"`
+	Label      string `json:"label,omitempty"`
+	FormFactor string `json:"formFactor,omitempty"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Type       string `json:"type"`
+}
+
+type ChangelogEntry struct {
+	AppId           string `json:"appId"`
+	VersionId       string `json:"versionId,omitempty"`
+	VersionNotes    string `json:"versionNotes,omitempty"`
+	UploadTimestamp string `json:"uploadTimestamp"`
+}
+
 type AppDetailResponse struct {
-	App AppListing `json:"app"`
+	App AppDetail `json:"app"`
 }
 
 const (
 	extraToDetermineIfNextPage = 1
 )
 
+// Handler serves the catalog, subscription and public publisher routes.
+type Handler struct {
+	services Services
+	router   *router
+}
+
+func NewHandler(services Services) *Handler {
+	h := &Handler{services: services, router: newRouter()}
+	h.router.handle("GET", "/apps", subscriberOnly(h.handleGetAllApps))
+	h.router.handle("GET", "/apps/{app-id}", subscriberOnly(h.handleGetApp))
+	h.router.handle("POST", "/subscribe", subscriberOnly(h.handleSubscribe))
+	// Publisher pages are public
+	h.router.handle("GET", "/publishers/{publisher-id}", h.handleGetPublisher)
+	return h
+}
+
 /*****************************************************/
 // Response types
 /*****************************************************/
@@ -58,7 +151,8 @@ const (
 )
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error         string `json:"error"`
+	CorrelationId string `json:"correlationId,omitempty"`
 }
 
 /*****************************************************/
@@ -86,15 +180,19 @@ func createSuccessResponse(statusCode int, data interface{}) events.APIGatewayV2
 /*****************************************************/
 // Validation Helper functions
 /*****************************************************/
-func validateSubscriber(request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	claims := request.RequestContext.Authorizer.JWT.Claims
+func validateSubscriber(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	authorizer := request.RequestContext.Authorizer
+	if authorizer == nil || authorizer.JWT == nil {
+		return createErrorResponse(403, "Access denied. No group information found.")
+	}
+	claims := authorizer.JWT.Claims
 	groupsClaim, ok := claims["cognito:groups"]
 	if !ok {
 		return createErrorResponse(403, "Access denied. No group information found.")
 	}
 
 	if !strings.HasPrefix(groupsClaim, "[") || !strings.HasSuffix(groupsClaim, "]") {
-		log.Println("Group claim is not in the expected format '[...]'")
+		slog.WarnContext(ctx, "Group claim is not in the expected format '[...]'")
 		return createErrorResponse(403, "Invalid group format in token.")
 	}
 	trimmedGroups := groupsClaim[1 : len(groupsClaim)-1]
@@ -110,57 +208,38 @@ func validateSubscriber(request events.APIGatewayV2HTTPRequest) (events.APIGatew
 		groupsList = []string{}
 	}
 
-	log.Printf("Raw groups claim: %s", groupsClaim)
-	log.Printf("Trimmed groups: %s", trimmedGroups)
-	log.Printf("Parsed groups list: %v", groupsList)
-
 	isSubscriber := false
 	for _, group := range groupsList {
-		log.Printf("Checking group: '%s'", group)
 		if group == "Subscriber" {
 			isSubscriber = true
 			break
 		}
 	}
-	log.Printf("isSubscriber result: %t", isSubscriber)
+	slog.DebugContext(ctx, "Checked subscriber role", "groups", groupsList, "is_subscriber", isSubscriber)
 	if !isSubscriber {
 		return createErrorResponse(403, "Access denied. Subscriber role required.")
 	}
 	return events.APIGatewayV2HTTPResponse{}, nil
 }
 
-/*****************************************************/
-// DynamoDB Query helper functions
-/*****************************************************/
-func getLastEvaluatedKey(cursor string) (
-	map[string]types.AttributeValue,
-	error,
-) {
-	var lastEvaluatedKey map[string]types.AttributeValue
-	if cursor != "" {
-		decodedCursor, err := url.QueryUnescape(cursor)
-		if err != nil {
-			log.Printf("Debug: Error decoding cursor: %v", err)
-			return nil, err
-		}
-
-		var tempMap map[string]interface{}
-		if err := json.Unmarshal([]byte(decodedCursor), &tempMap); err != nil {
-			log.Printf("Debug: Error unmarshaling cursor JSON: %v", err)
-			return nil, err
-		}
-
-		lastEvaluatedKey = make(map[string]types.AttributeValue)
-		for k, v := range tempMap {
-			if strVal, ok := v.(string); ok {
-				lastEvaluatedKey[k] = &types.AttributeValueMemberS{Value: strVal}
-			}
+// subscriberOnly runs handler for members of the Subscriber group only.
+func subscriberOnly(handler routeHandler) routeHandler {
+	return func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		if errorResp, err := validateSubscriber(ctx, request); err != nil {
+			return errorResp, err
+		} else if errorResp.StatusCode != 0 {
+			return errorResp, nil
 		}
+		return handler(ctx, request)
 	}
-	return lastEvaluatedKey, nil
 }
 
-func getNextCursor(apps []AppListing, limit int) (string, error) {
+/*****************************************************/
+// Catalog query helper functions
+/*****************************************************/
+// getNextCursor trims a page fetched with one extra item back to limit and
+// returns the cursor of the next page when the extra item was found.
+func getNextCursor(apps []AppListing, limit int) ([]AppListing, string, error) {
 	var nextCursor string
 	if len(apps) > limit {
 		apps = apps[:limit]
@@ -173,41 +252,11 @@ func getNextCursor(apps []AppListing, limit int) (string, error) {
 		}
 		nextCursorBytes, err := json.Marshal(cursorMap)
 		if err != nil {
-			log.Printf("Debug: Error creating next cursor: %v", err)
-			return "", err
+			return nil, "", err
 		}
 		nextCursor = string(nextCursorBytes)
-		log.Printf("Debug: Generated nextCursor from last returned item: %s", nextCursor)
-	}
-	return nextCursor, nil
-}
-
-func getApps(
-	ctx context.Context,
-	dynamoClient *dynamodb.Client,
-	input *dynamodb.ScanInput,
-) (
-	[]AppListing,
-	error,
-) {
-	result, err := dynamoClient.Scan(ctx, input)
-	if err != nil {
-		log.Printf("Debug: DynamoDB scan failed: %v", err)
-		return nil, err
 	}
-
-	if len(result.Items) > 0 {
-		log.Printf("Debug: First item: %+v", result.Items[0])
-	}
-
-	var apps []AppListing
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &apps)
-	if err != nil {
-		log.Printf("Debug: Error unmarshaling items: %v", err)
-		return nil, err
-	}
-
-	return apps, nil
+	return apps, nextCursor, nil
 }
 
 func getLimit(limitStr string) (int, error) {
@@ -222,150 +271,34 @@ func getLimit(limitStr string) (int, error) {
 	return limit, nil
 }
 
-type SubscriptionItem struct {
-	AppId            string `dynamodbav:"appId"`
-	UserId           string `dynamodbav:"userId"`
-	SubscriptionTime string `dynamodbav:"subscriptionTime"`
-}
-
-func getSubscribedAppIdsBasedOnUserId(ctx context.Context, dynamoClient *dynamodb.Client, userID string) ([]string, error) {
-	input := &dynamodb.ScanInput{
-		TableName:        aws.String(os.Getenv("subscription_table_name")),
-		FilterExpression: aws.String("userId = :userId"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":userId": &types.AttributeValueMemberS{Value: userID},
-		},
-	}
-	result, err := dynamoClient.Scan(ctx, input)
-	if err != nil {
-		log.Printf("Debug: Error scanning subscription table: %v", err)
-		return nil, err
-	}
-
-	var subscriptions []SubscriptionItem
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &subscriptions)
-	if err != nil {
-		log.Printf("Debug: Error unmarshaling items: %v", err)
-		return nil, err
-	}
-
-	// Extract app IDs from the subscription items
-	var appIds []string
-	for _, subscription := range subscriptions {
-		appIds = append(appIds, subscription.AppId)
-	}
-
-	log.Printf("Debug: Found %d subscribed app IDs for user %s: %v", len(appIds), userID, appIds)
-	return appIds, nil
-}
-
-/*****************************************************/
-// POST Subscription helper functions
-/*****************************************************/
-// checkSubscriptionExists checks if a subscription already exists for the given appID and userID
-func checkSubscriptionExists(ctx context.Context, dynamoClient *dynamodb.Client, tableName, appID, userID string) (bool, error) {
-	input := &dynamodb.GetItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"appId":  &types.AttributeValueMemberS{Value: appID},
-			"userId": &types.AttributeValueMemberS{Value: userID},
-		},
-	}
-	result, err := dynamoClient.GetItem(ctx, input)
-	if err != nil {
-		log.Printf("Debug: Error checking subscription existence: %v", err)
-		return false, err
-	}
-	exists := result.Item != nil
-	return exists, nil
-}
-
-func insertSubscription(ctx context.Context, dynamoClient *dynamodb.Client, tableName, appID, userID string) error {
-	subscriptionTime := time.Now().UTC().Format(time.RFC3339)
-
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item: map[string]types.AttributeValue{
-			"appId":            &types.AttributeValueMemberS{Value: appID},
-			"userId":           &types.AttributeValueMemberS{Value: userID},
-			"subscriptionTime": &types.AttributeValueMemberS{Value: subscriptionTime},
-		},
-	}
-	_, err := dynamoClient.PutItem(ctx, input)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-/*****************************************************/
-// DynamoDB query functions
-/*****************************************************/
-func getAllApps(ctx context.Context, dynamoClient *dynamodb.Client, input *dynamodb.ScanInput, limit int, cursor string) ([]AppListing, string, error) {
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	lastEvaluatedKey, err := getLastEvaluatedKey(cursor)
-	if err != nil {
-		log.Printf("Debug: Error getting last evaluated key: %v", err)
-		return nil, "", err
-	}
-
-	if lastEvaluatedKey != nil {
-		input.ExclusiveStartKey = lastEvaluatedKey
-	}
-
-	apps, err := getApps(ctx, dynamoClient, input)
-	if err != nil {
-		log.Printf("Debug: Error getting apps: %v", err)
-		return nil, "", err
-	}
-
-	nextCursor, err := getNextCursor(apps, limit)
-	if err != nil {
-		log.Printf("Debug: Error getting next cursor: %v", err)
-		return nil, "", err
-	}
-
-	return apps, nextCursor, nil
-}
-
 /*****************************************************/
 // Handler functions
 /*****************************************************/
-func handleGetAllApps(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+func (h *Handler) handleGetAllApps(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	// Parse query parameters
 	limitStr := request.QueryStringParameters["limit"]
 	cursor := request.QueryStringParameters["cursor"]
 	getSubscribed := request.QueryStringParameters["getSubscribed"] == "true"
 
-	log.Printf("Debug: Query parameters - limit: %s, cursor: %s", limitStr, cursor)
+	slog.DebugContext(ctx, "Listing apps", "limit", limitStr, "has_cursor", cursor != "", "get_subscribed", getSubscribed)
 
 	limit, err := getLimit(limitStr)
 	if err != nil {
-		log.Printf("Debug-getAllSubscribedApps: Error getting limit: %v", err)
 		return createErrorResponse(400, err.Error())
 	}
-	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Printf("Error loading AWS config: %v", err)
-		return createErrorResponse(500, "Internal server error")
-	}
-	log.Printf("Debug-getAllSubscribedApps: limit: %d", limit)
-
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	tableName := os.Getenv("app_table_name")
 
-	log.Printf("Debug-getAllSubscribedApps: getSubscribed: %t", getSubscribed)
-
-	var input *dynamodb.ScanInput
+	query := AppQuery{Limit: limit, Cursor: cursor}
 	if getSubscribed {
 		userID := request.RequestContext.Authorizer.JWT.Claims["sub"]
-		appIds, err := getSubscribedAppIdsBasedOnUserId(ctx, dynamoClient, userID)
+		appIds, err := h.services.Subscriptions.ListSubscribedAppIds(ctx, userID)
 		if err != nil {
-			log.Printf("Error getting subscribed app IDs: %v", err)
+			if isTransientError(err) {
+				return events.APIGatewayV2HTTPResponse{}, err
+			}
+			slog.ErrorContext(ctx, "Error getting subscribed app IDs", "error", err)
 			return createErrorResponse(500, "Error retrieving subscribed apps")
 		}
+		slog.DebugContext(ctx, "Found subscribed apps", "count", len(appIds))
 
 		if len(appIds) == 0 {
 			// If user has no subscriptions, return empty result
@@ -376,55 +309,78 @@ func handleGetAllApps(ctx context.Context, request events.APIGatewayV2HTTPReques
 			}
 			return createSuccessResponse(200, response), nil
 		}
-
-		// Build FilterExpression with individual parameters for each app ID
-		var filterParts []string
-		expressionAttributeValues := make(map[string]types.AttributeValue)
-		for i, appId := range appIds {
-			paramName := fmt.Sprintf(":appId%d", i)
-			filterParts = append(filterParts, fmt.Sprintf("appId = %s", paramName))
-			expressionAttributeValues[paramName] = &types.AttributeValueMemberS{Value: appId}
-		}
-		filterExpression := strings.Join(filterParts, " OR ")
-
-		input = &dynamodb.ScanInput{
-			TableName:                 aws.String(tableName),
-			FilterExpression:          aws.String(filterExpression),
-			ExpressionAttributeValues: expressionAttributeValues,
-			Limit:                     aws.Int32(int32(limit + extraToDetermineIfNextPage)),
-		}
-	} else {
-		input = &dynamodb.ScanInput{
-			TableName: aws.String(tableName),
-			// fetch one more to check if there are more pages
-			Limit: aws.Int32(int32(limit + extraToDetermineIfNextPage)),
-		}
+		query.AppIds = appIds
 	}
 
-	log.Printf("Debug-getAllSubscribedApps: input: %+v", input)
-	apps, nextCursor, err := getAllApps(ctx, dynamoClient, input, limit, cursor)
-	log.Printf("Debug-getAllSubscribedApps: apps: %+v", apps)
+	apps, nextCursor, err := h.services.Apps.ListApps(ctx, query)
 	if err != nil {
-		log.Printf("Error querying apps: %v", err)
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error querying apps", "error", err)
 		return createErrorResponse(500, "Error retrieving apps")
 	}
+	if apps == nil {
+		apps = []AppListing{}
+	}
+	if err := h.attachPublisherNames(ctx, apps); err != nil {
+		// Publisher names are decorative, so the catalog is still served without them
+		slog.WarnContext(ctx, "Error attaching publisher names", "error", err)
+	}
 
 	response := AppListResponse{
 		Apps:       apps,
 		Count:      len(apps),
 		NextCursor: nextCursor,
 	}
-	log.Printf("Debug-getAllSubscribedApps: response: %+v", response)
+	slog.DebugContext(ctx, "Listed apps", "count", response.Count, "has_next_page", response.NextCursor != "")
+	emitMetrics("GET /apps", outcomeSuccess, countMetric("CatalogApps", response.Count))
 	return createSuccessResponse(200, response), nil
 }
 
-func handleSubscribe(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+func (h *Handler) handleGetApp(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	appId := request.PathParameters["app-id"]
+	if appId == "" {
+		return createErrorResponse(400, "app-id is required in the URL path")
+	}
+
+	app, err := h.services.Apps.GetApp(ctx, appId)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting app", "error", err)
+		return createErrorResponse(500, "Error retrieving app")
+	}
+	if app == nil {
+		return createErrorResponse(404, "App not found")
+	}
+
+	changelog, err := h.services.Apps.ListAppVersions(ctx, app.PublisherId, app.AppSlug)
+	if err != nil {
+		if isTransientError(err) {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		slog.ErrorContext(ctx, "Error getting app versions", "error", err)
+		return createErrorResponse(500, "Error retrieving app versions")
+	}
+	app.Changelog = changelog
+
+	listings := []AppListing{app.AppListing}
+	if err := h.attachPublisherNames(ctx, listings); err != nil {
+		slog.WarnContext(ctx, "Error attaching publisher name", "error", err)
+	}
+	app.AppListing = listings[0]
+	return createSuccessResponse(200, AppDetailResponse{App: *app}), nil
+}
+
+func (h *Handler) handleSubscribe(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	// Parse query parameters
 	appID := request.QueryStringParameters["appID"]
 	claims := request.RequestContext.Authorizer.JWT.Claims
 	userID := claims["sub"]
 
-	log.Printf("Debug: handleSubscribe started with appID: %s, userID: %s", appID, userID)
+	slog.DebugContext(ctx, "Subscribing to app", "app_id", appID)
 
 	if appID == "" {
 		return createErrorResponse(400, "appID is required")
@@ -432,30 +388,23 @@ func handleSubscribe(ctx context.Context, request events.APIGatewayV2HTTPRequest
 	if userID == "" {
 		return createErrorResponse(400, "User ID not found in token")
 	}
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Printf("Error loading AWS config: %v", err)
-		return createErrorResponse(500, "Internal server error")
-	}
-
-	dynamoClient := dynamodb.NewFromConfig(cfg)
-	subscriptionTableName := os.Getenv("subscription_table_name")
 
-	exists, err := checkSubscriptionExists(ctx, dynamoClient, subscriptionTableName, appID, userID)
+	exists, err := h.services.Subscriptions.SubscriptionExists(ctx, appID, userID)
 	if exists {
-		log.Printf("Debug: Subscription already exists for appID: %s, userID: %s", appID, userID)
+		slog.DebugContext(ctx, "Subscription already exists", "app_id", appID)
 		return createErrorResponse(409, "You are already subscribed to this app")
 	}
 	if err != nil {
 		return createErrorResponse(500, "Error checking subscription status")
 	}
 
-	err = insertSubscription(ctx, dynamoClient, subscriptionTableName, appID, userID)
+	err = h.services.Subscriptions.Subscribe(ctx, appID, userID)
 	if err != nil {
-		log.Printf("Debug: Error inserting subscription: %v", err)
+		slog.ErrorContext(ctx, "Error inserting subscription", "error", err)
 		return createErrorResponse(500, "Error creating subscription")
 	}
 
+	emitMetrics("POST /subscribe", outcomeSuccess, countMetric("Subscriptions", 1))
 	return createSuccessResponse(200, map[string]string{
 		"message": "Successfully subscribed to app",
 	}), nil
@@ -464,28 +413,8 @@ func handleSubscribe(ctx context.Context, request events.APIGatewayV2HTTPRequest
 /*****************************************************/
 // Main handler
 /*****************************************************/
-func handleRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	if errorResp, err := validateSubscriber(request); err != nil {
-		return errorResp, err
-	} else if errorResp.StatusCode != 0 {
-		return errorResp, nil
-	}
-	method := request.RequestContext.HTTP.Method
-	path := request.RequestContext.HTTP.Path
-	rawPath := request.RawPath
-	// Handle GET requests for getting all apps
-	if method == "GET" && (strings.Contains(path, "/apps") || strings.Contains(rawPath, "/apps")) {
-		log.Printf("Debug: Routing to handleGetAllApps")
-		return handleGetAllApps(ctx, request)
-	}
-	// Handle POST requests to subscribe to an app
-	if method == "POST" && (strings.Contains(path, "/subscribe") || strings.Contains(rawPath, "/subscribe")) {
-		log.Printf("Debug: Routing to handleSubscribe")
-		return handleSubscribe(ctx, request)
-	}
-	return createErrorResponse(404, "Route not found")
-}
-
-func main() {
-	lambda.Start(handleRequest)
+// HandleRequest is the Lambda entry point for API Gateway requests.
+func (h *Handler) HandleRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	ctx = withRequestId(ctx, request.RequestContext.RequestID)
+	return h.router.dispatch(ctx, request)
 }
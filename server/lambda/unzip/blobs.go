@@ -0,0 +1,71 @@
+package unzip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+/*****************************************************/
+// Shared blobs
+/*****************************************************/
+// Models and other large binaries are stored once by content under
+// blobs/sha256/{hex} instead of in each release, so re-publishing an app with
+// the same 25MB model only writes its UI files. Each release that uses a blob
+// records a reference at blobrefs/{appSlug}/{releaseId}/{hex}; the publisher
+// deletes the references with the release and the blob once none are left.
+
+const (
+	blobsPrefix    = "blobs/sha256/"
+	blobRefsPrefix = "blobrefs/"
+	// Binaries smaller than this stay in the release, where they are cheaper
+	// to write again than to look up
+	blobMinSize = 1 << 20
+)
+
+// blobTypes are the content types of the large files worth sharing. Images,
+// fonts and media stay in the release because pages load them by relative
+// URL.
+var blobTypes = map[string]bool{
+	"application/octet-stream": true,
+	"application/wasm":         true,
+}
+
+// isSharedBlob reports whether a file is stored as a shared blob. Declared
+// models always are, whatever their size.
+func isSharedBlob(entry FileEntry, model bool) bool {
+	return model || (entry.Size >= blobMinSize && blobTypes[entry.ContentType])
+}
+
+func blobKey(sha256Hex string) string {
+	return blobsPrefix + sha256Hex
+}
+
+func blobRefKey(source upload, sha256Hex string) string {
+	return fmt.Sprintf("%s%s/%s/%s", blobRefsPrefix, source.appSlug, source.requestId, sha256Hex)
+}
+
+// storeBlob writes a file to its shared blob unless an earlier release
+// already did, and reports whether the blob was reused. The reference is
+// written before the blob is looked up, so a garbage collection that starts
+// after that keeps the blob.
+func (h *Handler) storeBlob(ctx context.Context, source upload, entry FileEntry, body []byte) (key string, reused bool, err error) {
+	key = blobKey(entry.SHA256)
+	if err := h.services.Blobs.PutObject(ctx, h.services.AppsBucket, blobRefKey(source, entry.SHA256), nil, ObjectAttributes{}); err != nil {
+		return "", false, fmt.Errorf("failed to reference blob %s: %w", key, err)
+	}
+	exists, err := h.services.Blobs.ObjectExists(ctx, h.services.AppsBucket, key)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		slog.DebugContext(ctx, "Reused stored blob", "path", entry.Path, "key", key)
+		return key, true, nil
+	}
+	// The key is the digest, so the object never changes once written
+	attributes := ObjectAttributes{ContentType: entry.ContentType, CacheControl: cacheImmutable, SHA256: entry.SHA256}
+	if err := h.services.Blobs.PutObject(ctx, h.services.AppsBucket, key, body, attributes); err != nil {
+		return "", false, fmt.Errorf("failed to upload blob %s: %w", key, err)
+	}
+	return key, false, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	unzip "miniapps-lambda-unzip"
+)
+
+func main() {
+	unzip.SetupLogging()
+
+	// Clients are created once per cold start and reused by every invocation
+	services, err := unzip.NewAWSServices(context.Background())
+	if err != nil {
+		slog.Error("Failed to create services", "error", err)
+		os.Exit(1)
+	}
+	lambda.Start(unzip.NewHandler(services).HandleRequest)
+}
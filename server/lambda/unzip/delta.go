@@ -0,0 +1,85 @@
+package unzip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*****************************************************/
+// Delta uploads
+/*****************************************************/
+// A delta upload sends only the files the apps bucket does not hold yet. The
+// publisher lists every file of the bundle in the publish declaration with
+// the key to read it from: the client's upload of it under
+// uploads/.../{requestId}/{sha256}, the live release that already serves it,
+// or its shared blob. Once the client has uploaded its files the publisher
+// writes uploads/.../{requestId}.complete, whose notification starts the
+// extraction.
+
+const deltaCompleteSuffix = ".complete"
+
+// DeltaFile is one file of a delta upload. Source is the key its content is
+// read from.
+type DeltaFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Source string `json:"source"`
+}
+
+// deltaUploadPrefix is where the client uploads the files of a delta upload,
+// each named after its SHA-256.
+func deltaUploadPrefix(source upload) string {
+	return strings.TrimSuffix(source.key, deltaCompleteSuffix) + "/"
+}
+
+// deltaFiles lists the files of a delta upload. Each is checked against the
+// SHA-256 the client declared, since the client chose what to upload and a
+// reused file can have been replaced since the declaration was made.
+func (h *Handler) deltaFiles(ctx context.Context, source upload, declaration PublishDeclaration) ([]bundleFile, error) {
+	if len(declaration.Files) == 0 {
+		return nil, fmt.Errorf("%w: delta upload %s has no file list", errInvalidBundle, source.requestId)
+	}
+	files := make([]bundleFile, 0, len(declaration.Files))
+	for _, file := range declaration.Files {
+		files = append(files, bundleFile{name: file.Path, read: func() ([]byte, error) {
+			bucket := h.services.AppsBucket
+			if strings.HasPrefix(file.Source, deltaUploadPrefix(source)) {
+				bucket = source.bucket
+			}
+			body, err := h.services.Blobs.GetObject(ctx, bucket, file.Source)
+			if errors.Is(err, ErrObjectNotFound) {
+				// A newer promotion removed the reused file; the client publishes again
+				return nil, fmt.Errorf("%w: %s of delta upload is no longer at %s", errInvalidBundle, file.Path, file.Source)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s of delta upload: %w", file.Path, err)
+			}
+			if digest := sha256.Sum256(body); hex.EncodeToString(digest[:]) != file.SHA256 {
+				return nil, fmt.Errorf("%w: %s of delta upload does not match its declared SHA-256", errInvalidBundle, file.Path)
+			}
+			return body, nil
+		}})
+	}
+	return files, nil
+}
+
+// deleteDeltaUploads removes the files the client uploaded. Their content is
+// in the release now, and the archived declaration records what they were.
+func (h *Handler) deleteDeltaUploads(ctx context.Context, source upload, declaration PublishDeclaration) error {
+	deleted := make(map[string]bool)
+	for _, file := range declaration.Files {
+		if !strings.HasPrefix(file.Source, deltaUploadPrefix(source)) || deleted[file.Source] {
+			continue
+		}
+		if err := h.services.Blobs.DeleteObject(ctx, source.bucket, file.Source); err != nil {
+			return fmt.Errorf("failed to delete uploaded file %s: %w", file.Source, err)
+		}
+		deleted[file.Source] = true
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package unzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+/*****************************************************/
+// Icons
+/*****************************************************/
+// Unzip decodes the PNG, JPEG and WebP icons of the manifest and rejects an
+// upload whose icon is not the image its type and sizes declare. Installing
+// the app needs a 192px and a 512px icon and Android launchers a maskable
+// one, so those missing are generated from the largest icon into the
+// release, under generatedIconDir, and listed with its files. SVG and other
+// icons are served as they are.
+
+var errInvalidIcon = errors.New("invalid icon")
+
+const (
+	generatedIconDir = "_generated/"
+	// Icons are decoded in full, so larger ones are rejected before that
+	maxIconBytes  = 5 * 1024 * 1024
+	maxIconPixels = 4096 * 4096
+	// Maskable icons are cropped to a circle of 40% radius, so the source is
+	// scaled into the middle 80% of the canvas
+	maskablePadding = 0.1
+)
+
+// IconInfo describes one raster icon of the release. Path is relative to the
+// release prefix.
+type IconInfo struct {
+	Path      string `json:"path"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Type      string `json:"type"`
+	Purpose   string `json:"purpose"`
+	Generated bool   `json:"generated,omitempty"`
+}
+
+// generatedIcon is an icon unzip made for a standard size the app lacks.
+type generatedIcon struct {
+	info IconInfo
+	body []byte
+}
+
+// Standard icons, by purpose and size, and the name each is generated as
+var standardIcons = []struct {
+	purpose string
+	size    int
+	name    string
+}{
+	{purpose: "any", size: 192, name: "icon-192x192.png"},
+	{purpose: "any", size: 512, name: "icon-512x512.png"},
+	{purpose: "maskable", size: 512, name: "icon-maskable-512x512.png"},
+}
+
+var iconFormats = map[string]string{"image/png": "png", "image/jpeg": "jpeg", "image/webp": "webp"}
+
+// inspectIcons checks the raster icons of a normalized manifest against their
+// files and generates the standard icons the manifest does not provide.
+// Icons the bundle lacks are skipped; checkManifest rejects them for
+// declared manifests.
+func inspectIcons(manifest *Manifest, files map[string]bundleFile) ([]IconInfo, []generatedIcon, error) {
+	if manifest == nil {
+		return nil, nil, nil
+	}
+	var icons []IconInfo
+	var source image.Image
+	sourcePurpose := ""
+	for _, icon := range manifest.Icons {
+		name, ok := manifestFilePath(icon.Src)
+		file, inBundle := files[name]
+		format, raster := iconFormats[icon.Type]
+		if !ok || !inBundle || !raster {
+			continue
+		}
+		body, err := file.read()
+		if err != nil {
+			return nil, nil, err
+		}
+		img, err := decodeIcon(name, format, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		bounds := img.Bounds()
+		for _, size := range strings.Fields(icon.Sizes) {
+			if size != "any" && size != fmt.Sprintf("%dx%d", bounds.Dx(), bounds.Dy()) {
+				return nil, nil, fmt.Errorf("%w: %s is %dx%d, declared %s", errInvalidIcon, name, bounds.Dx(), bounds.Dy(), icon.Sizes)
+			}
+		}
+		icons = append(icons, IconInfo{Path: name, Width: bounds.Dx(), Height: bounds.Dy(), Type: icon.Type, Purpose: icon.Purpose})
+
+		// Generate from the largest icon, preferring those meant to be shown
+		// as they are over maskable ones
+		purpose := iconPurpose(icon.Purpose)
+		if source == nil || (purpose == "any" && sourcePurpose != "any") ||
+			(purpose == sourcePurpose && iconArea(bounds) > iconArea(source.Bounds())) {
+			source, sourcePurpose = img, purpose
+		}
+	}
+	if source == nil {
+		return icons, nil, nil
+	}
+
+	var generated []generatedIcon
+	for _, standard := range standardIcons {
+		if hasIcon(icons, standard.purpose, standard.size) {
+			continue
+		}
+		name := generatedIconDir + standard.name
+		if _, taken := files[name]; taken {
+			return nil, nil, fmt.Errorf("%w: %s is reserved for generated icons", errInvalidIcon, name)
+		}
+		var canvas *image.RGBA
+		if standard.purpose == "maskable" {
+			canvas = maskableIcon(source, standard.size, iconBackground(manifest.BackgroundColor))
+		} else {
+			canvas = resizeIcon(source, standard.size)
+		}
+		var body bytes.Buffer
+		if err := png.Encode(&body, canvas); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		info := IconInfo{Path: name, Width: standard.size, Height: standard.size, Type: "image/png", Purpose: standard.purpose, Generated: true}
+		icons = append(icons, info)
+		generated = append(generated, generatedIcon{info: info, body: body.Bytes()})
+	}
+	return icons, generated, nil
+}
+
+// decodeIcon decodes an icon after checking its dimensions, so a small file
+// cannot claim a huge image.
+func decodeIcon(name, format string, body []byte) (image.Image, error) {
+	if len(body) > maxIconBytes {
+		return nil, fmt.Errorf("%w: %s is larger than %dMB", errInvalidIcon, name, maxIconBytes/(1024*1024))
+	}
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidIcon, name, err)
+	}
+	if decoded != format {
+		return nil, fmt.Errorf("%w: %s is a %s image, declared %s", errInvalidIcon, name, decoded, format)
+	}
+	if config.Width*config.Height > maxIconPixels {
+		return nil, fmt.Errorf("%w: %s is %dx%d, larger than 4096x4096", errInvalidIcon, name, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidIcon, name, err)
+	}
+	return img, nil
+}
+
+// iconPurpose reduces the purposes of an icon to the one it is generated
+// from: "any" if it can be shown as it is.
+func iconPurpose(purposes string) string {
+	fields := strings.Fields(purposes)
+	for _, purpose := range fields {
+		if purpose == "any" {
+			return purpose
+		}
+	}
+	if len(fields) == 0 {
+		return "any"
+	}
+	return fields[0]
+}
+
+func iconArea(bounds image.Rectangle) int {
+	return bounds.Dx() * bounds.Dy()
+}
+
+func hasIcon(icons []IconInfo, purpose string, size int) bool {
+	for _, icon := range icons {
+		if icon.Width != size || icon.Height != size {
+			continue
+		}
+		for _, p := range strings.Fields(icon.Purpose) {
+			if p == purpose {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resizeIcon scales an icon to a size x size canvas, keeping its aspect
+// ratio on a transparent background.
+func resizeIcon(source image.Image, size int) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+	xdraw.CatmullRom.Scale(canvas, fitRect(source.Bounds(), canvas.Bounds()), source, source.Bounds(), xdraw.Over, nil)
+	return canvas
+}
+
+// maskableIcon places an icon inside the safe zone of a maskable icon filled
+// with the app's background color.
+func maskableIcon(source image.Image, size int, background color.Color) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	inset := int(float64(size) * maskablePadding)
+	xdraw.CatmullRom.Scale(canvas, fitRect(source.Bounds(), canvas.Bounds().Inset(inset)), source, source.Bounds(), xdraw.Over, nil)
+	return canvas
+}
+
+// fitRect is the largest rectangle with the proportions of src centered in
+// dst.
+func fitRect(src, dst image.Rectangle) image.Rectangle {
+	width, height := dst.Dx(), dst.Dy()
+	if src.Dx()*dst.Dy() > src.Dy()*dst.Dx() {
+		height = src.Dy() * dst.Dx() / src.Dx()
+	} else {
+		width = src.Dx() * dst.Dy() / src.Dy()
+	}
+	origin := dst.Min.Add(image.Pt((dst.Dx()-width)/2, (dst.Dy()-height)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+}
+
+// iconBackground is the manifest's background color if it is a hex color,
+// and white otherwise.
+func iconBackground(background string) color.Color {
+	hex := strings.TrimPrefix(background, "#")
+	if !strings.HasPrefix(background, "#") || (len(hex) != 3 && len(hex) != 6) {
+		return color.White
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.White
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 0xff}
+}
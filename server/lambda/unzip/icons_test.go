@@ -0,0 +1,164 @@
+package unzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// testIcon encodes a red image of the given size as a PNG or JPEG.
+func testIcon(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func iconBundle(files map[string][]byte) map[string]bundleFile {
+	bundle := make(map[string]bundleFile, len(files))
+	for name, body := range files {
+		bundle[name] = bundleFile{name: name, read: func() ([]byte, error) { return body, nil }}
+	}
+	return bundle
+}
+
+func TestIconsAreCheckedAgainstTheirDeclaredSizes(t *testing.T) {
+	tests := map[string]struct {
+		icon Icon
+		body []byte
+		want string
+	}{
+		"matching":        {icon: Icon{Src: "icon.png", Sizes: "96x96"}, body: testIcon(t, "png", 96, 96)},
+		"wrong size":      {icon: Icon{Src: "icon.png", Sizes: "192x192"}, body: testIcon(t, "png", 96, 96), want: "is 96x96, declared 192x192"},
+		"wrong type":      {icon: Icon{Src: "icon.png", Sizes: "96x96"}, body: testIcon(t, "jpeg", 96, 96), want: "is a jpeg image, declared png"},
+		"not an image":    {icon: Icon{Src: "icon.png", Sizes: "96x96"}, body: []byte("<svg/>"), want: "icon.png"},
+		"jpeg":            {icon: Icon{Src: "icon.jpg"}, body: testIcon(t, "jpeg", 64, 48)},
+		"vector":          {icon: Icon{Src: "icon.svg", Sizes: "any"}, body: []byte("<svg/>")},
+		"too many pixels": {icon: Icon{Src: "icon.png"}, body: testIcon(t, "png", 4097, 4096), want: "larger than 4096x4096"},
+	}
+	for name, tt := range tests {
+		manifest := normalizeManifest(Manifest{Icons: []Icon{tt.icon}})
+		_, _, err := inspectIcons(&manifest, iconBundle(map[string][]byte{manifest.Icons[0].Src: tt.body}))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidIcon) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want errInvalidIcon with %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestMissingStandardIconsAreGenerated(t *testing.T) {
+	manifest := normalizeManifest(Manifest{
+		BackgroundColor: "#336699",
+		Icons: []Icon{
+			{Src: "small.png", Sizes: "96x96"},
+			{Src: "icon-512.png", Sizes: "512x512"},
+			{Src: "wide.png", Sizes: "1024x256", Purpose: "maskable"},
+		},
+	})
+	files := iconBundle(map[string][]byte{
+		"small.png":    testIcon(t, "png", 96, 96),
+		"icon-512.png": testIcon(t, "png", 512, 512),
+		"wide.png":     testIcon(t, "png", 1024, 256),
+	})
+	icons, generated, err := inspectIcons(&manifest, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The maskable icon is not square, so it does not count as a 512px one
+	if len(icons) != 5 || len(generated) != 2 {
+		t.Fatalf("icons = %+v, generated %d, want the 192px and the maskable icon generated", icons, len(generated))
+	}
+	want := IconInfo{Path: "_generated/icon-192x192.png", Width: 192, Height: 192, Type: "image/png", Purpose: "any", Generated: true}
+	if generated[0].info != want || icons[3] != want {
+		t.Errorf("generated %+v, want %+v", generated[0].info, want)
+	}
+	img, err := png.Decode(bytes.NewReader(generated[0].body))
+	if err != nil || img.Bounds().Dx() != 192 {
+		t.Fatalf("generated icon is not a 192px PNG: %v", err)
+	}
+	// Scaled from the 512px icon: the wider maskable one would leave the
+	// top transparent
+	if r, _, _, a := img.At(96, 20).RGBA(); r>>8 != 0xff || a>>8 != 0xff {
+		t.Errorf("generated icon is not the 512px icon scaled")
+	}
+
+	// The maskable icon is made on the background color
+	if generated[1].info.Path != "_generated/icon-maskable-512x512.png" {
+		t.Fatalf("generated %+v, want the maskable icon", generated[1].info)
+	}
+	maskable, err := png.Decode(bytes.NewReader(generated[1].body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.RGBAModel.Convert(maskable.At(10, 10)).(color.RGBA); got != (color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff}) {
+		t.Errorf("maskable icon padding is %v, want the background color", got)
+	}
+	if r, g, _, _ := maskable.At(256, 256).RGBA(); r>>8 != 0xff || g != 0 {
+		t.Errorf("maskable icon does not hold the source icon in its middle")
+	}
+}
+
+func TestGeneratedIconsAreReleasedWithTheApp(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+
+	manifest := `{"name":"Shape","short_name":"Shape","start_url":"index.html","display":"standalone","icons":[{"src":"icon.png","sizes":"192x192"}]}`
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html></html>"},
+		{"manifest.json", manifest},
+		{"icon.png", string(testIcon(t, "png", 192, 192))},
+	})
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	metadata := queue.Messages()[0]
+	if len(metadata.Icons) != 3 || metadata.Icons[0].Path != "icon.png" || !metadata.Icons[1].Generated || !metadata.Icons[2].Generated {
+		t.Fatalf("icons = %+v, want icon.png and the generated 512px and maskable icons", metadata.Icons)
+	}
+	for _, icon := range metadata.Icons[1:] {
+		key := releasePrefix(source) + icon.Path
+		attributes, ok := blobs.Attributes(testBucket, key)
+		if !ok || attributes.ContentType != "image/png" {
+			t.Errorf("%s was not stored as a PNG: %+v", key, attributes)
+		}
+		listed := false
+		for _, file := range metadata.Files {
+			listed = listed || file.Path == icon.Path
+		}
+		if !listed {
+			t.Errorf("%s is not in the content manifest", icon.Path)
+		}
+	}
+	if body, _ := json.Marshal(metadata); !strings.Contains(string(body), `"generated":true`) {
+		t.Errorf("metadata message does not mark generated icons: %s", body)
+	}
+}
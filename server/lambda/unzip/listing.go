@@ -0,0 +1,93 @@
+package unzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+)
+
+var errInvalidScreenshot = errors.New("invalid screenshot")
+
+const (
+	maxScreenshotBytes = 8 * 1024 * 1024
+	// The range browsers show in their richer install dialogs
+	minScreenshotSide  = 320
+	maxScreenshotSide  = 3840
+	maxScreenshotRatio = 2.3
+)
+
+// StoreListing is the app's store page from the publish request. Unzip only
+// measures its screenshots and passes the rest on to ingest, so it matches
+// the publisher's StoreListing.
+type StoreListing struct {
+	Description   string       `json:"description,omitempty"`
+	PrivacyPolicy string       `json:"privacy_policy,omitempty"`
+	InputTypes    []string     `json:"input_types,omitempty"`
+	ModelCard     *ModelCard   `json:"model_card,omitempty"`
+	Screenshots   []Screenshot `json:"screenshots,omitempty"`
+}
+
+type ModelCard struct {
+	IntendedUse  string `json:"intended_use,omitempty"`
+	TrainingData string `json:"training_data,omitempty"`
+	Limitations  string `json:"limitations,omitempty"`
+	License      string `json:"license,omitempty"`
+}
+
+// Screenshot is an image of the bundle. Width, Height and Type are read from
+// the image.
+type Screenshot struct {
+	Path       string `json:"path"`
+	Label      string `json:"label,omitempty"`
+	FormFactor string `json:"form_factor,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Type       string `json:"type,omitempty"`
+}
+
+var screenshotFormats = map[string]string{".png": "png", ".jpg": "jpeg", ".jpeg": "jpeg", ".webp": "webp"}
+
+// inspectScreenshots reads the dimensions and type of each screenshot of the
+// listing from its image header, and rejects screenshots that are missing,
+// not the image their extension says, or outside the sizes browsers show.
+func inspectScreenshots(listing *StoreListing, files map[string]bundleFile) error {
+	if listing == nil {
+		return nil
+	}
+	for i := range listing.Screenshots {
+		screenshot := &listing.Screenshots[i]
+		file, ok := files[screenshot.Path]
+		if !ok {
+			return fmt.Errorf("%w: %s is not in the bundle", errInvalidScreenshot, screenshot.Path)
+		}
+		body, err := file.read()
+		if err != nil {
+			return err
+		}
+		if len(body) > maxScreenshotBytes {
+			return fmt.Errorf("%w: %s is larger than %dMB", errInvalidScreenshot, screenshot.Path, maxScreenshotBytes/(1024*1024))
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidScreenshot, screenshot.Path, err)
+		}
+		if want := screenshotFormats[strings.ToLower(path.Ext(screenshot.Path))]; format != want {
+			return fmt.Errorf("%w: %s is a %s image", errInvalidScreenshot, screenshot.Path, format)
+		}
+		short, long := min(config.Width, config.Height), max(config.Width, config.Height)
+		if short < minScreenshotSide || long > maxScreenshotSide || float64(long) > maxScreenshotRatio*float64(short) {
+			return fmt.Errorf("%w: %s is %dx%d; sides must be %d to %dpx, the longer at most %.1f times the shorter",
+				errInvalidScreenshot, screenshot.Path, config.Width, config.Height, minScreenshotSide, maxScreenshotSide, maxScreenshotRatio)
+		}
+		if (screenshot.FormFactor == "wide" && config.Width < config.Height) || (screenshot.FormFactor == "narrow" && config.Width > config.Height) {
+			return fmt.Errorf("%w: %s is %dx%d, which is not %s", errInvalidScreenshot, screenshot.Path, config.Width, config.Height, screenshot.FormFactor)
+		}
+		screenshot.Width = config.Width
+		screenshot.Height = config.Height
+		screenshot.Type = "image/" + format
+	}
+	return nil
+}
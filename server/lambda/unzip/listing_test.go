@@ -0,0 +1,78 @@
+package unzip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScreenshotsAreMeasured(t *testing.T) {
+	tests := map[string]struct {
+		screenshot Screenshot
+		body       []byte
+		missing    bool
+		want       string
+	}{
+		"narrow":       {screenshot: Screenshot{Path: "home.png", FormFactor: "narrow"}, body: testIcon(t, "png", 540, 1080)},
+		"jpeg":         {screenshot: Screenshot{Path: "home.jpg"}, body: testIcon(t, "jpeg", 1280, 720)},
+		"wrong format": {screenshot: Screenshot{Path: "home.png"}, body: testIcon(t, "jpeg", 540, 1080), want: "is a jpeg image"},
+		"not an image": {screenshot: Screenshot{Path: "home.png"}, body: []byte("<html>"), want: "home.png"},
+		"too small":    {screenshot: Screenshot{Path: "home.png"}, body: testIcon(t, "png", 200, 400), want: "sides must be 320 to 3840px"},
+		"too long":     {screenshot: Screenshot{Path: "home.png"}, body: testIcon(t, "png", 320, 800), want: "at most 2.3 times"},
+		"not wide":     {screenshot: Screenshot{Path: "home.png", FormFactor: "wide"}, body: testIcon(t, "png", 540, 1080), want: "which is not wide"},
+		"missing":      {screenshot: Screenshot{Path: "home.png"}, missing: true, want: "not in the bundle"},
+	}
+	for name, tt := range tests {
+		listing := &StoreListing{Screenshots: []Screenshot{tt.screenshot}}
+		files := iconBundle(map[string][]byte{tt.screenshot.Path: tt.body})
+		if tt.missing {
+			files = iconBundle(nil)
+		}
+		err := inspectScreenshots(listing, files)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidScreenshot) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want errInvalidScreenshot with %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestListingIsPassedOnWithMeasuredScreenshots(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html></html>"},
+		{"screens/home.png", string(testIcon(t, "png", 540, 1080))},
+	})
+	declaration, _ := json.Marshal(PublishDeclaration{
+		VersionNotes: "First release",
+		Listing: &StoreListing{
+			Description: "Draw a shape",
+			InputTypes:  []string{"camera"},
+			ModelCard:   &ModelCard{License: "MIT"},
+			Screenshots: []Screenshot{{Path: "screens/home.png", Label: "Home"}},
+		},
+	})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	metadata := queue.Messages()[0]
+	if metadata.VersionNotes != "First release" || metadata.Listing == nil || metadata.Listing.Description != "Draw a shape" || metadata.Listing.ModelCard.License != "MIT" {
+		t.Fatalf("listing was not passed on: %+v", metadata)
+	}
+	want := Screenshot{Path: "screens/home.png", Label: "Home", Width: 540, Height: 1080, Type: "image/png"}
+	if len(metadata.Listing.Screenshots) != 1 || metadata.Listing.Screenshots[0] != want {
+		t.Errorf("screenshots = %+v, want %+v", metadata.Listing.Screenshots, want)
+	}
+}
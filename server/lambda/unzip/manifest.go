@@ -0,0 +1,183 @@
+package unzip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+)
+
+var errInvalidManifest = errors.New("invalid manifest")
+
+// Manifest holds the members of a W3C web app manifest the publisher
+// validates. It matches the publisher's Manifest.
+type Manifest struct {
+	Id              string   `json:"id,omitempty"`
+	Name            string   `json:"name"`
+	ShortName       string   `json:"short_name"`
+	Description     string   `json:"description,omitempty"`
+	StartUrl        string   `json:"start_url"`
+	Scope           string   `json:"scope,omitempty"`
+	Display         string   `json:"display"`
+	Orientation     string   `json:"orientation,omitempty"`
+	ThemeColor      string   `json:"theme_color,omitempty"`
+	BackgroundColor string   `json:"background_color,omitempty"`
+	Lang            string   `json:"lang,omitempty"`
+	Dir             string   `json:"dir,omitempty"`
+	Categories      []string `json:"categories,omitempty"`
+	Icons           []Icon   `json:"icons"`
+}
+
+type Icon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// checkManifest normalizes the bundle's manifest.json. When the publish
+// request declared a manifest, the bundle must carry the same one, with every
+// icon among its files, since the publisher only validated the declared copy.
+// Uploads without a declared manifest keep theirs if it parses.
+func checkManifest(declared *Manifest, content []byte, found bool, files map[string]bool) (*Manifest, error) {
+	if declared == nil {
+		var manifest Manifest
+		if !found || json.Unmarshal(content, &manifest) != nil {
+			return nil, nil
+		}
+		manifest = normalizeManifest(manifest)
+		return &manifest, nil
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: the bundle has no manifest.json", errInvalidManifest)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: manifest.json: %v", errInvalidManifest, err)
+	}
+	manifest = normalizeManifest(manifest)
+	if !reflect.DeepEqual(manifest, *declared) {
+		return nil, fmt.Errorf("%w: manifest.json differs from the manifest of the publish request", errInvalidManifest)
+	}
+	for _, icon := range manifest.Icons {
+		if name, ok := manifestFilePath(icon.Src); !ok || !files[name] {
+			return nil, fmt.Errorf("%w: icon %s is not in the bundle", errInvalidManifest, icon.Src)
+		}
+	}
+	return &manifest, nil
+}
+
+// Image types by extension, for icons that do not declare their type
+var iconTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".ico":  "image/x-icon",
+}
+
+// normalizeManifest is the publisher's normalizeManifest: it trims every
+// member, lowercases the keywords, colors and categories, cleans relative
+// URLs and fills in the defaults of scope, id and icon purpose.
+func normalizeManifest(manifest Manifest) Manifest {
+	normalized := Manifest{
+		Name:            strings.TrimSpace(manifest.Name),
+		ShortName:       strings.TrimSpace(manifest.ShortName),
+		Description:     strings.TrimSpace(manifest.Description),
+		StartUrl:        normalizeManifestUrl(manifest.StartUrl),
+		Scope:           normalizeManifestUrl(manifest.Scope),
+		Id:              normalizeManifestUrl(manifest.Id),
+		Display:         strings.ToLower(strings.TrimSpace(manifest.Display)),
+		Orientation:     strings.ToLower(strings.TrimSpace(manifest.Orientation)),
+		ThemeColor:      strings.ToLower(strings.TrimSpace(manifest.ThemeColor)),
+		BackgroundColor: strings.ToLower(strings.TrimSpace(manifest.BackgroundColor)),
+		Lang:            strings.TrimSpace(manifest.Lang),
+		Dir:             strings.ToLower(strings.TrimSpace(manifest.Dir)),
+	}
+	if normalized.Scope == "" {
+		normalized.Scope = defaultScope(normalized.StartUrl)
+	}
+	if normalized.Id == "" {
+		normalized.Id = normalized.StartUrl
+	}
+	for _, category := range manifest.Categories {
+		if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+			normalized.Categories = append(normalized.Categories, category)
+		}
+	}
+	if manifest.Icons != nil {
+		normalized.Icons = make([]Icon, 0, len(manifest.Icons))
+	}
+	for _, icon := range manifest.Icons {
+		icon = Icon{
+			Src:     normalizeManifestUrl(icon.Src),
+			Sizes:   strings.Join(strings.Fields(strings.ToLower(icon.Sizes)), " "),
+			Type:    strings.ToLower(strings.TrimSpace(icon.Type)),
+			Purpose: strings.Join(strings.Fields(strings.ToLower(icon.Purpose)), " "),
+		}
+		if icon.Type == "" {
+			icon.Type = iconTypes[strings.ToLower(path.Ext(icon.Src))]
+		}
+		if icon.Purpose == "" {
+			icon.Purpose = "any"
+		}
+		normalized.Icons = append(normalized.Icons, icon)
+	}
+	return normalized
+}
+
+// normalizeManifestUrl cleans the path of a relative URL, keeping a trailing
+// slash, and leaves absolute URLs and paths as they are.
+func normalizeManifestUrl(raw string) string {
+	raw = strings.TrimSpace(raw)
+	ref, err := url.Parse(raw)
+	if raw == "" || err != nil || ref.IsAbs() || ref.Host != "" || ref.Path == "" || strings.HasPrefix(ref.Path, "/") {
+		return raw
+	}
+	cleaned := path.Clean(ref.Path)
+	if cleaned == "." {
+		cleaned = "./"
+	} else if strings.HasSuffix(ref.Path, "/") {
+		cleaned += "/"
+	}
+	ref.Path = cleaned
+	return ref.String()
+}
+
+// defaultScope is the directory of the start URL.
+func defaultScope(startUrl string) string {
+	ref, err := url.Parse(startUrl)
+	if startUrl == "" || err != nil || ref.IsAbs() || ref.Host != "" {
+		return ""
+	}
+	dir := path.Dir(ref.Path)
+	if strings.HasSuffix(ref.Path, "/") {
+		dir = ref.Path
+	}
+	if dir == "." {
+		return "./"
+	}
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+// manifestFilePath returns the bundle file a relative manifest URL points at.
+// The manifest is served from /app/{slug}/releases/{releaseId}/, so absolute
+// and root-absolute URLs, and relative ones that climb out of the release,
+// name no file of the bundle.
+func manifestFilePath(raw string) (string, bool) {
+	ref, err := url.Parse(raw)
+	if err != nil || ref.Scheme != "" || ref.Host != "" || ref.RawQuery != "" || strings.HasPrefix(ref.Path, "/") {
+		return "", false
+	}
+	name := path.Clean(ref.Path)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,100 @@
+package unzip
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*****************************************************/
+// In-memory services
+/*****************************************************/
+// The in-memory services stand in for S3 and SQS in tests.
+
+// NewMemoryServices returns services backed by fresh in-memory stores.
+func NewMemoryServices(appsBucket string) Services {
+	return Services{
+		Blobs:      NewMemoryBlobStore(),
+		Metadata:   NewMemoryMetadataQueue(),
+		AppsBucket: appsBucket,
+	}
+}
+
+// MemoryBlobStore keeps objects keyed by bucket and key.
+type MemoryBlobStore struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	body       []byte
+	attributes ObjectAttributes
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{objects: make(map[string]memoryObject)}
+}
+
+func (s *MemoryBlobStore) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucket, ErrObjectNotFound)
+	}
+	return append([]byte(nil), object.body...), nil
+}
+
+func (s *MemoryBlobStore) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[bucket+"/"+key]
+	return ok, nil
+}
+
+func (s *MemoryBlobStore) PutObject(ctx context.Context, bucket, key string, body []byte, attributes ObjectAttributes) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = memoryObject{body: append([]byte(nil), body...), attributes: attributes}
+	return nil
+}
+
+func (s *MemoryBlobStore) DeleteObject(ctx context.Context, bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+key)
+	return nil
+}
+
+// Attributes returns the attributes an object was stored with and whether
+// the object exists.
+func (s *MemoryBlobStore) Attributes(bucket, key string) (ObjectAttributes, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[bucket+"/"+key]
+	return object.attributes, ok
+}
+
+// MemoryMetadataQueue collects the messages sent to it.
+type MemoryMetadataQueue struct {
+	mu       sync.Mutex
+	messages []AppMetadataMessage
+}
+
+func NewMemoryMetadataQueue() *MemoryMetadataQueue {
+	return &MemoryMetadataQueue{}
+}
+
+func (q *MemoryMetadataQueue) SendAppMetadata(ctx context.Context, metadata AppMetadataMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, metadata)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (q *MemoryMetadataQueue) Messages() []AppMetadataMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]AppMetadataMessage(nil), q.messages...)
+}
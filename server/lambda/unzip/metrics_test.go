@@ -0,0 +1,76 @@
+package unzip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := metricsOutput
+	metricsOutput = &buf
+	t.Cleanup(func() { metricsOutput = previous })
+	return &buf
+}
+
+func decodeMetricLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("metric line is not JSON: %v: %s", err, line)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func TestRecordExtractionMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantOutcome string
+	}{
+		{name: "success", wantOutcome: outcomeSuccess},
+		{name: "failure", err: errors.New("failed to upload unzipped file"), wantOutcome: outcomeServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureMetrics(t)
+			stats := extractionStats{archiveBytes: 4096, extractedFiles: 3, extractedBytes: 9000}
+
+			recordExtractionMetrics(stats, 250*time.Millisecond, tt.err)
+
+			lines := decodeMetricLines(t, buf)
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 metric line, got %d", len(lines))
+			}
+			line := lines[0]
+			if line["Service"] != "unzip" || line["Route"] != "unzip" || line["Outcome"] != tt.wantOutcome {
+				t.Errorf("unexpected dimensions: %v", line)
+			}
+			want := map[string]float64{
+				"Extractions":        1,
+				"ExtractionDuration": 250,
+				"ArchiveBytes":       4096,
+				"ExtractedFiles":     3,
+				"ExtractedBytes":     9000,
+			}
+			for name, value := range want {
+				if line[name] != value {
+					t.Errorf("%s = %v, want %v", name, line[name], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package unzip
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*****************************************************/
+// Content types and caching
+/*****************************************************/
+// The extracted files are served by CloudFront with the content type they are
+// stored with, so it has to be right for the browser: WebAssembly streaming
+// compilation requires application/wasm, module scripts require a JavaScript
+// type and fonts are refused cross-origin without a font type.
+
+// mimeTypes maps lowercase extensions to content types. The table is fixed
+// rather than taken from mime.TypeByExtension, whose answers depend on the
+// system the function runs on.
+var mimeTypes = map[string]string{
+	// Documents and scripts
+	".html":        "text/html",
+	".htm":         "text/html",
+	".css":         "text/css",
+	".js":          "application/javascript",
+	".mjs":         "application/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".txt":         "text/plain",
+	".xml":         "application/xml",
+	".wasm":        "application/wasm",
+
+	// Images
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".svg":  "image/svg+xml",
+	".ico":  "image/x-icon",
+
+	// Fonts
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+
+	// Media
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+
+	// Models. Neither ONNX nor the ONNX Runtime format has a registered type.
+	".onnx": "application/octet-stream",
+	".ort":  "application/octet-stream",
+}
+
+// textTypes are the non-text/* types that carry text and so get a charset.
+var textTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+}
+
+// getMimeType returns the content type to store a file with. Files without a
+// known extension are sniffed. Text types are declared UTF-8, which is what
+// browsers would otherwise have to guess.
+func getMimeType(filename string, body []byte) string {
+	contentType, ok := mimeTypes[strings.ToLower(path.Ext(filename))]
+	if !ok {
+		// Reports text with its charset already, and anything it cannot
+		// identify as application/octet-stream
+		return http.DetectContentType(body)
+	}
+	if strings.HasPrefix(contentType, "text/") || textTypes[contentType] {
+		contentType += "; charset=utf-8"
+	}
+	return contentType
+}
+
+// Cache-Control classes for extracted files. Each release has its own prefix,
+// but the entry points are what a browser revalidates to find an update, so
+// they are never served from cache unchecked.
+const (
+	cacheRevalidate = "no-cache"
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheDefault    = "public, max-age=3600"
+)
+
+// hashedNamePattern matches build output named after its content, like
+// index-4f9a1c2e.js or app.D2xa9Qk7.css: a segment of at least 8 letters,
+// digits or underscores, with a digit in it, right before the extension.
+var hashedNamePattern = regexp.MustCompile(`[.-]([A-Za-z0-9_]*[0-9][A-Za-z0-9_]*)\.[A-Za-z0-9]+$`)
+
+// cacheControlFor picks the Cache-Control of a file from its name.
+func cacheControlFor(filename string) string {
+	base := strings.ToLower(path.Base(filename))
+	switch base {
+	case "sw.js", "service-worker.js", "manifest.json":
+		return cacheRevalidate
+	}
+	switch path.Ext(base) {
+	case ".html", ".htm", ".webmanifest":
+		return cacheRevalidate
+	case ".onnx", ".ort":
+		// Models are the largest files and are only replaced with a release
+		return cacheImmutable
+	}
+	if match := hashedNamePattern.FindStringSubmatch(path.Base(filename)); match != nil && len(match[1]) >= 8 {
+		return cacheImmutable
+	}
+	return cacheDefault
+}
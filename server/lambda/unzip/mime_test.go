@@ -0,0 +1,55 @@
+package unzip
+
+import "testing"
+
+func TestGetMimeType(t *testing.T) {
+	tests := []struct {
+		filename string
+		body     string
+		want     string
+	}{
+		{"index.html", "", "text/html; charset=utf-8"},
+		{"app.js", "", "application/javascript; charset=utf-8"},
+		{"lib/module.MJS", "", "application/javascript; charset=utf-8"},
+		{"app.js.map", "", "application/json; charset=utf-8"},
+		{"site.webmanifest", "", "application/manifest+json; charset=utf-8"},
+		{"icon.svg", "", "image/svg+xml; charset=utf-8"},
+		{"ort-wasm-simd.wasm", "", "application/wasm"},
+		{"fonts/inter.woff2", "", "font/woff2"},
+		{"favicon.ico", "", "image/x-icon"},
+		{"clip.webm", "", "video/webm"},
+		{"model.onnx", "", "application/octet-stream"},
+		{"model.ort", "", "application/octet-stream"},
+		// Sniffed
+		{"LICENSE", "MIT License", "text/plain; charset=utf-8"},
+		{"module", "\x00asm\x01\x00\x00\x00", "application/wasm"},
+		{"weights.bin", "\x08\x07\x12\x00\xff\xfe", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if got := getMimeType(test.filename, []byte(test.body)); got != test.want {
+			t.Errorf("getMimeType(%q) = %q, want %q", test.filename, got, test.want)
+		}
+	}
+}
+
+func TestCacheControlFor(t *testing.T) {
+	tests := map[string]string{
+		"sw.js":                       cacheRevalidate,
+		"manifest.json":               cacheRevalidate,
+		"index.html":                  cacheRevalidate,
+		"pages/About.HTML":            cacheRevalidate,
+		"site.webmanifest":            cacheRevalidate,
+		"model.onnx":                  cacheImmutable,
+		"assets/index-4f9a1c2e.js":    cacheImmutable,
+		"assets/app.D2xa9Qk7.css":     cacheImmutable,
+		"app.js":                      cacheDefault,
+		"assets/app-settings.js":      cacheDefault,
+		"icons/icon-192.png":          cacheDefault,
+		"fonts/inter-var_latin.woff2": cacheDefault,
+	}
+	for filename, want := range tests {
+		if got := cacheControlFor(filename); got != want {
+			t.Errorf("cacheControlFor(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package unzip
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*****************************************************/
+// ONNX model inspection
+/*****************************************************/
+// The model is read straight from the protobuf wire format, field numbers as
+// in onnx.proto, instead of through generated code. Only the parts that
+// describe the model's interface are decoded; tensor data is skipped.
+
+// ModelInfo describes what an ONNX model expects and what it needs from the
+// runtime.
+type ModelInfo struct {
+	// Name and Role are as the publish request declared them
+	Name         string        `json:"name"`
+	Role         string        `json:"role"`
+	Path         string        `json:"path"`
+	IrVersion    int64         `json:"ir_version"`
+	OpsetVersion int64         `json:"opset_version"`
+	ProducerName string        `json:"producer_name,omitempty"`
+	Opsets       []OperatorSet `json:"opsets"`
+	Inputs       []TensorInfo  `json:"inputs"`
+	Outputs      []TensorInfo  `json:"outputs"`
+	Operators    []string      `json:"operators"`
+	// UnsupportedOperators are operators onnxruntime-web cannot run, as
+	// "domain:op_type" for domains other than the default one
+	UnsupportedOperators []string `json:"unsupported_operators,omitempty"`
+}
+
+// OperatorSet is one opset the model imports. The default ONNX domain is "".
+type OperatorSet struct {
+	Domain  string `json:"domain"`
+	Version int64  `json:"version"`
+}
+
+// TensorInfo describes a graph input or output. Dimensions are sizes, or the
+// symbolic name of a dynamic dimension such as "batch", or "?" when unnamed.
+type TensorInfo struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Shape []string `json:"shape,omitempty"`
+}
+
+// modelFileName is the model of apps that do not declare their models.
+const modelFileName = "model.onnx"
+
+// errInvalidModel reports a model file that is not a usable ONNX model.
+var errInvalidModel = errors.New("invalid ONNX model")
+
+// maxOrtWebOpset is the newest default-domain opset the onnxruntime-web
+// release the PWA shell loads (1.16) implements.
+const maxOrtWebOpset = 19
+
+// ONNX field numbers
+const (
+	modelIrVersion    = 1
+	modelProducerName = 2
+	modelGraph        = 7
+	modelOpsetImport  = 8
+
+	opsetDomain  = 1
+	opsetVersion = 2
+
+	graphNode        = 1
+	graphInitializer = 5
+	graphInput       = 11
+	graphOutput      = 12
+
+	nodeOpType    = 4
+	nodeAttribute = 5
+	nodeDomain    = 7
+
+	attributeGraph  = 6
+	attributeGraphs = 11
+
+	tensorName = 8
+
+	valueInfoName = 1
+	valueInfoType = 2
+
+	typeTensor         = 1
+	typeSequence       = 4
+	typeMap            = 5
+	typeSparseTensor   = 8
+	typeOptional       = 9
+	tensorTypeElemType = 1
+	tensorTypeShape    = 2
+	shapeDim           = 1
+	dimensionValue     = 1
+	dimensionParam     = 2
+)
+
+// tensorElemTypes names TensorProto.DataType values
+var tensorElemTypes = map[int64]string{
+	1: "float32", 2: "uint8", 3: "int8", 4: "uint16", 5: "int16", 6: "int32",
+	7: "int64", 8: "string", 9: "bool", 10: "float16", 11: "float64",
+	12: "uint32", 13: "uint64", 14: "complex64", 15: "complex128",
+	16: "bfloat16", 17: "float8e4m3fn", 18: "float8e4m3fnuz", 19: "float8e5m2",
+	20: "float8e5m2fnuz", 21: "uint4", 22: "int4",
+}
+
+// protoField is one field of a protobuf message. For length-delimited fields
+// bytes holds the payload; for varints value holds the number.
+type protoField struct {
+	num   protowire.Number
+	bytes []byte
+	value uint64
+}
+
+// protoFields splits a message into its fields, skipping wire types the
+// model description does not use.
+func protoFields(message []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(message) > 0 {
+		num, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+		field := protoField{num: num}
+		switch wireType {
+		case protowire.VarintType:
+			field.value, n = protowire.ConsumeVarint(message)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(message)
+		default:
+			n = protowire.ConsumeFieldValue(num, wireType, message)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// inspectModel parses an ONNX model and describes its interface. It returns
+// an error wrapping errInvalidModel when the file is not a model with a graph,
+// inputs and outputs.
+func inspectModel(filename string, body []byte) (*ModelInfo, error) {
+	fields, err := protoFields(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidModel, filename, err)
+	}
+
+	info := &ModelInfo{Path: filename}
+	var graph []byte
+	for _, field := range fields {
+		switch field.num {
+		case modelIrVersion:
+			info.IrVersion = int64(field.value)
+		case modelProducerName:
+			info.ProducerName = string(field.bytes)
+		case modelOpsetImport:
+			opset, err := parseOperatorSet(field.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: opset_import: %v", errInvalidModel, filename, err)
+			}
+			info.Opsets = append(info.Opsets, opset)
+			if opset.Domain == "" || opset.Domain == "ai.onnx" {
+				info.OpsetVersion = opset.Version
+			}
+		case modelGraph:
+			graph = field.bytes
+		}
+	}
+	if info.IrVersion <= 0 || graph == nil || len(info.Opsets) == 0 {
+		return nil, fmt.Errorf("%w: %s: missing IR version, opset or graph", errInvalidModel, filename)
+	}
+
+	operators := make(map[string]bool)
+	if err := parseGraph(graph, info, operators, 0); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidModel, filename, err)
+	}
+	if len(info.Inputs) == 0 || len(info.Outputs) == 0 || len(operators) == 0 {
+		return nil, fmt.Errorf("%w: %s: graph has no inputs, outputs or nodes", errInvalidModel, filename)
+	}
+
+	for operator := range operators {
+		info.Operators = append(info.Operators, operator)
+		if !ortWebSupports(operator) {
+			info.UnsupportedOperators = append(info.UnsupportedOperators, operator)
+		}
+	}
+	sort.Strings(info.Operators)
+	sort.Strings(info.UnsupportedOperators)
+	if info.OpsetVersion > maxOrtWebOpset {
+		info.UnsupportedOperators = append(info.UnsupportedOperators, "opset:"+strconv.FormatInt(info.OpsetVersion, 10))
+	}
+	return info, nil
+}
+
+func parseOperatorSet(message []byte) (OperatorSet, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return OperatorSet{}, err
+	}
+	var opset OperatorSet
+	for _, field := range fields {
+		switch field.num {
+		case opsetDomain:
+			opset.Domain = string(field.bytes)
+		case opsetVersion:
+			opset.Version = int64(field.value)
+		}
+	}
+	return opset, nil
+}
+
+// maxGraphDepth bounds how deeply subgraphs can nest, so a crafted model
+// cannot exhaust the stack. Real models nest a few levels at most.
+const maxGraphDepth = 32
+
+// parseGraph collects the operators of a graph and of the subgraphs its
+// nodes carry in attributes (If, Loop, Scan). depth is 0 for the main graph,
+// the only one whose inputs and outputs are recorded. Initializers listed as
+// inputs, which models before IR version 4 do, are weights rather than
+// inputs and are left out.
+func parseGraph(message []byte, info *ModelInfo, operators map[string]bool, depth int) error {
+	if depth > maxGraphDepth {
+		return fmt.Errorf("subgraphs nested more than %d levels deep", maxGraphDepth)
+	}
+	fields, err := protoFields(message)
+	if err != nil {
+		return err
+	}
+	initializers := make(map[string]bool)
+	for _, field := range fields {
+		switch field.num {
+		case graphNode:
+			if err := parseNode(field.bytes, info, operators, depth); err != nil {
+				return fmt.Errorf("node: %w", err)
+			}
+		case graphInitializer:
+			name, err := stringField(field.bytes, tensorName)
+			if err != nil {
+				return fmt.Errorf("initializer: %w", err)
+			}
+			initializers[name] = true
+		}
+	}
+	if depth > 0 {
+		return nil
+	}
+
+	for _, field := range fields {
+		if field.num != graphInput && field.num != graphOutput {
+			continue
+		}
+		tensor, err := parseValueInfo(field.bytes)
+		if err != nil {
+			return fmt.Errorf("value info: %w", err)
+		}
+		if field.num == graphOutput {
+			info.Outputs = append(info.Outputs, tensor)
+		} else if !initializers[tensor.Name] {
+			info.Inputs = append(info.Inputs, tensor)
+		}
+	}
+	return nil
+}
+
+func parseNode(message []byte, info *ModelInfo, operators map[string]bool, depth int) error {
+	fields, err := protoFields(message)
+	if err != nil {
+		return err
+	}
+	var opType, domain string
+	for _, field := range fields {
+		switch field.num {
+		case nodeOpType:
+			opType = string(field.bytes)
+		case nodeDomain:
+			domain = string(field.bytes)
+		case nodeAttribute:
+			attributes, err := protoFields(field.bytes)
+			if err != nil {
+				return err
+			}
+			for _, attribute := range attributes {
+				if attribute.num == attributeGraph || attribute.num == attributeGraphs {
+					if err := parseGraph(attribute.bytes, info, operators, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if opType == "" {
+		return errors.New("node without op_type")
+	}
+	if domain == "" || domain == "ai.onnx" {
+		operators[opType] = true
+	} else {
+		operators[domain+":"+opType] = true
+	}
+	return nil
+}
+
+func parseValueInfo(message []byte) (TensorInfo, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return TensorInfo{}, err
+	}
+	var tensor TensorInfo
+	for _, field := range fields {
+		switch field.num {
+		case valueInfoName:
+			tensor.Name = string(field.bytes)
+		case valueInfoType:
+			if err := parseType(field.bytes, &tensor); err != nil {
+				return TensorInfo{}, err
+			}
+		}
+	}
+	return tensor, nil
+}
+
+// parseType fills in the element type and shape of a tensor type, and names
+// the other kinds of value (sequence, map, optional) without detail.
+func parseType(message []byte, tensor *TensorInfo) error {
+	fields, err := protoFields(message)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		switch field.num {
+		case typeTensor, typeSparseTensor:
+			tensorFields, err := protoFields(field.bytes)
+			if err != nil {
+				return err
+			}
+			for _, tensorField := range tensorFields {
+				switch tensorField.num {
+				case tensorTypeElemType:
+					tensor.Type = tensorElemTypes[int64(tensorField.value)]
+					if tensor.Type == "" {
+						tensor.Type = "unknown"
+					}
+				case tensorTypeShape:
+					shape, err := parseShape(tensorField.bytes)
+					if err != nil {
+						return err
+					}
+					tensor.Shape = shape
+				}
+			}
+		case typeSequence:
+			tensor.Type = "sequence"
+		case typeMap:
+			tensor.Type = "map"
+		case typeOptional:
+			tensor.Type = "optional"
+		}
+	}
+	return nil
+}
+
+func parseShape(message []byte) ([]string, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return nil, err
+	}
+	shape := []string{}
+	for _, field := range fields {
+		if field.num != shapeDim {
+			continue
+		}
+		dimFields, err := protoFields(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		dim := "?"
+		for _, dimField := range dimFields {
+			switch dimField.num {
+			case dimensionValue:
+				dim = strconv.FormatInt(int64(dimField.value), 10)
+			case dimensionParam:
+				dim = string(dimField.bytes)
+			}
+		}
+		shape = append(shape, dim)
+	}
+	return shape, nil
+}
+
+// stringField returns the first string field with the given number.
+func stringField(message []byte, num protowire.Number) (string, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range fields {
+		if field.num == num {
+			return string(field.bytes), nil
+		}
+	}
+	return "", nil
+}
+
+// ortWebOperators are the default-domain operators up to opset 19, the set
+// the onnxruntime-web WebAssembly backend implements. Operators that ONNX
+// defines as functions, such as Mish or GroupNormalization, are expanded by
+// the runtime and are listed too.
+var ortWebOperators = setOf(
+	"Abs", "Acos", "Acosh", "Add", "And", "ArgMax", "ArgMin", "Asin", "Asinh",
+	"Atan", "Atanh", "AveragePool", "BatchNormalization", "Bernoulli",
+	"BitShift", "BitwiseAnd", "BitwiseNot", "BitwiseOr", "BitwiseXor",
+	"BlackmanWindow", "Cast", "CastLike", "Ceil", "Celu", "CenterCropPad",
+	"Clip", "Col2Im", "Compress", "Concat", "ConcatFromSequence", "Constant",
+	"ConstantOfShape", "Conv", "ConvInteger", "ConvTranspose", "Cos", "Cosh",
+	"CumSum", "DFT", "DepthToSpace", "DequantizeLinear", "Det", "Div",
+	"Dropout", "DynamicQuantizeLinear", "Einsum", "Elu", "Equal", "Erf", "Exp",
+	"Expand", "EyeLike", "Flatten", "Floor", "GRU", "Gather", "GatherElements",
+	"GatherND", "Gemm", "GlobalAveragePool", "GlobalLpPool", "GlobalMaxPool",
+	"Greater", "GreaterOrEqual", "GridSample", "GroupNormalization",
+	"HammingWindow", "HannWindow", "HardSigmoid", "HardSwish", "Hardmax",
+	"Identity", "If", "InstanceNormalization", "IsInf", "IsNaN", "LRN", "LSTM",
+	"LayerNormalization", "LeakyRelu", "Less", "LessOrEqual", "Log",
+	"LogSoftmax", "Loop", "LpNormalization", "LpPool", "MatMul",
+	"MatMulInteger", "Max", "MaxPool", "MaxRoiPool", "MaxUnpool", "Mean",
+	"MeanVarianceNormalization", "MelWeightMatrix", "Min", "Mish", "Mod", "Mul",
+	"Multinomial", "Neg", "NegativeLogLikelihoodLoss", "NonMaxSuppression",
+	"NonZero", "Not", "OneHot", "Optional", "OptionalGetElement",
+	"OptionalHasElement", "Or", "PRelu", "Pad", "Pow", "QLinearConv",
+	"QLinearMatMul", "QuantizeLinear", "RNN", "RandomNormal",
+	"RandomNormalLike", "RandomUniform", "RandomUniformLike", "Range",
+	"Reciprocal", "ReduceL1", "ReduceL2", "ReduceLogSum", "ReduceLogSumExp",
+	"ReduceMax", "ReduceMean", "ReduceMin", "ReduceProd", "ReduceSum",
+	"ReduceSumSquare", "Relu", "Reshape", "Resize", "ReverseSequence",
+	"RoiAlign", "Round", "STFT", "Scan", "Scatter", "ScatterElements",
+	"ScatterND", "Selu", "SequenceAt", "SequenceConstruct", "SequenceEmpty",
+	"SequenceErase", "SequenceInsert", "SequenceLength", "SequenceMap", "Shape",
+	"Shrink", "Sigmoid", "Sign", "Sin", "Sinh", "Size", "Slice", "Softmax",
+	"SoftmaxCrossEntropyLoss", "Softplus", "Softsign", "SpaceToDepth", "Split",
+	"SplitToSequence", "Sqrt", "Squeeze", "StringNormalizer", "Sub", "Sum",
+	"Tan", "Tanh", "TfIdfVectorizer", "ThresholdedRelu", "Tile", "TopK",
+	"Transpose", "Trilu", "Unique", "Unsqueeze", "Upsample", "Where", "Xor",
+
+	// ai.onnx.ml
+	"ai.onnx.ml:ArrayFeatureExtractor", "ai.onnx.ml:Binarizer",
+	"ai.onnx.ml:CastMap", "ai.onnx.ml:CategoryMapper",
+	"ai.onnx.ml:DictVectorizer", "ai.onnx.ml:FeatureVectorizer",
+	"ai.onnx.ml:Imputer", "ai.onnx.ml:LabelEncoder",
+	"ai.onnx.ml:LinearClassifier", "ai.onnx.ml:LinearRegressor",
+	"ai.onnx.ml:Normalizer", "ai.onnx.ml:OneHotEncoder",
+	"ai.onnx.ml:SVMClassifier", "ai.onnx.ml:SVMRegressor", "ai.onnx.ml:Scaler",
+	"ai.onnx.ml:TreeEnsembleClassifier", "ai.onnx.ml:TreeEnsembleRegressor",
+	"ai.onnx.ml:ZipMap",
+)
+
+// ortWebSupports reports whether onnxruntime-web can run an operator, named
+// as in ModelInfo.Operators. The runtime's own com.microsoft contrib
+// operators are accepted as a domain; other custom domains are not.
+func ortWebSupports(operator string) bool {
+	if ortWebOperators[operator] {
+		return true
+	}
+	return strings.HasPrefix(operator, "com.microsoft:")
+}
+
+func setOf(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
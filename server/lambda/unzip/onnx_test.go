@@ -0,0 +1,151 @@
+package unzip
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Builders for the parts of onnx.proto the inspection reads
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendVarint(b []byte, num protowire.Number, value uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+// testValueInfo builds a tensor ValueInfoProto. Integer dimensions become
+// dim_value, string ones dim_param.
+func testValueInfo(name string, elemType uint64, dims ...any) []byte {
+	var shape []byte
+	for _, dim := range dims {
+		var dimension []byte
+		switch dim := dim.(type) {
+		case int:
+			dimension = appendVarint(nil, dimensionValue, uint64(dim))
+		case string:
+			dimension = appendString(nil, dimensionParam, dim)
+		}
+		shape = appendMessage(shape, shapeDim, dimension)
+	}
+	tensorType := appendVarint(nil, tensorTypeElemType, elemType)
+	tensorType = appendMessage(tensorType, tensorTypeShape, shape)
+	valueInfo := appendString(nil, valueInfoName, name)
+	return appendMessage(valueInfo, valueInfoType, appendMessage(nil, typeTensor, tensorType))
+}
+
+func testNode(opType, domain string) []byte {
+	node := appendString(nil, nodeOpType, opType)
+	if domain != "" {
+		node = appendString(node, nodeDomain, domain)
+	}
+	return node
+}
+
+// testModel builds a model computing output = Softmax(Gemm(input, weights))
+// with the weights also listed as an input, the way IR 3 models do.
+func testModel(opset uint64, extraNodes ...[]byte) []byte {
+	graph := appendMessage(nil, graphNode, testNode("Gemm", ""))
+	graph = appendMessage(graph, graphNode, testNode("Softmax", ""))
+	for _, node := range extraNodes {
+		graph = appendMessage(graph, graphNode, node)
+	}
+	weights := appendString(nil, tensorName, "weights")
+	weights = appendMessage(weights, 9, make([]byte, 64)) // raw_data
+	graph = appendMessage(graph, graphInitializer, weights)
+	graph = appendMessage(graph, graphInput, testValueInfo("input", 1, "batch", 1, 56, 56))
+	graph = appendMessage(graph, graphInput, testValueInfo("weights", 1, 16))
+	graph = appendMessage(graph, graphOutput, testValueInfo("output", 1, "batch", 6))
+
+	model := appendVarint(nil, modelIrVersion, 8)
+	model = appendString(model, modelProducerName, "pytorch")
+	model = appendMessage(model, modelGraph, graph)
+	opsetImport := appendVarint(nil, opsetVersion, opset)
+	return appendMessage(model, modelOpsetImport, opsetImport)
+}
+
+func TestInspectModel(t *testing.T) {
+	info, err := inspectModel("model.onnx", testModel(17))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ModelInfo{
+		Path:         "model.onnx",
+		IrVersion:    8,
+		OpsetVersion: 17,
+		ProducerName: "pytorch",
+		Opsets:       []OperatorSet{{Domain: "", Version: 17}},
+		Inputs:       []TensorInfo{{Name: "input", Type: "float32", Shape: []string{"batch", "1", "56", "56"}}},
+		Outputs:      []TensorInfo{{Name: "output", Type: "float32", Shape: []string{"batch", "6"}}},
+		Operators:    []string{"Gemm", "Softmax"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("inspectModel() = %+v\nwant %+v", info, want)
+	}
+}
+
+func TestInspectModelFlagsUnsupportedOperators(t *testing.T) {
+	info, err := inspectModel("model.onnx", testModel(21,
+		testNode("FusedMatMul", "com.microsoft"),
+		testNode("MyOp", "com.example"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"com.example:MyOp", "opset:21"}
+	if !reflect.DeepEqual(info.UnsupportedOperators, want) {
+		t.Errorf("unsupported operators = %v, want %v", info.UnsupportedOperators, want)
+	}
+}
+
+func TestInspectModelRejectsInvalidFiles(t *testing.T) {
+	tests := map[string][]byte{
+		"placeholder text": []byte("placeholder model"),
+		"truncated":        testModel(17)[:40],
+		"no graph":         appendVarint(nil, modelIrVersion, 8),
+		"empty":            {},
+	}
+	for name, body := range tests {
+		if _, err := inspectModel("model.onnx", body); !errors.Is(err, errInvalidModel) {
+			t.Errorf("%s: got %v, want errInvalidModel", name, err)
+		}
+	}
+}
+
+// testNestedIf builds an If node whose then_branch nests levels more If
+// nodes, the innermost of which runs a Relu.
+func testNestedIf(levels int) []byte {
+	node := testNode("Relu", "")
+	for i := 0; i < levels; i++ {
+		branch := appendMessage(nil, graphNode, node)
+		attribute := appendString(nil, 1, "then_branch") // name
+		attribute = appendMessage(attribute, attributeGraph, branch)
+		node = appendMessage(testNode("If", ""), nodeAttribute, attribute)
+	}
+	return node
+}
+
+func TestInspectModelBoundsSubgraphDepth(t *testing.T) {
+	info, err := inspectModel("model.onnx", testModel(17, testNestedIf(maxGraphDepth)))
+	if err != nil {
+		t.Fatalf("subgraphs %d levels deep: %v", maxGraphDepth, err)
+	}
+	if want := []string{"Gemm", "If", "Relu", "Softmax"}; !reflect.DeepEqual(info.Operators, want) {
+		t.Errorf("operators = %v, want %v", info.Operators, want)
+	}
+
+	if _, err := inspectModel("model.onnx", testModel(17, testNestedIf(maxGraphDepth+1))); !errors.Is(err, errInvalidModel) {
+		t.Errorf("subgraphs %d levels deep: got %v, want errInvalidModel", maxGraphDepth+1, err)
+	}
+}
@@ -0,0 +1,548 @@
+package unzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testBucket = "apps-bucket"
+
+type failingMetadataQueue struct {
+	*MemoryMetadataQueue
+	failures int
+}
+
+func (q *failingMetadataQueue) SendAppMetadata(ctx context.Context, metadata AppMetadataMessage) error {
+	if q.failures > 0 {
+		q.failures--
+		return errors.New("sqs unavailable")
+	}
+	return q.MemoryMetadataQueue.SendAppMetadata(ctx, metadata)
+}
+
+func newTestUpload(t *testing.T, blobs *MemoryBlobStore) upload {
+	t.Helper()
+	return putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html></html>"},
+		{"app.js", "console.log('app')"},
+		{"manifest.json", `{"name":"Shape"}`},
+	})
+}
+
+// putTestZip uploads a zip of the given name and body pairs, in order.
+func putTestZip(t *testing.T, blobs *MemoryBlobStore, files [][2]string) upload {
+	t.Helper()
+	return putTestZipAt(t, blobs, "uploads/shape/1.0.0/publisher-1/req-1.zip", files)
+}
+
+func putTestZipAt(t *testing.T, blobs *MemoryBlobStore, key string, files [][2]string) upload {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file[1]))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	source, ok := parseUploadKey(testBucket, key)
+	if !ok {
+		t.Fatal("test upload key does not parse")
+	}
+	blobs.PutObject(context.Background(), testBucket, source.key, buf.Bytes(), ObjectAttributes{ContentType: "application/zip"})
+	return source
+}
+
+func TestFailedEnqueueKeepsUploadForRetry(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := &failingMetadataQueue{MemoryMetadataQueue: NewMemoryMetadataQueue(), failures: 1}
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	source := newTestUpload(t, blobs)
+	ctx := context.Background()
+
+	if _, err := handler.processUpload(ctx, source); err == nil {
+		t.Fatal("expected the failed enqueue to fail the invocation")
+	}
+	if _, ok := blobs.Attributes(testBucket, source.key); !ok {
+		t.Fatal("upload was removed although its metadata was never enqueued")
+	}
+
+	// The retry runs every step again
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if len(queue.Messages()) != 1 {
+		t.Errorf("expected 1 metadata message after the retry, got %d", len(queue.Messages()))
+	}
+	if _, ok := blobs.Attributes(testBucket, source.key); ok {
+		t.Error("upload is still in uploads/ after it was processed")
+	}
+	if attributes, ok := blobs.Attributes(testBucket, archiveKey(source)); !ok || attributes.ContentType != "application/zip" {
+		t.Errorf("upload was not archived at %s", archiveKey(source))
+	}
+
+	// A duplicate notification for the processed upload is a no-op
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatalf("duplicate notification failed: %v", err)
+	}
+	if len(queue.Messages()) != 1 {
+		t.Errorf("duplicate notification enqueued again: %d messages", len(queue.Messages()))
+	}
+}
+
+func TestDuplicateFileNamesAreRejected(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html>v1</html>"},
+		{"index.html", "<html>v2</html>"},
+	})
+
+	if _, err := handler.processUpload(context.Background(), source); err == nil {
+		t.Fatal("expected a zip with a repeated name to be rejected")
+	}
+	if len(queue.Messages()) != 0 {
+		t.Errorf("metadata was queued for a rejected upload: %+v", queue.Messages())
+	}
+}
+
+func TestExtractedFilesCarryHashAndCacheControl(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	source := newTestUpload(t, blobs)
+
+	if _, err := handler.processUpload(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	metadata := queue.Messages()[0]
+	if len(metadata.Files) != len(metadata.ProcessedFiles) {
+		t.Fatalf("got %d file entries for %d processed files", len(metadata.Files), len(metadata.ProcessedFiles))
+	}
+	for i, entry := range metadata.Files {
+		if metadata.ProcessedFiles[i] != releasePrefix(source)+entry.Path {
+			t.Errorf("file entry %q does not match processed file %q", entry.Path, metadata.ProcessedFiles[i])
+		}
+		attributes, ok := blobs.Attributes(testBucket, metadata.ProcessedFiles[i])
+		if !ok {
+			t.Fatalf("%s was not stored", metadata.ProcessedFiles[i])
+		}
+		if attributes.SHA256 != entry.SHA256 || attributes.CacheControl != entry.CacheControl || attributes.ContentType != entry.ContentType {
+			t.Errorf("%s stored with %+v, listed as %+v", entry.Path, attributes, entry)
+		}
+	}
+	// sha256("<html></html>")
+	if entry := metadata.Files[0]; entry.Path != "index.html" || entry.Size != 13 ||
+		entry.SHA256 != "b633a587c652d02386c4f16f8c6f6aab7352d97f16367c3c40576214372dd628" || entry.CacheControl != cacheRevalidate {
+		t.Errorf("unexpected entry for index.html: %+v", entry)
+	}
+}
+
+func TestDeclaredModelsAreInspected(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	declaration := []byte(`{"models":[
+		{"name":"encoder","path":"models/encoder.onnx","role":"encoder"},
+		{"name":"decoder","path":"models/decoder.onnx","role":"decoder"}]}`)
+
+	// A declared model missing from the bundle rejects the upload
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html></html>"},
+		{"models/encoder.onnx", string(testModel(17))},
+	})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	if _, err := handler.processUpload(ctx, source); !errors.Is(err, errInvalidModel) {
+		t.Fatalf("expected a missing model to reject the upload, got %v", err)
+	}
+
+	source = putTestZip(t, blobs, [][2]string{
+		{"index.html", "<html></html>"},
+		{"models/encoder.onnx", string(testModel(17))},
+		{"models/decoder.onnx", string(testModel(17))},
+	})
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	models := queue.Messages()[0].Models
+	if len(models) != 2 || models[0].Name != "encoder" || models[0].Role != "encoder" ||
+		models[1].Name != "decoder" || models[1].Path != "models/decoder.onnx" || len(models[1].Inputs) != 1 {
+		t.Errorf("unexpected models: %+v", models)
+	}
+	if _, ok := blobs.Attributes(testBucket, declarationKey(source.key)); ok {
+		t.Error("publish declaration was left in uploads/")
+	}
+	if _, ok := blobs.Attributes(testBucket, declarationKey(archiveKey(source))); !ok {
+		t.Error("publish declaration was not archived")
+	}
+}
+
+func TestModelsAreStoredOnceAsSharedBlobs(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	model := string(testModel(17))
+
+	first := putTestZipAt(t, blobs, "uploads/shape/1.0.0/publisher-1/req-1.zip", [][2]string{
+		{"index.html", "<html></html>"},
+		{"model.onnx", model},
+	})
+	if _, err := handler.processUpload(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := putTestZipAt(t, blobs, "uploads/shape/1.0.1/publisher-1/req-2.zip", [][2]string{
+		{"index.html", "<html><body></body></html>"},
+		{"model.onnx", model},
+	})
+	extraction, err := handler.processUpload(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := queue.Messages()[1].Files[1]
+	if entry.Path != "model.onnx" || entry.Blob != blobKey(entry.SHA256) || entry.CacheControl != cacheImmutable {
+		t.Fatalf("unexpected entry for the model: %+v", entry)
+	}
+	if processed := queue.Messages()[1].ProcessedFiles[1]; processed != entry.Blob {
+		t.Errorf("processed file %q, want the blob key", processed)
+	}
+	if _, ok := blobs.Attributes(testBucket, releasePrefix(second)+"model.onnx"); ok {
+		t.Error("model was also written to the release")
+	}
+	if attributes, ok := blobs.Attributes(testBucket, entry.Blob); !ok || attributes.SHA256 != entry.SHA256 {
+		t.Errorf("blob stored with %+v", attributes)
+	}
+	for _, source := range []upload{first, second} {
+		if _, ok := blobs.Attributes(testBucket, blobRefKey(source, entry.SHA256)); !ok {
+			t.Errorf("release %s has no reference to the blob", source.requestId)
+		}
+	}
+	if extraction.reusedBytes != entry.Size {
+		t.Errorf("reused %d bytes, want %d", extraction.reusedBytes, entry.Size)
+	}
+}
+
+func TestDeltaUploadIsAssembledFromDeclaredSources(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	digest := func(body string) string {
+		sum := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(sum[:])
+	}
+	model := string(testModel(17))
+
+	// index.html is unchanged from the live release, the model is a stored
+	// blob, and only app.js was uploaded
+	blobs.PutObject(ctx, testBucket, "app/shape/releases/req-1/index.html", []byte("<html></html>"), ObjectAttributes{})
+	blobs.PutObject(ctx, testBucket, blobKey(digest(model)), []byte(model), ObjectAttributes{})
+	source, _ := parseUploadKey(testBucket, "uploads/shape/1.0.1/publisher-1/req-2"+deltaCompleteSuffix)
+	uploaded := deltaUploadPrefix(source) + digest("console.log('v2')")
+	blobs.PutObject(ctx, testBucket, uploaded, []byte("console.log('v2')"), ObjectAttributes{})
+	declaration, _ := json.Marshal(PublishDeclaration{
+		Models: []ModelFile{{Name: "model", Path: "model.onnx", Role: "default"}},
+		Files: []DeltaFile{
+			{Path: "index.html", SHA256: digest("<html></html>"), Source: "app/shape/releases/req-1/index.html"},
+			{Path: "app.js", SHA256: digest("console.log('v2')"), Source: uploaded},
+			{Path: "model.onnx", SHA256: digest(model), Source: blobKey(digest(model))},
+		},
+	})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	blobs.PutObject(ctx, testBucket, source.key, nil, ObjectAttributes{})
+
+	extraction, err := handler.processUpload(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := queue.Messages()[0]
+	if metadata.ReleaseId != "req-2" || len(metadata.Files) != 3 || len(metadata.Models) != 1 {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+	for _, name := range []string{"index.html", "app.js"} {
+		if body, err := blobs.GetObject(ctx, testBucket, releasePrefix(source)+name); err != nil || len(body) == 0 {
+			t.Errorf("%s was not extracted: %v", name, err)
+		}
+	}
+	if extraction.reusedBytes != int64(len(model)) {
+		t.Errorf("reused %d bytes, want the model's %d", extraction.reusedBytes, len(model))
+	}
+	for _, key := range []string{source.key, uploaded, declarationKey(source.key)} {
+		if _, ok := blobs.Attributes(testBucket, key); ok {
+			t.Errorf("%s was left in uploads/", key)
+		}
+	}
+	if _, ok := blobs.Attributes(testBucket, declarationKey(archiveKey(source))); !ok {
+		t.Error("publish declaration was not archived")
+	}
+
+	// A redelivered notification finds the marker gone
+	if _, err := handler.processUpload(ctx, source); err != nil || len(queue.Messages()) != 1 {
+		t.Errorf("redelivery was processed again: %v", err)
+	}
+}
+
+func TestDeltaUploadRejectsFilesThatDoNotMatchTheirHash(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+
+	source, _ := parseUploadKey(testBucket, "uploads/shape/1.0.1/publisher-1/req-2"+deltaCompleteSuffix)
+	uploaded := deltaUploadPrefix(source) + "0000"
+	blobs.PutObject(ctx, testBucket, uploaded, []byte("console.log('tampered')"), ObjectAttributes{})
+	declaration, _ := json.Marshal(PublishDeclaration{Files: []DeltaFile{{Path: "app.js", SHA256: "0000", Source: uploaded}}})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	blobs.PutObject(ctx, testBucket, source.key, nil, ObjectAttributes{})
+
+	if _, err := handler.processUpload(ctx, source); err == nil {
+		t.Fatal("expected a file that does not match its hash to fail the upload")
+	}
+	if len(queue.Messages()) != 0 {
+		t.Error("metadata was queued for a rejected upload")
+	}
+}
+
+func TestZipIsCheckedAgainstItsDeclaredChecksum(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	source := newTestUpload(t, blobs)
+	body, err := blobs.GetObject(ctx, testBucket, source.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(body)
+
+	declare := func(bundle Bundle) {
+		declaration, _ := json.Marshal(PublishDeclaration{Bundle: &bundle})
+		blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	}
+	declare(Bundle{Size: int64(len(body)), SHA256: hex.EncodeToString(make([]byte, sha256.Size))})
+	if _, err := handler.processUpload(ctx, source); err == nil {
+		t.Fatal("expected a zip that does not match its SHA-256 to be rejected")
+	}
+	declare(Bundle{Size: int64(len(body)) + 1, SHA256: hex.EncodeToString(digest[:])})
+	if _, err := handler.processUpload(ctx, source); err == nil {
+		t.Fatal("expected a zip that does not match its size to be rejected")
+	}
+	declare(Bundle{Size: int64(len(body))})
+	if _, err := handler.processUpload(ctx, source); err == nil {
+		t.Fatal("expected a zip without a declared SHA-256 to be rejected")
+	}
+	if len(queue.Messages()) != 0 {
+		t.Fatal("metadata was queued for a rejected upload")
+	}
+
+	declare(Bundle{Size: int64(len(body)), SHA256: hex.EncodeToString(digest[:])})
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatalf("matching zip was rejected: %v", err)
+	}
+	if len(queue.Messages()) != 1 {
+		t.Errorf("expected 1 metadata message, got %d", len(queue.Messages()))
+	}
+}
+
+func TestArchivedManifestMustMatchTheDeclaredOne(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	declared := normalizeManifest(Manifest{
+		Name: "Shape", ShortName: "Shape", StartUrl: "index.html", Display: "standalone",
+		Icons: []Icon{{Src: "icon.png", Sizes: "192x192"}},
+	})
+	publish := func(manifest string, icon bool) upload {
+		t.Helper()
+		files := [][2]string{{"index.html", "<html></html>"}, {"manifest.json", manifest}}
+		if icon {
+			files = append(files, [2]string{"icon.png", string(testIcon(t, "png", 192, 192))})
+		}
+		source := putTestZip(t, blobs, files)
+		declaration, _ := json.Marshal(PublishDeclaration{Manifest: &declared})
+		blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+		return source
+	}
+
+	rejected := map[string]struct {
+		manifest string
+		icon     bool
+	}{
+		"different name": {manifest: `{"name":"Circle","short_name":"Shape","start_url":"index.html","display":"standalone","icons":[{"src":"icon.png","sizes":"192x192"}]}`, icon: true},
+		"unparsable":     {manifest: `{"name":`, icon: true},
+		"missing icon":   {manifest: `{"name":"Shape","short_name":"Shape","start_url":"./index.html","display":"standalone","icons":[{"src":"icon.png","sizes":"192x192"}]}`},
+	}
+	for name, tt := range rejected {
+		if _, err := handler.processUpload(ctx, publish(tt.manifest, tt.icon)); !errors.Is(err, errInvalidManifest) {
+			t.Errorf("%s: got %v, want errInvalidManifest", name, err)
+		}
+	}
+	if len(queue.Messages()) != 0 {
+		t.Fatal("metadata was queued for a rejected upload")
+	}
+
+	// Spelled differently, but the same manifest once normalized
+	source := publish(`{"name":" Shape","short_name":"Shape","start_url":"./index.html","display":"Standalone","icons":[{"src":"./icon.png","sizes":"192X192","type":"image/png"}]}`, true)
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatalf("matching manifest was rejected: %v", err)
+	}
+	if messages := queue.Messages(); len(messages) != 1 || messages[0].Manifest == nil || messages[0].Manifest.Icons[0].Purpose != "any" {
+		t.Errorf("expected the normalized manifest in the metadata, got %+v", messages)
+	}
+}
+
+func TestBundleOverTheFileLimitIsRejected(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	files := make([][2]string, maxBundleFiles+1)
+	for i := range files {
+		files[i] = [2]string{fmt.Sprintf("assets/%d.txt", i), "x"}
+	}
+	source := putTestZip(t, blobs, files)
+
+	if _, err := handler.processUpload(context.Background(), source); !errors.Is(err, errBundleTooLarge) {
+		t.Fatalf("expected errBundleTooLarge, got %v", err)
+	}
+	if len(queue.Messages()) != 0 {
+		t.Errorf("metadata was queued for a rejected upload: %d messages", len(queue.Messages()))
+	}
+}
+
+// zipOfZeros zips files of the given sizes, which compress to almost nothing.
+func zipOfZeros(t *testing.T, sizes ...int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	zeros := make([]byte, 1024*1024)
+	for i, size := range sizes {
+		w, err := archive.Create(fmt.Sprintf("assets/%d.bin", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ; size > 0; size -= len(zeros) {
+			w.Write(zeros[:min(size, len(zeros))])
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFileExpandingPastTheCapIsRejected(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	source, _ := parseUploadKey(testBucket, "uploads/shape/1.0.0/publisher-1/req-1.zip")
+	blobs.PutObject(context.Background(), testBucket, source.key, zipOfZeros(t, maxExtractedFileBytes+1), ObjectAttributes{ContentType: "application/zip"})
+
+	if _, err := handler.processUpload(context.Background(), source); !errors.Is(err, errBundleTooLarge) {
+		t.Fatalf("expected errBundleTooLarge, got %v", err)
+	}
+	if len(queue.Messages()) != 0 {
+		t.Errorf("metadata was queued for a rejected upload: %d messages", len(queue.Messages()))
+	}
+}
+
+func TestFilesExpandingPastTheTotalCapAreRejected(t *testing.T) {
+	sizes := make([]int, maxExtractedBytes/maxExtractedFileBytes+1)
+	for i := range sizes {
+		sizes[i] = maxExtractedFileBytes
+	}
+	files, err := zipFiles(zipOfZeros(t, sizes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range files {
+		_, err := file.read()
+		if i < len(files)-1 && err != nil {
+			t.Fatalf("file %d within the total cap was rejected: %v", i, err)
+		}
+		if i == len(files)-1 && !errors.Is(err, errBundleTooLarge) {
+			t.Fatalf("expected errBundleTooLarge past the total cap, got %v", err)
+		}
+	}
+	// Reading a file again does not count it twice
+	if _, err := files[0].read(); err != nil {
+		t.Errorf("rereading a file was rejected: %v", err)
+	}
+}
+
+// TestLargestDeclarableBundleFitsInOneMessage fills every field the publisher
+// bounds to its limit, so raising a limit past what SQS takes fails here.
+func TestLargestDeclarableBundleFitsInOneMessage(t *testing.T) {
+	text := func(length int) string { return strings.Repeat("a", length) }
+	source := upload{appSlug: text(64), versionId: "1.0.0", publisherId: "publisher-1", requestId: "c0a8012e-5b1f-4c1e-9d3a-0e6f2b7d9a41"}
+	metadata := AppMetadataMessage{
+		AppSlug:         source.appSlug,
+		VersionId:       source.versionId,
+		PublisherId:     source.publisherId,
+		RequestId:       source.requestId,
+		ReleaseId:       source.requestId,
+		S3FilePath:      releasePrefix(source),
+		ManifestFound:   true,
+		ManifestContent: text(16 * 1024),
+		Manifest:        &Manifest{Name: text(45), ShortName: text(12), Description: text(16 * 1024)},
+		VersionNotes:    text(2000),
+		Listing: &StoreListing{
+			Description:   text(10000),
+			PrivacyPolicy: text(10000),
+			InputTypes:    []string{"camera", "file", "microphone", "text"},
+			ModelCard:     &ModelCard{IntendedUse: text(2000), TrainingData: text(2000), Limitations: text(2000), License: text(2000)},
+		},
+	}
+	// The files the publisher allows and the icons unzip can generate
+	for i := 0; i < maxBundleFiles+3; i++ {
+		name := fmt.Sprintf("%0*d", maxFilePathLength, i)
+		metadata.ProcessedFiles = append(metadata.ProcessedFiles, releasePrefix(source)+name)
+		metadata.Files = append(metadata.Files, FileEntry{
+			Path: name, Size: 1 << 30, SHA256: text(64), ContentType: "application/octet-stream", CacheControl: "public, max-age=31536000, immutable",
+		})
+	}
+	for i := 0; i < 8; i++ {
+		metadata.Listing.Screenshots = append(metadata.Listing.Screenshots, Screenshot{Path: text(maxFilePathLength), Label: text(100), FormFactor: "narrow", Width: 3840, Height: 2160, Type: "image/webp"})
+		metadata.Icons = append(metadata.Icons, IconInfo{Path: text(maxFilePathLength), Width: 512, Height: 512, Type: "image/png", Purpose: "maskable"})
+		model := ModelInfo{Name: text(32), Role: text(32), Path: text(maxFilePathLength), IrVersion: 9, OpsetVersion: 19, ProducerName: "pytorch"}
+		for j := 0; j < 8; j++ {
+			model.Inputs = append(model.Inputs, TensorInfo{Name: text(32), Type: "float32", Shape: []string{"batch", "3", "224", "224"}})
+			model.Outputs = append(model.Outputs, TensorInfo{Name: text(32), Type: "float32", Shape: []string{"batch", "1000"}})
+		}
+		for j := 0; j < 60; j++ {
+			model.Operators = append(model.Operators, text(24))
+		}
+		metadata.Models = append(metadata.Models, model)
+	}
+
+	if err := checkMessageSize(metadata); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package unzip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// An upload that breaks the publish contract fails the same way every time,
+// so retrying it only delays the failure until S3 gives up and the error is
+// lost. Unzip records such a rejection next to the declaration as
+// uploads/.../{requestId}.status.json, where the publisher's upload route
+// reads it, and removes the upload so a redelivered notification finds
+// nothing to do. Files already extracted into the release are never served
+// and are deleted with the release's other stale files.
+
+// errInvalidBundle reports a zip or delta upload that cannot be extracted as
+// declared.
+var errInvalidBundle = errors.New("invalid bundle")
+
+// permanentFailures are the errors retrying an upload cannot fix.
+var permanentFailures = []error{
+	errInvalidBundle,
+	errBundleTooLarge,
+	errInvalidModel,
+	errInvalidManifest,
+	errInvalidIcon,
+	errInvalidScreenshot,
+	errShellContract,
+}
+
+const uploadStatusRejected = "rejected"
+
+// UploadStatus is what unzip records about an upload it rejected.
+type UploadStatus struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	RejectedAt time.Time `json:"rejected_at"`
+}
+
+func isPermanentFailure(err error) bool {
+	for _, permanent := range permanentFailures {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusKey is where the status of an upload is recorded: the upload key with
+// a .status.json extension, which does not notify unzip.
+func statusKey(key string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(key, ".zip"), deltaCompleteSuffix) + ".status.json"
+}
+
+// rejectUpload records why the upload was rejected and then removes it. An
+// error means neither may have happened and the invocation should fail, so
+// the rejection is retried.
+func (h *Handler) rejectUpload(ctx context.Context, source upload, reason error) error {
+	body, err := json.Marshal(UploadStatus{Status: uploadStatusRejected, Error: reason.Error(), RejectedAt: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload status: %w", err)
+	}
+	if err := h.services.Blobs.PutObject(ctx, source.bucket, statusKey(source.key), body, ObjectAttributes{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to record rejected upload: %w", err)
+	}
+	if err := h.services.Blobs.DeleteObject(ctx, source.bucket, source.key); err != nil {
+		return fmt.Errorf("failed to delete rejected upload: %w", err)
+	}
+	slog.WarnContext(ctx, "Rejected upload", "key", source.key, "status_key", statusKey(source.key), "error", reason)
+	return nil
+}
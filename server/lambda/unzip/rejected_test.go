@@ -0,0 +1,80 @@
+package unzip
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func s3Event(source upload) events.S3Event {
+	var record events.S3EventRecord
+	record.S3.Bucket.Name = source.bucket
+	record.S3.Object.Key = source.key
+	return events.S3Event{Records: []events.S3EventRecord{record}}
+}
+
+func TestUploadBreakingTheShellContractIsRejectedOnce(t *testing.T) {
+	buf := captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<main></main>"},
+		{"app.js", "run()"},
+	})
+	declaration, _ := json.Marshal(PublishDeclaration{Entrypoint: "index.html", ServiceWorker: "sw.js"})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+
+	if err := handler.HandleRequest(ctx, s3Event(source)); err != nil {
+		t.Fatalf("a rejected upload failed the invocation, so S3 would retry it: %v", err)
+	}
+	body, err := blobs.GetObject(ctx, testBucket, "uploads/shape/1.0.0/publisher-1/req-1.status.json")
+	if err != nil {
+		t.Fatalf("rejection was not recorded: %v", err)
+	}
+	var status UploadStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != uploadStatusRejected || !strings.Contains(status.Error, "sw.js is not at the root of the bundle") || status.RejectedAt.IsZero() {
+		t.Errorf("status = %+v, want the shell contract failure", status)
+	}
+	if _, ok := blobs.Attributes(testBucket, source.key); ok {
+		t.Error("rejected upload is still in uploads/")
+	}
+	if len(queue.Messages()) != 0 {
+		t.Errorf("metadata was queued for a rejected upload: %+v", queue.Messages())
+	}
+	if !strings.Contains(buf.String(), `"Outcome":"client_error"`) {
+		t.Errorf("rejection was not counted as a client error: %s", buf.String())
+	}
+
+	// A redelivered notification finds nothing left to do
+	if err := handler.HandleRequest(ctx, s3Event(source)); err != nil {
+		t.Fatalf("redelivery failed: %v", err)
+	}
+}
+
+func TestTransientFailureIsLeftForRetry(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := &failingMetadataQueue{MemoryMetadataQueue: NewMemoryMetadataQueue(), failures: 1}
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+	source := newTestUpload(t, blobs)
+
+	if err := handler.HandleRequest(ctx, s3Event(source)); err == nil {
+		t.Fatal("expected a failed enqueue to fail the invocation")
+	}
+	if _, err := blobs.GetObject(ctx, testBucket, statusKey(source.key)); err == nil {
+		t.Error("a transient failure was recorded as a rejection")
+	}
+	if _, ok := blobs.Attributes(testBucket, source.key); !ok {
+		t.Error("upload was removed although S3 retries it")
+	}
+}
@@ -0,0 +1,221 @@
+package unzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+/*****************************************************/
+// Storage and queue interfaces
+/*****************************************************/
+// BlobStore reads and writes objects in S3 buckets. GetObject returns an error
+// wrapping ErrObjectNotFound when the key does not exist.
+type BlobStore interface {
+	GetObject(ctx context.Context, bucket, key string) ([]byte, error)
+	// ObjectExists reports whether the key exists without reading the body
+	ObjectExists(ctx context.Context, bucket, key string) (bool, error)
+	PutObject(ctx context.Context, bucket, key string, body []byte, attributes ObjectAttributes) error
+	DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// ObjectAttributes are stored with an object and returned with it when it is
+// served. Empty fields are left unset.
+type ObjectAttributes struct {
+	ContentType  string
+	CacheControl string
+	// SHA256 is the hex digest of the body, which S3 verifies on upload
+	SHA256 string
+}
+
+// ErrObjectNotFound reports a missing object. It is exported so blob stores
+// outside this package, like the local development server's, can return it.
+var ErrObjectNotFound = errors.New("the specified key does not exist")
+
+// MetadataQueue hands extracted app metadata to the publisher's ingest.
+type MetadataQueue interface {
+	SendAppMetadata(ctx context.Context, metadata AppMetadataMessage) error
+}
+
+// Services are the clients the handler uses. They are created once per cold
+// start and shared by every invocation.
+type Services struct {
+	Blobs    BlobStore
+	Metadata MetadataQueue
+	// AppsBucket receives the extracted files
+	AppsBucket string
+}
+
+const (
+	// awsCallTimeout bounds each SQS call, retries included, so one slow call
+	// fails on its own instead of using up the whole invocation.
+	awsCallTimeout = 5 * time.Second
+	// objectCallTimeout also covers reading or writing the object body, which
+	// for an app bundle can take far longer than a metadata call.
+	objectCallTimeout = 2 * time.Minute
+)
+
+// NewAWSServices creates the S3 and SQS backed services from the environment
+// Terraform gives the function.
+func NewAWSServices(ctx context.Context) (Services, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return Services{}, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return Services{
+		Blobs: &s3BlobStore{client: s3.NewFromConfig(cfg)},
+		Metadata: &sqsMetadataQueue{
+			client:    sqs.NewFromConfig(cfg),
+			queueName: os.Getenv("app_metadata_queue"),
+		},
+		AppsBucket: os.Getenv("apps_bucket"),
+	}, nil
+}
+
+/*****************************************************/
+// S3 blob store
+/*****************************************************/
+type s3BlobStore struct {
+	client *s3.Client
+}
+
+func (s *s3BlobStore) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, objectCallTimeout)
+	defer cancel()
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			err = ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucket, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object body: %w", err)
+	}
+	return body, nil
+}
+
+func (s *s3BlobStore) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check object %s in bucket %s: %w", key, bucket, err)
+	}
+	return true, nil
+}
+
+func (s *s3BlobStore) PutObject(ctx context.Context, bucket, key string, body []byte, attributes ObjectAttributes) error {
+	ctx, cancel := context.WithTimeout(ctx, objectCallTimeout)
+	defer cancel()
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if attributes.ContentType != "" {
+		input.ContentType = aws.String(attributes.ContentType)
+	}
+	if attributes.CacheControl != "" {
+		input.CacheControl = aws.String(attributes.CacheControl)
+	}
+	if attributes.SHA256 != "" {
+		digest, err := hex.DecodeString(attributes.SHA256)
+		if err != nil {
+			return fmt.Errorf("invalid SHA-256 for %s: %w", key, err)
+		}
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(digest))
+	}
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *s3BlobStore) DeleteObject(ctx context.Context, bucket, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, objectCallTimeout)
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+/*****************************************************/
+// SQS metadata queue
+/*****************************************************/
+type sqsMetadataQueue struct {
+	client    *sqs.Client
+	queueName string
+
+	// The queue URL is looked up on first use and kept for the warm container
+	mu       sync.Mutex
+	queueUrl *string
+}
+
+func (q *sqsMetadataQueue) getQueueUrl(ctx context.Context) (*string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queueUrl != nil {
+		return q.queueUrl, nil
+	}
+	queueUrlResp, err := q.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(q.queueName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue URL: %w", err)
+	}
+	q.queueUrl = queueUrlResp.QueueUrl
+	return q.queueUrl, nil
+}
+
+func (q *sqsMetadataQueue) SendAppMetadata(ctx context.Context, metadata AppMetadataMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	queueUrl, err := q.getQueueUrl(ctx)
+	if err != nil {
+		return err
+	}
+
+	messageBody, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	_, err = q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    queueUrl,
+		MessageBody: aws.String(string(messageBody)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message to SQS: %w", err)
+	}
+	return nil
+}
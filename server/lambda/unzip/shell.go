@@ -0,0 +1,39 @@
+package unzip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errShellContract reports a bundle the PWA shell could not run.
+var errShellContract = errors.New("bundle does not meet the PWA shell's contract")
+
+// The script the shell runs besides the declared entrypoint and service worker
+const shellScript = "app.js"
+
+// checkShellFiles checks that the entrypoint, app.js and the service worker
+// the publisher declared are files of the bundle with content: the shell
+// mounts nothing for an empty page and skips registering an empty service
+// worker. Uploads declared before the publisher named them are not checked.
+func checkShellFiles(declaration PublishDeclaration, files map[string]bundleFile) error {
+	if declaration.Entrypoint == "" {
+		return nil
+	}
+	for _, name := range []string{declaration.Entrypoint, shellScript, declaration.ServiceWorker} {
+		if name == "" {
+			continue
+		}
+		file, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is not at the root of the bundle", errShellContract, name)
+		}
+		body, err := file.read()
+		if err != nil {
+			return err
+		}
+		if len(body) == 0 {
+			return fmt.Errorf("%w: %s is empty", errShellContract, name)
+		}
+	}
+	return nil
+}
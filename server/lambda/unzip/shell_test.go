@@ -0,0 +1,72 @@
+package unzip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestShellFilesMustBeInTheBundle(t *testing.T) {
+	declaration := PublishDeclaration{Entrypoint: "index.html", ServiceWorker: "sw.js"}
+	complete := map[string][]byte{"index.html": []byte("<main></main>"), "app.js": []byte("run()"), "sw.js": []byte("self.skipWaiting()")}
+	tests := map[string]struct {
+		declaration PublishDeclaration
+		drop        string
+		empty       string
+		want        string
+	}{
+		"complete":             {declaration: declaration},
+		"undeclared":           {declaration: PublishDeclaration{}, drop: "sw.js"},
+		"no service worker":    {declaration: declaration, drop: "sw.js", want: "sw.js is not at the root of the bundle"},
+		"no script":            {declaration: declaration, drop: "app.js", want: "app.js is not at the root of the bundle"},
+		"empty service worker": {declaration: declaration, empty: "sw.js", want: "sw.js is empty"},
+		"empty page":           {declaration: declaration, empty: "index.html", want: "index.html is empty"},
+	}
+	for name, tt := range tests {
+		files := make(map[string][]byte, len(complete))
+		for path, body := range complete {
+			switch path {
+			case tt.drop:
+				continue
+			case tt.empty:
+				body = nil
+			}
+			files[path] = body
+		}
+		err := checkShellFiles(tt.declaration, iconBundle(files))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errShellContract) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want errShellContract with %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestShellFilesArePassedOn(t *testing.T) {
+	captureMetrics(t)
+	blobs := NewMemoryBlobStore()
+	queue := NewMemoryMetadataQueue()
+	handler := NewHandler(Services{Blobs: blobs, Metadata: queue, AppsBucket: testBucket})
+	ctx := context.Background()
+
+	source := putTestZip(t, blobs, [][2]string{
+		{"index.html", "<main></main>"},
+		{"app.js", "run()"},
+		{"sw.js", "self.skipWaiting()"},
+	})
+	declaration, _ := json.Marshal(PublishDeclaration{Entrypoint: "index.html", ServiceWorker: "sw.js"})
+	blobs.PutObject(ctx, testBucket, declarationKey(source.key), declaration, ObjectAttributes{})
+	if _, err := handler.processUpload(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	metadata := queue.Messages()[0]
+	if metadata.Entrypoint != "index.html" || metadata.ServiceWorker != "sw.js" {
+		t.Errorf("entrypoint %q and service worker %q were not passed on", metadata.Entrypoint, metadata.ServiceWorker)
+	}
+}
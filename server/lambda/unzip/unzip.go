@@ -1,199 +1,600 @@
-package main
+package unzip
 
 import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
-	"path/filepath"
+	"log/slog"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
 type AppMetadataMessage struct {
 	AppSlug         string    `json:"app_slug"`
 	VersionId       string    `json:"version_id"`
+	PublisherId     string    `json:"publisher_id"`
+	RequestId       string    `json:"request_id,omitempty"`
+	ReleaseId       string    `json:"release_id"`
 	S3FilePath      string    `json:"s3_file_path"`
 	UploadTimestamp time.Time `json:"upload_timestamp"`
 	ProcessedFiles  []string  `json:"processed_files"`
-	ManifestFound   bool      `json:"manifest_found"`
-	ManifestContent string    `json:"manifest_content,omitempty"`
-}
-
-func getMimeType(filename string) string {
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".html":
-		return "text/html"
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".json":
-		return "application/json"
-	case ".png":
-		return "image/png"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".svg":
-		return "image/svg+xml"
-	case ".onnx":
-		return "application/octet-stream"
-	default:
-		return "application/octet-stream"
-	}
-}
-
-func sendAppMetadataMessage(ctx context.Context, sqsClient *sqs.Client, queueName string, metadata AppMetadataMessage) error {
-	queueUrlResp, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
-		QueueName: aws.String(queueName),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to get queue URL: %w", err)
+	// Files is the content manifest of the release, in ProcessedFiles order
+	Files []FileEntry `json:"files"`
+	// Models describes each model the publish request declared
+	Models          []ModelInfo `json:"models,omitempty"`
+	ManifestFound   bool        `json:"manifest_found"`
+	ManifestContent string      `json:"manifest_content,omitempty"`
+	// Manifest is the normalized manifest.json, checked against the declared one
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// Icons are the manifest's raster icons and those generated for it
+	Icons []IconInfo `json:"icons,omitempty"`
+	// VersionNotes and Listing come from the publish declaration, with the
+	// screenshots measured
+	VersionNotes string        `json:"version_notes,omitempty"`
+	Listing      *StoreListing `json:"listing,omitempty"`
+	// Entrypoint and ServiceWorker come from the publish declaration, checked
+	// by checkShellFiles
+	Entrypoint    string `json:"entrypoint,omitempty"`
+	ServiceWorker string `json:"service_worker,omitempty"`
+}
+
+// FileEntry describes one extracted file. Path is relative to the release
+// prefix; SHA256 is the hex digest of its content. Blob is the key of the
+// shared blob a model or large binary is stored as instead of in the release.
+type FileEntry struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	ContentType  string `json:"content_type"`
+	CacheControl string `json:"cache_control"`
+	Blob         string `json:"blob,omitempty"`
+}
+
+// upload describes one bundle uploaded through a presigned publish URL, or
+// the completion marker of a delta upload.
+type upload struct {
+	bucket      string
+	key         string
+	appSlug     string
+	versionId   string
+	publisherId string
+	requestId   string
+	// delta uploads send their changed files one by one and are assembled
+	// from the file list in the publish declaration
+	delta bool
+}
+
+// extractionStats are reported as metrics for every processed upload.
+type extractionStats struct {
+	archiveBytes   int64
+	extractedFiles int
+	extractedBytes int64
+	// reusedBytes were not written because a release already stored the blob
+	reusedBytes int64
+}
+
+// parseUploadKey reads the upload attributes from a key in the format
+// uploads/{appSlug}/{versionId}/{publisherId}/{requestId}.zip, or
+// {requestId}.complete for a delta upload.
+func parseUploadKey(bucket, key string) (upload, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 || parts[0] != "uploads" {
+		return upload{}, false
 	}
+	return upload{
+		bucket:      bucket,
+		key:         key,
+		appSlug:     parts[1],
+		versionId:   parts[2],
+		publisherId: parts[3],
+		requestId:   strings.TrimSuffix(strings.TrimSuffix(parts[4], ".zip"), deltaCompleteSuffix),
+		delta:       strings.HasSuffix(parts[4], deltaCompleteSuffix),
+	}, true
+}
 
-	messageBody, err := json.Marshal(metadata)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+// releasePrefix is where an upload is extracted. Every upload gets its own
+// release prefix, which is only served once the publisher points
+// app/{appSlug}/current.json at it, so a half extracted upload is never live.
+func releasePrefix(source upload) string {
+	return fmt.Sprintf("app/%s/releases/%s/", source.appSlug, source.requestId)
+}
+
+// ModelFile is one model a publish request declares.
+type ModelFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Role string `json:"role"`
+}
+
+// PublishDeclaration is what the publisher stored about the upload when it
+// issued the upload URL. Files is only set for delta uploads, Bundle for
+// zips uploaded since their checksum is declared and Manifest since the
+// publisher validates manifests, and Entrypoint and ServiceWorker since it
+// checks the shell's contract. They, VersionNotes and Listing are passed on
+// to ingest.
+type PublishDeclaration struct {
+	Models        []ModelFile   `json:"models"`
+	Files         []DeltaFile   `json:"files,omitempty"`
+	Bundle        *Bundle       `json:"bundle,omitempty"`
+	Manifest      *Manifest     `json:"manifest,omitempty"`
+	Entrypoint    string        `json:"entrypoint,omitempty"`
+	ServiceWorker string        `json:"service_worker,omitempty"`
+	VersionNotes  string        `json:"version_notes,omitempty"`
+	Listing       *StoreListing `json:"listing,omitempty"`
+}
+
+// Bundle is the size and SHA-256 of the zip the client declared.
+type Bundle struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// verifyBundle checks the zip against its declaration before anything is
+// extracted from it. S3 already rejects a single PUT that does not match, so
+// this catches multipart uploads and zips written some other way.
+func verifyBundle(body []byte, check *Bundle) error {
+	if check == nil {
+		return nil
+	}
+	if int64(len(body)) != check.Size {
+		return fmt.Errorf("%w: zip is %d bytes, declared %d", errInvalidBundle, len(body), check.Size)
+	}
+	if check.SHA256 == "" {
+		return fmt.Errorf("%w: zip has no declared SHA-256", errInvalidBundle)
+	}
+	if digest := sha256.Sum256(body); hex.EncodeToString(digest[:]) != check.SHA256 {
+		return fmt.Errorf("%w: zip does not match its declared SHA-256", errInvalidBundle)
+	}
+	return nil
+}
+
+// The metadata message carries an entry per file, and ingest stores it as
+// the app record. The publisher limits what a publish request declares so
+// both fit in SQS's 256 KB per message and DynamoDB's 400 KB per item; a
+// bundle can hold more than it declared, so unzip checks again.
+const (
+	maxBundleFiles    = 250
+	maxFilePathLength = 128
+	// SQS counts message attributes against the 256 KB too
+	maxMetadataMessageBytes = 250 * 1024
+)
+
+var errBundleTooLarge = errors.New("bundle exceeds the limits of unzip")
+
+func checkBundleLimits(bundle []bundleFile) error {
+	if len(bundle) > maxBundleFiles {
+		return fmt.Errorf("%w: %d files, at most %d", errBundleTooLarge, len(bundle), maxBundleFiles)
+	}
+	for _, file := range bundle {
+		if len(file.name) > maxFilePathLength {
+			return fmt.Errorf("%w: file name longer than %d bytes: %.40q", errBundleTooLarge, maxFilePathLength, file.name)
+		}
 	}
+	return nil
+}
 
-	_, err = sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    queueUrlResp.QueueUrl,
-		MessageBody: aws.String(string(messageBody)),
-	})
+func checkMessageSize(metadata AppMetadataMessage) error {
+	body, err := json.Marshal(metadata)
 	if err != nil {
-		return fmt.Errorf("failed to send message to SQS: %w", err)
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if len(body) > maxMetadataMessageBytes {
+		return fmt.Errorf("%w: the message is %d bytes, at most %d", errBundleTooLarge, len(body), maxMetadataMessageBytes)
 	}
 	return nil
 }
 
-func handleRequest(ctx context.Context, s3Event events.S3Event) error {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// declarationKey is where the publisher stores the publish declaration: the
+// upload key with a .json extension.
+func declarationKey(key string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(key, ".zip"), deltaCompleteSuffix) + ".json"
+}
+
+// loadDeclaration reads the publish declaration of an upload. Uploads issued
+// before declarations existed have none, and found is false.
+func (h *Handler) loadDeclaration(ctx context.Context, source upload) (declaration PublishDeclaration, found bool, err error) {
+	body, err := h.services.Blobs.GetObject(ctx, source.bucket, declarationKey(source.key))
+	if errors.Is(err, ErrObjectNotFound) {
+		return PublishDeclaration{}, false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return PublishDeclaration{}, false, err
 	}
-	s3Client := s3.NewFromConfig(cfg)
-	sqsClient := sqs.NewFromConfig(cfg)
+	if err := json.Unmarshal(body, &declaration); err != nil {
+		return PublishDeclaration{}, false, fmt.Errorf("%w: invalid publish declaration: %v", errInvalidBundle, err)
+	}
+	return declaration, true, nil
+}
 
-	appsBucket := os.Getenv("apps_bucket")
-	queueName := os.Getenv("app_metadata_queue")
+// archiveKey is where an upload is kept once its metadata is enqueued:
+// archive/{appSlug}/{versionId}/{publisherId}/{requestId}.zip
+func archiveKey(source upload) string {
+	return "archive/" + strings.TrimPrefix(source.key, "uploads/")
+}
 
-	for _, record := range s3Event.Records {
-		sourceBucket := record.S3.Bucket.Name
-		sourceKey := record.S3.Object.Key
+// Handler extracts uploaded app bundles into the apps bucket.
+type Handler struct {
+	services Services
+}
+
+func NewHandler(services Services) *Handler {
+	return &Handler{services: services}
+}
 
-		log.Printf("Processing file from bucket %s, key %s", sourceBucket, sourceKey)
+// bundleFile is one file of an upload, read when it is extracted.
+type bundleFile struct {
+	name string
+	read func() ([]byte, error)
+}
 
-		// Expected sourceKey format: uploads/{appSlug}/{versionId}/{some_uuid}.zip
-		parts := strings.Split(sourceKey, "/")
-		if len(parts) != 4 || parts[0] != "uploads" {
-			log.Printf("Invalid key format, skipping: %s", sourceKey)
+// A zip of at most 128 MB can still expand to far more than the Lambda has
+// memory for, and its headers only state the sizes its writer chose to, so
+// reads are capped rather than trusting them. No file of a bundle comes
+// close: a model is at most 25 MB.
+const (
+	maxExtractedFileBytes = 32 * 1024 * 1024
+	maxExtractedBytes     = 256 * 1024 * 1024
+)
+
+// zipFiles lists the files of a zipped bundle. Reading a file fails with
+// errBundleTooLarge once it, or all files read so far, expand past the caps.
+func zipFiles(body []byte) ([]bundleFile, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a zip: %v", errInvalidBundle, err)
+	}
+	var files []bundleFile
+	var extracted int64
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
 			continue
 		}
-		appSlug := parts[1]
-		versionId := parts[2]
+		// Files are read again for icons and listings; only count them once
+		counted := false
+		files = append(files, bundleFile{name: file.Name, read: func() ([]byte, error) {
+			rc, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to open %s in zip: %v", errInvalidBundle, file.Name, err)
+			}
+			defer rc.Close()
+			body, err := io.ReadAll(io.LimitReader(rc, maxExtractedFileBytes+1))
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to read %s from zip: %v", errInvalidBundle, file.Name, err)
+			}
+			if len(body) > maxExtractedFileBytes {
+				return nil, fmt.Errorf("%w: %s expands past %d MB", errBundleTooLarge, file.Name, maxExtractedFileBytes/(1024*1024))
+			}
+			if !counted {
+				counted = true
+				extracted += int64(len(body))
+				if extracted > maxExtractedBytes {
+					return nil, fmt.Errorf("%w: the files expand past %d MB", errBundleTooLarge, maxExtractedBytes/(1024*1024))
+				}
+			}
+			return body, nil
+		}})
+	}
+	return files, nil
+}
 
-		resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: aws.String(sourceBucket),
-			Key:    aws.String(sourceKey),
-		})
-		if err != nil {
-			return fmt.Errorf("failed to get object %s from bucket %s: %w", sourceKey, sourceBucket, err)
-		}
-		defer resp.Body.Close()
+// processUpload extracts the upload, enqueues its metadata and then moves the
+// zip to the archive prefix. Any failure fails the invocation with the zip
+// still in place, so the retry Lambda makes for S3 events starts over. Every
+// step can run twice: files and the archive copy are overwritten, and ingest
+// keys the app on the upload, so a second message updates the same app.
+func (h *Handler) processUpload(ctx context.Context, source upload) (extractionStats, error) {
+	var stats extractionStats
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read object body: %w", err)
+	// S3 can deliver a notification more than once. The zip, or the marker
+	// of a delta upload, is only removed once a previous delivery has
+	// finished with it.
+	var body []byte
+	var err error
+	if source.delta {
+		var exists bool
+		exists, err = h.services.Blobs.ObjectExists(ctx, source.bucket, source.key)
+		if err == nil && !exists {
+			err = ErrObjectNotFound
 		}
+	} else {
+		body, err = h.services.Blobs.GetObject(ctx, source.bucket, source.key)
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		slog.InfoContext(ctx, "Upload already processed, skipping", "key", source.key)
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	stats.archiveBytes = int64(len(body))
 
-		zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
-		if err != nil {
-			return fmt.Errorf("failed to create zip reader: %w", err)
+	// Declared models must all be in the bundle. Without a declaration the
+	// upload predates them and model.onnx is inspected if it is there.
+	declaration, declared, err := h.loadDeclaration(ctx, source)
+	if err != nil {
+		return stats, err
+	}
+	if !declared {
+		declaration.Models = []ModelFile{{Name: "model", Path: modelFileName, Role: "default"}}
+	}
+	declaredModels := make(map[string]ModelFile, len(declaration.Models))
+	for _, model := range declaration.Models {
+		declaredModels[model.Path] = model
+	}
+
+	if !source.delta {
+		if err := verifyBundle(body, declaration.Bundle); err != nil {
+			return stats, err
 		}
+	}
 
-		var processedFiles []string
-		var manifestContent string
-		manifestFound := false
+	var bundle []bundleFile
+	if source.delta {
+		bundle, err = h.deltaFiles(ctx, source, declaration)
+	} else {
+		bundle, err = zipFiles(body)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if err := checkBundleLimits(bundle); err != nil {
+		return stats, err
+	}
 
-		for _, file := range zipReader.File {
-			if file.FileInfo().IsDir() {
-				continue
-			}
+	var processedFiles []string
+	var files []FileEntry
+	var models []ModelInfo
+	var manifestContent string
+	manifestFound := false
+	// processedFiles lists what the release serves, so each name must be
+	// extracted once; with a repeated name only the last copy would be served
+	seen := make(map[string]bool, len(bundle))
 
-			rc, err := file.Open()
-			if err != nil {
-				return fmt.Errorf("failed to open file in zip: %w", err)
-			}
-			defer rc.Close()
+	for _, file := range bundle {
+		if seen[file.name] {
+			return stats, fmt.Errorf("%w: duplicate file name in zip: %q", errInvalidBundle, file.name)
+		}
+		seen[file.name] = true
+
+		fileBody, err := file.read()
+		if err != nil {
+			return stats, err
+		}
+
+		// Check if this is a manifest file
+		if strings.ToLower(file.name) == "manifest.json" {
+			manifestFound = true
+			manifestContent = string(fileBody)
+		}
 
-			fileBody, err := io.ReadAll(rc)
+		// Keys are joined rather than cleaned, so a name must not climb out
+		// of the release prefix
+		if name := path.Clean(file.name); name != file.name || name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+			return stats, fmt.Errorf("%w: invalid file name in zip: %q", errInvalidBundle, file.name)
+		}
+		declaredModel, isModel := declaredModels[file.name]
+		if isModel {
+			// A model onnxruntime cannot load would only fail in the browser
+			model, err := inspectModel(file.name, fileBody)
 			if err != nil {
-				return fmt.Errorf("failed to read file content from zip: %w", err)
+				return stats, err
 			}
-
-			// Check if this is a manifest file
-			if strings.ToLower(file.Name) == "manifest.json" {
-				manifestFound = true
-				manifestContent = string(fileBody)
+			model.Name = declaredModel.Name
+			model.Role = declaredModel.Role
+			if len(model.UnsupportedOperators) > 0 {
+				slog.WarnContext(ctx, "Model needs operators onnxruntime-web does not support",
+					"model", model.Name, "operators", model.UnsupportedOperators)
 			}
+			models = append(models, *model)
+		}
 
-			destKey := filepath.Join("app", appSlug, file.Name)
-			contentType := getMimeType(file.Name)
+		destKey := releasePrefix(source) + file.name
+		digest := sha256.Sum256(fileBody)
+		entry := FileEntry{
+			Path:         file.name,
+			Size:         int64(len(fileBody)),
+			SHA256:       hex.EncodeToString(digest[:]),
+			ContentType:  getMimeType(file.name, fileBody),
+			CacheControl: cacheControlFor(file.name),
+		}
 
-			_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
-				Bucket:      aws.String(appsBucket),
-				Key:         aws.String(destKey),
-				Body:        bytes.NewReader(fileBody),
-				ContentType: aws.String(contentType),
-			})
+		if isSharedBlob(entry, isModel) {
+			blob, reused, err := h.storeBlob(ctx, source, entry, fileBody)
 			if err != nil {
-				return fmt.Errorf("failed to upload unzipped file %s: %w", destKey, err)
+				return stats, err
+			}
+			if reused {
+				stats.reusedBytes += entry.Size
+			}
+			destKey = blob
+			entry.Blob = blob
+			entry.CacheControl = cacheImmutable
+		} else {
+			attributes := ObjectAttributes{ContentType: entry.ContentType, CacheControl: entry.CacheControl, SHA256: entry.SHA256}
+			if err := h.services.Blobs.PutObject(ctx, h.services.AppsBucket, destKey, fileBody, attributes); err != nil {
+				return stats, fmt.Errorf("failed to upload unzipped file %s: %w", destKey, err)
 			}
-			log.Printf("Successfully uploaded %s", destKey)
-			processedFiles = append(processedFiles, destKey)
 		}
+		slog.DebugContext(ctx, "Uploaded extracted file", "key", destKey)
+		processedFiles = append(processedFiles, destKey)
+		files = append(files, entry)
+		stats.extractedFiles++
+		stats.extractedBytes += int64(len(fileBody))
+	}
 
-		// Send metadata message to SQS
-		metadata := AppMetadataMessage{
-			AppSlug:         appSlug,
-			VersionId:       versionId,
-			S3FilePath:      fmt.Sprintf("app/%s/", appSlug),
-			UploadTimestamp: time.Now(),
-			ProcessedFiles:  processedFiles,
-			ManifestFound:   manifestFound,
-			ManifestContent: manifestContent,
+	if declared && len(models) != len(declaration.Models) {
+		return stats, fmt.Errorf("%w: %d of %d declared models are missing from the bundle",
+			errInvalidModel, len(declaration.Models)-len(models), len(declaration.Models))
+	}
+	manifest, err := checkManifest(declaration.Manifest, []byte(manifestContent), manifestFound, seen)
+	if err != nil {
+		return stats, err
+	}
+	bundleByName := make(map[string]bundleFile, len(bundle))
+	for _, file := range bundle {
+		bundleByName[file.name] = file
+	}
+	if err := checkShellFiles(declaration, bundleByName); err != nil {
+		return stats, err
+	}
+	icons, generated, err := inspectIcons(manifest, bundleByName)
+	if err != nil {
+		return stats, err
+	}
+	if err := inspectScreenshots(declaration.Listing, bundleByName); err != nil {
+		return stats, err
+	}
+	for _, icon := range generated {
+		destKey := releasePrefix(source) + icon.info.Path
+		digest := sha256.Sum256(icon.body)
+		entry := FileEntry{
+			Path:         icon.info.Path,
+			Size:         int64(len(icon.body)),
+			SHA256:       hex.EncodeToString(digest[:]),
+			ContentType:  icon.info.Type,
+			CacheControl: cacheControlFor(icon.info.Path),
 		}
-
-		if err := sendAppMetadataMessage(ctx, sqsClient, queueName, metadata); err != nil {
-			log.Printf("Failed to send metadata message: %v", err)
+		attributes := ObjectAttributes{ContentType: entry.ContentType, CacheControl: entry.CacheControl, SHA256: entry.SHA256}
+		if err := h.services.Blobs.PutObject(ctx, h.services.AppsBucket, destKey, icon.body, attributes); err != nil {
+			return stats, fmt.Errorf("failed to upload generated icon %s: %w", destKey, err)
 		}
+		slog.DebugContext(ctx, "Uploaded generated icon", "key", destKey)
+		processedFiles = append(processedFiles, destKey)
+		files = append(files, entry)
+	}
 
-		// Delete the original zip file
-		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(sourceBucket),
-			Key:    aws.String(sourceKey),
-		})
-		if err != nil {
-			log.Printf("Failed to delete original zip file %s: %v", sourceKey, err)
-		} else {
-			log.Printf("Successfully deleted original zip file %s", sourceKey)
+	// Send metadata message to SQS
+	metadata := AppMetadataMessage{
+		AppSlug:         source.appSlug,
+		VersionId:       source.versionId,
+		PublisherId:     source.publisherId,
+		RequestId:       source.requestId,
+		ReleaseId:       source.requestId,
+		S3FilePath:      releasePrefix(source),
+		UploadTimestamp: time.Now(),
+		ProcessedFiles:  processedFiles,
+		Files:           files,
+		Models:          models,
+		ManifestFound:   manifestFound,
+		ManifestContent: manifestContent,
+		Manifest:        manifest,
+		Icons:           icons,
+		VersionNotes:    declaration.VersionNotes,
+		Listing:         declaration.Listing,
+		Entrypoint:      declaration.Entrypoint,
+		ServiceWorker:   declaration.ServiceWorker,
+	}
+
+	if err := checkMessageSize(metadata); err != nil {
+		return stats, err
+	}
+	if err := h.services.Metadata.SendAppMetadata(ctx, metadata); err != nil {
+		return stats, fmt.Errorf("failed to send metadata message: %w", err)
+	}
+
+	// Only now that the metadata is durably queued can the upload leave
+	// uploads/. The zip, or the marker of a delta upload, goes last, as its
+	// absence marks the upload processed.
+	if !source.delta {
+		if err := h.services.Blobs.PutObject(ctx, source.bucket, archiveKey(source), body, ObjectAttributes{ContentType: "application/zip"}); err != nil {
+			return stats, fmt.Errorf("failed to archive upload: %w", err)
+		}
+	}
+	if declared {
+		if err := h.archiveDeclaration(ctx, source, declaration); err != nil {
+			return stats, err
 		}
 	}
+	if err := h.services.Blobs.DeleteObject(ctx, source.bucket, source.key); err != nil {
+		return stats, fmt.Errorf("failed to delete archived upload: %w", err)
+	}
+	if source.delta {
+		// Files left behind by a failure here expire with uploads/
+		if err := h.deleteDeltaUploads(ctx, source, declaration); err != nil {
+			slog.WarnContext(ctx, "Failed to delete uploaded files", "key", source.key, "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "Archived upload", "key", source.key, "archive_key", archiveKey(source), "files", len(processedFiles))
+	return stats, nil
+}
+
+func (h *Handler) archiveDeclaration(ctx context.Context, source upload, declaration PublishDeclaration) error {
+	body, err := json.Marshal(declaration)
+	if err != nil {
+		return fmt.Errorf("failed to marshal publish declaration: %w", err)
+	}
+	if err := h.services.Blobs.PutObject(ctx, source.bucket, declarationKey(archiveKey(source)), body, ObjectAttributes{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to archive publish declaration: %w", err)
+	}
+	if err := h.services.Blobs.DeleteObject(ctx, source.bucket, declarationKey(source.key)); err != nil {
+		return fmt.Errorf("failed to delete archived publish declaration: %w", err)
+	}
 	return nil
 }
 
-func main() {
-	lambda.Start(handleRequest)
+// recordExtractionMetrics reports the outcome, duration and sizes of one upload.
+func recordExtractionMetrics(stats extractionStats, duration time.Duration, err error) {
+	outcome := outcomeSuccess
+	if isPermanentFailure(err) {
+		outcome = outcomeClientError
+	} else if err != nil {
+		outcome = outcomeServerError
+	}
+	emitMetrics("unzip", outcome,
+		countMetric("Extractions", 1),
+		durationMetric("ExtractionDuration", duration),
+		bytesMetric("ArchiveBytes", stats.archiveBytes),
+		countMetric("ExtractedFiles", stats.extractedFiles),
+		bytesMetric("ExtractedBytes", stats.extractedBytes),
+		bytesMetric("ReusedBlobBytes", stats.reusedBytes),
+	)
+}
+
+// HandleRequest is the Lambda entry point for S3 upload notifications. An
+// upload that breaks the publish contract is rejected instead of failing the
+// invocation, which S3 would retry to no avail.
+func (h *Handler) HandleRequest(ctx context.Context, s3Event events.S3Event) error {
+	if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = withRequestId(ctx, lambdaCtx.AwsRequestID)
+	}
+
+	for _, record := range s3Event.Records {
+		// Object keys in S3 event notifications are URL encoded
+		sourceKey, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid key encoding, skipping", "key", record.S3.Object.Key)
+			continue
+		}
+		source, ok := parseUploadKey(record.S3.Bucket.Name, sourceKey)
+		if !ok {
+			slog.WarnContext(ctx, "Invalid key format, skipping", "key", sourceKey)
+			continue
+		}
+		// The upload is named after the publish request, so logging under its
+		// id ties the extraction to the API call that started it
+		ctx := withRequestId(ctx, source.requestId)
+		slog.InfoContext(ctx, "Processing upload", "bucket", source.bucket, "key", source.key)
+
+		start := time.Now()
+		stats, err := h.processUpload(ctx, source)
+		recordExtractionMetrics(stats, time.Since(start), err)
+		if isPermanentFailure(err) {
+			err = h.rejectUpload(ctx, source, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
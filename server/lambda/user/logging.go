@@ -0,0 +1,110 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/*****************************************************/
+// Structured logging
+/*****************************************************/
+type requestIdContextKey struct{}
+
+const redactedValue = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// JWTs are three base64url segments, the first always starts with eyJ ({")
+	tokenPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+)
+
+var sensitiveLogKeys = map[string]bool{
+	"authorization": true,
+	"token":         true,
+	"access_token":  true,
+	"id_token":      true,
+	"refresh_token": true,
+	"password":      true,
+	"claims":        true,
+	"email":         true,
+}
+
+// contextHandler adds the request id carried by the context to every record
+// and redacts tokens and email addresses from the message.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.Message = redactString(record.Message)
+	if requestId := requestIdFromContext(ctx); requestId != "" {
+		record.AddAttrs(slog.String("request_id", requestId))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func redactString(value string) string {
+	value = tokenPattern.ReplaceAllString(value, redactedValue)
+	return emailPattern.ReplaceAllString(value, redactedValue)
+}
+
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redactedValue)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, redactString(err.Error()))
+		}
+	}
+	return attr
+}
+
+// parseLogLevel reads LOG_LEVEL (debug, info, warn or error), defaulting to info.
+func parseLogLevel(value string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func newLogger() *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       parseLogLevel(os.Getenv("LOG_LEVEL")),
+		ReplaceAttr: redactAttr,
+	})
+	return slog.New(contextHandler{handler})
+}
+
+func withRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey{}, requestId)
+}
+
+func requestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdContextKey{}).(string)
+	return requestId
+}
+
+// SetupLogging installs the redacting JSON logger as the slog default. The
+// lambda and devserver mains call it before anything logs.
+func SetupLogging() {
+	slog.SetDefault(newLogger())
+}
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+/*****************************************************/
+// In-memory services
+/*****************************************************/
+// The in-memory services stand in for Cognito in tests and in the local
+// development server.
+
+// NewMemoryServices returns services backed by a fresh in-memory user pool.
+func NewMemoryServices() Services {
+	return Services{Identity: NewMemoryIdentityProvider()}
+}
+
+// MemoryIdentityProvider keeps group memberships per user pool and user.
+type MemoryIdentityProvider struct {
+	mu     sync.Mutex
+	groups map[[2]string]map[string]bool
+}
+
+func NewMemoryIdentityProvider() *MemoryIdentityProvider {
+	return &MemoryIdentityProvider{groups: make(map[[2]string]map[string]bool)}
+}
+
+func (p *MemoryIdentityProvider) AddUserToGroup(ctx context.Context, userPoolId, username, groupName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := [2]string{userPoolId, username}
+	if p.groups[key] == nil {
+		p.groups[key] = make(map[string]bool)
+	}
+	p.groups[key][groupName] = true
+	return nil
+}
+
+func (p *MemoryIdentityProvider) ListGroupsForUser(ctx context.Context, userPoolId, username string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var groups []string
+	for group := range p.groups[[2]string{userPoolId, username}] {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (p *MemoryIdentityProvider) RemoveUserFromGroup(ctx context.Context, userPoolId, username, groupName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.groups[[2]string{userPoolId, username}], groupName)
+	return nil
+}
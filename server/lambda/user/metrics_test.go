@@ -0,0 +1,71 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := metricsOutput
+	metricsOutput = &buf
+	t.Cleanup(func() { metricsOutput = previous })
+	return &buf
+}
+
+func decodeMetricLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("metric line is not JSON: %v: %s", err, line)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func newAPIRequest(method, path string) events.APIGatewayV2HTTPRequest {
+	var request events.APIGatewayV2HTTPRequest
+	request.RawPath = path
+	request.RequestContext.RequestID = "req-123"
+	request.RequestContext.HTTP.Method = method
+	return request
+}
+
+func TestUserRoleUpdateEmitsRequestMetrics(t *testing.T) {
+	buf := captureMetrics(t)
+	request := newAPIRequest("PUT", "/default/user-role")
+	request.RequestContext.Stage = "default"
+	request.Body = "not json"
+
+	response, err := NewHandler(NewMemoryServices()).handleAPIGateway(context.Background(), request)
+	if err != nil || response.StatusCode != 400 {
+		t.Fatalf("expected 400 for invalid body, got %d: %v", response.StatusCode, err)
+	}
+
+	lines := decodeMetricLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 metric line, got %d", len(lines))
+	}
+	line := lines[0]
+	if line["Service"] != "user" || line["Route"] != "PUT /user-role" || line["Outcome"] != outcomeClientError {
+		t.Errorf("unexpected dimensions: %v", line)
+	}
+	if line["Requests"] != 1.0 {
+		t.Errorf("expected Requests=1, got %v", line["Requests"])
+	}
+	if _, ok := line["Latency"].(float64); !ok {
+		t.Errorf("missing Latency: %v", line)
+	}
+}
@@ -0,0 +1,94 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+)
+
+/*****************************************************/
+// Identity provider interface
+/*****************************************************/
+// IdentityProvider manages the role groups of users in a user pool.
+type IdentityProvider interface {
+	AddUserToGroup(ctx context.Context, userPoolId, username, groupName string) error
+	ListGroupsForUser(ctx context.Context, userPoolId, username string) ([]string, error)
+	RemoveUserFromGroup(ctx context.Context, userPoolId, username, groupName string) error
+}
+
+// Services are the clients the handler uses. They are created once per cold
+// start and shared by every invocation.
+type Services struct {
+	Identity IdentityProvider
+}
+
+// awsCallTimeout bounds each Cognito call, retries included, so one slow call
+// fails on its own instead of using up the whole invocation.
+const awsCallTimeout = 5 * time.Second
+
+// NewAWSServices creates the Cognito backed services.
+func NewAWSServices(ctx context.Context) (Services, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return Services{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	return Services{
+		Identity: &cognitoIdentityProvider{client: cognitoidentityprovider.NewFromConfig(cfg)},
+	}, nil
+}
+
+/*****************************************************/
+// Cognito identity provider
+/*****************************************************/
+type cognitoIdentityProvider struct {
+	client *cognitoidentityprovider.Client
+}
+
+func (p *cognitoIdentityProvider) AddUserToGroup(ctx context.Context, userPoolId, username, groupName string) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	_, err := p.client.AdminAddUserToGroup(ctx, &cognitoidentityprovider.AdminAddUserToGroupInput{
+		UserPoolId: aws.String(userPoolId),
+		Username:   aws.String(username),
+		GroupName:  aws.String(groupName),
+	})
+	return err
+}
+
+func (p *cognitoIdentityProvider) ListGroupsForUser(ctx context.Context, userPoolId, username string) ([]string, error) {
+	// A user belongs to a handful of role groups, but follow the pages anyway
+	paginator := cognitoidentityprovider.NewAdminListGroupsForUserPaginator(p.client, &cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(userPoolId),
+		Username:   aws.String(username),
+	})
+	var groups []string
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.Groups {
+			if group.GroupName != nil {
+				groups = append(groups, *group.GroupName)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (p *cognitoIdentityProvider) RemoveUserFromGroup(ctx context.Context, userPoolId, username, groupName string) error {
+	ctx, cancel := context.WithTimeout(ctx, awsCallTimeout)
+	defer cancel()
+	_, err := p.client.AdminRemoveUserFromGroup(ctx, &cognitoidentityprovider.AdminRemoveUserFromGroupInput{
+		UserPoolId: aws.String(userPoolId),
+		Username:   aws.String(username),
+		GroupName:  aws.String(groupName),
+	})
+	return err
+}
@@ -1,20 +1,15 @@
-package main
+package user
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"regexp"
+	"log/slog"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
-	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
 /*****************************************************/
@@ -30,46 +25,31 @@ const (
 )
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error         string `json:"error"`
+	CorrelationId string `json:"correlationId,omitempty"`
 }
 
-var cognitoClient *cognitoidentityprovider.CognitoIdentityProvider
-var lambdaClient *awslambda.Lambda
-var publishRouteRegex *regexp.Regexp
-var subscribeGetAppsRouteRegex *regexp.Regexp
-var subscribePostSubscriptionRouteRegex *regexp.Regexp
-
-func init() {
-	sess := session.Must(session.NewSession())
-	cognitoClient = cognitoidentityprovider.New(sess)
-	lambdaClient = awslambda.New(sess)
+// Handler serves the user routes and the Cognito post confirmation trigger.
+type Handler struct {
+	services Services
+	router   *router
+}
 
-	// Compile regex for publish route: publish/{app-slug}/version/{version-id}
-	publishRouteRegex = regexp.MustCompile(`publish/[^/]+/version/[^/]+`)
-	subscribeGetAppsRouteRegex = regexp.MustCompile(`apps`)
-	subscribePostSubscriptionRouteRegex = regexp.MustCompile(`subscribe`)
+func NewHandler(services Services) *Handler {
+	// Publish, catalog and publisher routes are integrated directly with their
+	// own lambdas in API Gateway, this lambda only serves the user routes.
+	h := &Handler{services: services, router: newRouter()}
+	h.router.handle("PUT", "/user-role", h.handleUserRoleUpdate)
+	return h
 }
 
 /*****************************************************/
 // Helper functions
 /*****************************************************/
 
-func addUserToGroup(userPoolId, username, groupName string) error {
-	input := &cognitoidentityprovider.AdminAddUserToGroupInput{
-		UserPoolId: aws.String(userPoolId),
-		Username:   aws.String(username),
-		GroupName:  aws.String(groupName),
-	}
-	_, err := cognitoClient.AdminAddUserToGroup(input)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func parseCustomRoles(rolesString string) []string {
+func parseCustomRoles(ctx context.Context, rolesString string) []string {
 	if rolesString == "" {
-		log.Println("No preferred roles found, defaulting to Subscriber")
+		slog.InfoContext(ctx, "No preferred roles found, defaulting to Subscriber")
 		return []string{"Subscriber"}
 	}
 
@@ -83,7 +63,7 @@ func parseCustomRoles(rolesString string) []string {
 		}
 	}
 	if len(cleanRoles) == 0 {
-		log.Println("No valid roles found, defaulting to Subscriber")
+		slog.InfoContext(ctx, "No valid roles found, defaulting to Subscriber")
 		return []string{"Subscriber"}
 	}
 	return cleanRoles
@@ -101,39 +81,13 @@ func getUserFromJWT(event events.APIGatewayV2HTTPRequest) (string, error) {
 		return sub, nil
 	}
 
-	return "", fmt.Errorf("no valid username found in JWT claims: %+v", claims)
-}
-
-func getCurrentUserGroups(userPoolId, username string) ([]string, error) {
-	input := &cognitoidentityprovider.AdminListGroupsForUserInput{
-		UserPoolId: aws.String(userPoolId),
-		Username:   aws.String(username),
-	}
-
-	result, err := cognitoClient.AdminListGroupsForUser(input)
-	if err != nil {
-		return nil, err
-	}
-
-	var groups []string
-	for _, group := range result.Groups {
-		if group.GroupName != nil {
-			groups = append(groups, *group.GroupName)
-		}
-	}
-	return groups, nil
+	return "", errors.New("no valid username found in JWT claims")
 }
 
-func removeUserFromGroups(userPoolId, username string, groups []string) error {
+func (h *Handler) removeUserFromGroups(ctx context.Context, userPoolId, username string, groups []string) error {
 	for _, group := range groups {
-		input := &cognitoidentityprovider.AdminRemoveUserFromGroupInput{
-			UserPoolId: aws.String(userPoolId),
-			Username:   aws.String(username),
-			GroupName:  aws.String(group),
-		}
-		_, err := cognitoClient.AdminRemoveUserFromGroup(input)
-		if err != nil {
-			log.Printf("Failed to remove user %s from group %s: %v", username, group, err)
+		if err := h.services.Identity.RemoveUserFromGroup(ctx, userPoolId, username, group); err != nil {
+			slog.ErrorContext(ctx, "Failed to remove user from group", "user", username, "group", group, "error", err)
 			return err
 		}
 	}
@@ -167,26 +121,26 @@ func createSuccessResponse(statusCode int, data interface{}) events.APIGatewayV2
 // Main handler function
 /*****************************************************/
 
-func handlePostConfirmation(
+func (h *Handler) handlePostConfirmation(
+	ctx context.Context,
 	event events.CognitoEventUserPoolsPostConfirmation,
 ) (events.CognitoEventUserPoolsPostConfirmation, error) {
 	var roles = []string{}
 	if customRoles, exists := event.Request.UserAttributes["custom:preferred_roles"]; exists {
-		roles = parseCustomRoles(customRoles)
+		roles = parseCustomRoles(ctx, customRoles)
 	} else {
 		roles = []string{"Subscriber"}
-		log.Println("No preferred roles found, defaulting to Subscriber for user: ", event.UserName)
+		slog.InfoContext(ctx, "No preferred roles found, defaulting to Subscriber", "user", event.UserName)
 	}
 
 	userPoolId := event.UserPoolID
 	for _, role := range roles {
-		err := addUserToGroup(userPoolId, event.UserName, role)
+		err := h.services.Identity.AddUserToGroup(ctx, userPoolId, event.UserName, role)
 		if err != nil {
-			log.Printf(
-				"Failed to add user %s to group %s: %v",
-				event.UserName,
-				role,
-				err,
+			slog.ErrorContext(ctx, "Failed to add user to group",
+				"user", event.UserName,
+				"group", role,
+				"error", err,
 			)
 			continue
 		}
@@ -194,76 +148,14 @@ func handlePostConfirmation(
 	return event, nil
 }
 
-// Generic function to relay requests to any lambda function
-func relayToLambda(event events.APIGatewayV2HTTPRequest, functionName, lambdaType string) (events.APIGatewayV2HTTPResponse, error) {
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		return createErrorResponse(500, "Failed to marshal event")
-	}
-	result, err := lambdaClient.Invoke(&awslambda.InvokeInput{
-		FunctionName: aws.String(functionName),
-		Payload:      eventJSON,
-	})
-	if err != nil {
-		log.Printf("Failed to invoke %s lambda: %v", lambdaType, err)
-		return createErrorResponse(500, fmt.Sprintf("Failed to process %s request", lambdaType))
-	}
-	var response events.APIGatewayV2HTTPResponse
-	if err := json.Unmarshal(result.Payload, &response); err != nil {
-		log.Printf("Failed to unmarshal %s response: %v", lambdaType, err)
-		return createErrorResponse(500, fmt.Sprintf("Invalid response from %s", lambdaType))
-	}
-	return response, nil
-}
-
-func relayToPublisherLambda(event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	publisherFunctionName := os.Getenv("PUBLISHER_FUNCTION_NAME")
-	return relayToLambda(event, publisherFunctionName, "publisher")
-}
-
-func relayToSubscriberLambda(event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	subscriberFunctionName := os.Getenv("SUBSCRIBER_FUNCTION_NAME")
-	return relayToLambda(event, subscriberFunctionName, "subscriber")
-}
-
-func handleAPIGateway(
+func (h *Handler) handleAPIGateway(
+	ctx context.Context,
 	event events.APIGatewayV2HTTPRequest,
 ) (events.APIGatewayV2HTTPResponse, error) {
-
-	// Debug logging
-	log.Printf("Received request - Method: %s, RawPath: %s, Path: %s",
-		event.RequestContext.HTTP.Method, event.RawPath, event.RequestContext.HTTP.Path)
-
-	// Check if this is a publish request using regex
-	if event.RequestContext.HTTP.Method == "POST" && publishRouteRegex.MatchString(event.RawPath) {
-		log.Printf("Routing to publisher lambda (matched regex pattern)")
-		return relayToPublisherLambda(event)
-	}
-
-	// Check if this is a subscribe request using regex
-	if event.RequestContext.HTTP.Method == "POST" && subscribePostSubscriptionRouteRegex.MatchString(event.RawPath) {
-		log.Printf("Routing to subscriber lambda (matched regex pattern)")
-		return relayToSubscriberLambda(event)
-	}
-
-	if event.RequestContext.HTTP.Method == "GET" && subscribeGetAppsRouteRegex.MatchString(event.RawPath) {
-		log.Printf("Routing to subscriber lambda (matched regex pattern)")
-		return relayToSubscriberLambda(event)
-	}
-
-	// Handle user role management (PUT /user-role)
-	if event.RequestContext.HTTP.Method == "PUT" &&
-		(event.RawPath == "/user-role" || strings.HasSuffix(event.RawPath, "/user-role")) {
-		log.Printf("Routing to user role update")
-		return handleUserRoleUpdate(event)
-	}
-
-	log.Printf("No matching route found for Method: %s, RawPath: %s",
-		event.RequestContext.HTTP.Method, event.RawPath)
-	return createErrorResponse(404, "Endpoint not found")
+	return h.router.dispatch(ctx, event)
 }
 
-func handleUserRoleUpdate(event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+func (h *Handler) handleUserRoleUpdate(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	var request UpdateRoleRequest
 	if err := json.Unmarshal([]byte(event.Body), &request); err != nil {
 		return createErrorResponse(400, "Invalid request body")
@@ -285,12 +177,12 @@ func handleUserRoleUpdate(event events.APIGatewayV2HTTPRequest) (events.APIGatew
 	}
 	userPoolId = parts[len(parts)-1]
 
-	currentGroups, err := getCurrentUserGroups(userPoolId, email)
+	currentGroups, err := h.services.Identity.ListGroupsForUser(ctx, userPoolId, email)
 	if err != nil {
-		log.Printf("Failed to get current groups for user %s: %v", email, err)
+		slog.ErrorContext(ctx, "Failed to get current groups", "user", email, "error", err)
 		return createErrorResponse(500, "Failed to get current groups")
 	}
-	if err := removeUserFromGroups(userPoolId, email, currentGroups); err != nil {
+	if err := h.removeUserFromGroups(ctx, userPoolId, email, currentGroups); err != nil {
 		return createErrorResponse(500, "Failed to remove user from groups")
 	}
 
@@ -302,8 +194,8 @@ func handleUserRoleUpdate(event events.APIGatewayV2HTTPRequest) (events.APIGatew
 			role = strings.ToUpper(role[:1]) + role[1:]
 		}
 		if role == "Subscriber" || role == "Publisher" {
-			if err := addUserToGroup(userPoolId, email, role); err != nil {
-				log.Printf("Failed to add user %s to group %s: %v", email, role, err)
+			if err := h.services.Identity.AddUserToGroup(ctx, userPoolId, email, role); err != nil {
+				slog.ErrorContext(ctx, "Failed to add user to group", "user", email, "group", role, "error", err)
 				return createErrorResponse(500, "Failed to add user to group")
 			}
 		}
@@ -315,7 +207,13 @@ func handleUserRoleUpdate(event events.APIGatewayV2HTTPRequest) (events.APIGatew
 // Lambda entry point
 /*****************************************************/
 
-func handleRequest(ctx context.Context, event json.RawMessage) (interface{}, error) {
+// HandleRequest is the Lambda entry point for API Gateway requests and the
+// Cognito post confirmation trigger.
+func (h *Handler) HandleRequest(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = withRequestId(ctx, lambdaCtx.AwsRequestID)
+	}
+
 	var eventMap map[string]interface{}
 	if err := json.Unmarshal(event, &eventMap); err != nil {
 		return nil, fmt.Errorf("failed to parse event: %w", err)
@@ -328,7 +226,7 @@ func handleRequest(ctx context.Context, event json.RawMessage) (interface{}, err
 			if err := json.Unmarshal(event, &apiEvent); err != nil {
 				return nil, fmt.Errorf("failed to parse API Gateway event: %w", err)
 			}
-			return handleAPIGateway(apiEvent)
+			return h.handleAPIGateway(ctx, apiEvent)
 		}
 	}
 
@@ -339,14 +237,10 @@ func handleRequest(ctx context.Context, event json.RawMessage) (interface{}, err
 			if err := json.Unmarshal(event, &cognitoEvent); err != nil {
 				return nil, fmt.Errorf("failed to parse Cognito event: %w", err)
 			}
-			return handlePostConfirmation(cognitoEvent)
+			return h.handlePostConfirmation(ctx, cognitoEvent)
 		}
 	}
 
-	log.Printf("Unsupported event structure: %+v", eventMap)
+	slog.ErrorContext(ctx, "Unsupported event structure")
 	return nil, fmt.Errorf("unsupported event type")
 }
-
-func main() {
-	lambda.Start(handleRequest)
-}
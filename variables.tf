@@ -42,3 +42,9 @@ variable "root_domain" {
   description = "The root domain name (e.g., miniprograms.app)"
   type        = string
 }
+
+variable "log_level" {
+  description = "Minimum level of the Lambda JSON logs (debug, info, warn, error)"
+  type        = string
+  default     = "info"
+}